@@ -1,19 +1,43 @@
 package agent
 
 import (
+	"context"
 	"path/filepath"
 
 	"github.com/superfly/flyctl/helpers"
+	"github.com/superfly/flyctl/internal/config"
 )
 
 // TODO: deprecate
-func PathToSocket() string {
+func PathToSocket(ctx context.Context) string {
 	dir, err := helpers.GetConfigDirectory()
 	if err != nil {
 		panic(err)
 	}
 
-	return filepath.Join(dir, "fly-agent.sock")
+	return filepath.Join(dir, SocketName(config.ProfileFromContext(ctx)))
+}
+
+// SocketName returns the name of the agent socket for an auth profile. Each
+// profile has an agent of its own, since WireGuard peers belong to an account.
+func SocketName(profile string) string {
+	if profile == "" || profile == config.DefaultProfile {
+		return "fly-agent.sock"
+	}
+	return "fly-agent-" + profile + ".sock"
+}
+
+// LockName returns the name of the file that keeps more than one agent from
+// running for an auth profile.
+func LockName(profile string) string {
+	return lockPrefix(profile) + ".lock"
+}
+
+func lockPrefix(profile string) string {
+	if profile == "" || profile == config.DefaultProfile {
+		return "flyctl.agent"
+	}
+	return "flyctl.agent-" + profile
 }
 
 type Instances struct {
@@ -12,6 +12,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -23,6 +24,7 @@ import (
 	"github.com/superfly/flyctl/gql"
 	"github.com/superfly/flyctl/internal/buildinfo"
 	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/env"
 	"github.com/superfly/flyctl/internal/flag"
 	"github.com/superfly/flyctl/internal/flyutil"
 	"github.com/superfly/flyctl/internal/logger"
@@ -40,7 +42,7 @@ func Establish(ctx context.Context, apiClient wireguard.WebClient) (*Client, err
 		return nil, err
 	}
 
-	c := newClient("unix", PathToSocket())
+	c := newClient("unix", PathToSocket(ctx))
 
 	res, err := c.Ping(ctx)
 	if err != nil {
@@ -93,7 +95,7 @@ func Establish(ctx context.Context, apiClient wireguard.WebClient) (*Client, err
 	}
 
 	// wait for the agent to exit
-	waitUntilDeleted(ctx, PathToSocket(), time.Second)
+	waitUntilDeleted(ctx, PathToSocket(ctx), time.Second)
 
 	return StartDaemon(ctx)
 }
@@ -153,7 +155,7 @@ func Dial(ctx context.Context, network, addr string) (*Client, error) {
 }
 
 func DefaultClient(ctx context.Context) (*Client, error) {
-	return Dial(ctx, "unix", PathToSocket())
+	return Dial(ctx, "unix", PathToSocket(ctx))
 }
 
 const (
@@ -165,6 +167,11 @@ type Client struct {
 	address            string
 	dialer             net.Dialer
 	agentRefusedTokens bool
+
+	// rpc is the protocol v2 connection shared by requests, see session.
+	rpcMu sync.Mutex
+	rpc   *RPCClient
+	noV2  bool
 }
 
 var errDone = errors.New("done")
@@ -313,6 +320,15 @@ type EstablishResponse struct {
 }
 
 func (c *Client) doEstablish(ctx context.Context, slug string, reestablish bool, network string) (res *EstablishResponse, err error) {
+	if rc := c.session(ctx); rc != nil {
+		res = &EstablishResponse{}
+		params := EstablishParams{Org: slug, Network: network, Recycle: reestablish}
+		if err = rc.Call(ctx, MethodEstablish, params, res); err != nil {
+			res = nil
+		}
+		return
+	}
+
 	err = c.do(ctx, func(conn net.Conn) (err error) {
 		verb := "establish"
 		if reestablish {
@@ -356,6 +372,10 @@ func (c *Client) Reestablish(ctx context.Context, slug, network string) (res *Es
 }
 
 func (c *Client) Probe(ctx context.Context, slug, network string) error {
+	if rc := c.session(ctx); rc != nil {
+		return rc.Call(ctx, MethodProbe, ProbeParams{Org: slug, Network: network}, nil)
+	}
+
 	return c.do(ctx, func(conn net.Conn) (err error) {
 		if err = proto.Write(conn, "probe", slug, network); err != nil {
 			return
@@ -380,6 +400,15 @@ func (c *Client) Probe(ctx context.Context, slug, network string) error {
 }
 
 func (c *Client) Resolve(ctx context.Context, slug, host, network string) (addr string, err error) {
+	if rc := c.session(ctx); rc != nil {
+		var res ResolveResult
+		params := ResolveParams{Org: slug, Host: host, Network: network}
+		if err = rc.Call(ctx, MethodResolve, params, &res); err == nil && res.Addr == "" {
+			err = ErrNoSuchHost
+		}
+		return res.Addr, err
+	}
+
 	err = c.do(ctx, func(conn net.Conn) (err error) {
 		if err = proto.Write(conn, "resolve", slug, host, network); err != nil {
 			return
@@ -408,6 +437,12 @@ func (c *Client) Resolve(ctx context.Context, slug, host, network string) (addr
 }
 
 func (c *Client) LookupTxt(ctx context.Context, slug, host string) (records []string, err error) {
+	if rc := c.session(ctx); rc != nil {
+		var res LookupTXTResult
+		err = rc.Call(ctx, MethodLookupTXT, LookupTXTParams{Org: slug, Host: host}, &res)
+		return res.Records, err
+	}
+
 	err = c.do(ctx, func(conn net.Conn) (err error) {
 		if err = proto.Write(conn, "lookupTxt", slug, host); err != nil {
 			return
@@ -487,6 +522,11 @@ func (c *Client) Instances(ctx context.Context, org, app string) (instances Inst
 	gqlChan := make(chan instancesResult)
 	var agentInstances Instances
 	go func() {
+		if rc := c.session(ctx); rc != nil {
+			agentChan <- rc.Call(ctx, MethodInstances, InstancesParams{Org: org, App: app}, &agentInstances)
+			return
+		}
+
 		agentChan <- c.do(ctx, func(conn net.Conn) (err error) {
 			if err = proto.Write(conn, "instances", org, app); err != nil {
 				return
@@ -698,6 +738,10 @@ type Dialer interface {
 	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
 }
 
+// StreamsEnvName names the environment variable that makes dialers multiplex
+// TCP connections over protocol v2 streams.
+const StreamsEnvName = "FLY_AGENT_STREAMS"
+
 type dialer struct {
 	slug    string
 	network string
@@ -718,6 +762,18 @@ func (d *dialer) Config() *wg.Config {
 }
 
 func (d *dialer) DialContext(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+	udp := strings.HasPrefix(network, "udp")
+
+	// TCP connections may be multiplexed over the client's protocol v2
+	// connection rather than dialing the agent for each. That's opt-in for
+	// now: every write is a round trip to the agent, so raw connections are
+	// faster.
+	if !udp && env.IsTruthy(StreamsEnvName) {
+		if rc := d.client.session(ctx); rc != nil && rc.hello.HasCapability(CapabilityStreams) && rc.hello.HasCapability(CapabilityHalfClose) {
+			return rc.Connect(ctx, d.slug, addr, d.network, d.timeout)
+		}
+	}
+
 	if conn, err = d.client.dialContext(ctx); err != nil {
 		return
 	}
@@ -727,10 +783,15 @@ func (d *dialer) DialContext(ctx context.Context, network, addr string) (conn ne
 		}
 	}()
 
+	verb := "connect"
+	if udp {
+		verb = "connect-udp"
+	}
+
 	c := make(chan error, 1)
 	go func() {
 		timeout := strconv.FormatInt(int64(d.timeout), 10)
-		if err := proto.Write(conn, "connect", d.slug, addr, timeout, d.network); err != nil {
+		if err := proto.Write(conn, verb, d.slug, addr, timeout, d.network); err != nil {
 			c <- err
 			return
 		}
@@ -756,9 +817,50 @@ func (d *dialer) DialContext(ctx context.Context, network, addr string) (conn ne
 		err = ctx.Err()
 	case err = <-c:
 	}
+
+	if err == nil && udp {
+		conn = &datagramConn{Conn: conn}
+	}
 	return
 }
 
+// datagramConn carries one UDP datagram per Read and Write over a connection
+// to the agent.
+type datagramConn struct {
+	net.Conn
+
+	readMu  sync.Mutex
+	writeMu sync.Mutex
+	buf     []byte
+}
+
+// Read reads a single datagram, truncating it if p is too small.
+func (c *datagramConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if c.buf == nil {
+		c.buf = make([]byte, proto.MaxDatagram)
+	}
+
+	n, err := proto.ReadDatagram(c.Conn, c.buf)
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, c.buf[:n]), nil
+}
+
+// Write sends p as a single datagram.
+func (c *datagramConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := proto.WriteDatagram(c.Conn, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 // Pinger wraps a connection to the flyctl agent over which ICMP
 // requests and replies are written. There's a simple protocol
 // for encapsulating requests and responses; drive it with the Pinger
@@ -5,4 +5,5 @@ import "errors"
 var (
 	ErrNoSuchHost        = errors.New("host was not found in DNS")
 	ErrTunnelUnavailable = errors.New("tunnel unavailable")
+	ErrNoSuchOrg         = errors.New("no such organization")
 )
@@ -46,3 +46,37 @@ func Write(w io.Writer, verb string, args ...string) (err error) {
 
 	return
 }
+
+// MaxDatagram is the largest datagram WriteDatagram can frame.
+const MaxDatagram = 1<<16 - 1
+
+// WriteDatagram writes p as a single length-prefixed frame. Connections
+// switched to datagram mode carry one such frame per UDP datagram.
+func WriteDatagram(w io.Writer, p []byte) error {
+	if len(p) > MaxDatagram {
+		return io.ErrShortWrite
+	}
+
+	frame := make([]byte, 2+len(p))
+	binary.LittleEndian.PutUint16(frame, uint16(len(p)))
+	copy(frame[2:], p)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadDatagram reads a frame written by WriteDatagram into buf, which should
+// be MaxDatagram bytes long, and returns the datagram's length.
+func ReadDatagram(r io.Reader, buf []byte) (n int, err error) {
+	var b [2]byte
+	if _, err = io.ReadFull(r, b[:]); err != nil {
+		return
+	}
+
+	l := int(binary.LittleEndian.Uint16(b[:]))
+	if l > len(buf) {
+		return 0, io.ErrShortBuffer
+	}
+
+	return io.ReadFull(r, buf[:l])
+}
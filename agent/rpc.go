@@ -0,0 +1,271 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Protocol v2
+//
+// A client switches a connection to protocol v2 by sending the v1 command
+// "v2". Once the agent replies "ok 2", both sides exchange newline-delimited
+// JSON-RPC 2.0 messages over the same connection for as long as it stays
+// open. Requests may be sent concurrently; responses carry the request's id
+// and may arrive in any order.
+//
+// The first request must be "hello", which negotiates the protocol version
+// and capabilities. Methods that stream, such as "connect", reply with a
+// stream id and then send "stream.data" notifications until a final
+// "stream.closed" notification.
+//
+// Streams are flow controlled: the agent sends at most StreamWindow bytes of
+// stream.data that the client hasn't granted back yet. Clients grant bytes
+// with "stream.credit" notifications as they consume data. Writes to a stream
+// are queued and answered in order, without holding up other requests.
+// "stream.close_write" is queued the same way and shuts down the writing side
+// of the stream's connection once the writes before it are done.
+//
+// Errors are JSON-RPC errors whose data carries a machine readable kind, see
+// RPCError.
+
+// ProtocolVersion is the newest agent protocol version.
+const ProtocolVersion = 2
+
+// V2Command is the v1 command that switches a connection to protocol v2.
+const V2Command = "v2"
+
+// Methods and notifications of protocol v2.
+const (
+	MethodHello            = "hello"
+	MethodPing             = "ping"
+	MethodStats            = "stats"
+	MethodEstablish        = "establish"
+	MethodProbe            = "probe"
+	MethodInstances        = "instances"
+	MethodResolve          = "resolve"
+	MethodLookupTXT        = "lookup_txt"
+	MethodSetToken         = "set_token"
+	MethodConnect          = "connect"
+	MethodStreamWrite      = "stream.write"
+	MethodStreamClose      = "stream.close"
+	MethodStreamCloseWrite = "stream.close_write"
+
+	NotifyStreamData   = "stream.data"
+	NotifyStreamClosed = "stream.closed"
+	NotifyStreamCredit = "stream.credit"
+)
+
+// StreamWindow is how many bytes of stream.data the agent sends ahead of a
+// stream's client.
+const StreamWindow = 256 << 10
+
+// Capabilities an agent may offer during the hello exchange.
+const (
+	// CapabilityStreams means connect is supported, with connections
+	// multiplexed over the RPC connection as streams
+	CapabilityStreams = "streams"
+	// CapabilityTokens means set_token is supported
+	CapabilityTokens = "tokens"
+	// CapabilityHalfClose means stream.close_write is supported
+	CapabilityHalfClose = "half_close"
+)
+
+// JSON-RPC error codes.
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCServerError    = -32000
+)
+
+// Kinds of structured errors, carried in RPCError.Data.
+const (
+	ErrorKindInternal          = "internal"
+	ErrorKindTunnelUnavailable = "tunnel_unavailable"
+	ErrorKindNoSuchHost        = "no_such_host"
+	ErrorKindNoSuchOrg         = "no_such_org"
+	ErrorKindTimeout           = "timeout"
+	ErrorKindNotNegotiated     = "not_negotiated"
+	ErrorKindNoSuchStream      = "no_such_stream"
+	ErrorKindStreamBusy        = "stream_busy"
+)
+
+// RPCMessage is a JSON-RPC 2.0 request, response or notification.
+type RPCMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *uint64         `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is a structured protocol v2 error.
+type RPCError struct {
+	Code    int           `json:"code"`
+	Message string        `json:"message"`
+	Data    *RPCErrorData `json:"data,omitempty"`
+}
+
+// RPCErrorData tells clients what went wrong without parsing messages.
+type RPCErrorData struct {
+	Kind string `json:"kind"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// Kind returns the error's kind, or ErrorKindInternal if it has none.
+func (e *RPCError) Kind() string {
+	if e.Data == nil || e.Data.Kind == "" {
+		return ErrorKindInternal
+	}
+	return e.Data.Kind
+}
+
+// Is lets errors.Is match structured errors against the agent's sentinel
+// errors.
+func (e *RPCError) Is(target error) bool {
+	switch e.Kind() {
+	case ErrorKindTunnelUnavailable:
+		return target == ErrTunnelUnavailable
+	case ErrorKindNoSuchHost:
+		return target == ErrNoSuchHost
+	}
+	return false
+}
+
+// NewRPCError builds a structured error from err, classifying the agent's
+// well known errors.
+func NewRPCError(code int, err error) *RPCError {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+
+	kind := ErrorKindInternal
+	switch {
+	case errors.Is(err, ErrTunnelUnavailable):
+		kind = ErrorKindTunnelUnavailable
+	case errors.Is(err, ErrNoSuchHost):
+		kind = ErrorKindNoSuchHost
+	case errors.Is(err, ErrNoSuchOrg):
+		kind = ErrorKindNoSuchOrg
+	case isTimeout(err):
+		kind = ErrorKindTimeout
+	}
+
+	return &RPCError{Code: code, Message: err.Error(), Data: &RPCErrorData{Kind: kind}}
+}
+
+func isTimeout(err error) bool {
+	var te interface{ Timeout() bool }
+	return errors.As(err, &te) && te.Timeout()
+}
+
+// HelloParams opens a protocol v2 conversation.
+type HelloParams struct {
+	Version      int      `json:"version"`
+	Client       string   `json:"client,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// HelloResult holds the negotiated version and the capabilities both sides
+// support.
+type HelloResult struct {
+	Version      int      `json:"version"`
+	AgentVersion string   `json:"agent_version"`
+	PID          int      `json:"pid"`
+	Background   bool     `json:"background"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// HasCapability reports whether a capability was negotiated.
+func (h *HelloResult) HasCapability(capability string) bool {
+	for _, c := range h.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+type EstablishParams struct {
+	Org     string `json:"org"`
+	Network string `json:"network,omitempty"`
+	Recycle bool   `json:"recycle,omitempty"`
+}
+
+type ProbeParams struct {
+	Org     string `json:"org"`
+	Network string `json:"network,omitempty"`
+}
+
+type InstancesParams struct {
+	Org string `json:"org"`
+	App string `json:"app"`
+}
+
+type ResolveParams struct {
+	Org     string `json:"org"`
+	Host    string `json:"host"`
+	Network string `json:"network,omitempty"`
+}
+
+type ResolveResult struct {
+	Addr string `json:"addr"`
+}
+
+type LookupTXTParams struct {
+	Org  string `json:"org"`
+	Host string `json:"host"`
+}
+
+type LookupTXTResult struct {
+	Records []string `json:"records"`
+}
+
+// SetTokenParams sets the tokens used for API calls made on behalf of the
+// connection. Either Tokens or ConfigFile is set.
+type SetTokenParams struct {
+	Tokens     string `json:"tokens,omitempty"`
+	ConfigFile string `json:"config_file,omitempty"`
+}
+
+type ConnectParams struct {
+	Org       string `json:"org"`
+	Addr      string `json:"addr"`
+	Network   string `json:"network,omitempty"`
+	TimeoutMS int64  `json:"timeout_ms,omitempty"`
+}
+
+type ConnectResult struct {
+	Stream uint64 `json:"stream"`
+}
+
+type StreamWriteParams struct {
+	Stream uint64 `json:"stream"`
+	Data   []byte `json:"data"`
+}
+
+type StreamCloseParams struct {
+	Stream uint64 `json:"stream"`
+}
+
+// StreamCreditParams grants the agent Bytes more bytes of a stream's data.
+type StreamCreditParams struct {
+	Stream uint64 `json:"stream"`
+	Bytes  int64  `json:"bytes"`
+}
+
+type StreamDataParams struct {
+	Stream uint64 `json:"stream"`
+	Data   []byte `json:"data"`
+}
+
+type StreamClosedParams struct {
+	Stream uint64    `json:"stream"`
+	Error  *RPCError `json:"error,omitempty"`
+}
@@ -0,0 +1,671 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/superfly/flyctl/agent/internal/proto"
+	"github.com/superfly/flyctl/internal/buildinfo"
+	"github.com/superfly/flyctl/internal/config"
+)
+
+// maxStreamWrite is the largest payload sent in a single stream.write.
+const maxStreamWrite = 32 << 10
+
+// RPCClient is a long-lived protocol v2 connection to the agent. It's safe
+// for concurrent use.
+type RPCClient struct {
+	conn  net.Conn
+	hello HelloResult
+
+	writeMu sync.Mutex
+	enc     *json.Encoder
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *RPCMessage
+	streams map[uint64]*rpcStream
+	err     error
+
+	// tombstones holds the ids of streams closed before the agent said
+	// they're done, so data still arriving for them is dropped.
+	tombstones map[uint64]struct{}
+
+	done chan struct{}
+}
+
+var errNoV2 = errors.New("agent doesn't support protocol v2")
+
+// session returns the client's protocol v2 connection, opening it on first
+// use. It returns nil if the agent doesn't speak protocol v2 or can't be
+// reached that way, in which case callers fall back to protocol v1.
+func (c *Client) session(ctx context.Context) *RPCClient {
+	c.rpcMu.Lock()
+	defer c.rpcMu.Unlock()
+
+	if c.noV2 {
+		return nil
+	}
+
+	if c.rpc != nil {
+		select {
+		case <-c.rpc.Done():
+			// the agent went away; try again with a new connection
+			c.rpc = nil
+		default:
+			return c.rpc
+		}
+	}
+
+	rc, err := c.RPC(ctx)
+	if err != nil {
+		c.noV2 = errors.Is(err, errNoV2)
+		return nil
+	}
+
+	c.rpc = rc
+	return rc
+}
+
+// RPC opens a protocol v2 connection to the agent, passing along the tokens
+// in the context, if any.
+func (c *Client) RPC(ctx context.Context) (*RPCClient, error) {
+	conn, err := c.dialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := NewRPCClient(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	toks := config.Tokens(ctx)
+	if c.agentRefusedTokens || toks.Empty() || !rc.hello.HasCapability(CapabilityTokens) {
+		return rc, nil
+	}
+
+	params := SetTokenParams{ConfigFile: toks.FromFile()}
+	if params.ConfigFile == "" {
+		params.Tokens = toks.All()
+	}
+
+	if err := rc.Call(ctx, MethodSetToken, params, nil); err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("failed setting tokens: %w", err)
+	}
+
+	return rc, nil
+}
+
+// NewRPCClient switches conn to protocol v2 and negotiates capabilities.
+// The client owns conn from then on.
+func NewRPCClient(ctx context.Context, conn net.Conn) (*RPCClient, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if err := proto.Write(conn, V2Command); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	data, err := proto.Read(conn)
+	switch {
+	case err != nil:
+		conn.Close()
+		return nil, err
+	case isError(data):
+		conn.Close()
+		return nil, fmt.Errorf("%w: %w", errNoV2, extractError(data))
+	case !isOK(data):
+		conn.Close()
+		return nil, errInvalidResponse(data)
+	}
+
+	if v, err := strconv.Atoi(string(extractOK(data))); err != nil || v < ProtocolVersion {
+		conn.Close()
+		return nil, errInvalidResponse(data)
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	rc := &RPCClient{
+		conn:       conn,
+		enc:        json.NewEncoder(conn),
+		pending:    make(map[uint64]chan *RPCMessage),
+		streams:    make(map[uint64]*rpcStream),
+		tombstones: make(map[uint64]struct{}),
+		done:       make(chan struct{}),
+	}
+	go rc.readLoop()
+
+	hello := HelloParams{
+		Version:      ProtocolVersion,
+		Client:       "flyctl/" + buildinfo.Version().String(),
+		Capabilities: []string{CapabilityStreams, CapabilityTokens, CapabilityHalfClose},
+	}
+	if err := rc.Call(ctx, MethodHello, hello, &rc.hello); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	return rc, nil
+}
+
+// Hello returns what was negotiated with the agent.
+func (rc *RPCClient) Hello() HelloResult {
+	return rc.hello
+}
+
+// Close closes the connection, and with it all of its streams.
+func (rc *RPCClient) Close() error {
+	return rc.conn.Close()
+}
+
+// Done is closed once the connection is gone.
+func (rc *RPCClient) Done() <-chan struct{} {
+	return rc.done
+}
+
+// Call sends a request and decodes its result into result, unless it's nil.
+// Errors reported by the agent are *RPCError.
+func (rc *RPCClient) Call(ctx context.Context, method string, params, result interface{}) error {
+	id, ch, err := rc.send(method, params)
+	if err != nil {
+		return err
+	}
+	defer rc.drop(id)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-rc.done:
+		return rc.closedErr()
+	case res := <-ch:
+		if res.Error != nil {
+			return res.Error
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(res.Result, result)
+	}
+}
+
+// send sends a request and returns its id and the channel its response is
+// delivered on. Callers drop the request once they're done waiting for it.
+func (rc *RPCClient) send(method string, params interface{}) (uint64, <-chan *RPCMessage, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ch := make(chan *RPCMessage, 1)
+
+	rc.mu.Lock()
+	if rc.err != nil {
+		rc.mu.Unlock()
+		return 0, nil, rc.err
+	}
+	rc.nextID++
+	id := rc.nextID
+	rc.pending[id] = ch
+	rc.mu.Unlock()
+
+	if err := rc.write(&RPCMessage{JSONRPC: "2.0", ID: &id, Method: method, Params: data}); err != nil {
+		rc.drop(id)
+		return 0, nil, err
+	}
+
+	return id, ch, nil
+}
+
+func (rc *RPCClient) drop(id uint64) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	delete(rc.pending, id)
+}
+
+// notify sends a notification, which the agent doesn't answer.
+func (rc *RPCClient) notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	return rc.write(&RPCMessage{JSONRPC: "2.0", Method: method, Params: data})
+}
+
+func (rc *RPCClient) write(msg *RPCMessage) error {
+	rc.writeMu.Lock()
+	defer rc.writeMu.Unlock()
+
+	return rc.enc.Encode(msg)
+}
+
+func (rc *RPCClient) closedErr() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.err
+}
+
+func (rc *RPCClient) readLoop() {
+	scanner := bufio.NewScanner(rc.conn)
+	scanner.Buffer(make([]byte, 64<<10), 4<<20)
+
+	for scanner.Scan() {
+		var msg RPCMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+
+		switch {
+		case msg.Method == NotifyStreamData:
+			var params StreamDataParams
+			if json.Unmarshal(msg.Params, &params) == nil {
+				if s := rc.stream(params.Stream); s != nil {
+					s.deliver(params.Data)
+				}
+			}
+		case msg.Method == NotifyStreamClosed:
+			var params StreamClosedParams
+			if json.Unmarshal(msg.Params, &params) == nil {
+				rc.finishStream(params.Stream, params.Error)
+			}
+		case msg.ID != nil:
+			rc.mu.Lock()
+			ch := rc.pending[*msg.ID]
+			rc.mu.Unlock()
+
+			if ch != nil {
+				ch <- &msg
+			}
+		}
+	}
+
+	err := scanner.Err()
+	if err == nil {
+		err = io.EOF
+	}
+
+	rc.mu.Lock()
+	rc.err = fmt.Errorf("agent connection closed: %w", err)
+	streams := rc.streams
+	rc.streams = nil
+	rc.tombstones = nil
+	rc.mu.Unlock()
+
+	for _, s := range streams {
+		s.finish(nil)
+	}
+
+	close(rc.done)
+}
+
+// stream returns the stream with the given id, creating it if necessary, or
+// nil if it was closed already. Data for a stream may arrive, and it may even
+// finish, before the reply to connect is handled, so streams are only
+// forgotten once they're closed.
+func (rc *RPCClient) stream(id uint64) *rpcStream {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.lookupStream(id)
+}
+
+func (rc *RPCClient) lookupStream(id uint64) *rpcStream {
+	if s, ok := rc.streams[id]; ok {
+		return s
+	}
+	if _, ok := rc.tombstones[id]; ok {
+		return nil
+	}
+
+	s := newRPCStream(rc, id)
+	if rc.streams != nil {
+		rc.streams[id] = s
+	} else {
+		// the connection is gone
+		s.finished = true
+	}
+	return s
+}
+
+// finishStream handles the agent's stream.closed, the last message it sends
+// for a stream, which also lays the stream's tombstone to rest.
+func (rc *RPCClient) finishStream(id uint64, err *RPCError) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if _, ok := rc.tombstones[id]; ok {
+		delete(rc.tombstones, id)
+		return
+	}
+
+	rc.lookupStream(id).finish(err)
+}
+
+// forget drops a closed stream. Unless the agent finished it already, its id
+// is remembered until it does. forget reports whether it had.
+func (rc *RPCClient) forget(s *rpcStream) (finished bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	delete(rc.streams, s.id)
+
+	s.mu.Lock()
+	finished = s.finished
+	s.mu.Unlock()
+
+	if !finished && rc.tombstones != nil {
+		rc.tombstones[s.id] = struct{}{}
+	}
+	return finished
+}
+
+// Connect dials addr through the organization's tunnel. The connection is
+// multiplexed over the RPC connection.
+func (rc *RPCClient) Connect(ctx context.Context, slug, addr, network string, timeout time.Duration) (net.Conn, error) {
+	if !rc.hello.HasCapability(CapabilityStreams) {
+		return nil, errors.New("agent doesn't support streams")
+	}
+
+	var res ConnectResult
+	params := ConnectParams{
+		Org:       slug,
+		Addr:      addr,
+		Network:   network,
+		TimeoutMS: timeout.Milliseconds(),
+	}
+	if err := rc.Call(ctx, MethodConnect, params, &res); err != nil {
+		return nil, err
+	}
+
+	if s := rc.stream(res.Stream); s != nil {
+		return s, nil
+	}
+	return nil, net.ErrClosed
+}
+
+// rpcStream is a connection multiplexed over an RPCClient.
+type rpcStream struct {
+	rc *RPCClient
+	id uint64
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	buf         []byte
+	finished    bool
+	err         error
+	closed      bool
+	writeClosed bool
+
+	// consumed counts the bytes read since credit was last granted.
+	consumed int
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+	// deadlineSet is closed, and replaced, whenever the write deadline
+	// changes, so pending writes pick up the new one.
+	deadlineSet chan struct{}
+}
+
+var errStreamWriteClosed = errors.New("stream closed for writing")
+
+func newRPCStream(rc *RPCClient, id uint64) *rpcStream {
+	s := &rpcStream{rc: rc, id: id, deadlineSet: make(chan struct{})}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *rpcStream) deliver(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buf = append(s.buf, data...)
+	s.cond.Broadcast()
+}
+
+func (s *rpcStream) finish(err *RPCError) {
+	s.mu.Lock()
+	s.finished = true
+	if err != nil {
+		s.err = err
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// Read reads buffered data, granting the agent credit for more once half of
+// the stream's window was consumed.
+func (s *rpcStream) Read(p []byte) (int, error) {
+	n, grant, err := s.read(p)
+
+	if grant > 0 {
+		params := StreamCreditParams{Stream: s.id, Bytes: int64(grant)}
+		_ = s.rc.notify(NotifyStreamCredit, params)
+	}
+
+	return n, err
+}
+
+func (s *rpcStream) read(p []byte) (n, grant int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for len(s.buf) == 0 {
+		switch {
+		case s.closed:
+			return 0, 0, net.ErrClosed
+		case s.finished && s.err != nil:
+			return 0, 0, s.err
+		case s.finished:
+			return 0, 0, io.EOF
+		case !s.readDeadline.IsZero() && !time.Now().Before(s.readDeadline):
+			return 0, 0, os.ErrDeadlineExceeded
+		}
+
+		if !s.readDeadline.IsZero() && timer == nil {
+			timer = time.AfterFunc(time.Until(s.readDeadline), func() {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				s.cond.Broadcast()
+			})
+		}
+
+		s.cond.Wait()
+	}
+
+	n = copy(p, s.buf)
+	s.buf = s.buf[n:]
+
+	s.consumed += n
+	if s.consumed >= StreamWindow/2 && !s.finished {
+		grant, s.consumed = s.consumed, 0
+	}
+	return n, grant, nil
+}
+
+// Write writes p in chunks, each acknowledged by the agent once it was
+// written to the stream's connection, or fails with os.ErrDeadlineExceeded
+// once the write deadline passes.
+func (s *rpcStream) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxStreamWrite {
+			chunk = chunk[:maxStreamWrite]
+		}
+
+		if err = s.writeChunk(chunk); err != nil {
+			return
+		}
+
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return
+}
+
+func (s *rpcStream) writeChunk(chunk []byte) error {
+	if _, _, err := s.writeState(); err != nil {
+		return err
+	}
+
+	id, ch, err := s.rc.send(MethodStreamWrite, StreamWriteParams{Stream: s.id, Data: chunk})
+	if err != nil {
+		return err
+	}
+	defer s.rc.drop(id)
+
+	for {
+		deadline, deadlineSet, err := s.writeState()
+		if err != nil {
+			return err
+		}
+
+		if err := s.awaitWrite(ch, deadline, deadlineSet); !errors.Is(err, errDeadlineSet) {
+			return err
+		}
+	}
+}
+
+// errDeadlineSet means the write deadline changed while awaiting a write.
+var errDeadlineSet = errors.New("write deadline changed")
+
+func (s *rpcStream) awaitWrite(ch <-chan *RPCMessage, deadline time.Time, deadlineSet <-chan struct{}) error {
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case res := <-ch:
+		if res.Error != nil {
+			return res.Error
+		}
+		return nil
+	case <-s.rc.done:
+		return s.rc.closedErr()
+	case <-expired:
+		return os.ErrDeadlineExceeded
+	case <-deadlineSet:
+		return errDeadlineSet
+	}
+}
+
+// writeState returns the write deadline, the channel closed when it changes
+// and why writing is impossible, if it is.
+func (s *rpcStream) writeState() (time.Time, <-chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.closed:
+		return time.Time{}, nil, net.ErrClosed
+	case s.writeClosed:
+		return time.Time{}, nil, errStreamWriteClosed
+	case !s.writeDeadline.IsZero() && !time.Now().Before(s.writeDeadline):
+		return time.Time{}, nil, os.ErrDeadlineExceeded
+	}
+	return s.writeDeadline, s.deadlineSet, nil
+}
+
+// CloseWrite shuts down the writing side of the stream once the agent wrote
+// everything written before, so the remote end reads EOF while the stream
+// can still be read from.
+func (s *rpcStream) CloseWrite() error {
+	s.mu.Lock()
+	switch {
+	case s.closed:
+		s.mu.Unlock()
+		return net.ErrClosed
+	case s.writeClosed:
+		s.mu.Unlock()
+		return nil
+	}
+	s.writeClosed = true
+	s.mu.Unlock()
+
+	return s.rc.Call(context.Background(), MethodStreamCloseWrite, StreamCloseParams{Stream: s.id}, nil)
+}
+
+func (s *rpcStream) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.cond.Broadcast()
+	close(s.deadlineSet)
+	s.deadlineSet = make(chan struct{})
+	s.mu.Unlock()
+
+	if s.rc.forget(s) {
+		return nil
+	}
+
+	err := s.rc.Call(context.Background(), MethodStreamClose, StreamCloseParams{Stream: s.id}, nil)
+
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) && rpcErr.Kind() == ErrorKindNoSuchStream {
+		// the agent closed it first
+		return nil
+	}
+	return err
+}
+
+func (s *rpcStream) LocalAddr() net.Addr {
+	return s.rc.conn.LocalAddr()
+}
+
+func (s *rpcStream) RemoteAddr() net.Addr {
+	return s.rc.conn.RemoteAddr()
+}
+
+func (s *rpcStream) SetDeadline(t time.Time) error {
+	_ = s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+func (s *rpcStream) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.readDeadline = t
+	s.cond.Broadcast()
+	return nil
+}
+
+// SetWriteDeadline bounds how long writes wait for the agent to acknowledge
+// them, including writes already waiting.
+func (s *rpcStream) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.writeDeadline = t
+	close(s.deadlineSet)
+	s.deadlineSet = make(chan struct{})
+	return nil
+}
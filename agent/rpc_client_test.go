@@ -0,0 +1,226 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/superfly/flyctl/agent/internal/proto"
+)
+
+// fakeAgent is the agent's end of a protocol v2 connection, driven by a test.
+type fakeAgent struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	enc  *json.Encoder
+}
+
+// startFakeAgent returns a client negotiated with a fake agent offering all
+// capabilities.
+func startFakeAgent(t *testing.T) (*RPCClient, *fakeAgent) {
+	t.Helper()
+
+	clientConn, agentConn := net.Pipe()
+	fa := &fakeAgent{t: t, conn: agentConn, r: bufio.NewReader(agentConn), enc: json.NewEncoder(agentConn)}
+
+	go func() {
+		if _, err := proto.Read(agentConn); err != nil {
+			return
+		}
+		if err := proto.Write(agentConn, "ok", "2"); err != nil {
+			return
+		}
+		msg := fa.next()
+		fa.reply(msg, HelloResult{
+			Version:      ProtocolVersion,
+			Capabilities: []string{CapabilityStreams, CapabilityTokens, CapabilityHalfClose},
+		})
+	}()
+
+	rc, err := NewRPCClient(context.Background(), clientConn)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		rc.Close()
+		agentConn.Close()
+	})
+
+	return rc, fa
+}
+
+func (fa *fakeAgent) next() *RPCMessage {
+	line, err := fa.r.ReadBytes('\n')
+	require.NoError(fa.t, err)
+
+	var msg RPCMessage
+	require.NoError(fa.t, json.Unmarshal(line, &msg))
+	return &msg
+}
+
+func (fa *fakeAgent) reply(req *RPCMessage, result interface{}) {
+	data, err := json.Marshal(result)
+	require.NoError(fa.t, err)
+	require.NoError(fa.t, fa.enc.Encode(&RPCMessage{JSONRPC: "2.0", ID: req.ID, Result: data}))
+}
+
+func (fa *fakeAgent) notify(method string, params interface{}) {
+	data, err := json.Marshal(params)
+	require.NoError(fa.t, err)
+	require.NoError(fa.t, fa.enc.Encode(&RPCMessage{JSONRPC: "2.0", Method: method, Params: data}))
+}
+
+// connect opens stream id through the fake agent.
+func (fa *fakeAgent) connect(rc *RPCClient, id uint64) *rpcStream {
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := rc.Connect(context.Background(), "personal", "[fdaa::3]:22", "", time.Second)
+		assert.NoError(fa.t, err)
+		conns <- conn
+	}()
+
+	msg := fa.next()
+	require.Equal(fa.t, MethodConnect, msg.Method)
+	fa.reply(msg, ConnectResult{Stream: id})
+
+	return (<-conns).(*rpcStream)
+}
+
+// sync makes sure the client handled everything sent before.
+func (fa *fakeAgent) sync(rc *RPCClient) {
+	done := make(chan error, 1)
+	go func() {
+		done <- rc.Call(context.Background(), MethodPing, nil, nil)
+	}()
+
+	msg := fa.next()
+	require.Equal(fa.t, MethodPing, msg.Method)
+	fa.reply(msg, nil)
+	require.NoError(fa.t, <-done)
+}
+
+func TestRPCStreamWriteDeadline(t *testing.T) {
+	rc, fa := startFakeAgent(t)
+	s := fa.connect(rc, 1)
+
+	// the agent never acknowledges the write, and a deadline set while it's
+	// pending still applies
+	errs := make(chan error, 1)
+	go func() {
+		_, err := s.Write([]byte("hello"))
+		errs <- err
+	}()
+
+	msg := fa.next()
+	require.Equal(t, MethodStreamWrite, msg.Method)
+
+	require.NoError(t, s.SetWriteDeadline(time.Now().Add(20*time.Millisecond)))
+	assert.ErrorIs(t, <-errs, os.ErrDeadlineExceeded)
+
+	// once past, the deadline fails writes without sending them
+	_, err := s.Write([]byte("again"))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	// clearing it makes writes wait for the agent again
+	require.NoError(t, s.SetWriteDeadline(time.Time{}))
+	go func() {
+		_, err := s.Write([]byte("again"))
+		errs <- err
+	}()
+
+	msg = fa.next()
+	require.Equal(t, MethodStreamWrite, msg.Method)
+	fa.reply(msg, nil)
+	assert.NoError(t, <-errs)
+}
+
+func TestRPCStreamCloseWrite(t *testing.T) {
+	rc, fa := startFakeAgent(t)
+	s := fa.connect(rc, 1)
+
+	errs := make(chan error, 1)
+	go func() {
+		if _, err := s.Write([]byte("hello")); err != nil {
+			errs <- err
+			return
+		}
+		errs <- s.CloseWrite()
+	}()
+
+	msg := fa.next()
+	require.Equal(t, MethodStreamWrite, msg.Method)
+	fa.reply(msg, nil)
+
+	msg = fa.next()
+	require.Equal(t, MethodStreamCloseWrite, msg.Method)
+	var params StreamCloseParams
+	require.NoError(t, json.Unmarshal(msg.Params, &params))
+	assert.Equal(t, uint64(1), params.Stream)
+	fa.reply(msg, nil)
+	require.NoError(t, <-errs)
+
+	_, err := s.Write([]byte("more"))
+	assert.ErrorIs(t, err, errStreamWriteClosed)
+
+	// the stream can still be read until the agent is done with it
+	fa.notify(NotifyStreamData, StreamDataParams{Stream: 1, Data: []byte("bye")})
+	fa.notify(NotifyStreamClosed, StreamClosedParams{Stream: 1})
+
+	data, err := io.ReadAll(s)
+	require.NoError(t, err)
+	assert.Equal(t, "bye", string(data))
+}
+
+func TestRPCStreamTombstone(t *testing.T) {
+	rc, fa := startFakeAgent(t)
+	s := fa.connect(rc, 1)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Close()
+	}()
+
+	msg := fa.next()
+	require.Equal(t, MethodStreamClose, msg.Method)
+	fa.reply(msg, nil)
+	require.NoError(t, <-errs)
+
+	// data the agent sent before it noticed is dropped rather than bringing
+	// the stream back
+	fa.notify(NotifyStreamData, StreamDataParams{Stream: 1, Data: []byte("late")})
+	fa.sync(rc)
+
+	rc.mu.Lock()
+	assert.NotContains(t, rc.streams, uint64(1))
+	assert.Contains(t, rc.tombstones, uint64(1))
+	rc.mu.Unlock()
+
+	// stream.closed is the last message for the stream, so the tombstone
+	// goes with it
+	fa.notify(NotifyStreamClosed, StreamClosedParams{Stream: 1})
+	fa.sync(rc)
+
+	rc.mu.Lock()
+	assert.Empty(t, rc.streams)
+	assert.Empty(t, rc.tombstones)
+	rc.mu.Unlock()
+
+	// streams the agent finished first leave no tombstone behind
+	s = fa.connect(rc, 2)
+	fa.notify(NotifyStreamClosed, StreamClosedParams{Stream: 2})
+	fa.sync(rc)
+	require.NoError(t, s.Close())
+
+	rc.mu.Lock()
+	assert.Empty(t, rc.streams)
+	assert.Empty(t, rc.tombstones)
+	rc.mu.Unlock()
+}
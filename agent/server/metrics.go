@@ -0,0 +1,170 @@
+package server
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/superfly/flyctl/agent"
+	"github.com/superfly/flyctl/internal/buildinfo"
+)
+
+// metrics tracks what happens on the agent's tunnels. The zero value is ready
+// to use.
+type metrics struct {
+	mu       sync.Mutex
+	nextConn uint64
+	tunnels  map[tunnelKey]*tunnelMetrics
+}
+
+type tunnelMetrics struct {
+	establishedAt time.Time
+	establishes   int
+	reestablishes int
+
+	conns map[uint64]agent.ConnectionStats
+
+	dnsQueries      uint64
+	dnsFailures     uint64
+	dnsLastLatency  time.Duration
+	dnsTotalLatency time.Duration
+	dnsMaxLatency   time.Duration
+}
+
+// tunnel returns the metrics for tk; m.mu must be held.
+func (m *metrics) tunnel(tk tunnelKey) *tunnelMetrics {
+	if m.tunnels == nil {
+		m.tunnels = make(map[tunnelKey]*tunnelMetrics)
+	}
+
+	tm, ok := m.tunnels[tk]
+	if !ok {
+		tm = &tunnelMetrics{conns: make(map[uint64]agent.ConnectionStats)}
+		m.tunnels[tk] = tm
+	}
+	return tm
+}
+
+func (m *metrics) established(tk tunnelKey, reestablished bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tm := m.tunnel(tk)
+	tm.establishedAt = time.Now()
+	tm.establishes++
+	if reestablished {
+		tm.reestablishes++
+	}
+}
+
+// connOpened records a connection dialed through a tunnel. Call the returned
+// function once it's closed.
+func (m *metrics) connOpened(tk tunnelKey, destination string) (closed func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextConn++
+	id := m.nextConn
+
+	m.tunnel(tk).conns[id] = agent.ConnectionStats{
+		Destination: destination,
+		Since:       time.Now(),
+	}
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		delete(m.tunnel(tk).conns, id)
+	}
+}
+
+func (m *metrics) observeDNS(tk tunnelKey, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tm := m.tunnel(tk)
+	tm.dnsQueries++
+	if err != nil {
+		tm.dnsFailures++
+	}
+	tm.dnsLastLatency = latency
+	tm.dnsTotalLatency += latency
+	if latency > tm.dnsMaxLatency {
+		tm.dnsMaxLatency = latency
+	}
+}
+
+// fill copies the tracked metrics of tk into stats.
+func (m *metrics) fill(tk tunnelKey, stats *agent.TunnelStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tm, ok := m.tunnels[tk]
+	if !ok {
+		return
+	}
+
+	stats.EstablishedAt = tm.establishedAt
+	stats.Establishes = tm.establishes
+	stats.Reestablishes = tm.reestablishes
+
+	stats.Connections = make([]agent.ConnectionStats, 0, len(tm.conns))
+	for _, c := range tm.conns {
+		stats.Connections = append(stats.Connections, c)
+	}
+	sort.Slice(stats.Connections, func(i, j int) bool {
+		return stats.Connections[i].Since.Before(stats.Connections[j].Since)
+	})
+
+	stats.DNS = agent.DNSStats{
+		Queries:     tm.dnsQueries,
+		Failures:    tm.dnsFailures,
+		LastLatency: tm.dnsLastLatency,
+		MaxLatency:  tm.dnsMaxLatency,
+	}
+	if tm.dnsQueries > 0 {
+		stats.DNS.AvgLatency = tm.dnsTotalLatency / time.Duration(tm.dnsQueries)
+	}
+}
+
+// stats describes the agent and every tunnel it currently holds.
+func (s *server) stats() *agent.StatsResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := &agent.StatsResponse{
+		PID:       os.Getpid(),
+		Version:   buildinfo.Version().String(),
+		StartedAt: s.startedAt,
+		Tunnels:   make([]agent.TunnelStats, 0, len(s.tunnels)),
+	}
+
+	for tk, tunnel := range s.tunnels {
+		stats := agent.TunnelStats{
+			Org:     tk.orgSlug,
+			Network: tk.networkName,
+		}
+
+		if ts, err := tunnel.Stats(); err == nil {
+			stats.LastHandshake = ts.LastHandshake
+			stats.RxBytes = ts.RxBytes
+			stats.TxBytes = ts.TxBytes
+		}
+
+		s.metrics.fill(tk, &stats)
+
+		res.Tunnels = append(res.Tunnels, stats)
+	}
+
+	sort.Slice(res.Tunnels, func(i, j int) bool {
+		a, b := res.Tunnels[i], res.Tunnels[j]
+		if a.Org != b.Org {
+			return a.Org < b.Org
+		}
+		return a.Network < b.Network
+	})
+
+	return res
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/superfly/flyctl/agent"
+)
+
+func TestMetricsFill(t *testing.T) {
+	var m metrics
+	tk := tunnelKey{orgSlug: "personal"}
+
+	m.established(tk, false)
+	m.established(tk, true)
+
+	closeA := m.connOpened(tk, "[fdaa::2]:22")
+	closeB := m.connOpened(tk, "[fdaa::3]:5432")
+	closeA()
+
+	m.observeDNS(tk, 10*time.Millisecond, nil)
+	m.observeDNS(tk, 30*time.Millisecond, errors.New("timeout"))
+
+	var stats agent.TunnelStats
+	m.fill(tk, &stats)
+
+	assert.Equal(t, 2, stats.Establishes)
+	assert.Equal(t, 1, stats.Reestablishes)
+	assert.False(t, stats.EstablishedAt.IsZero())
+
+	require.Len(t, stats.Connections, 1)
+	assert.Equal(t, "[fdaa::3]:5432", stats.Connections[0].Destination)
+
+	assert.Equal(t, uint64(2), stats.DNS.Queries)
+	assert.Equal(t, uint64(1), stats.DNS.Failures)
+	assert.Equal(t, 20*time.Millisecond, stats.DNS.AvgLatency)
+	assert.Equal(t, 30*time.Millisecond, stats.DNS.MaxLatency)
+	assert.Equal(t, 30*time.Millisecond, stats.DNS.LastLatency)
+
+	closeB()
+	m.fill(tk, &stats)
+	assert.Empty(t, stats.Connections)
+
+	// untracked tunnels are left alone
+	other := agent.TunnelStats{Org: "other"}
+	m.fill(tunnelKey{orgSlug: "other"}, &other)
+	assert.Zero(t, other.Establishes)
+}
+
+func TestCheckMetricsAddr(t *testing.T) {
+	assert.NoError(t, checkMetricsAddr("127.0.0.1:9464"))
+	assert.NoError(t, checkMetricsAddr("[::1]:9464"))
+	assert.NoError(t, checkMetricsAddr("localhost:9464"))
+
+	assert.ErrorIs(t, checkMetricsAddr("0.0.0.0:9464"), errMetricsAddrNotLoopback)
+	assert.ErrorIs(t, checkMetricsAddr(":9464"), errMetricsAddrNotLoopback)
+	assert.Error(t, checkMetricsAddr("127.0.0.1"))
+}
+
+func TestRPCStats(t *testing.T) {
+	ctx := context.Background()
+
+	rc, err := agent.NewRPCClient(ctx, startRPCSession(t))
+	require.NoError(t, err)
+	defer rc.Close()
+
+	var stats agent.StatsResponse
+	require.NoError(t, rc.Call(ctx, agent.MethodStats, nil, &stats))
+	assert.NotZero(t, stats.PID)
+	assert.Empty(t, stats.Tunnels)
+}
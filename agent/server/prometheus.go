@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var errMetricsAddrNotLoopback = errors.New("metrics address must be on a loopback interface")
+
+// checkMetricsAddr makes sure metrics, which include organization names and
+// private addresses, aren't exposed beyond the local machine.
+func checkMetricsAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid metrics address %q: %w", addr, err)
+	}
+
+	if host == "localhost" {
+		return nil
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return errMetricsAddrNotLoopback
+	}
+
+	return nil
+}
+
+func (s *server) serveMetrics(ctx context.Context) {
+	if err := checkMetricsAddr(s.MetricsAddr); err != nil {
+		s.printf("not serving metrics: %v", err)
+
+		return
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&collector{srv: s})
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	srv := &http.Server{
+		Addr:              s.MetricsAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	s.printf("serving metrics on http://%s/metrics", s.MetricsAddr)
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.printf("failed serving metrics: %v", err)
+	}
+}
+
+var (
+	tunnelLabels = []string{"org", "network"}
+
+	descHandshakeAge = prometheus.NewDesc("fly_agent_tunnel_handshake_age_seconds",
+		"Seconds since the last WireGuard handshake.", tunnelLabels, nil)
+	descRxBytes = prometheus.NewDesc("fly_agent_tunnel_received_bytes_total",
+		"Bytes received through the tunnel.", tunnelLabels, nil)
+	descTxBytes = prometheus.NewDesc("fly_agent_tunnel_sent_bytes_total",
+		"Bytes sent through the tunnel.", tunnelLabels, nil)
+	descConnections = prometheus.NewDesc("fly_agent_tunnel_connections",
+		"Connections currently dialed through the tunnel.", tunnelLabels, nil)
+	descEstablishes = prometheus.NewDesc("fly_agent_tunnel_establishes_total",
+		"Tunnels built for the organization and network.", tunnelLabels, nil)
+	descReestablishes = prometheus.NewDesc("fly_agent_tunnel_reestablishes_total",
+		"Tunnels rebuilt on request for the organization and network.", tunnelLabels, nil)
+	descDNSQueries = prometheus.NewDesc("fly_agent_tunnel_dns_queries_total",
+		"Queries sent to the tunnel's DNS server.", tunnelLabels, nil)
+	descDNSFailures = prometheus.NewDesc("fly_agent_tunnel_dns_failures_total",
+		"Queries to the tunnel's DNS server that failed.", tunnelLabels, nil)
+	descDNSAvgLatency = prometheus.NewDesc("fly_agent_tunnel_dns_latency_avg_seconds",
+		"Average latency of queries to the tunnel's DNS server.", tunnelLabels, nil)
+	descDNSMaxLatency = prometheus.NewDesc("fly_agent_tunnel_dns_latency_max_seconds",
+		"Highest latency of queries to the tunnel's DNS server.", tunnelLabels, nil)
+)
+
+// collector exports the server's stats whenever Prometheus scrapes them.
+type collector struct {
+	srv *server
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		descHandshakeAge, descRxBytes, descTxBytes, descConnections, descEstablishes,
+		descReestablishes, descDNSQueries, descDNSFailures, descDNSAvgLatency, descDNSMaxLatency,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+
+	for _, t := range c.srv.stats().Tunnels {
+		labels := []string{t.Org, t.Network}
+
+		metric := func(desc *prometheus.Desc, kind prometheus.ValueType, value float64) {
+			ch <- prometheus.MustNewConstMetric(desc, kind, value, labels...)
+		}
+
+		if !t.LastHandshake.IsZero() {
+			metric(descHandshakeAge, prometheus.GaugeValue, t.HandshakeAge(now).Seconds())
+		}
+		metric(descRxBytes, prometheus.CounterValue, float64(t.RxBytes))
+		metric(descTxBytes, prometheus.CounterValue, float64(t.TxBytes))
+		metric(descConnections, prometheus.GaugeValue, float64(len(t.Connections)))
+		metric(descEstablishes, prometheus.CounterValue, float64(t.Establishes))
+		metric(descReestablishes, prometheus.CounterValue, float64(t.Reestablishes))
+		metric(descDNSQueries, prometheus.CounterValue, float64(t.DNS.Queries))
+		metric(descDNSFailures, prometheus.CounterValue, float64(t.DNS.Failures))
+		metric(descDNSAvgLatency, prometheus.GaugeValue, t.DNS.AvgLatency.Seconds())
+		metric(descDNSMaxLatency, prometheus.GaugeValue, t.DNS.MaxLatency.Seconds())
+	}
+}
@@ -0,0 +1,771 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/superfly/fly-go/tokens"
+	"github.com/superfly/flyctl/agent"
+
+	"github.com/superfly/flyctl/internal/buildinfo"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flyutil"
+)
+
+// maxRPCMessageSize bounds a single protocol v2 message; stream.write
+// payloads are split by clients well below it.
+const maxRPCMessageSize = 4 << 20
+
+// streamWriteQueue bounds the stream.write requests queued for a stream.
+const streamWriteQueue = 16
+
+// rpcCapabilities are the capabilities this agent offers.
+var rpcCapabilities = []string{agent.CapabilityStreams, agent.CapabilityTokens, agent.CapabilityHalfClose}
+
+var errMalformedV2 = errors.New("malformed v2 command")
+
+// v2 switches the connection to protocol v2 and serves it until either side
+// closes it.
+func (s *session) v2(ctx context.Context, args ...string) {
+	if !s.noArgs(args, errMalformedV2) {
+		return
+	}
+
+	if !s.ok(strconv.Itoa(agent.ProtocolVersion)) {
+		return
+	}
+
+	rs := &rpcSession{
+		session: s,
+		streams: make(map[uint64]*rpcStream),
+	}
+	rs.serve(ctx)
+}
+
+type rpcSession struct {
+	*session
+
+	writeMu sync.Mutex
+	enc     *json.Encoder
+
+	negotiated bool
+
+	streamsMu  sync.Mutex
+	streams    map[uint64]*rpcStream
+	nextStream uint64
+
+	wg sync.WaitGroup
+}
+
+// rpcCall is a single request. It carries the tokens that were current when
+// the request was read, since set_token may change them while it runs.
+type rpcCall struct {
+	id     *uint64
+	method string
+	params json.RawMessage
+	tokens *tokens.Tokens
+
+	// afterReply, if set by a handler, runs once the response was written.
+	afterReply func()
+}
+
+func (c *rpcCall) unmarshal(v interface{}) error {
+	if len(c.params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(c.params, v); err != nil {
+		return &agent.RPCError{
+			Code:    agent.RPCInvalidParams,
+			Message: fmt.Sprintf("invalid params: %v", err),
+		}
+	}
+	return nil
+}
+
+type rpcHandler func(*rpcSession, context.Context, *rpcCall) (interface{}, error)
+
+func (s *rpcSession) serve(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		s.closeStreams()
+		s.wg.Wait()
+	}()
+
+	s.enc = json.NewEncoder(s.conn)
+
+	scanner := bufio.NewScanner(s.conn)
+	scanner.Buffer(make([]byte, 64<<10), maxRPCMessageSize)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		var msg agent.RPCMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			s.respond(nil, nil, &agent.RPCError{Code: agent.RPCParseError, Message: err.Error()})
+			continue
+		}
+
+		if !isStreamMethod(msg.Method) {
+			s.logger.Printf("<- (% 5d) %q", len(line), redact(line))
+		}
+
+		if msg.JSONRPC != "2.0" || msg.Method == "" {
+			s.respond(msg.ID, nil, &agent.RPCError{Code: agent.RPCInvalidRequest, Message: "invalid request"})
+			continue
+		}
+
+		s.dispatch(ctx, &msg)
+	}
+
+	if err := scanner.Err(); err != nil && !isClosed(err) {
+		s.logger.Printf("failed reading: %v", err)
+	}
+}
+
+func (s *rpcSession) dispatch(ctx context.Context, msg *agent.RPCMessage) {
+	call := &rpcCall{
+		id:     msg.ID,
+		method: msg.Method,
+		params: msg.Params,
+		tokens: s.tokens,
+	}
+
+	if !s.negotiated && msg.Method != agent.MethodHello {
+		s.respond(call.id, nil, &agent.RPCError{
+			Code:    agent.RPCInvalidRequest,
+			Message: "hello must be the first request",
+			Data:    &agent.RPCErrorData{Kind: agent.ErrorKindNotNegotiated},
+		})
+		return
+	}
+
+	var handler rpcHandler
+	inline := false
+
+	switch msg.Method {
+	case agent.MethodHello:
+		handler, inline = (*rpcSession).hello, true
+	case agent.MethodSetToken:
+		handler, inline = (*rpcSession).setToken, true
+	// stream writes, and the half-close following them, are queued in order,
+	// as they're read, and answered once they're done
+	case agent.MethodStreamWrite, agent.MethodStreamCloseWrite:
+		s.streamWrite(call)
+		return
+	case agent.MethodStreamClose:
+		handler, inline = (*rpcSession).streamClose, true
+	case agent.NotifyStreamCredit:
+		handler, inline = (*rpcSession).streamCredit, true
+	case agent.MethodPing:
+		handler = (*rpcSession).ping
+	case agent.MethodStats:
+		handler = (*rpcSession).stats
+	case agent.MethodEstablish:
+		handler = (*rpcSession).establish
+	case agent.MethodProbe:
+		handler = (*rpcSession).probe
+	case agent.MethodInstances:
+		handler = (*rpcSession).instances
+	case agent.MethodResolve:
+		handler = (*rpcSession).resolve
+	case agent.MethodLookupTXT:
+		handler = (*rpcSession).lookupTXT
+	case agent.MethodConnect:
+		handler = (*rpcSession).connect
+	default:
+		s.respond(call.id, nil, &agent.RPCError{
+			Code:    agent.RPCMethodNotFound,
+			Message: fmt.Sprintf("method not found: %s", msg.Method),
+		})
+		return
+	}
+
+	run := func() {
+		result, err := handler(s, ctx, call)
+		s.respond(call.id, result, err)
+
+		if err == nil && call.afterReply != nil {
+			call.afterReply()
+		}
+	}
+
+	if inline {
+		run()
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		run()
+	}()
+}
+
+func isStreamMethod(method string) bool {
+	switch method {
+	case agent.MethodStreamWrite, agent.MethodStreamCloseWrite, agent.MethodStreamClose, agent.NotifyStreamCredit:
+		return true
+	}
+	return false
+}
+
+// respond answers a request. Notifications, which have no id, only get an
+// answer when they're malformed.
+func (s *rpcSession) respond(id *uint64, result interface{}, err error) {
+	msg := &agent.RPCMessage{JSONRPC: "2.0", ID: id}
+
+	if err != nil {
+		msg.Error = agent.NewRPCError(agent.RPCServerError, err)
+	} else if id == nil {
+		return
+	} else {
+		if result == nil {
+			result = struct{}{}
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			msg.Error = agent.NewRPCError(agent.RPCServerError, fmt.Errorf("failed marshaling response: %w", err))
+		} else {
+			msg.Result = data
+		}
+	}
+
+	s.write(msg, true)
+}
+
+func (s *rpcSession) notify(method string, params interface{}) bool {
+	data, err := json.Marshal(params)
+	if err != nil {
+		s.logger.Printf("failed marshaling %s: %v", method, err)
+		return false
+	}
+
+	return s.write(&agent.RPCMessage{JSONRPC: "2.0", Method: method, Params: data}, false)
+}
+
+func (s *rpcSession) write(msg *agent.RPCMessage, log bool) bool {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if log {
+		if data, err := json.Marshal(msg); err == nil {
+			s.logger.Printf("-> (% 5d) %q", len(data), redact(data))
+		}
+	}
+
+	if err := s.enc.Encode(msg); err != nil {
+		if !isClosed(err) {
+			s.logger.Printf("failed writing: %v", err)
+		}
+		return false
+	}
+
+	return true
+}
+
+func (s *rpcSession) client(ctx context.Context, call *rpcCall) flyutil.Client {
+	sc := *s.session
+	sc.tokens = call.tokens
+	return sc.getClient(ctx)
+}
+
+func (s *rpcSession) hello(_ context.Context, call *rpcCall) (interface{}, error) {
+	var params agent.HelloParams
+	if err := call.unmarshal(&params); err != nil {
+		return nil, err
+	}
+
+	if params.Version < agent.ProtocolVersion {
+		return nil, &agent.RPCError{
+			Code:    agent.RPCInvalidParams,
+			Message: fmt.Sprintf("unsupported protocol version %d", params.Version),
+		}
+	}
+
+	capabilities := rpcCapabilities
+	if len(params.Capabilities) > 0 {
+		capabilities = nil
+		for _, c := range rpcCapabilities {
+			for _, requested := range params.Capabilities {
+				if c == requested {
+					capabilities = append(capabilities, c)
+				}
+			}
+		}
+	}
+
+	s.negotiated = true
+
+	return agent.HelloResult{
+		Version:      agent.ProtocolVersion,
+		AgentVersion: buildinfo.Version().String(),
+		PID:          os.Getpid(),
+		Background:   s.srv.Options.Background,
+		Capabilities: capabilities,
+	}, nil
+}
+
+func (s *rpcSession) ping(context.Context, *rpcCall) (interface{}, error) {
+	return agent.PingResponse{
+		Version:    buildinfo.Version().String(),
+		PID:        os.Getpid(),
+		Background: s.srv.Options.Background,
+	}, nil
+}
+
+func (s *rpcSession) stats(context.Context, *rpcCall) (interface{}, error) {
+	return s.srv.stats(), nil
+}
+
+func (s *rpcSession) setToken(_ context.Context, call *rpcCall) (interface{}, error) {
+	var params agent.SetTokenParams
+	if err := call.unmarshal(&params); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case params.ConfigFile != "":
+		tokStr, err := config.ReadAccessToken(params.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+
+		s.tokens = tokens.ParseFromFile(tokStr, params.ConfigFile)
+	case params.Tokens != "":
+		s.tokens = tokens.Parse(params.Tokens)
+	default:
+		return nil, &agent.RPCError{Code: agent.RPCInvalidParams, Message: "either tokens or config_file is required"}
+	}
+
+	go s.srv.UpdateTokensFromClient(s.tokens)
+
+	return nil, nil
+}
+
+func (s *rpcSession) establish(ctx context.Context, call *rpcCall) (interface{}, error) {
+	var params agent.EstablishParams
+	if err := call.unmarshal(&params); err != nil {
+		return nil, err
+	}
+	s.logger.Printf("establishing tunnel for %s, %s", params.Org, params.Network)
+
+	client := s.client(ctx, call)
+
+	org, err := fetchOrg(ctx, client, params.Org)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnel, err := s.srv.buildTunnel(ctx, org, params.Recycle, params.Network, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return agent.EstablishResponse{
+		WireGuardState: tunnel.State,
+		TunnelConfig:   tunnel.Config,
+	}, nil
+}
+
+func (s *rpcSession) probe(ctx context.Context, call *rpcCall) (interface{}, error) {
+	var params agent.ProbeParams
+	if err := call.unmarshal(&params); err != nil {
+		return nil, err
+	}
+
+	return nil, s.srv.probeTunnel(ctx, params.Org, params.Network)
+}
+
+func (s *rpcSession) instances(ctx context.Context, call *rpcCall) (interface{}, error) {
+	var params agent.InstancesParams
+	if err := call.unmarshal(&params); err != nil {
+		return nil, err
+	}
+
+	tunnel := s.srv.tunnelFor(params.Org, "")
+	if tunnel == nil {
+		return nil, agent.ErrTunnelUnavailable
+	}
+
+	ret, err := s.srv.fetchInstances(ctx, tunnel, params.App)
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching instances for %q: %w", params.App, err)
+	}
+
+	if len(ret.Addresses) == 0 {
+		return nil, fmt.Errorf("no running hosts for %q found", params.App)
+	}
+
+	return ret, nil
+}
+
+func (s *rpcSession) resolve(ctx context.Context, call *rpcCall) (interface{}, error) {
+	var params agent.ResolveParams
+	if err := call.unmarshal(&params); err != nil {
+		return nil, err
+	}
+
+	tunnel := s.srv.tunnelFor(params.Org, params.Network)
+	if tunnel == nil {
+		return nil, agent.ErrTunnelUnavailable
+	}
+
+	addr, err := resolve(ctx, tunnel, params.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	return agent.ResolveResult{Addr: addr}, nil
+}
+
+func (s *rpcSession) lookupTXT(ctx context.Context, call *rpcCall) (interface{}, error) {
+	var params agent.LookupTXTParams
+	if err := call.unmarshal(&params); err != nil {
+		return nil, err
+	}
+
+	tunnel := s.srv.tunnelFor(params.Org, "")
+	if tunnel == nil {
+		return nil, agent.ErrTunnelUnavailable
+	}
+
+	host := params.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	txt, err := tunnel.LookupTXT(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	return agent.LookupTXTResult{Records: txt}, nil
+}
+
+// connect dials addr through the tunnel and multiplexes the connection over
+// the session as a stream. Data read from it is sent as stream.data
+// notifications once the reply carrying the stream id was written.
+func (s *rpcSession) connect(ctx context.Context, call *rpcCall) (interface{}, error) {
+	var params agent.ConnectParams
+	if err := call.unmarshal(&params); err != nil {
+		return nil, err
+	}
+
+	tunnel := s.srv.tunnelFor(params.Org, params.Network)
+	if tunnel == nil {
+		return nil, agent.ErrTunnelUnavailable
+	}
+
+	dialContext, cancel := context.WithCancel(ctx)
+	if params.TimeoutMS > 0 {
+		dialContext, cancel = context.WithTimeout(ctx, time.Duration(params.TimeoutMS)*time.Millisecond)
+	}
+	defer cancel()
+
+	outconn, err := tunnel.DialContext(dialContext, "tcp", params.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.streamsMu.Lock()
+	s.nextStream++
+	stream := newRPCStream(s.nextStream, outconn)
+	s.streams[stream.id] = stream
+	s.streamsMu.Unlock()
+
+	s.logger.Printf("stream %d connected to %s", stream.id, params.Addr)
+
+	closed := s.srv.metrics.connOpened(tunnelKey{orgSlug: params.Org, networkName: params.Network}, params.Addr)
+
+	call.afterReply = func() {
+		s.wg.Add(2)
+		go func() {
+			defer s.wg.Done()
+			defer closed()
+			s.pump(stream)
+		}()
+		go func() {
+			defer s.wg.Done()
+			s.drainWrites(stream)
+		}()
+	}
+
+	return agent.ConnectResult{Stream: stream.id}, nil
+}
+
+// rpcStream is a connection multiplexed over a session.
+type rpcStream struct {
+	id   uint64
+	conn net.Conn
+
+	// writes holds stream.write and stream.close_write requests until
+	// they're done.
+	writes chan *rpcCall
+	quit   chan struct{}
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	credit int64
+	done   bool
+}
+
+func newRPCStream(id uint64, conn net.Conn) *rpcStream {
+	st := &rpcStream{
+		id:     id,
+		conn:   conn,
+		writes: make(chan *rpcCall, streamWriteQueue),
+		quit:   make(chan struct{}),
+		credit: agent.StreamWindow,
+	}
+	st.cond = sync.NewCond(&st.mu)
+	return st
+}
+
+// enqueue queues a stream.write or stream.close_write request. It fails rather than block the
+// session when the client has too many writes in flight.
+func (st *rpcStream) enqueue(call *rpcCall) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.done {
+		return errNoSuchStream(st.id)
+	}
+
+	select {
+	case st.writes <- call:
+		return nil
+	default:
+		return &agent.RPCError{
+			Code:    agent.RPCServerError,
+			Message: fmt.Sprintf("too many writes in flight for stream %d", st.id),
+			Data:    &agent.RPCErrorData{Kind: agent.ErrorKindStreamBusy},
+		}
+	}
+}
+
+// take waits until the client granted credit for more data, returning how
+// much, or 0 once the stream is closed.
+func (st *rpcStream) take(limit int) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for st.credit <= 0 && !st.done {
+		st.cond.Wait()
+	}
+
+	if st.done {
+		return 0
+	}
+	return int(min(st.credit, int64(limit)))
+}
+
+func (st *rpcStream) spend(n int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.credit -= int64(n)
+}
+
+func (st *rpcStream) grant(n int64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.credit += n
+	st.cond.Broadcast()
+}
+
+// close closes the stream's connection and stops its pump and writer.
+func (st *rpcStream) close() error {
+	st.mu.Lock()
+	if st.done {
+		st.mu.Unlock()
+		return nil
+	}
+	st.done = true
+	st.cond.Broadcast()
+	close(st.quit)
+	st.mu.Unlock()
+
+	return st.conn.Close()
+}
+
+// pump relays data read from a stream's connection to the client, as far as
+// the client granted credit for it, until the connection is done.
+func (s *rpcSession) pump(st *rpcStream) {
+	buf := make([]byte, 32<<10)
+
+	var readErr error
+	for {
+		limit := st.take(len(buf))
+		if limit == 0 {
+			readErr = net.ErrClosed
+			break
+		}
+
+		n, err := st.conn.Read(buf[:limit])
+		if n > 0 {
+			st.spend(n)
+			if !s.notify(agent.NotifyStreamData, agent.StreamDataParams{Stream: st.id, Data: buf[:n]}) {
+				readErr = net.ErrClosed
+				break
+			}
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+	}
+
+	s.streamsMu.Lock()
+	delete(s.streams, st.id)
+	s.streamsMu.Unlock()
+
+	if err := st.close(); err != nil && !isClosed(err) {
+		s.logger.Printf("failed closing stream %d: %v", st.id, err)
+	}
+
+	closed := agent.StreamClosedParams{Stream: st.id}
+	if !errors.Is(readErr, io.EOF) && !isClosed(readErr) {
+		closed.Error = agent.NewRPCError(agent.RPCServerError, readErr)
+	}
+	s.notify(agent.NotifyStreamClosed, closed)
+
+	s.logger.Printf("stream %d closed", st.id)
+}
+
+// drainWrites writes queued stream.write requests to the stream's connection,
+// or half-closes it, and answers them, in order, until the stream is closed.
+func (s *rpcSession) drainWrites(st *rpcStream) {
+	for {
+		select {
+		case call := <-st.writes:
+			s.writeStream(st, call)
+		case <-st.quit:
+			// nothing is queued once the stream is closed; fail what was
+			for {
+				select {
+				case call := <-st.writes:
+					s.respond(call.id, nil, errNoSuchStream(st.id))
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *rpcSession) writeStream(st *rpcStream, call *rpcCall) {
+	if call.method == agent.MethodStreamCloseWrite {
+		s.respond(call.id, nil, closeWrite(st.conn))
+		return
+	}
+
+	var params agent.StreamWriteParams
+	if err := call.unmarshal(&params); err != nil {
+		s.respond(call.id, nil, err)
+		return
+	}
+
+	_, err := st.conn.Write(params.Data)
+	s.respond(call.id, nil, err)
+}
+
+// closeWrite shuts down the writing side of conn, so the other end reads EOF
+// while data still flows back.
+func closeWrite(conn net.Conn) error {
+	cw, ok := conn.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.New("connection doesn't support half-close")
+	}
+	return cw.CloseWrite()
+}
+
+func errNoSuchStream(id uint64) error {
+	return &agent.RPCError{
+		Code:    agent.RPCInvalidParams,
+		Message: fmt.Sprintf("no such stream: %d", id),
+		Data:    &agent.RPCErrorData{Kind: agent.ErrorKindNoSuchStream},
+	}
+}
+
+func (s *rpcSession) stream(id uint64) (*rpcStream, error) {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+
+	st, ok := s.streams[id]
+	if !ok {
+		return nil, errNoSuchStream(id)
+	}
+	return st, nil
+}
+
+// streamWrite queues a write to, or the half-close of, a stream's connection.
+// It's answered once that's done, so a slow stream holds up neither the session nor other
+// streams.
+func (s *rpcSession) streamWrite(call *rpcCall) {
+	var params struct {
+		Stream uint64 `json:"stream"`
+	}
+	if err := call.unmarshal(&params); err != nil {
+		s.respond(call.id, nil, err)
+		return
+	}
+
+	st, err := s.stream(params.Stream)
+	if err == nil {
+		err = st.enqueue(call)
+	}
+	if err != nil {
+		s.respond(call.id, nil, err)
+	}
+}
+
+func (s *rpcSession) streamCredit(_ context.Context, call *rpcCall) (interface{}, error) {
+	var params agent.StreamCreditParams
+	if err := call.unmarshal(&params); err != nil {
+		return nil, err
+	}
+
+	// the stream may have finished while the client consumed its data
+	if st, err := s.stream(params.Stream); err == nil && params.Bytes > 0 {
+		st.grant(params.Bytes)
+	}
+	return nil, nil
+}
+
+func (s *rpcSession) streamClose(_ context.Context, call *rpcCall) (interface{}, error) {
+	var params agent.StreamCloseParams
+	if err := call.unmarshal(&params); err != nil {
+		return nil, err
+	}
+
+	st, err := s.stream(params.Stream)
+	if err != nil {
+		return nil, err
+	}
+
+	// pump notices the closed stream, drops it and tells the client
+	if err := st.close(); err != nil && !isClosed(err) {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (s *rpcSession) closeStreams() {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+
+	for _, st := range s.streams {
+		_ = st.close()
+	}
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/superfly/flyctl/agent"
+	"github.com/superfly/flyctl/agent/internal/proto"
+	"github.com/superfly/flyctl/wg"
+)
+
+// startRPCSession serves one connection of a server without tunnels and
+// returns the client end.
+func startRPCSession(t *testing.T) net.Conn {
+	t.Helper()
+
+	configFile := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(configFile, nil, 0o600))
+
+	srv := &server{
+		Options: Options{
+			Logger:     log.New(io.Discard, "", 0),
+			ConfigFile: configFile,
+		},
+		currentChange: time.Now().Add(time.Hour),
+		tunnels:       make(map[tunnelKey]*wg.Tunnel),
+	}
+
+	clientConn, serverConn := net.Pipe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runSession(ctx, srv, serverConn, 1)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		clientConn.Close()
+		<-done
+	})
+
+	return clientConn
+}
+
+func TestRPCHelloAndPing(t *testing.T) {
+	ctx := context.Background()
+
+	rc, err := agent.NewRPCClient(ctx, startRPCSession(t))
+	require.NoError(t, err)
+	defer rc.Close()
+
+	hello := rc.Hello()
+	assert.Equal(t, agent.ProtocolVersion, hello.Version)
+	assert.True(t, hello.HasCapability(agent.CapabilityStreams))
+	assert.True(t, hello.HasCapability(agent.CapabilityTokens))
+
+	var ping agent.PingResponse
+	require.NoError(t, rc.Call(ctx, agent.MethodPing, nil, &ping))
+	assert.Equal(t, os.Getpid(), ping.PID)
+}
+
+func TestRPCStructuredErrors(t *testing.T) {
+	ctx := context.Background()
+
+	rc, err := agent.NewRPCClient(ctx, startRPCSession(t))
+	require.NoError(t, err)
+	defer rc.Close()
+
+	err = rc.Call(ctx, "nope", nil, nil)
+	var rpcErr *agent.RPCError
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, agent.RPCMethodNotFound, rpcErr.Code)
+
+	// requests run concurrently over the same connection
+	errs := make(chan error, 2)
+	for _, host := range []string{"a.internal", "b.internal"} {
+		go func() {
+			errs <- rc.Call(ctx, agent.MethodResolve, agent.ResolveParams{Org: "personal", Host: host}, nil)
+		}()
+	}
+	for range 2 {
+		err := <-errs
+		require.True(t, errors.As(err, &rpcErr))
+		assert.Equal(t, agent.ErrorKindTunnelUnavailable, rpcErr.Kind())
+		assert.ErrorIs(t, err, agent.ErrTunnelUnavailable)
+	}
+
+	_, err = rc.Connect(ctx, "personal", "[fdaa::3]:22", "", time.Second)
+	assert.ErrorIs(t, err, agent.ErrTunnelUnavailable)
+}
+
+func TestRPCRequiresHello(t *testing.T) {
+	conn := startRPCSession(t)
+
+	require.NoError(t, proto.Write(conn, agent.V2Command))
+	data, err := proto.Read(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "ok 2", string(data))
+
+	_, err = io.WriteString(conn, `{"jsonrpc":"2.0","id":1,"method":"ping"}`+"\n")
+	require.NoError(t, err)
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	require.NoError(t, err)
+
+	var msg agent.RPCMessage
+	require.NoError(t, json.Unmarshal(line, &msg))
+	require.NotNil(t, msg.Error)
+	assert.Equal(t, agent.ErrorKindNotNegotiated, msg.Error.Kind())
+	assert.Equal(t, uint64(1), *msg.ID)
+}
+
+// newTestRPCSession returns a negotiated session, without a connection
+// loop, and a reader of what it writes.
+func newTestRPCSession(t *testing.T) (*rpcSession, *bufio.Reader) {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+
+	rs := &rpcSession{
+		session: &session{
+			srv:    &server{Options: Options{Logger: log.New(io.Discard, "", 0)}},
+			conn:   serverConn,
+			logger: log.New(io.Discard, "", 0),
+		},
+		enc:        json.NewEncoder(serverConn),
+		negotiated: true,
+		streams:    make(map[uint64]*rpcStream),
+	}
+	return rs, bufio.NewReader(clientConn)
+}
+
+func readRPCMessage(t *testing.T, r *bufio.Reader) *agent.RPCMessage {
+	t.Helper()
+
+	line, err := r.ReadBytes('\n')
+	require.NoError(t, err)
+
+	var msg agent.RPCMessage
+	require.NoError(t, json.Unmarshal(line, &msg))
+	return &msg
+}
+
+func TestRPCStreamCredit(t *testing.T) {
+	rs, r := newTestRPCSession(t)
+
+	upstream, remote := net.Pipe()
+	defer remote.Close()
+
+	st := newRPCStream(1, upstream)
+	st.credit = 4
+	rs.streams[st.id] = st
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rs.pump(st)
+	}()
+	go remote.Write([]byte("0123456789"))
+
+	msg := readRPCMessage(t, r)
+	var data agent.StreamDataParams
+	require.Equal(t, agent.NotifyStreamData, msg.Method)
+	require.NoError(t, json.Unmarshal(msg.Params, &data))
+	assert.Equal(t, "0123", string(data.Data))
+
+	// nothing more is sent until the client grants credit
+	more := make(chan *agent.RPCMessage, 1)
+	go func() {
+		more <- readRPCMessage(t, r)
+	}()
+	select {
+	case <-more:
+		t.Fatal("stream data sent without credit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	params, _ := json.Marshal(agent.StreamCreditParams{Stream: 1, Bytes: 6})
+	rs.dispatch(context.Background(), &agent.RPCMessage{JSONRPC: "2.0", Method: agent.NotifyStreamCredit, Params: params})
+
+	msg = <-more
+	require.NoError(t, json.Unmarshal(msg.Params, &data))
+	assert.Equal(t, "456789", string(data.Data))
+
+	require.NoError(t, st.close())
+
+	msg = readRPCMessage(t, r)
+	assert.Equal(t, agent.NotifyStreamClosed, msg.Method)
+	<-done
+}
+
+func TestRPCStreamWriteDoesntBlockSession(t *testing.T) {
+	rs, r := newTestRPCSession(t)
+	ctx := context.Background()
+
+	// nobody reads from the stream's connection, so writes to it block
+	upstream, remote := net.Pipe()
+	defer remote.Close()
+
+	st := newRPCStream(1, upstream)
+	rs.streams[st.id] = st
+	go rs.drainWrites(st)
+	defer st.close()
+
+	writeID, pingID := uint64(1), uint64(2)
+	params, _ := json.Marshal(agent.StreamWriteParams{Stream: 1, Data: []byte("hello")})
+	rs.dispatch(ctx, &agent.RPCMessage{JSONRPC: "2.0", ID: &writeID, Method: agent.MethodStreamWrite, Params: params})
+	rs.dispatch(ctx, &agent.RPCMessage{JSONRPC: "2.0", ID: &pingID, Method: agent.MethodPing})
+
+	msg := readRPCMessage(t, r)
+	assert.Equal(t, pingID, *msg.ID)
+
+	buf := make([]byte, 5)
+	_, err := io.ReadFull(remote, buf)
+	require.NoError(t, err)
+
+	msg = readRPCMessage(t, r)
+	assert.Equal(t, writeID, *msg.ID)
+	assert.Nil(t, msg.Error)
+}
+
+func TestRPCStreamCloseWrite(t *testing.T) {
+	rs, r := newTestRPCSession(t)
+	ctx := context.Background()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	upstream, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	remote, err := l.Accept()
+	require.NoError(t, err)
+	defer remote.Close()
+
+	st := newRPCStream(1, upstream)
+	rs.streams[st.id] = st
+	go rs.drainWrites(st)
+	defer st.close()
+
+	writeID, closeWriteID := uint64(1), uint64(2)
+	params, _ := json.Marshal(agent.StreamWriteParams{Stream: 1, Data: []byte("hello")})
+	rs.dispatch(ctx, &agent.RPCMessage{JSONRPC: "2.0", ID: &writeID, Method: agent.MethodStreamWrite, Params: params})
+	params, _ = json.Marshal(agent.StreamCloseParams{Stream: 1})
+	rs.dispatch(ctx, &agent.RPCMessage{JSONRPC: "2.0", ID: &closeWriteID, Method: agent.MethodStreamCloseWrite, Params: params})
+
+	msg := readRPCMessage(t, r)
+	assert.Equal(t, writeID, *msg.ID)
+	assert.Nil(t, msg.Error)
+	msg = readRPCMessage(t, r)
+	assert.Equal(t, closeWriteID, *msg.ID)
+	assert.Nil(t, msg.Error)
+
+	// the remote end reads what was written before the half-close, then EOF
+	data, err := io.ReadAll(remote)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// while it can still send data back
+	_, err = remote.Write([]byte("bye"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(upstream, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "bye", string(buf))
+}
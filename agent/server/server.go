@@ -32,6 +32,9 @@ type Options struct {
 	Background       bool
 	ConfigFile       string
 	ConfigWebsockets bool
+	// MetricsAddr, if set, is a loopback address to serve Prometheus
+	// metrics on
+	MetricsAddr string
 }
 
 func Run(ctx context.Context, opt Options) (err error) {
@@ -63,6 +66,7 @@ func Run(ctx context.Context, opt Options) (err error) {
 		Options:               opt,
 		listener:              l,
 		runCtx:                ctx,
+		startedAt:             time.Now(),
 		currentChange:         latestChangeAt,
 		tunnels:               make(map[tunnelKey]*wg.Tunnel),
 		tokens:                toks,
@@ -129,6 +133,9 @@ type server struct {
 	tunnels               map[tunnelKey]*wg.Tunnel
 	tokens                *tokens.Tokens
 	cancelTokenMonitoring func()
+
+	startedAt time.Time
+	metrics   metrics
 }
 
 type terminateError struct{ error }
@@ -156,6 +163,14 @@ func (s *server) serve(parent context.Context, l net.Listener) (err error) {
 		return nil
 	})
 
+	if s.MetricsAddr != "" {
+		eg.Go(func() error {
+			s.serveMetrics(ctx)
+
+			return nil
+		})
+	}
+
 	eg.Go(func() (err error) {
 		s.printf("OK %d", os.Getpid())
 		defer s.print("QUIT")
@@ -255,6 +270,11 @@ func (s *server) buildTunnel(ctx context.Context, org *fly.Organization, reestab
 		}
 	}
 
+	tunnel.OnDNSQuery = func(latency time.Duration, err error) {
+		s.metrics.observeDNS(tk, latency, err)
+	}
+	s.metrics.established(tk, reestablish)
+
 	s.tunnels[tk] = tunnel
 
 	return
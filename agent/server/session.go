@@ -110,12 +110,16 @@ func (s *session) runCommand(ctx context.Context) {
 		handler = (*session).kill
 	case "ping":
 		handler = (*session).ping
+	case "stats":
+		handler = (*session).stats
 	case "establish":
 		handler = (*session).establish
 	case "reestablish":
 		handler = (*session).reestablish
 	case "connect":
 		handler = (*session).connect
+	case "connect-udp":
+		handler = (*session).connectUDP
 	case "probe":
 		handler = (*session).probe
 	case "instances":
@@ -128,6 +132,8 @@ func (s *session) runCommand(ctx context.Context) {
 		handler = (*session).ping6
 	case "set-token":
 		handler = (*session).setToken
+	case agent.V2Command:
+		handler = (*session).v2
 	default:
 		s.error(errUnsupportedCommand)
 		return
@@ -162,6 +168,16 @@ func (s *session) ping(_ context.Context, args ...string) {
 	})
 }
 
+var errMalformedStats = errors.New("malformed stats command")
+
+func (s *session) stats(_ context.Context, args ...string) {
+	if !s.noArgs(args, errMalformedStats) {
+		return
+	}
+
+	_ = s.marshal(s.srv.stats())
+}
+
 var errMalformedEstablish = errors.New("malformed establish command")
 
 func (s *session) doEstablish(ctx context.Context, recycle bool, args ...string) {
@@ -170,14 +186,16 @@ func (s *session) doEstablish(ctx context.Context, recycle bool, args ...string)
 	}
 	s.logger.Printf("establishing tunnel for %s, %s", args[0], args[1])
 
-	org, err := s.fetchOrg(ctx, args[0])
+	client := s.getClient(ctx)
+
+	org, err := fetchOrg(ctx, client, args[0])
 	if err != nil {
 		s.error(err)
 
 		return
 	}
 
-	tunnel, err := s.srv.buildTunnel(ctx, org, recycle, args[1], s.getClient(ctx))
+	tunnel, err := s.srv.buildTunnel(ctx, org, recycle, args[1], client)
 	if err != nil {
 		s.error(err)
 
@@ -198,10 +216,8 @@ func (s *session) reestablish(ctx context.Context, args ...string) {
 	s.doEstablish(ctx, true, args...)
 }
 
-var errNoSuchOrg = errors.New("no such organization")
-
-func (s *session) fetchOrg(ctx context.Context, slug string) (*fly.Organization, error) {
-	orgs, err := s.getClient(ctx).GetOrganizations(ctx)
+func fetchOrg(ctx context.Context, client flyutil.Client, slug string) (*fly.Organization, error) {
+	orgs, err := client.GetOrganizations(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -213,7 +229,7 @@ func (s *session) fetchOrg(ctx context.Context, slug string) (*fly.Organization,
 		}
 	}
 
-	return nil, errNoSuchOrg
+	return nil, agent.ErrNoSuchOrg
 }
 
 var errMalformedProbe = errors.New("malformed probe command")
@@ -402,6 +418,7 @@ func (s *session) connect(ctx context.Context, args ...string) {
 			s.logger.Printf("failed closing outconn: %v", err)
 		}
 	}()
+	defer s.srv.metrics.connOpened(tunnelKey{orgSlug: args[0], networkName: args[3]}, args[1])()
 
 	if !s.ok() {
 		return
@@ -437,6 +454,92 @@ func (s *session) connect(ctx context.Context, args ...string) {
 	_ = eg.Wait()
 }
 
+var errMalformedConnectUDP = errors.New("malformed connect-udp command")
+
+// connectUDP is the datagram counterpart of connect. After the ok, every
+// datagram travels as a frame in either direction, see proto.WriteDatagram.
+func (s *session) connectUDP(ctx context.Context, args ...string) {
+	if !s.exactArgs(4, args, errMalformedConnectUDP) {
+		return
+	}
+
+	timeout, err := strconv.ParseUint(args[2], 10, 32)
+	if err != nil {
+		s.error(err)
+
+		return
+	}
+
+	tunnel := s.srv.tunnelFor(args[0], args[3])
+	if tunnel == nil {
+		s.error(agent.ErrTunnelUnavailable)
+
+		return
+	}
+
+	dialContext, cancel := context.WithCancel(ctx)
+	if timeout > 0 {
+		dialContext, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+	}
+	defer cancel()
+
+	outconn, err := tunnel.DialContext(dialContext, "udp", args[1])
+	if err != nil {
+		s.error(err)
+
+		return
+	}
+	defer func() {
+		if err := outconn.Close(); err != nil && !isClosed(err) {
+			s.logger.Printf("failed closing outconn: %v", err)
+		}
+	}()
+	defer s.srv.metrics.connOpened(tunnelKey{orgSlug: args[0], networkName: args[3]}, args[1]+" (udp)")()
+
+	if !s.ok() {
+		return
+	}
+
+	var eg *errgroup.Group
+	eg, ctx = errgroup.WithContext(ctx)
+
+	eg.Go(func() error {
+		<-ctx.Done()
+		_ = s.conn.Close()
+		_ = outconn.Close()
+
+		return errDone
+	})
+
+	eg.Go(func() error {
+		buf := make([]byte, proto.MaxDatagram)
+		for {
+			n, err := outconn.Read(buf)
+			if err != nil {
+				return err
+			}
+			if err := proto.WriteDatagram(s.conn, buf[:n]); err != nil {
+				return err
+			}
+		}
+	})
+
+	eg.Go(func() error {
+		buf := make([]byte, proto.MaxDatagram)
+		for {
+			n, err := proto.ReadDatagram(s.conn, buf)
+			if err != nil {
+				return err
+			}
+			if _, err := outconn.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+	})
+
+	_ = eg.Wait()
+}
+
 func (s *session) ping6(ctx context.Context, args ...string) {
 	// As with "dial", "ping6" handles an agent command and then
 	// repurposes the agent connection as a transport.
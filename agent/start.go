@@ -45,6 +45,12 @@ func StartDaemon(ctx context.Context) (*Client, error) {
 	env := os.Environ()
 	env = append(env, "FLY_NO_UPDATE_CHECK=1")
 
+	// the agent serves a single profile, so make sure it picks ours however
+	// we picked it
+	if profile := config.ProfileFromContext(ctx); profile != config.DefaultProfile {
+		env = append(env, fmt.Sprintf("%s=%s", config.ProfileEnvKey, profile))
+	}
+
 	// if our tokens came from the config file, let agent get them there too
 	if toks := config.Tokens(ctx); toks.FromFile() == "" {
 		env = append(env, fmt.Sprintf("FLY_API_TOKEN=%s", config.Tokens(ctx).All()))
@@ -97,12 +103,12 @@ func (alreadyStartingError) Error() string {
 	return "another process is already starting the agent"
 }
 
-func lockPath() string {
-	return filepath.Join(flyctl.ConfigDir(), "flyctl.agent.start.lock")
+func lockPath(ctx context.Context) string {
+	return filepath.Join(flyctl.ConfigDir(), lockPrefix(config.ProfileFromContext(ctx))+".start.lock")
 }
 
 func lock(ctx context.Context) (unlock filemu.UnlockFunc, err error) {
-	switch unlock, err = filemu.Lock(ctx, lockPath()); {
+	switch unlock, err = filemu.Lock(ctx, lockPath(ctx)); {
 	case err == nil:
 		break // all done
 	case ctx.Err() != nil:
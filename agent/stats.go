@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/superfly/flyctl/agent/internal/proto"
+)
+
+// StatsResponse describes the agent and the state of its tunnels.
+type StatsResponse struct {
+	PID       int
+	Version   string
+	StartedAt time.Time
+	Tunnels   []TunnelStats
+}
+
+// TunnelStats describes a single WireGuard tunnel.
+type TunnelStats struct {
+	Org     string
+	Network string `json:",omitempty"`
+
+	EstablishedAt time.Time
+	// LastHandshake is zero if the peer never completed a handshake
+	LastHandshake time.Time
+	RxBytes       uint64
+	TxBytes       uint64
+
+	// Establishes counts tunnels built for this organization and network,
+	// Reestablishes those that replaced a working tunnel on request.
+	Establishes   int
+	Reestablishes int
+
+	Connections []ConnectionStats
+	DNS         DNSStats
+}
+
+// HandshakeAge is the time since the last handshake, or zero if there was
+// none.
+func (t *TunnelStats) HandshakeAge(now time.Time) time.Duration {
+	if t.LastHandshake.IsZero() {
+		return 0
+	}
+	return now.Sub(t.LastHandshake)
+}
+
+// ConnectionStats describes a connection dialed through a tunnel.
+type ConnectionStats struct {
+	Destination string
+	Since       time.Time
+}
+
+// DNSStats summarizes queries sent to a tunnel's DNS server.
+type DNSStats struct {
+	Queries     uint64
+	Failures    uint64
+	LastLatency time.Duration
+	AvgLatency  time.Duration
+	MaxLatency  time.Duration
+}
+
+// Stats returns the agent's tunnel metrics.
+func (c *Client) Stats(ctx context.Context) (res *StatsResponse, err error) {
+	if rc := c.session(ctx); rc != nil {
+		res = &StatsResponse{}
+		if err = rc.Call(ctx, MethodStats, nil, res); err != nil {
+			res = nil
+		}
+		return
+	}
+
+	err = c.doNoTokens(ctx, func(conn net.Conn) (err error) {
+		if err = proto.Write(conn, "stats"); err != nil {
+			return
+		}
+
+		var data []byte
+		if data, err = proto.Read(conn); err != nil {
+			return
+		}
+
+		switch {
+		case isOK(data):
+			res = &StatsResponse{}
+			err = unmarshal(res, data)
+		case isError(data):
+			err = extractError(data)
+		default:
+			err = errInvalidResponse(data)
+		}
+
+		return
+	})
+
+	return
+}
@@ -0,0 +1,178 @@
+package appsecrets
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/superfly/fly-go"
+
+	"github.com/superfly/flyctl/internal/state"
+	"github.com/superfly/flyctl/terminal"
+)
+
+// HistoryEntry records one change to an app secret. It never holds the value,
+// only its digest.
+//
+// The history is kept locally, in the config directory, so it only covers
+// changes made from this machine.
+type HistoryEntry struct {
+	Time time.Time `json:"time"`
+	Name string    `json:"name"`
+	// Action is set or unset, or the reason passed to UpdateWithReason.
+	Action string `json:"action"`
+	Digest string `json:"digest,omitempty"`
+	// Version is the app's secrets version after the change.
+	Version uint64 `json:"version"`
+	// Release is the version of the release that deployed the change, if
+	// one did yet.
+	Release int `json:"release,omitempty"`
+}
+
+func historyPath(ctx context.Context, appName string) string {
+	return filepath.Join(state.ConfigDirectory(ctx), "secrets-history", appName+".jsonl")
+}
+
+// historyEntries describes the changes an update made.
+func historyEntries(resp *fly.UpdateAppSecretsResp, setSecrets map[string]string, unsetSecrets []string, reason string, at time.Time) []HistoryEntry {
+	digests := map[string]string{}
+	for _, secret := range resp.Secrets {
+		digests[secret.Name] = secret.Digest
+	}
+
+	var entries []HistoryEntry
+	add := func(name, action string) {
+		if reason != "" {
+			action = reason
+		}
+		entries = append(entries, HistoryEntry{
+			Time:    at,
+			Name:    name,
+			Action:  action,
+			Digest:  digests[name],
+			Version: resp.Version,
+		})
+	}
+
+	names := make([]string, 0, len(setSecrets))
+	for name := range setSecrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		add(name, "set")
+	}
+	for _, name := range unsetSecrets {
+		add(name, "unset")
+	}
+
+	return entries
+}
+
+// recordHistory appends entries to the app's secrets history. The history is
+// informational, so failing to write it is not an error.
+func recordHistory(ctx context.Context, appName string, entries []HistoryEntry) {
+	if err := appendHistory(historyPath(ctx, appName), entries); err != nil {
+		terminal.Debugf("Could not record secrets history for %s: %v\n", appName, err)
+	}
+}
+
+func appendHistory(path string, entries []HistoryEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	return f.Close()
+}
+
+// RecordRelease notes that release deployed the app's secrets up to version
+// secretsVersion, or its latest secrets if secretsVersion is nil, in the
+// changes that no release had deployed yet.
+func RecordRelease(ctx context.Context, appName string, secretsVersion *uint64, release int) {
+	if err := recordRelease(historyPath(ctx, appName), secretsVersion, release); err != nil {
+		terminal.Debugf("Could not record release in secrets history for %s: %v\n", appName, err)
+	}
+}
+
+func recordRelease(path string, secretsVersion *uint64, release int) error {
+	entries, err := readHistory(path)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+
+	changed := false
+	for i := range entries {
+		if entries[i].Release != 0 || (secretsVersion != nil && entries[i].Version > *secretsVersion) {
+			continue
+		}
+		entries[i].Release = release
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := appendHistory(tmp, entries); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// History returns the secret changes this flyctl has recorded for appName,
+// oldest first. Changes made elsewhere, like the dashboard or another
+// machine, are not included.
+func History(ctx context.Context, appName string) ([]HistoryEntry, error) {
+	return readHistory(historyPath(ctx, appName))
+}
+
+func readHistory(path string) ([]HistoryEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []HistoryEntry
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
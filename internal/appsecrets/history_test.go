@@ -0,0 +1,92 @@
+package appsecrets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/fly-go"
+
+	"github.com/superfly/flyctl/internal/state"
+)
+
+func TestHistory(t *testing.T) {
+	ctx := state.WithConfigDirectory(context.Background(), t.TempDir())
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	resp := &fly.UpdateAppSecretsResp{
+		Version: 7,
+		Secrets: []fly.AppSecret{
+			{Name: "B", Digest: "bbb"},
+			{Name: "A", Digest: "aaa"},
+			{Name: "UNTOUCHED", Digest: "zzz"},
+		},
+	}
+
+	entries := historyEntries(resp, map[string]string{"B": "2", "A": "1"}, []string{"GONE"}, "", at)
+	assert.Equal(t, []HistoryEntry{
+		{Time: at, Name: "A", Action: "set", Digest: "aaa", Version: 7},
+		{Time: at, Name: "B", Action: "set", Digest: "bbb", Version: 7},
+		{Time: at, Name: "GONE", Action: "unset", Version: 7},
+	}, entries)
+
+	recordHistory(ctx, "my-app", entries[:1])
+	recordHistory(ctx, "my-app", historyEntries(resp, map[string]string{"B": "2"}, nil, "rotate", at))
+
+	history, err := History(ctx, "my-app")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "A", history[0].Name)
+	assert.Equal(t, "rotate", history[1].Action)
+	assert.True(t, history[1].Time.Equal(at))
+
+	history, err = History(ctx, "other-app")
+	require.NoError(t, err)
+	assert.Empty(t, history)
+}
+
+func TestRecordRelease(t *testing.T) {
+	ctx := state.WithConfigDirectory(context.Background(), t.TempDir())
+
+	recordHistory(ctx, "my-app", []HistoryEntry{
+		{Name: "A", Action: "set", Version: 3},
+		{Name: "B", Action: "set", Version: 4},
+	})
+
+	// the release deployed secrets up to version 3, which leaves B staged
+	v := uint64(3)
+	RecordRelease(ctx, "my-app", &v, 12)
+
+	recordHistory(ctx, "my-app", []HistoryEntry{{Name: "C", Action: "set", Version: 5}})
+	RecordRelease(ctx, "my-app", nil, 13)
+
+	history, err := History(ctx, "my-app")
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, 12, history[0].Release)
+	assert.Equal(t, 13, history[1].Release)
+	assert.Equal(t, 13, history[2].Release)
+}
+
+func TestPendingRotation(t *testing.T) {
+	ctx := state.WithConfigDirectory(context.Background(), t.TempDir())
+	until := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	_, pending, err := PendingRotation(ctx, "my-app", "API_KEY")
+	require.NoError(t, err)
+	assert.False(t, pending)
+
+	require.NoError(t, SetPendingRotation(ctx, "my-app", "API_KEY", until))
+
+	got, pending, err := PendingRotation(ctx, "my-app", "API_KEY")
+	require.NoError(t, err)
+	assert.True(t, pending)
+	assert.True(t, got.Equal(until))
+
+	require.NoError(t, FinishRotation(ctx, "my-app", "API_KEY"))
+	_, pending, err = PendingRotation(ctx, "my-app", "API_KEY")
+	require.NoError(t, err)
+	assert.False(t, pending)
+}
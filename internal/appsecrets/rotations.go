@@ -0,0 +1,83 @@
+package appsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/superfly/flyctl/internal/state"
+)
+
+// Rotations started with a grace period keep the old value of a secret as
+// NAME_PREVIOUS until they're finished. When each grace period ends is kept
+// next to the secrets history.
+
+func rotationsPath(ctx context.Context, appName string) string {
+	return filepath.Join(state.ConfigDirectory(ctx), "secrets-rotations", appName+".json")
+}
+
+// PendingRotation returns when the grace period of the rotation of the
+// secret name ends, if one was started from this machine and isn't finished.
+func PendingRotation(ctx context.Context, appName, name string) (time.Time, bool, error) {
+	pending, err := readRotations(rotationsPath(ctx, appName))
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	until, ok := pending[name]
+	return until, ok, nil
+}
+
+// SetPendingRotation records that the grace period of the rotation of the
+// secret name ends at until.
+func SetPendingRotation(ctx context.Context, appName, name string, until time.Time) error {
+	return updateRotations(rotationsPath(ctx, appName), func(pending map[string]time.Time) {
+		pending[name] = until
+	})
+}
+
+// FinishRotation forgets the pending rotation of the secret name.
+func FinishRotation(ctx context.Context, appName, name string) error {
+	return updateRotations(rotationsPath(ctx, appName), func(pending map[string]time.Time) {
+		delete(pending, name)
+	})
+}
+
+func readRotations(path string) (map[string]time.Time, error) {
+	pending := map[string]time.Time{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return pending, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+func updateRotations(path string, update func(map[string]time.Time)) error {
+	pending, err := readRotations(path)
+	if err != nil {
+		return err
+	}
+
+	update(pending)
+
+	data, err := json.MarshalIndent(pending, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
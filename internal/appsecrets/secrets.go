@@ -5,6 +5,7 @@ import (
 	crand "crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"time"
 
 	"github.com/superfly/fly-go"
 
@@ -23,30 +24,47 @@ func List(ctx context.Context, client flapsutil.FlapsClient, appName string) ([]
 
 // Update sets setSecrets and unsets unsetSecrets. client must be a flaps client for appName.
 // It is not an error to unset a secret that does not exist.
-// Update will keep track of the secrets minvers for appName after successfully changing secrets.
+// Update will keep track of the secrets minvers for appName after successfully changing secrets,
+// and records the changes in the app's secrets history.
 func Update(ctx context.Context, client flapsutil.FlapsClient, appName string, setSecrets map[string]string, unsetSecrets []string) error {
-	update := map[string]*string{}
+	return UpdateWithReason(ctx, client, appName, setSecrets, unsetSecrets, "")
+}
+
+// UpdateWithReason is Update, recording reason rather than set or unset as
+// the action in the secrets history.
+func UpdateWithReason(ctx context.Context, client flapsutil.FlapsClient, appName string, setSecrets map[string]string, unsetSecrets []string, reason string) error {
+	resp, err := update(ctx, client, appName, setSecrets, unsetSecrets)
+	if err != nil || resp == nil {
+		return err
+	}
+
+	recordHistory(ctx, appName, historyEntries(resp, setSecrets, unsetSecrets, reason, time.Now()))
+	return nil
+}
+
+func update(ctx context.Context, client flapsutil.FlapsClient, appName string, setSecrets map[string]string, unsetSecrets []string) (*fly.UpdateAppSecretsResp, error) {
+	values := map[string]*string{}
 	for name, value := range setSecrets {
 		value := value
-		update[name] = &value
+		values[name] = &value
 	}
 	for _, name := range unsetSecrets {
-		update[name] = nil
+		values[name] = nil
 	}
 
-	if len(update) == 0 {
-		return nil
+	if len(values) == 0 {
+		return nil, nil
 	}
 
-	resp, err := client.UpdateAppSecrets(ctx, appName, update)
+	resp, err := client.UpdateAppSecrets(ctx, appName, values)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := SetMinvers(ctx, appName, resp.Version); err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return resp, nil
 }
 
 // Sync sets the min version for the app to the current min version, allowing
@@ -58,5 +76,6 @@ func Sync(ctx context.Context, client flapsutil.FlapsClient, appName string) err
 	_, _ = crand.Read(rand)
 	bogusDummySecret := fmt.Sprintf("BogusDummySecret_%s", hex.EncodeToString(rand))
 	unsetSecrets := []string{bogusDummySecret}
-	return Update(ctx, client, appName, nil, unsetSecrets)
+	_, err := update(ctx, client, appName, nil, unsetSecrets)
+	return err
 }
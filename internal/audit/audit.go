@@ -0,0 +1,206 @@
+// Package audit implements the local audit trail of the mutating operations
+// flyctl commands perform.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// Entry is a single command recorded in the audit log.
+type Entry struct {
+	Command  string            `json:"command"`
+	Args     []string          `json:"args,omitempty"`
+	Flags    map[string]string `json:"flags,omitempty"`
+	App      string            `json:"app,omitempty"`
+	Org      string            `json:"org,omitempty"`
+	Identity string            `json:"identity,omitempty"`
+	Profile  string            `json:"profile,omitempty"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Status   string            `json:"status"`
+	Error    string            `json:"error,omitempty"`
+	Machines []string          `json:"machines,omitempty"`
+	Releases []string          `json:"releases,omitempty"`
+}
+
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+var (
+	mu       sync.Mutex
+	mutated  bool
+	machines []string
+	releases []string
+)
+
+// Touched reports whether the process performed a mutating API call, along
+// with the IDs of the machines and releases those calls touched.
+func Touched() (bool, []string, []string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return mutated, slices.Clone(machines), slices.Clone(releases)
+}
+
+// Reset forgets the API calls recorded so far.
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	mutated, machines, releases = false, nil, nil
+}
+
+func touch(machineID, releaseID string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	mutated = true
+	if machineID != "" && !slices.Contains(machines, machineID) {
+		machines = append(machines, machineID)
+	}
+	if releaseID != "" && !slices.Contains(releases, releaseID) {
+		releases = append(releases, releaseID)
+	}
+}
+
+const redacted = "<redacted>"
+
+var sensitiveFlag = regexp.MustCompile(`(?i)token|secret|password|passphrase|key|credential|auth`)
+
+// RedactArgs returns args with the values of KEY=VALUE pairs redacted.
+func RedactArgs(args []string) []string {
+	out := make([]string, 0, len(args))
+	for _, arg := range args {
+		out = append(out, redactPair(arg))
+	}
+	return out
+}
+
+// RedactFlags returns the flags set on the command line, with the values of
+// sensitive flags and of KEY=VALUE pairs redacted.
+func RedactFlags(flags *pflag.FlagSet) map[string]string {
+	out := map[string]string{}
+	flags.Visit(func(f *pflag.Flag) {
+		switch {
+		case sensitiveFlag.MatchString(f.Name):
+			out[f.Name] = redacted
+		case f.Value.Type() == "bool":
+			out[f.Name] = f.Value.String()
+		default:
+			if sv, ok := f.Value.(pflag.SliceValue); ok {
+				out[f.Name] = strings.Join(RedactArgs(sv.GetSlice()), ",")
+			} else {
+				out[f.Name] = redactPair(f.Value.String())
+			}
+		}
+	})
+	return out
+}
+
+func redactPair(s string) string {
+	if k, _, ok := strings.Cut(s, "="); ok && k != "" {
+		return k + "=" + redacted
+	}
+	return s
+}
+
+// Write appends e to the audit log at path.
+func Write(path string, e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed creating audit log directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed opening audit log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed writing audit log: %w", err)
+	}
+
+	return nil
+}
+
+// Read returns the entries of the audit log at path, oldest first. A missing
+// log has no entries.
+func Read(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed opening audit log: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+
+		var e Entry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("failed parsing line %d of %s: %w", line, path, err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, s.Err()
+}
+
+// Forward posts e as JSON to the webhook at url.
+func Forward(ctx context.Context, url string, e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit webhook responded with %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransport(t *testing.T) {
+	Reset()
+	t.Cleanup(Reset)
+
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/graphql":
+			w.Write(body)
+		case "/v1/apps/web/machines":
+			w.Write([]byte(`{"id":"148e"}`))
+		case "/api/v1/releases":
+			w.Write([]byte(`{"id":"rel_1"}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer hs.Close()
+
+	client := &http.Client{Transport: NewTransport(http.DefaultTransport)}
+
+	do := func(method, path, body string) string {
+		req, err := http.NewRequest(method, hs.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	query := `{"query":"query { viewer { email } }"}`
+	assert.Equal(t, query, do(http.MethodPost, "/graphql", query), "body reaches the server intact")
+	do(http.MethodGet, "/v1/apps/web/machines/148e", "")
+	do(http.MethodPost, "/v1/apps/web/machines/148e/lease", "")
+	do(http.MethodPost, "/v1/apps/web/machines/148e/wait", "")
+	do(http.MethodDelete, "/v1/apps/web/machines/148e/lease", "")
+
+	// the caller's request is left alone
+	req, err := http.NewRequest(http.MethodPost, hs.URL+"/graphql", strings.NewReader(query))
+	require.NoError(t, err)
+	body := req.Body
+	resp, err := NewTransport(http.DefaultTransport).RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.True(t, body == req.Body)
+
+	mutated, _, _ := Touched()
+	assert.False(t, mutated)
+
+	do(http.MethodPost, "/graphql", `{"query":"mutation { deleteApp }"}`)
+	mutated, _, _ = Touched()
+	assert.True(t, mutated)
+
+	assert.Equal(t, `{"id":"148e"}`, do(http.MethodPost, "/v1/apps/web/machines", `{}`), "body reaches the client intact")
+	do(http.MethodPost, "/v1/apps/web/machines/3d8d/stop", "")
+	do(http.MethodDelete, "/v1/apps/web/machines/148e", "")
+	do(http.MethodPost, "/api/v1/releases", `{}`)
+	do(http.MethodPatch, "/api/v1/releases/rel_1", `{}`)
+
+	_, machines, releases := Touched()
+	assert.Equal(t, []string{"148e", "3d8d"}, machines)
+	assert.Equal(t, []string{"rel_1"}, releases)
+}
+
+func TestTransportRecordsExec(t *testing.T) {
+	Reset()
+	t.Cleanup(Reset)
+
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"exit_code":0}`))
+	}))
+	defer hs.Close()
+
+	client := &http.Client{Transport: NewTransport(http.DefaultTransport)}
+
+	// commands run on machines, as fly machine exec and fly ssh exec do, are
+	// exactly what the audit log is for
+	resp, err := client.Post(hs.URL+"/v1/apps/web/machines/148e/exec", "application/json", strings.NewReader(`{"cmd":"rm -rf /data"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	mutated, machines, _ := Touched()
+	assert.True(t, mutated)
+	assert.Equal(t, []string{"148e"}, machines)
+}
+
+func TestRedact(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.StringP("access-token", "t", "", "")
+	fs.String("app", "", "")
+	fs.StringSlice("env", nil, "")
+	fs.Bool("yes", false, "")
+	fs.String("region", "", "")
+	require.NoError(t, fs.Parse([]string{"-t", "fo1_secret", "--app", "web", "--env", "A=1", "--env", "B=2", "--yes"}))
+
+	assert.Equal(t, map[string]string{
+		"access-token": redacted,
+		"app":          "web",
+		"env":          "A=" + redacted + ",B=" + redacted,
+		"yes":          "true",
+	}, RedactFlags(fs))
+
+	assert.Equal(t, []string{"DATABASE_URL=" + redacted, "web", "=x"}, RedactArgs([]string{"DATABASE_URL=postgres://", "web", "=x"}))
+}
+
+func TestWriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "audit.log")
+
+	entries, err := Read(path)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	start := time.Now().Truncate(time.Second)
+	require.NoError(t, Write(path, Entry{Command: "fly deploy", App: "web", Start: start, End: start.Add(time.Minute), Status: StatusSucceeded, Releases: []string{"rel_1"}}))
+	require.NoError(t, Write(path, Entry{Command: "fly machine destroy", App: "web", Start: start, End: start, Status: StatusFailed, Error: "not found"}))
+
+	entries, err = Read(path)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "fly deploy", entries[0].Command)
+	assert.True(t, start.Equal(entries[0].Start))
+	assert.Equal(t, []string{"rel_1"}, entries[0].Releases)
+	assert.Equal(t, StatusFailed, entries[1].Status)
+}
@@ -0,0 +1,128 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var (
+	machinePath  = regexp.MustCompile(`/apps/[^/]+/machines/([^/]+)`)
+	machinesPath = regexp.MustCompile(`/apps/[^/]+/machines/?$`)
+	releasePath  = regexp.MustCompile(`/releases/([^/]+)`)
+	releasesPath = regexp.MustCompile(`/releases/?$`)
+
+	// readOnlyPath matches the flaps endpoints that aren't reads by method
+	// but change nothing: machine leases and wait, placement queries and
+	// secret key operations. Exec isn't one of them; the commands it runs
+	// may change anything.
+	readOnlyPath = regexp.MustCompile(`/machines/[^/]+/(lease|wait)/?$|/platform/placements/?$|/secretkeys/[^/]+/(encrypt|decrypt|sign|verify)/?$`)
+)
+
+type transport struct {
+	inner http.RoundTripper
+}
+
+// NewTransport wraps inner so that it records the mutating requests that go
+// through it: GraphQL mutations and any other request that isn't a read.
+func NewTransport(inner http.RoundTripper) http.RoundTripper {
+	return &transport{inner: inner}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return t.inner.RoundTrip(req)
+	}
+
+	if readOnlyPath.MatchString(req.URL.Path) {
+		return t.inner.RoundTrip(req)
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/graphql") && req.Body != nil {
+		body, clone, err := readBody(req)
+		if err != nil {
+			return nil, err
+		}
+		req = clone
+
+		if !isMutation(body) {
+			return t.inner.RoundTrip(req)
+		}
+	}
+
+	var machineID, releaseID string
+	if m := machinePath.FindStringSubmatch(req.URL.Path); m != nil {
+		machineID = m[1]
+	}
+	if m := releasePath.FindStringSubmatch(req.URL.Path); m != nil {
+		releaseID = m[1]
+	}
+	touch(machineID, releaseID)
+
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, err
+	}
+
+	// creating machines and releases is the only way to learn their IDs
+	switch {
+	case req.Method != http.MethodPost:
+	case machinesPath.MatchString(req.URL.Path):
+		touch(createdID(resp), "")
+	case releasesPath.MatchString(req.URL.Path):
+		touch("", createdID(resp))
+	}
+
+	return resp, nil
+}
+
+// readBody reads the body of req, and returns it with a clone of req that
+// carries a copy of it, since a RoundTripper mustn't modify its request.
+func readBody(req *http.Request) ([]byte, *http.Request, error) {
+	b, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clone := req.Clone(req.Context())
+	clone.Body = io.NopCloser(bytes.NewReader(b))
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+
+	return b, clone, nil
+}
+
+// isMutation reports whether body is that of a GraphQL mutation.
+func isMutation(body []byte) bool {
+	var req struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(strings.TrimSpace(req.Query), "mutation")
+}
+
+// createdID returns the ID of the resource resp describes, leaving the body of
+// resp intact.
+func createdID(resp *http.Response) string {
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return ""
+	}
+
+	var body struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(b, &body)
+	return body.ID
+}
@@ -158,7 +158,7 @@ func (*buildpacksBuilder) Run(ctx context.Context, dockerFactory *dockerClientFa
 		build.PushStart()
 		cmdfmt.PrintBegin(streams.ErrOut, "Pushing image to fly")
 
-		if err := pushToFly(ctx, docker, streams, opts.Tag); err != nil {
+		if err := pushToFly(ctx, dockerFactory, docker, streams, opts.Tag); err != nil {
 			build.PushFinish()
 			return nil, "", err
 		}
@@ -167,6 +167,12 @@ func (*buildpacksBuilder) Run(ctx context.Context, dockerFactory *dockerClientFa
 		cmdfmt.PrintDone(streams.ErrOut, "Pushing image done")
 	}
 
+	if opts.Export != nil {
+		if err := exportImageFromDocker(ctx, docker, streams, opts.Tag, opts.Export); err != nil {
+			return nil, "", err
+		}
+	}
+
 	img, err := findImageWithDocker(ctx, docker, opts.Tag)
 	if err != nil {
 		return nil, "", err
@@ -126,7 +126,7 @@ func (*builtinBuilder) Run(ctx context.Context, dockerFactory *dockerClientFacto
 		build.PushStart()
 		cmdfmt.PrintBegin(streams.ErrOut, "Pushing image to fly")
 
-		if err := pushToFly(ctx, docker, streams, opts.Tag); err != nil {
+		if err := pushToFly(ctx, dockerFactory, docker, streams, opts.Tag); err != nil {
 			build.PushFinish()
 			return nil, "", err
 		}
@@ -135,6 +135,12 @@ func (*builtinBuilder) Run(ctx context.Context, dockerFactory *dockerClientFacto
 		cmdfmt.PrintDone(streams.ErrOut, "Pushing image done")
 	}
 
+	if opts.Export != nil {
+		if err := exportImageFromDocker(ctx, docker, streams, opts.Tag, opts.Export); err != nil {
+			return nil, "", err
+		}
+	}
+
 	img, _, err := docker.ImageInspectWithRaw(ctx, imageID)
 	if err != nil {
 		return nil, "", errors.Wrap(err, "count not find built image")
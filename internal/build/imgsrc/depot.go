@@ -254,6 +254,15 @@ func buildImage(ctx context.Context, buildkitClient *client.Client, opts ImageOp
 	exportEntry.Attrs["compression-level"] = strconv.Itoa(opts.CompressionLevel)
 	exportEntry.Attrs["force-compression"] = "true"
 
+	exports := []client.ExportEntry{exportEntry}
+	if opts.Export != nil {
+		localExport, err := buildkitExportEntry(opts.Tag, opts.Export)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, localExport)
+	}
+
 	ch := make(chan *client.SolveStatus)
 	eg, ctx := errgroup.WithContext(ctx)
 	eg.Go(func() error {
@@ -268,7 +277,7 @@ func buildImage(ctx context.Context, buildkitClient *client.Client, opts ImageOp
 				"dockerfile": filepath.Dir(dockerfilePath),
 				"context":    opts.WorkingDir,
 			},
-			Exports: []client.ExportEntry{exportEntry},
+			Exports: exports,
 			// Prevent recording the build steps and traces in buildkit as it is _very_ slow.
 			Internal: true,
 		}
@@ -21,6 +21,9 @@ import (
 	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/progress"
 	"github.com/docker/docker/pkg/streamformatter"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/exporter/containerimage/exptypes"
 	"github.com/moby/buildkit/session/secrets/secretsprovider"
@@ -273,7 +276,7 @@ func (*dockerfileBuilder) Run(ctx context.Context, dockerFactory *dockerClientFa
 	if opts.Publish {
 		build.PushStart()
 		tb := render.NewTextBlock(ctx, "Pushing image to fly")
-		if err := pushToFly(ctx, docker, streams, opts.Tag); err != nil {
+		if err := pushToFly(ctx, dockerFactory, docker, streams, opts.Tag); err != nil {
 			build.PushFinish()
 			return nil, "", err
 		}
@@ -282,6 +285,12 @@ func (*dockerfileBuilder) Run(ctx context.Context, dockerFactory *dockerClientFa
 		tb.Done("Pushing image done")
 	}
 
+	if opts.Export != nil {
+		if err := exportImageFromDocker(ctx, docker, streams, opts.Tag, opts.Export); err != nil {
+			return nil, "", err
+		}
+	}
+
 	img, _, err := docker.ImageInspectWithRaw(ctx, imageID)
 	if err != nil {
 		return nil, "", errors.Wrap(err, "count not find built image")
@@ -533,7 +542,7 @@ func runBuildKitBuild(ctx context.Context, docker *dockerclient.Client, opts Ima
 	return res.ExporterResponse[exptypes.ExporterImageDigestKey], nil
 }
 
-func pushToFly(ctx context.Context, docker *dockerclient.Client, streams *iostreams.IOStreams, tag string) (err error) {
+func pushToFly(ctx context.Context, dockerFactory *dockerClientFactory, docker *dockerclient.Client, streams *iostreams.IOStreams, tag string) (err error) {
 	ctx, span := tracing.GetTracer().Start(ctx, "push_image_to_registry", trace.WithAttributes(attribute.String("tag", tag)))
 	defer span.End()
 
@@ -546,28 +555,105 @@ func pushToFly(ctx context.Context, docker *dockerclient.Client, streams *iostre
 	metrics.Started(ctx, "image_push")
 	sendImgPushMetrics := metrics.StartTiming(ctx, "image_push/duration")
 
+	diffIDs, err := imageDiffIDs(ctx, docker, tag)
+	if err != nil {
+		return err
+	}
+
+	ref, err := name.NewTag(tag)
+	if err != nil {
+		return errors.Wrap(err, "error parsing image tag")
+	}
+	opts := []remote.Option{remote.WithContext(ctx), remote.WithAuth(flyRegistryAuthenticator(ctx))}
+
+	// When enabled, layers that the app's repository lacks but another of the
+	// org's repositories has are mounted into it on the registry, so that the
+	// daemon's push finds them in place rather than uploading them.
+	var mounted map[v1.Hash]layerSource
+	if layerDedupEnabled() {
+		if missing := layersMissingFromApp(ctx, dockerFactory.appName, diffIDs); len(missing) > 0 {
+			idx, ierr := buildOrgLayerIndex(ctx, dockerFactory.appName)
+			if ierr != nil {
+				terminal.Debugf("failed to build layer index, pushing without deduplication: %v\n", ierr)
+				idx = orgLayerIndex{}
+			}
+			mounted = mountIndexedLayers(ctx, streams.ErrOut, ref.Context(), missing, idx, opts...)
+		}
+	}
+
+	summary, err := pushWithDocker(ctx, docker, streams, tag)
+	metrics.Status(ctx, "image_push", err == nil)
+	if err != nil {
+		return err
+	}
+	sendImgPushMetrics()
+
+	// docker only reports the sizes of the layers it uploads, the manifest
+	// has all of them
+	var manifest *v1.Manifest
+	if img, err := remote.Image(ref, opts...); err == nil {
+		manifest, _ = img.Manifest()
+	} else {
+		terminal.Debugf("failed to read the pushed manifest of %s: %v\n", tag, err)
+	}
+	summary.resolve(diffIDs, manifest, mounted)
+
+	span.SetAttributes(summary.ToSpanAttributes()...)
+	cmdfmt.PrintDone(streams.ErrOut, summary.String())
+
+	return nil
+}
+
+// imageDiffIDs returns the diff IDs of the layers of a built image.
+func imageDiffIDs(ctx context.Context, docker *dockerclient.Client, tag string) ([]v1.Hash, error) {
+	img, _, err := docker.ImageInspectWithRaw(ctx, tag)
+	if err != nil {
+		return nil, errors.Wrap(err, "error inspecting image")
+	}
+
+	var diffIDs []v1.Hash
+	if img.RootFS.Type == "layers" {
+		for _, l := range img.RootFS.Layers {
+			h, err := v1.NewHash(l)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid layer %s", l)
+			}
+			diffIDs = append(diffIDs, h)
+		}
+	}
+	return diffIDs, nil
+}
+
+func pushWithDocker(ctx context.Context, docker *dockerclient.Client, streams *iostreams.IOStreams, tag string) (*pushSummary, error) {
 	pushResp, err := docker.ImagePush(ctx, tag, image.PushOptions{
 		RegistryAuth: flyRegistryAuth(config.Tokens(ctx).Docker()),
 	})
-	metrics.Status(ctx, "image_push", err == nil)
-
 	if err != nil {
-		return errors.Wrap(err, "error pushing image to registry")
+		return nil, errors.Wrap(err, "error pushing image to registry")
 	}
 	defer pushResp.Close() // skipcq: GO-S2307
-	sendImgPushMetrics()
 
-	err = jsonmessage.DisplayJSONMessagesStream(pushResp, streams.ErrOut, streams.StderrFd(), streams.IsStderrTTY(), nil)
+	summary := &pushSummary{}
+	pr, pw := io.Pipe()
+	tracked := make(chan struct{})
+	go func() {
+		defer close(tracked)
+		trackDockerPush(pr, summary)
+	}()
+
+	err = jsonmessage.DisplayJSONMessagesStream(io.TeeReader(pushResp, pw), streams.ErrOut, streams.StderrFd(), streams.IsStderrTTY(), nil)
+	pw.Close()
+	<-tracked
 	if err != nil {
 		var msgerr *jsonmessage.JSONError
 
 		if errors.As(err, &msgerr) {
 			if msgerr.Message == "denied: requested access to the resource is denied" {
-				return &RegistryUnauthorizedError{Tag: tag}
+				return nil, &RegistryUnauthorizedError{Tag: tag}
 			}
 		}
-		return errors.Wrap(err, "error rendering push status stream")
+		return nil, errors.Wrap(err, "error rendering push status stream")
 	}
 
-	return nil
+	return summary, nil
 }
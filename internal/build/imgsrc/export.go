@@ -0,0 +1,253 @@
+package imgsrc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	dockerclient "github.com/docker/docker/client"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/moby/buildkit/client"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/superfly/flyctl/flyctl"
+	"github.com/superfly/flyctl/internal/cmdfmt"
+	"github.com/superfly/flyctl/internal/tracing"
+	"github.com/superfly/flyctl/iostreams"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ExportFormat is the on-disk format an image is exported to.
+type ExportFormat string
+
+const (
+	// ExportFormatOCILayout writes an OCI image layout directory.
+	ExportFormatOCILayout ExportFormat = "oci-layout"
+	// ExportFormatDockerArchive writes a tarball loadable with `docker load`.
+	ExportFormatDockerArchive ExportFormat = "docker-archive"
+)
+
+// ExportFormats lists the supported export formats.
+var ExportFormats = []ExportFormat{ExportFormatOCILayout, ExportFormatDockerArchive}
+
+// ImageExport describes where and how a built image is written to local disk.
+type ImageExport struct {
+	Format ExportFormat
+	Path   string
+}
+
+// NewImageExport validates format and returns an ImageExport writing to path.
+// When path is empty a default derived from the app name is used.
+func NewImageExport(format, path, appName string) (*ImageExport, error) {
+	f := ExportFormat(format)
+	switch f {
+	case ExportFormatOCILayout:
+		if path == "" {
+			path = appName + "-image"
+		}
+	case ExportFormatDockerArchive:
+		if path == "" {
+			path = appName + "-image.tar"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported output format %q, must be one of %v", format, ExportFormats)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageExport{Format: f, Path: abs}, nil
+}
+
+func (e *ImageExport) String() string {
+	return fmt.Sprintf("%s:%s", e.Format, e.Path)
+}
+
+// exportImageFromDocker saves the image identified by ref from the docker
+// daemon and writes it to disk in the requested format.
+func exportImageFromDocker(ctx context.Context, docker *dockerclient.Client, streams *iostreams.IOStreams, ref string, export *ImageExport) (err error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "export_image_from_docker", trace.WithAttributes(
+		attribute.String("ref", ref),
+		attribute.String("export", export.String()),
+	))
+	defer span.End()
+
+	defer func() {
+		if err != nil {
+			tracing.RecordError(span, err, "failed to export image")
+		}
+	}()
+
+	cmdfmt.PrintBegin(streams.ErrOut, fmt.Sprintf("Exporting image to %s", export.Path))
+
+	rc, err := docker.ImageSave(ctx, []string{ref})
+	if err != nil {
+		return errors.Wrap(err, "error saving image from docker")
+	}
+	defer rc.Close() // skipcq: GO-S2307
+
+	switch export.Format {
+	case ExportFormatDockerArchive:
+		if err := writeFileFromReader(export.Path, rc); err != nil {
+			return err
+		}
+	case ExportFormatOCILayout:
+		// The docker save output has to be seekable to be read as an image, so
+		// spool it to a temporary file first.
+		tmp, err := os.CreateTemp("", "flyctl-image-*.tar")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name()) // skipcq: GO-S2307
+
+		if _, err := io.Copy(tmp, rc); err != nil {
+			tmp.Close()
+			return errors.Wrap(err, "error reading image from docker")
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+
+		tag, err := name.NewTag(ref)
+		if err != nil {
+			return errors.Wrap(err, "error parsing image tag")
+		}
+		img, err := tarball.ImageFromPath(tmp.Name(), &tag)
+		if err != nil {
+			return errors.Wrap(err, "error reading image archive")
+		}
+		if err := writeOCILayout(export.Path, ref, img); err != nil {
+			return err
+		}
+	}
+
+	cmdfmt.PrintDone(streams.ErrOut, fmt.Sprintf("Exported %s image to %s", export.Format, export.Path))
+	return nil
+}
+
+// exportImageFromRegistry pulls ref from its registry and writes it to disk
+// in the requested format. Images on the Fly registry are pulled with the
+// current user's credentials.
+func exportImageFromRegistry(ctx context.Context, streams *iostreams.IOStreams, ref string, export *ImageExport) (err error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "export_image_from_registry", trace.WithAttributes(
+		attribute.String("ref", ref),
+		attribute.String("export", export.String()),
+	))
+	defer span.End()
+
+	defer func() {
+		if err != nil {
+			tracing.RecordError(span, err, "failed to export image")
+		}
+	}()
+
+	cmdfmt.PrintBegin(streams.ErrOut, fmt.Sprintf("Exporting image to %s", export.Path))
+
+	parsed, err := name.ParseReference(ref)
+	if err != nil {
+		return errors.Wrap(err, "error parsing image reference")
+	}
+
+	auth := authn.Anonymous
+	if parsed.Context().RegistryStr() == viper.GetString(flyctl.ConfigRegistryHost) {
+		auth = flyRegistryAuthenticator(ctx)
+	}
+
+	img, err := remote.Image(parsed, remote.WithContext(ctx), remote.WithAuth(auth), remote.WithPlatform(v1.Platform{OS: "linux", Architecture: "amd64"}))
+	if err != nil {
+		return errors.Wrap(err, "error fetching image from registry")
+	}
+
+	switch export.Format {
+	case ExportFormatDockerArchive:
+		f, err := os.Create(export.Path)
+		if err != nil {
+			return err
+		}
+		if err := tarball.Write(parsed, img, f); err != nil {
+			f.Close()
+			return errors.Wrap(err, "error writing image archive")
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	case ExportFormatOCILayout:
+		if err := writeOCILayout(export.Path, ref, img); err != nil {
+			return err
+		}
+	}
+
+	cmdfmt.PrintDone(streams.ErrOut, fmt.Sprintf("Exported %s image to %s", export.Format, export.Path))
+	return nil
+}
+
+// buildkitExportEntry returns an additional buildkit exporter that writes the
+// built image to disk alongside the regular image exporter.
+func buildkitExportEntry(tag string, export *ImageExport) (client.ExportEntry, error) {
+	switch export.Format {
+	case ExportFormatOCILayout:
+		if err := os.MkdirAll(export.Path, 0o755); err != nil {
+			return client.ExportEntry{}, err
+		}
+		return client.ExportEntry{
+			Type: client.ExporterOCI,
+			Attrs: map[string]string{
+				"name": tag,
+				"tar":  "false",
+			},
+			OutputDir: export.Path,
+		}, nil
+	case ExportFormatDockerArchive:
+		return client.ExportEntry{
+			Type: client.ExporterDocker,
+			Attrs: map[string]string{
+				"name": tag,
+			},
+			Output: func(map[string]string) (io.WriteCloser, error) {
+				return os.Create(export.Path)
+			},
+		}, nil
+	default:
+		return client.ExportEntry{}, fmt.Errorf("unsupported output format %q", export.Format)
+	}
+}
+
+func writeOCILayout(dir, ref string, img v1.Image) error {
+	p, err := layout.FromPath(dir)
+	if err != nil {
+		p, err = layout.Write(dir, empty.Index)
+		if err != nil {
+			return errors.Wrap(err, "error creating oci layout")
+		}
+	}
+
+	annotations := map[string]string{
+		"org.opencontainers.image.ref.name": ref,
+	}
+	if err := p.AppendImage(img, layout.WithAnnotations(annotations)); err != nil {
+		return errors.Wrap(err, "error writing oci layout")
+	}
+	return nil
+}
+
+func writeFileFromReader(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return errors.Wrap(err, "error writing image archive")
+	}
+	return f.Close()
+}
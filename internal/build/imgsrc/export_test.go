@@ -0,0 +1,67 @@
+package imgsrc
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewImageExport(t *testing.T) {
+	dir := t.TempDir()
+
+	export, err := NewImageExport("oci-layout", "", "my-app")
+	require.NoError(t, err)
+	assert.Equal(t, ExportFormatOCILayout, export.Format)
+	assert.Equal(t, "my-app-image", filepath.Base(export.Path))
+	assert.True(t, filepath.IsAbs(export.Path))
+
+	export, err = NewImageExport("docker-archive", "", "my-app")
+	require.NoError(t, err)
+	assert.Equal(t, ExportFormatDockerArchive, export.Format)
+	assert.Equal(t, "my-app-image.tar", filepath.Base(export.Path))
+
+	export, err = NewImageExport("docker-archive", filepath.Join(dir, "out.tar"), "my-app")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "out.tar"), export.Path)
+
+	_, err = NewImageExport("zip", "", "my-app")
+	assert.Error(t, err)
+}
+
+func TestWriteOCILayout(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "image")
+
+	img, err := random.Image(1024, 2)
+	require.NoError(t, err)
+
+	err = writeOCILayout(dir, "registry.fly.io/my-app:deployment-1", img)
+	require.NoError(t, err)
+
+	p, err := layout.FromPath(dir)
+	require.NoError(t, err)
+	index, err := p.ImageIndex()
+	require.NoError(t, err)
+	manifest, err := index.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, manifest.Manifests, 1)
+	assert.Equal(t, "registry.fly.io/my-app:deployment-1", manifest.Manifests[0].Annotations["org.opencontainers.image.ref.name"])
+
+	digest, err := img.Digest()
+	require.NoError(t, err)
+	assert.Equal(t, digest, manifest.Manifests[0].Digest)
+
+	// Writing into an existing layout appends rather than replacing.
+	img2, err := random.Image(1024, 1)
+	require.NoError(t, err)
+	require.NoError(t, writeOCILayout(dir, "registry.fly.io/my-app:deployment-2", img2))
+
+	index, err = p.ImageIndex()
+	require.NoError(t, err)
+	manifest, err = index.IndexManifest()
+	require.NoError(t, err)
+	assert.Len(t, manifest.Manifests, 2)
+}
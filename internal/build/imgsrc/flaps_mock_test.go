@@ -132,6 +132,21 @@ func (mr *MockFlapsClientMockRecorder) CreateVolumeSnapshot(ctx, appName, volume
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateVolumeSnapshot", reflect.TypeOf((*MockFlapsClient)(nil).CreateVolumeSnapshot), ctx, appName, volumeId)
 }
 
+// DecryptSecretKey mocks base method.
+func (m *MockFlapsClient) DecryptSecretKey(ctx context.Context, appName, name string, ciphertext, assoc []byte, version *uint64) (*fly.DecryptSecretKeyResp, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DecryptSecretKey", ctx, appName, name, ciphertext, assoc, version)
+	ret0, _ := ret[0].(*fly.DecryptSecretKeyResp)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecryptSecretKey indicates an expected call of DecryptSecretKey.
+func (mr *MockFlapsClientMockRecorder) DecryptSecretKey(ctx, appName, name, ciphertext, assoc, version any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecryptSecretKey", reflect.TypeOf((*MockFlapsClient)(nil).DecryptSecretKey), ctx, appName, name, ciphertext, assoc, version)
+}
+
 // DeleteApp mocks base method.
 func (m *MockFlapsClient) DeleteApp(ctx context.Context, name string) error {
 	m.ctrl.T.Helper()
@@ -232,6 +247,21 @@ func (mr *MockFlapsClientMockRecorder) Destroy(ctx, appName, input, nonce any) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Destroy", reflect.TypeOf((*MockFlapsClient)(nil).Destroy), ctx, appName, input, nonce)
 }
 
+// EncryptSecretKey mocks base method.
+func (m *MockFlapsClient) EncryptSecretKey(ctx context.Context, appName, name string, plaintext, assoc []byte, version *uint64) (*fly.EncryptSecretKeyResp, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EncryptSecretKey", ctx, appName, name, plaintext, assoc, version)
+	ret0, _ := ret[0].(*fly.EncryptSecretKeyResp)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EncryptSecretKey indicates an expected call of EncryptSecretKey.
+func (mr *MockFlapsClientMockRecorder) EncryptSecretKey(ctx, appName, name, plaintext, assoc, version any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EncryptSecretKey", reflect.TypeOf((*MockFlapsClient)(nil).EncryptSecretKey), ctx, appName, name, plaintext, assoc, version)
+}
+
 // Exec mocks base method.
 func (m *MockFlapsClient) Exec(ctx context.Context, appName, machineID string, in *fly.MachineExecRequest) (*fly.MachineExecResponse, error) {
 	m.ctrl.T.Helper()
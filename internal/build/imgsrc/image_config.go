@@ -0,0 +1,39 @@
+package imgsrc
+
+import (
+	"context"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/superfly/flyctl/flyctl"
+)
+
+// RemoteImageEnv returns the environment an image sets in its config, as
+// KEY=VALUE pairs, fetching only the image config from the registry.
+func RemoteImageEnv(ctx context.Context, ref string) ([]string, error) {
+	parsed, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing image reference")
+	}
+
+	auth := authn.Anonymous
+	if parsed.Context().RegistryStr() == viper.GetString(flyctl.ConfigRegistryHost) {
+		auth = flyRegistryAuthenticator(ctx)
+	}
+
+	img, err := remote.Image(parsed, remote.WithContext(ctx), remote.WithAuth(auth), remote.WithPlatform(v1.Platform{OS: "linux", Architecture: "amd64"}))
+	if err != nil {
+		return nil, errors.Wrap(err, "error fetching image from registry")
+	}
+
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading image config")
+	}
+
+	return cfg.Config.Env, nil
+}
@@ -0,0 +1,421 @@
+package imgsrc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/dustin/go-humanize"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/superfly/flyctl/flyctl"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/env"
+	"github.com/superfly/flyctl/internal/flyutil"
+	"github.com/superfly/flyctl/internal/tracing"
+	"github.com/superfly/flyctl/terminal"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// maxLayerIndexConcurrency bounds how many of the org's images are
+	// inspected at once while building a layer index.
+	maxLayerIndexConcurrency = 8
+
+	// maxLayerIndexApps and layerIndexTimeout bound the work of building a
+	// layer index in large orgs. Images not inspected by then are left out.
+	maxLayerIndexApps = 10
+	layerIndexTimeout = 5 * time.Second
+)
+
+// layerIndexes caches the layer index of each org for the life of the
+// process, since a deploy may push several images.
+var layerIndexes sync.Map
+
+// layerDedupEnabled reports whether cross-repository layer deduplication has
+// been turned on with FLY_LAYER_DEDUP. It's opt-in since indexing the org's
+// images costs a round of registry requests per app. Only images pushed by a
+// docker daemon, local or remote, are deduplicated: depot pushes from inside
+// its builder, before flyctl sees the image's layers.
+func layerDedupEnabled() bool {
+	return env.IsTruthy("FLY_LAYER_DEDUP")
+}
+
+func flyRegistryAuthenticator(ctx context.Context) authn.Authenticator {
+	return &authn.Basic{Username: "x", Password: config.Tokens(ctx).Docker()}
+}
+
+// layerSource records where a layer blob already lives on the Fly registry.
+type layerSource struct {
+	Digest     v1.Hash
+	Size       int64
+	MediaType  types.MediaType
+	Repository string
+}
+
+// orgLayerIndex maps uncompressed layer diff IDs to a blob that is already
+// stored in one of the organization's repositories.
+type orgLayerIndex map[v1.Hash]layerSource
+
+// addImage records every layer of img as living in repository.
+func (idx orgLayerIndex) addImage(repository string, img v1.Image) error {
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return err
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+	if len(cfg.RootFS.DiffIDs) != len(manifest.Layers) {
+		return fmt.Errorf("image in %s has %d diff ids but %d layers", repository, len(cfg.RootFS.DiffIDs), len(manifest.Layers))
+	}
+	for i, diffID := range cfg.RootFS.DiffIDs {
+		if _, ok := idx[diffID]; ok {
+			continue
+		}
+		l := manifest.Layers[i]
+		idx[diffID] = layerSource{
+			Digest:     l.Digest,
+			Size:       l.Size,
+			MediaType:  l.MediaType,
+			Repository: repository,
+		}
+	}
+	return nil
+}
+
+// layersMissingFromApp returns the diff IDs that the current image of appName
+// doesn't have. Those are the only layers a push may upload, so when there are
+// none the org doesn't need indexing.
+func layersMissingFromApp(ctx context.Context, appName string, diffIDs []v1.Hash) []v1.Hash {
+	apiClient := flyutil.ClientFromContext(ctx)
+	if apiClient == nil {
+		return diffIDs
+	}
+
+	imageRef, err := apiClient.LatestImage(ctx, appName)
+	if err != nil || imageRef == "" {
+		return diffIDs
+	}
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return diffIDs
+	}
+	img, err := remote.Image(ref, remote.WithContext(ctx), remote.WithAuth(flyRegistryAuthenticator(ctx)), remote.WithPlatform(v1.Platform{OS: "linux", Architecture: "amd64"}))
+	if err != nil {
+		terminal.Debugf("failed to read the current image of %s: %v\n", appName, err)
+		return diffIDs
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return diffIDs
+	}
+	return missingDiffIDs(diffIDs, cfg.RootFS.DiffIDs)
+}
+
+// missingDiffIDs returns the diff IDs in want that aren't in have.
+func missingDiffIDs(want, have []v1.Hash) []v1.Hash {
+	present := make(map[v1.Hash]bool, len(have))
+	for _, h := range have {
+		present[h] = true
+	}
+	var missing []v1.Hash
+	for _, h := range want {
+		if !present[h] {
+			missing = append(missing, h)
+		}
+	}
+	return missing
+}
+
+// buildOrgLayerIndex inspects the current image of apps in the same
+// organization as appName and returns the layers found in them. Apps without
+// a release, or whose image can't be read, are skipped. Indexes are cached
+// per organization.
+func buildOrgLayerIndex(ctx context.Context, appName string) (orgLayerIndex, error) {
+	ctx, span := tracing.GetTracer().Start(ctx, "build_org_layer_index")
+	defer span.End()
+
+	apiClient := flyutil.ClientFromContext(ctx)
+	if apiClient == nil {
+		return orgLayerIndex{}, nil
+	}
+
+	org, err := apiClient.GetOrganizationByApp(ctx, appName)
+	if err != nil {
+		tracing.RecordError(span, err, "failed to get organization")
+		return nil, err
+	}
+	if idx, ok := layerIndexes.Load(org.ID); ok {
+		return idx.(orgLayerIndex), nil
+	}
+
+	apps, err := apiClient.GetAppsForOrganization(ctx, org.ID)
+	if err != nil {
+		tracing.RecordError(span, err, "failed to list organization apps")
+		return nil, err
+	}
+	if len(apps) > maxLayerIndexApps {
+		apps = apps[:maxLayerIndexApps]
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, layerIndexTimeout)
+	defer cancel()
+
+	registryHost := viper.GetString(flyctl.ConfigRegistryHost)
+	auth := flyRegistryAuthenticator(ctx)
+
+	var (
+		mu  sync.Mutex
+		idx = orgLayerIndex{}
+	)
+
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(maxLayerIndexConcurrency)
+	for _, app := range apps {
+		eg.Go(func() error {
+			imageRef, err := apiClient.LatestImage(ctx, app.Name)
+			if err != nil || imageRef == "" {
+				return nil
+			}
+			ref, err := name.ParseReference(imageRef)
+			if err != nil || ref.Context().RegistryStr() != registryHost {
+				return nil
+			}
+			img, err := remote.Image(ref, remote.WithContext(ctx), remote.WithAuth(auth), remote.WithPlatform(v1.Platform{OS: "linux", Architecture: "amd64"}))
+			if err != nil {
+				terminal.Debugf("skipping layers of %s: %v\n", imageRef, err)
+				return nil
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err := idx.addImage(ref.Context().RepositoryStr(), img); err != nil {
+				terminal.Debugf("skipping layers of %s: %v\n", imageRef, err)
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("apps", len(apps)), attribute.Int("layers", len(idx)))
+	layerIndexes.Store(org.ID, idx)
+	return idx, nil
+}
+
+// layerPushStatus describes what happened to a single layer during a push.
+type layerPushStatus string
+
+const (
+	layerPushed   layerPushStatus = "pushed"
+	layerMounted  layerPushStatus = "mounted"
+	layerExisting layerPushStatus = "existing"
+)
+
+type layerPushResult struct {
+	ID     string
+	Status layerPushStatus
+	Size   int64
+	From   string
+}
+
+// pushSummary collects per-layer results of an image push.
+type pushSummary struct {
+	mu     sync.Mutex
+	Layers []layerPushResult
+}
+
+func (s *pushSummary) add(r layerPushResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Layers = append(s.Layers, r)
+}
+
+// BytesPushed returns the number of bytes uploaded to the registry.
+func (s *pushSummary) BytesPushed() (n int64) {
+	for _, l := range s.Layers {
+		if l.Status == layerPushed {
+			n += l.Size
+		}
+	}
+	return n
+}
+
+// BytesSaved returns the size of layers that were mounted or already present.
+func (s *pushSummary) BytesSaved() (n int64) {
+	for _, l := range s.Layers {
+		if l.Status != layerPushed {
+			n += l.Size
+		}
+	}
+	return n
+}
+
+func (s *pushSummary) count(status layerPushStatus) (n int) {
+	for _, l := range s.Layers {
+		if l.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *pushSummary) String() string {
+	msg := fmt.Sprintf("Pushed %d layers (%s)", s.count(layerPushed), humanize.Bytes(uint64(s.BytesPushed())))
+	if reused := s.count(layerMounted) + s.count(layerExisting); reused > 0 {
+		msg += fmt.Sprintf(", reused %d layers (%s saved)", reused, humanize.Bytes(uint64(s.BytesSaved())))
+	}
+	return msg
+}
+
+func (s *pushSummary) ToSpanAttributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int("push.layers_pushed", s.count(layerPushed)),
+		attribute.Int("push.layers_mounted", s.count(layerMounted)),
+		attribute.Int("push.layers_existing", s.count(layerExisting)),
+		attribute.Int64("push.bytes_pushed", s.BytesPushed()),
+		attribute.Int64("push.bytes_saved", s.BytesSaved()),
+	}
+}
+
+// trackDockerPush decodes a docker push progress stream and records the outcome
+// of every layer. Docker identifies layers by their truncated diff IDs, and
+// only reports the sizes of layers it uploads; see pushSummary.resolve.
+func trackDockerPush(r io.Reader, summary *pushSummary) {
+	sizes := map[string]int64{}
+	dec := json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := dec.Decode(&msg); err != nil {
+			// Drain so the writer side of the pipe never blocks.
+			_, _ = io.Copy(io.Discard, r)
+			return
+		}
+		if msg.ID == "" {
+			continue
+		}
+		if msg.Progress != nil && msg.Progress.Total > 0 {
+			sizes[msg.ID] = msg.Progress.Total
+		}
+
+		switch {
+		case msg.Status == "Pushed":
+			summary.add(layerPushResult{ID: msg.ID, Status: layerPushed, Size: sizes[msg.ID]})
+		case msg.Status == "Layer already exists":
+			summary.add(layerPushResult{ID: msg.ID, Status: layerExisting, Size: sizes[msg.ID]})
+		case strings.HasPrefix(msg.Status, "Mounted from "):
+			summary.add(layerPushResult{ID: msg.ID, Status: layerMounted, Size: sizes[msg.ID], From: strings.TrimPrefix(msg.Status, "Mounted from ")})
+		}
+	}
+}
+
+// resolve fills in what docker doesn't report: the sizes of the layers it
+// didn't upload, taken from the pushed manifest, and which of the layers it
+// found in place were mounted by mountIndexedLayers beforehand.
+func (s *pushSummary) resolve(diffIDs []v1.Hash, manifest *v1.Manifest, mounted map[v1.Hash]layerSource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.Layers {
+		l := &s.Layers[i]
+		for j, diffID := range diffIDs {
+			if !strings.HasPrefix(diffID.Hex, l.ID) {
+				continue
+			}
+			if manifest != nil && j < len(manifest.Layers) {
+				l.Size = manifest.Layers[j].Size
+			}
+			if src, ok := mounted[diffID]; ok && l.Status == layerExisting {
+				l.Status, l.From = layerMounted, src.Repository
+			}
+			break
+		}
+	}
+}
+
+// errLayerNotMounted means the registry didn't mount a layer; mountOnlyLayer
+// refuses to be uploaded instead.
+var errLayerNotMounted = errors.New("layer wasn't mounted")
+
+// mountIndexedLayers mounts the layers of an image, given by their diff IDs,
+// into repo from the org's repositories that idx says hold them. It never
+// uploads anything: layers already in repo are left alone, and layers the
+// registry won't mount are left to the push that follows. Since builders check
+// which blobs the registry has before pushing, they then skip the mounted
+// layers. It returns the layers that were mounted.
+func mountIndexedLayers(ctx context.Context, w io.Writer, repo name.Repository, diffIDs []v1.Hash, idx orgLayerIndex, opts ...remote.Option) map[v1.Hash]layerSource {
+	ctx, span := tracing.GetTracer().Start(ctx, "mount_indexed_layers")
+	defer span.End()
+
+	var (
+		mu      sync.Mutex
+		mounted = map[v1.Hash]layerSource{}
+	)
+
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(maxLayerIndexConcurrency)
+	opts = append(opts, remote.WithContext(ctx))
+
+	for _, diffID := range diffIDs {
+		src, ok := idx[diffID]
+		if !ok || src.Repository == repo.RepositoryStr() || !isGzipLayer(src.MediaType) {
+			continue
+		}
+
+		eg.Go(func() error {
+			short := diffID.Hex[:12]
+			srcRef := repo.Registry.Repo(src.Repository).Digest(src.Digest.String())
+			layer := &remote.MountableLayer{Layer: &mountOnlyLayer{src: src, diffID: diffID}, Reference: srcRef}
+
+			if err := remote.WriteLayer(repo, layer, opts...); err != nil {
+				terminal.Debugf("failed to mount layer %s from %s: %v\n", short, src.Repository, err)
+				return nil
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			mounted[diffID] = src
+			fmt.Fprintf(w, "%s: Mounted from %s (%s)\n", short, src.Repository, humanize.Bytes(uint64(src.Size)))
+			return nil
+		})
+	}
+	_ = eg.Wait()
+
+	span.SetAttributes(attribute.Int("layers_mounted", len(mounted)))
+	return mounted
+}
+
+// isGzipLayer reports whether layers of type mt are gzipped tarballs, which
+// can be listed under either the Docker or the OCI media type.
+func isGzipLayer(mt types.MediaType) bool {
+	return mt == types.DockerLayer || mt == types.OCILayer
+}
+
+// mountOnlyLayer is a layer in the registry whose metadata comes from the org
+// layer index. Its content is never read, so writing it either finds it in
+// place, mounts it or fails.
+type mountOnlyLayer struct {
+	src    layerSource
+	diffID v1.Hash
+}
+
+func (l *mountOnlyLayer) Digest() (v1.Hash, error)             { return l.src.Digest, nil }
+func (l *mountOnlyLayer) DiffID() (v1.Hash, error)             { return l.diffID, nil }
+func (l *mountOnlyLayer) Size() (int64, error)                 { return l.src.Size, nil }
+func (l *mountOnlyLayer) MediaType() (types.MediaType, error)  { return l.src.MediaType, nil }
+func (l *mountOnlyLayer) Compressed() (io.ReadCloser, error)   { return nil, errLayerNotMounted }
+func (l *mountOnlyLayer) Uncompressed() (io.ReadCloser, error) { return nil, errLayerNotMounted }
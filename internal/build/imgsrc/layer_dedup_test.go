@@ -0,0 +1,182 @@
+package imgsrc
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrgLayerIndex(t *testing.T) {
+	img, err := random.Image(1024, 3)
+	require.NoError(t, err)
+
+	idx := orgLayerIndex{}
+	require.NoError(t, idx.addImage("app-a", img))
+	assert.Len(t, idx, 3)
+
+	cfg, err := img.ConfigFile()
+	require.NoError(t, err)
+	manifest, err := img.Manifest()
+	require.NoError(t, err)
+
+	for i, diffID := range cfg.RootFS.DiffIDs {
+		src, ok := idx[diffID]
+		require.True(t, ok)
+		assert.Equal(t, "app-a", src.Repository)
+		assert.Equal(t, manifest.Layers[i].Digest, src.Digest)
+		assert.Equal(t, manifest.Layers[i].Size, src.Size)
+	}
+
+	// The first repository a layer was seen in wins.
+	require.NoError(t, idx.addImage("app-b", img))
+	for _, src := range idx {
+		assert.Equal(t, "app-a", src.Repository)
+	}
+}
+
+func TestTrackDockerPush(t *testing.T) {
+	stream := strings.Join([]string{
+		`{"status":"The push refers to repository [registry.fly.io/my-app]"}`,
+		`{"status":"Preparing","id":"aaaaaaaaaaaa"}`,
+		`{"status":"Preparing","id":"bbbbbbbbbbbb"}`,
+		`{"status":"Preparing","id":"cccccccccccc"}`,
+		`{"status":"Pushing","progressDetail":{"current":512,"total":2048},"id":"aaaaaaaaaaaa"}`,
+		`{"status":"Layer already exists","id":"bbbbbbbbbbbb"}`,
+		`{"status":"Mounted from other-app","id":"cccccccccccc"}`,
+		`{"status":"Pushed","id":"aaaaaaaaaaaa"}`,
+		`{"status":"deployment-1: digest: sha256:1234 size: 1234"}`,
+	}, "\n")
+
+	summary := &pushSummary{}
+	trackDockerPush(strings.NewReader(stream), summary)
+
+	require.Len(t, summary.Layers, 3)
+	assert.Equal(t, 1, summary.count(layerPushed))
+	assert.Equal(t, 1, summary.count(layerExisting))
+	assert.Equal(t, 1, summary.count(layerMounted))
+	assert.Equal(t, int64(2048), summary.BytesPushed())
+	assert.Equal(t, "other-app", summary.Layers[1].From)
+}
+
+func TestPushSummaryString(t *testing.T) {
+	summary := &pushSummary{}
+	summary.add(layerPushResult{ID: "a", Status: layerPushed, Size: 1000})
+	assert.Equal(t, "Pushed 1 layers (1.0 kB)", summary.String())
+
+	summary.add(layerPushResult{ID: "b", Status: layerMounted, Size: 2000, From: "other-app"})
+	summary.add(layerPushResult{ID: "c", Status: layerExisting, Size: 3000})
+	assert.Equal(t, "Pushed 1 layers (1.0 kB), reused 2 layers (5.0 kB saved)", summary.String())
+}
+
+func TestMountIndexedLayers(t *testing.T) {
+	hs := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer hs.Close()
+	u, err := url.Parse(hs.URL)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	opts := []remote.Option{remote.WithContext(ctx)}
+
+	// another app of the org has an image sharing the first layer of ours
+	local, err := random.Image(1024, 2)
+	require.NoError(t, err)
+	localLayers, err := local.Layers()
+	require.NoError(t, err)
+
+	other, err := mutate.Append(empty.Image, mutate.Addendum{Layer: localLayers[0], MediaType: types.DockerLayer})
+	require.NoError(t, err)
+	otherRef, err := name.NewTag(u.Host + "/other-app:latest")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(otherRef, other, opts...))
+
+	idx := orgLayerIndex{}
+	require.NoError(t, idx.addImage("other-app", other))
+
+	cfg, err := local.ConfigFile()
+	require.NoError(t, err)
+
+	// a layer the index names but the registry doesn't have isn't uploaded
+	missing, err := random.Layer(1024, types.DockerLayer)
+	require.NoError(t, err)
+	missingDigest, err := missing.Digest()
+	require.NoError(t, err)
+	missingDiffID, err := missing.DiffID()
+	require.NoError(t, err)
+	idx[missingDiffID] = layerSource{Digest: missingDigest, Size: 1024, MediaType: types.DockerLayer, Repository: "gone-app"}
+
+	repo, err := name.NewRepository(u.Host + "/my-app")
+	require.NoError(t, err)
+
+	var out strings.Builder
+	mounted := mountIndexedLayers(ctx, &out, repo, append(cfg.RootFS.DiffIDs, missingDiffID), idx, opts...)
+	require.Len(t, mounted, 1)
+	assert.Equal(t, "other-app", mounted[cfg.RootFS.DiffIDs[0]].Repository)
+	assert.Contains(t, out.String(), "Mounted from other-app")
+
+	wantDigest, err := localLayers[0].Digest()
+	require.NoError(t, err)
+	l, err := remote.Layer(repo.Digest(wantDigest.String()), opts...)
+	require.NoError(t, err)
+	_, err = l.Compressed()
+	require.NoError(t, err, "the mounted layer is readable from the app's repository")
+
+	rc, err := remote.Layer(repo.Digest(missingDigest.String()), opts...)
+	require.NoError(t, err)
+	_, err = rc.Compressed()
+	assert.Error(t, err, "nothing was uploaded for the missing layer")
+}
+
+func TestPushSummaryResolve(t *testing.T) {
+	img, err := random.Image(1024, 3)
+	require.NoError(t, err)
+	cfg, err := img.ConfigFile()
+	require.NoError(t, err)
+	manifest, err := img.Manifest()
+	require.NoError(t, err)
+
+	diffIDs := cfg.RootFS.DiffIDs
+	short := func(i int) string { return diffIDs[i].Hex[:12] }
+
+	// docker reports layers by their truncated diff IDs, and sizes only for
+	// the ones it uploads
+	summary := &pushSummary{}
+	summary.add(layerPushResult{ID: short(0), Status: layerPushed, Size: 999})
+	summary.add(layerPushResult{ID: short(1), Status: layerExisting})
+	summary.add(layerPushResult{ID: short(2), Status: layerExisting})
+
+	mounted := map[v1.Hash]layerSource{diffIDs[2]: {Repository: "other-app"}}
+	summary.resolve(diffIDs, manifest, mounted)
+
+	for i, l := range summary.Layers {
+		assert.Equal(t, manifest.Layers[i].Size, l.Size)
+	}
+	assert.Equal(t, layerExisting, summary.Layers[1].Status)
+	assert.Equal(t, layerMounted, summary.Layers[2].Status)
+	assert.Equal(t, "other-app", summary.Layers[2].From)
+	assert.Equal(t, manifest.Layers[1].Size+manifest.Layers[2].Size, summary.BytesSaved())
+}
+
+func TestMissingDiffIDs(t *testing.T) {
+	a := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)}
+	b := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("b", 64)}
+	c := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("c", 64)}
+
+	assert.Equal(t, []v1.Hash{c}, missingDiffIDs([]v1.Hash{a, b, c}, []v1.Hash{b, a}))
+	assert.Empty(t, missingDiffIDs([]v1.Hash{a, b}, []v1.Hash{a, b, c}))
+	assert.Equal(t, []v1.Hash{a, b}, missingDiffIDs([]v1.Hash{a, b}, nil))
+}
@@ -83,20 +83,21 @@ func (*localImageResolver) Run(ctx context.Context, dockerFactory *dockerClientF
 
 	span.SetAttributes(attribute.String("image.id", img.ID))
 
-	if opts.Publish {
-		build.PushStart()
+	if opts.Publish || opts.Export != nil {
 		err = docker.ImageTag(ctx, img.ID, opts.Tag)
 		if err != nil {
-			build.PushFinish()
 			tracing.RecordError(span, err, "failed to tag image")
 			return nil, "", errors.Wrap(err, "error tagging image")
 		}
 
 		defer clearDeploymentTags(ctx, docker, opts.Tag)
+	}
 
+	if opts.Publish {
+		build.PushStart()
 		cmdfmt.PrintBegin(streams.ErrOut, "Pushing image to fly")
 
-		if err := pushToFly(ctx, docker, streams, opts.Tag); err != nil {
+		if err := pushToFly(ctx, dockerFactory, docker, streams, opts.Tag); err != nil {
 			build.PushFinish()
 			return nil, "", err
 		}
@@ -104,6 +105,12 @@ func (*localImageResolver) Run(ctx context.Context, dockerFactory *dockerClientF
 		cmdfmt.PrintDone(streams.ErrOut, "Pushing image done")
 	}
 
+	if opts.Export != nil {
+		if err := exportImageFromDocker(ctx, docker, streams, opts.Tag, opts.Export); err != nil {
+			return nil, "", err
+		}
+	}
+
 	di := &DeploymentImage{
 		ID:   img.ID,
 		Tag:  opts.Tag,
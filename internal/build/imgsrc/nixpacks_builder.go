@@ -208,12 +208,18 @@ func (b *nixpacksBuilder) Run(ctx context.Context, dockerFactory *dockerClientFa
 	build.BuildFinish()
 
 	build.PushStart()
-	if err := pushToFly(ctx, docker, streams, opts.Tag); err != nil {
+	if err := pushToFly(ctx, dockerFactory, docker, streams, opts.Tag); err != nil {
 		build.PushFinish()
 		return nil, "", err
 	}
 	build.PushFinish()
 
+	if opts.Export != nil {
+		if err := exportImageFromDocker(ctx, docker, streams, opts.Tag, opts.Export); err != nil {
+			return nil, "", err
+		}
+	}
+
 	img, err := findImageWithDocker(ctx, docker, opts.Tag)
 	if err != nil {
 		return nil, "", err
@@ -63,6 +63,12 @@ func (s *remoteImageResolver) Run(ctx context.Context, _ *dockerClientFactory, s
 		}
 	}
 
+	if opts.Export != nil {
+		if err := exportImageFromRegistry(ctx, streams, di.String(), opts.Export); err != nil {
+			return nil, "", err
+		}
+	}
+
 	span.SetAttributes(di.ToSpanAttributes()...)
 
 	return di, "", nil
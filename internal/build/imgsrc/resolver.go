@@ -59,6 +59,8 @@ type ImageOptions struct {
 	UseOverlaybd         bool
 	Compression          string
 	CompressionLevel     int
+	// Export, when set, additionally writes the built image to local disk.
+	Export *ImageExport
 }
 
 func (io ImageOptions) ToSpanAttributes() []attribute.KeyValue {
@@ -81,6 +83,10 @@ func (io ImageOptions) ToSpanAttributes() []attribute.KeyValue {
 		attribute.Int("imageoptions.compressionLevel", io.CompressionLevel),
 	}
 
+	if io.Export != nil {
+		attrs = append(attrs, attribute.String("imageoptions.export", io.Export.String()))
+	}
+
 	if io.BuildArgs != nil {
 		attrs = append(attrs, attribute.Bool("imageoptions.has_build_args", true))
 	}
@@ -109,10 +115,12 @@ type RefOptions struct {
 	ImageLabel string
 	Publish    bool
 	Tag        string
+	// Export, when set, additionally writes the resolved image to local disk.
+	Export *ImageExport
 }
 
 func (ro RefOptions) ToSpanAttributes() []attribute.KeyValue {
-	return []attribute.KeyValue{
+	attrs := []attribute.KeyValue{
 		attribute.String("refoptions.app_name", ro.AppName),
 		attribute.String("refoptions.work_dir", ro.WorkingDir),
 		attribute.String("refoptions.image.ref", ro.ImageRef),
@@ -120,6 +128,12 @@ func (ro RefOptions) ToSpanAttributes() []attribute.KeyValue {
 		attribute.Bool("refoptions.publish", ro.Publish),
 		attribute.String("refoptions.tag", ro.Tag),
 	}
+
+	if ro.Export != nil {
+		attrs = append(attrs, attribute.String("refoptions.export", ro.Export.String()))
+	}
+
+	return attrs
 }
 
 type DeploymentImage struct {
@@ -35,6 +35,7 @@ import (
 
 	term2 "github.com/superfly/flyctl/terminal"
 
+	"github.com/superfly/flyctl/internal/command/plugins"
 	"github.com/superfly/flyctl/internal/command/root"
 )
 
@@ -63,6 +64,7 @@ func Run(ctx context.Context, io *iostreams.IOStreams, args ...string) int {
 	defer httptracing.Finish()
 
 	cmd := root.New()
+	plugins.Register(cmd, args)
 	cmd.SetOut(io.Out)
 	cmd.SetErr(io.ErrOut)
 
@@ -101,9 +103,13 @@ func Run(ctx context.Context, io *iostreams.IOStreams, args ...string) int {
 	// shutdown background tasks, giving up to 5s for them to finish
 	task.FromContext(ctx).ShutdownWithTimeout(5 * time.Second)
 
+	var exitErr flyerr.ExitCodeError
+
 	switch {
 	case err == nil:
 		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.Code
 	case errors.Is(err, context.Canceled), errors.Is(err, terminal.InterruptErr):
 		return 127
 	case errors.Is(err, context.DeadlineExceeded):
@@ -8,9 +8,12 @@ import (
 	"strings"
 
 	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
 	fly "github.com/superfly/fly-go"
 	"github.com/superfly/fly-go/flaps"
+	"github.com/superfly/flyctl/flyctl"
 	"github.com/superfly/flyctl/helpers"
+	"github.com/superfly/flyctl/internal/audit"
 	"github.com/superfly/flyctl/internal/config"
 	"github.com/superfly/flyctl/internal/flag/flagctx"
 	"github.com/superfly/flyctl/internal/flapsutil"
@@ -34,11 +37,24 @@ type Preparer func(context.Context) (context.Context, error)
 func LoadConfig(ctx context.Context) (context.Context, error) {
 	logger := logger.FromContext(ctx)
 
-	cfg, err := config.Load(ctx, filepath.Join(state.ConfigDirectory(ctx), config.FileName))
+	path := filepath.Join(state.ConfigDirectory(ctx), config.FileName)
+
+	cfg, err := config.Load(ctx, path)
 	if err != nil {
 		return nil, err
 	}
 
+	// WireGuard peers belong to the profile's account, so use its state
+	// rather than that of the config file viper loaded.
+	if cfg.ProfileFile != path {
+		states, err := config.ReadWireGuardState(cfg.ProfileFile)
+		if err != nil {
+			return nil, err
+		}
+		viper.Set(flyctl.ConfigWireGuardState, states)
+		logger.Debugf("using auth profile %s (from %s).", cfg.Profile, cfg.ProfileSource)
+	}
+
 	logger.Debug("config initialized.")
 
 	return config.NewContext(ctx, cfg), nil
@@ -52,7 +68,12 @@ func InitClient(ctx context.Context) (context.Context, error) {
 	fly.SetBaseURL(cfg.APIBaseURL)
 	fly.SetErrorLog(cfg.LogGQLErrors)
 	fly.SetInstrumenter(instrument.ApiAdapter)
-	fly.SetTransport(otelhttp.NewTransport(http.DefaultTransport))
+
+	transport := http.DefaultTransport
+	if config.AuditEnabled(ctx) {
+		transport = audit.NewTransport(transport)
+	}
+	fly.SetTransport(otelhttp.NewTransport(transport))
 
 	if flyutil.ClientFromContext(ctx) == nil {
 		client := flyutil.NewClientFromOptions(ctx, fly.ClientOptions{Tokens: cfg.Tokens})
@@ -11,6 +11,7 @@ import (
 	"github.com/superfly/flyctl/agent"
 
 	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/config"
 	"github.com/superfly/flyctl/internal/env"
 	"github.com/superfly/flyctl/internal/flyutil"
 	"github.com/superfly/flyctl/internal/state"
@@ -29,9 +30,11 @@ func New() (cmd *cobra.Command) {
 	cmd.AddCommand(
 		newRun(),
 		newPing(),
+		newStats(),
 		newStart(),
 		newStop(),
 		newRestart(),
+		newDNS(),
 	)
 
 	if env.IsTruthy("DEV") {
@@ -66,5 +69,5 @@ func dial(ctx context.Context) (client *agent.Client, err error) {
 }
 
 func socketPath(ctx context.Context) string {
-	return filepath.Join(state.ConfigDirectory(ctx), "fly-agent.sock")
+	return filepath.Join(state.ConfigDirectory(ctx), agent.SocketName(config.ProfileFromContext(ctx)))
 }
@@ -0,0 +1,286 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/superfly/flyctl/agent"
+	"github.com/superfly/flyctl/iostreams"
+
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/flyutil"
+	"github.com/superfly/flyctl/internal/prompt"
+	"github.com/superfly/flyctl/terminal"
+)
+
+const (
+	defaultDNSListen   = "127.0.0.1:5353"
+	defaultDNSUpstream = "1.1.1.1:53"
+	dnsQueryTimeout    = 5 * time.Second
+)
+
+// privateDNSSuffixes are answered by the organization's DNS server over the
+// WireGuard tunnel rather than upstream.
+var privateDNSSuffixes = []string{".internal.", ".flycast."}
+
+func newDNS() (cmd *cobra.Command) {
+	const (
+		short = "Run a local DNS server that resolves .internal and .flycast names"
+		long  = `Run a local DNS server that answers queries for .internal and .flycast names,
+including _apps.internal and _instances.internal lookups, through the organization's
+WireGuard tunnel, and forwards all other queries to an upstream server.
+
+Point split DNS for the internal and flycast domains at the listen address to use
+ordinary tools such as dig, curl or a browser with private names.
+`
+		usage = "dns"
+	)
+
+	cmd = command.New(usage, short, long, runDNS,
+		command.RequireSession,
+		command.LoadAppNameIfPresent,
+	)
+
+	cmd.Args = cobra.NoArgs
+
+	flag.Add(cmd,
+		flag.App(),
+		flag.AppConfig(),
+		flag.Org(),
+		flag.String{
+			Name:        "listen",
+			Default:     defaultDNSListen,
+			Description: "Address to serve DNS on, over both UDP and TCP",
+		},
+		flag.String{
+			Name:        "upstream",
+			Description: "DNS server for names outside the private network. Defaults to the first nameserver in /etc/resolv.conf",
+		},
+	)
+
+	return
+}
+
+func runDNS(ctx context.Context) (err error) {
+	var (
+		io     = iostreams.FromContext(ctx)
+		client = flyutil.ClientFromContext(ctx)
+	)
+
+	orgSlug := flag.GetOrg(ctx)
+	if orgSlug == "" {
+		if appName := appconfig.NameFromContext(ctx); appName != "" {
+			app, err := client.GetAppBasic(ctx, appName)
+			if err != nil {
+				return fmt.Errorf("get app: %w", err)
+			}
+			orgSlug = app.Organization.Slug
+		} else {
+			org, err := prompt.Org(ctx)
+			if err != nil {
+				return err
+			}
+			orgSlug = org.Slug
+		}
+	}
+
+	ac, err := establish(ctx)
+	if err != nil {
+		return err
+	}
+
+	ts, err := ac.Establish(ctx, orgSlug, "")
+	if err != nil {
+		return err
+	}
+	nameserver := net.JoinHostPort(ts.TunnelConfig.DNS.String(), "53")
+
+	dialer, err := ac.Dialer(ctx, orgSlug, "")
+	if err != nil {
+		return err
+	}
+
+	upstream := flag.GetString(ctx, "upstream")
+	if upstream == "" {
+		upstream = systemNameserver()
+	} else if _, _, err := net.SplitHostPort(upstream); err != nil {
+		upstream = net.JoinHostPort(upstream, "53")
+	}
+
+	forwarder := &dnsForwarder{
+		private: func(ctx context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+			return exchangeOverTunnel(ctx, dialer, nameserver, m)
+		},
+		upstream: func(ctx context.Context, m *dns.Msg, network string) (*dns.Msg, error) {
+			return exchangeUpstream(ctx, upstream, network, m)
+		},
+		observe: func(name string, private bool, latency time.Duration, _ error) {
+			via := upstream
+			if private {
+				via = "the tunnel"
+			}
+			terminal.Debugf("DNS query for %s through %s took %s\n", name, via, latency)
+		},
+	}
+
+	listen := flag.GetString(ctx, "listen")
+	servers := []*dns.Server{
+		{Addr: listen, Net: "udp", Handler: forwarder},
+		{Addr: listen, Net: "tcp", Handler: forwarder},
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, srv := range servers {
+		started := make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+
+		eg.Go(srv.ListenAndServe)
+		eg.Go(func() error {
+			<-ctx.Done()
+			return srv.Shutdown()
+		})
+
+		select {
+		case <-started:
+		case <-ctx.Done():
+			return eg.Wait()
+		}
+	}
+
+	fmt.Fprintf(io.Out, "Resolving .internal and .flycast names for %s on %s (udp and tcp), forwarding other queries to %s\n", orgSlug, listen, upstream)
+
+	if err := eg.Wait(); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// dnsExchange sends a query that came in over network, udp or tcp, and
+// returns the reply.
+type dnsExchange func(ctx context.Context, m *dns.Msg, network string) (*dns.Msg, error)
+
+// dnsForwarder answers private names through the tunnel and everything else
+// through the upstream server.
+type dnsForwarder struct {
+	private  dnsExchange
+	upstream dnsExchange
+
+	// observe, if set, is called after every query either way.
+	observe func(name string, private bool, latency time.Duration, err error)
+}
+
+func (f *dnsForwarder) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsQueryTimeout)
+	defer cancel()
+
+	network := "udp"
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		network = "tcp"
+	}
+
+	reply := f.answer(ctx, r, network)
+	if network == "udp" {
+		// replies fetched over TCP may not fit in a datagram; setting the TC
+		// bit makes the client retry over TCP
+		size := dns.MinMsgSize
+		if opt := r.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		reply.Truncate(size)
+	}
+
+	if err := w.WriteMsg(reply); err != nil {
+		terminal.Debugf("failed writing DNS response: %v\n", err)
+	}
+}
+
+func (f *dnsForwarder) answer(ctx context.Context, r *dns.Msg, network string) *dns.Msg {
+	if len(r.Question) != 1 {
+		return new(dns.Msg).SetRcode(r, dns.RcodeFormatError)
+	}
+
+	name := r.Question[0].Name
+	private := isPrivateName(name)
+
+	exchange := f.upstream
+	if private {
+		exchange = f.private
+	}
+
+	start := time.Now()
+	reply, err := exchange(ctx, r, network)
+	if f.observe != nil {
+		f.observe(name, private, time.Since(start), err)
+	}
+	if err != nil {
+		terminal.Debugf("DNS query for %s failed: %v\n", name, err)
+		return new(dns.Msg).SetRcode(r, dns.RcodeServerFailure)
+	}
+
+	reply.Id = r.Id
+	return reply
+}
+
+func isPrivateName(name string) bool {
+	name = strings.ToLower(dns.Fqdn(name))
+	for _, suffix := range privateDNSSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// exchangeUpstream sends a query to the upstream server over network, and
+// retries over TCP if the reply is truncated.
+func exchangeUpstream(ctx context.Context, upstream, network string, m *dns.Msg) (*dns.Msg, error) {
+	r, _, err := (&dns.Client{Net: network, Timeout: dnsQueryTimeout}).ExchangeContext(ctx, m, upstream)
+	if err == nil && r.Truncated && network != "tcp" {
+		r, _, err = (&dns.Client{Net: "tcp", Timeout: dnsQueryTimeout}).ExchangeContext(ctx, m, upstream)
+	}
+	return r, err
+}
+
+// exchangeOverTunnel sends a query to the organization's nameserver over TCP
+// through the tunnel.
+func exchangeOverTunnel(ctx context.Context, dialer agent.Dialer, nameserver string, m *dns.Msg) (*dns.Msg, error) {
+	c, err := dialer.DialContext(ctx, "tcp", nameserver)
+	if err != nil {
+		return nil, err
+	}
+
+	// the connections we get from the agent are over a unix domain socket
+	// proxy, which implements PacketConn, so miekg/dns would speak UDP
+	// framing over it. Hide that.
+	type streamConn struct {
+		net.Conn
+	}
+	conn := &dns.Conn{Conn: &streamConn{c}}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if err := conn.WriteMsg(m); err != nil {
+		return nil, err
+	}
+	return conn.ReadMsg()
+}
+
+func systemNameserver() string {
+	config, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || len(config.Servers) == 0 {
+		return defaultDNSUpstream
+	}
+	return net.JoinHostPort(config.Servers[0], config.Port)
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPrivateName(t *testing.T) {
+	assert.True(t, isPrivateName("my-app.internal"))
+	assert.True(t, isPrivateName("_apps.internal."))
+	assert.True(t, isPrivateName("My-App.Flycast."))
+	assert.False(t, isPrivateName("fly.io."))
+	assert.False(t, isPrivateName("internal.example.com."))
+}
+
+func TestDNSForwarderAnswer(t *testing.T) {
+	var privateQueries, upstreamQueries []string
+
+	f := &dnsForwarder{
+		private: func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+			privateQueries = append(privateQueries, m.Question[0].Name)
+			reply := new(dns.Msg).SetReply(m)
+			reply.Id = 0
+			reply.Answer = append(reply.Answer, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 5},
+				AAAA: net.ParseIP("fdaa::2"),
+			})
+			return reply, nil
+		},
+		upstream: func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+			upstreamQueries = append(upstreamQueries, m.Question[0].Name)
+			return nil, errors.New("no network")
+		},
+	}
+
+	var observed []string
+	f.observe = func(name string, private bool, _ time.Duration, err error) {
+		observed = append(observed, fmt.Sprintf("%s private=%t failed=%t", name, private, err != nil))
+	}
+
+	q := new(dns.Msg).SetQuestion("my-app.internal.", dns.TypeAAAA)
+	reply := f.answer(context.Background(), q, "udp")
+	require.Len(t, reply.Answer, 1)
+	assert.Equal(t, q.Id, reply.Id)
+	assert.Equal(t, "fdaa::2", reply.Answer[0].(*dns.AAAA).AAAA.String())
+
+	q = new(dns.Msg).SetQuestion("fly.io.", dns.TypeA)
+	reply = f.answer(context.Background(), q, "udp")
+	assert.Equal(t, dns.RcodeServerFailure, reply.Rcode)
+
+	assert.Equal(t, []string{"my-app.internal."}, privateQueries)
+	assert.Equal(t, []string{"fly.io."}, upstreamQueries)
+	assert.Equal(t, []string{"my-app.internal. private=true failed=false", "fly.io. private=false failed=true"}, observed)
+
+	reply = f.answer(context.Background(), new(dns.Msg), "udp")
+	assert.Equal(t, dns.RcodeFormatError, reply.Rcode)
+}
+
+func TestExchangeUpstreamRetriesOverTCP(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		networks []string
+	)
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		reply := new(dns.Msg).SetReply(r)
+		if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+			networks = append(networks, "tcp")
+			reply.Answer = append(reply.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+				Txt: []string{"large"},
+			})
+		} else {
+			networks = append(networks, "udp")
+			reply.Truncated = true
+		}
+		_ = w.WriteMsg(reply)
+	})
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	require.NoError(t, err)
+
+	servers := []*dns.Server{{PacketConn: udp, Handler: handler}, {Listener: tcp, Handler: handler}}
+	for _, srv := range servers {
+		go srv.ActivateAndServe()
+		defer srv.Shutdown()
+	}
+
+	q := new(dns.Msg).SetQuestion("example.com.", dns.TypeTXT)
+	reply, err := exchangeUpstream(context.Background(), udp.LocalAddr().String(), "udp", q)
+	require.NoError(t, err)
+	assert.False(t, reply.Truncated)
+	assert.Len(t, reply.Answer, 1)
+	mu.Lock()
+	assert.Equal(t, []string{"udp", "tcp"}, networks)
+	networks = nil
+	mu.Unlock()
+
+	_, err = exchangeUpstream(context.Background(), udp.LocalAddr().String(), "tcp", q)
+	require.NoError(t, err)
+	mu.Lock()
+	assert.Equal(t, []string{"tcp"}, networks)
+	mu.Unlock()
+}
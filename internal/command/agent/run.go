@@ -12,6 +12,7 @@ import (
 	"github.com/spf13/viper"
 
 	fly "github.com/superfly/fly-go"
+	"github.com/superfly/flyctl/agent"
 	"github.com/superfly/flyctl/agent/server"
 	"github.com/superfly/flyctl/flyctl"
 
@@ -19,7 +20,6 @@ import (
 	"github.com/superfly/flyctl/internal/config"
 	"github.com/superfly/flyctl/internal/filemu"
 	"github.com/superfly/flyctl/internal/flag"
-	"github.com/superfly/flyctl/internal/state"
 )
 
 func newRun() (cmd *cobra.Command) {
@@ -36,6 +36,14 @@ func newRun() (cmd *cobra.Command) {
 	cmd.Args = cobra.MaximumNArgs(1)
 	cmd.Aliases = []string{"daemon-start"}
 
+	flag.Add(cmd,
+		flag.String{
+			Name:        "metrics-addr",
+			EnvName:     "FLY_AGENT_METRICS_ADDR",
+			Description: "Serve Prometheus metrics on this loopback address, e.g. 127.0.0.1:9464",
+		},
+	)
+
 	return
 }
 
@@ -65,8 +73,9 @@ func run(ctx context.Context) error {
 		Socket:           socketPath(ctx),
 		Logger:           logger,
 		Background:       logPath != "",
-		ConfigFile:       state.ConfigFile(ctx),
+		ConfigFile:       config.FromContext(ctx).ProfileFile,
 		ConfigWebsockets: viper.GetBool(flyctl.ConfigWireGuardWebsockets),
+		MetricsAddr:      flag.GetString(ctx, "metrics-addr"),
 	}
 
 	return server.Run(ctx, opt)
@@ -110,12 +119,12 @@ func (*dupInstanceError) Description() string {
 
 var errDupInstance = new(dupInstanceError)
 
-func lockPath() string {
-	return filepath.Join(flyctl.ConfigDir(), "flyctl.agent.lock")
+func lockPath(ctx context.Context) string {
+	return filepath.Join(flyctl.ConfigDir(), agent.LockName(config.ProfileFromContext(ctx)))
 }
 
 func lock(ctx context.Context, logger *log.Logger) (unlock filemu.UnlockFunc, err error) {
-	switch unlock, err = filemu.Lock(ctx, lockPath()); {
+	switch unlock, err = filemu.Lock(ctx, lockPath(ctx)); {
 	case err == nil:
 		break // all done
 	case ctx.Err() != nil:
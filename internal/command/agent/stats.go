@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+
+	"github.com/superfly/flyctl/agent"
+	"github.com/superfly/flyctl/iostreams"
+
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/render"
+)
+
+func newStats() (cmd *cobra.Command) {
+	const (
+		short = "Show the state of the Fly agent's tunnels"
+		long  = `Show the state of every WireGuard tunnel held by the Fly agent: handshake age,
+bytes sent and received, connections dialed through it, DNS query latencies and
+how often it was established.
+
+Run the agent with --metrics-addr, or set FLY_AGENT_METRICS_ADDR before it starts,
+to also export these as Prometheus metrics.
+`
+	)
+
+	cmd = command.New("stats", short, long, runStats)
+
+	cmd.Args = cobra.NoArgs
+
+	flag.Add(cmd, flag.JSONOutput())
+	return
+}
+
+func runStats(ctx context.Context) (err error) {
+	var client *agent.Client
+	if client, err = dial(ctx); err != nil {
+		return
+	}
+
+	var stats *agent.StatsResponse
+	if stats, err = client.Stats(ctx); err != nil {
+		err = fmt.Errorf("failed fetching agent stats: %w", err)
+
+		return
+	}
+
+	out := iostreams.FromContext(ctx).Out
+	if config.FromContext(ctx).JSONOutput {
+		return render.JSON(out, stats)
+	}
+
+	now := time.Now()
+
+	fmt.Fprintf(out, "%-10s: %d\n", "PID", stats.PID)
+	fmt.Fprintf(out, "%-10s: %s\n", "Version", stats.Version)
+	if !stats.StartedAt.IsZero() {
+		fmt.Fprintf(out, "%-10s: %s\n", "Uptime", now.Sub(stats.StartedAt).Round(time.Second))
+	}
+	fmt.Fprintln(out)
+
+	if len(stats.Tunnels) == 0 {
+		fmt.Fprintln(out, "No tunnels established")
+		return nil
+	}
+
+	var tunnelRows, connRows [][]string
+	for _, t := range stats.Tunnels {
+		handshake := "never"
+		if !t.LastHandshake.IsZero() {
+			handshake = t.HandshakeAge(now).Round(time.Second).String() + " ago"
+		}
+
+		tunnelRows = append(tunnelRows, []string{
+			t.Org,
+			t.Network,
+			handshake,
+			humanize.Bytes(t.RxBytes),
+			humanize.Bytes(t.TxBytes),
+			strconv.Itoa(len(t.Connections)),
+			fmt.Sprintf("%d/%d", t.Establishes, t.Reestablishes),
+			fmt.Sprintf("%d (%d failed)", t.DNS.Queries, t.DNS.Failures),
+			fmt.Sprintf("%s/%s", roundLatency(t.DNS.AvgLatency), roundLatency(t.DNS.MaxLatency)),
+		})
+
+		for _, c := range t.Connections {
+			connRows = append(connRows, []string{
+				t.Org,
+				c.Destination,
+				now.Sub(c.Since).Round(time.Second).String(),
+			})
+		}
+	}
+
+	if err = render.Table(out, "Tunnels", tunnelRows, "Org", "Network", "Handshake", "Received", "Sent", "Conns", "Established/Re", "DNS Queries", "DNS Avg/Max"); err != nil {
+		return
+	}
+
+	if len(connRows) > 0 {
+		err = render.Table(out, "Connections", connRows, "Org", "Destination", "Duration")
+	}
+
+	return
+}
+
+func roundLatency(d time.Duration) time.Duration {
+	if d > time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(100 * time.Microsecond)
+}
@@ -0,0 +1,143 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/superfly/fly-go/tokens"
+	"github.com/superfly/macaroon"
+	"github.com/superfly/macaroon/flyio"
+
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/audit"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/logger"
+)
+
+// recordAudit writes the command that ran to the audit log and forwards it
+// to the audit webhook, if either is configured and the command changed
+// anything.
+func recordAudit(ctx context.Context, cmd *cobra.Command, start time.Time, err error) {
+	if !config.AuditEnabled(ctx) {
+		return
+	}
+	cfg := config.FromContext(ctx)
+
+	mutated, machines, releases := audit.Touched()
+	if !mutated {
+		return
+	}
+
+	// the command may have been interrupted, which shouldn't keep it out
+	// of the log
+	ctx = context.WithoutCancel(ctx)
+
+	e := audit.Entry{
+		Command:  cmd.CommandPath(),
+		Args:     audit.RedactArgs(flag.Args(ctx)),
+		Flags:    audit.RedactFlags(flag.FromContext(ctx)),
+		App:      appconfig.NameFromContext(ctx),
+		Org:      flag.GetOrg(ctx),
+		Profile:  cfg.Profile,
+		Start:    start,
+		End:      time.Now(),
+		Status:   audit.StatusSucceeded,
+		Machines: machines,
+		Releases: releases,
+	}
+	if e.App == "" {
+		e.App = flag.GetApp(ctx)
+	}
+	if err != nil {
+		e.Status = audit.StatusFailed
+		e.Error = err.Error()
+	}
+
+	e.Identity = Identity(cfg)
+
+	if e.Org == "" {
+		e.Org = tokenOrg(cfg.Tokens)
+	}
+
+	log := logger.FromContext(ctx)
+
+	if cfg.AuditLog != "" {
+		if err := audit.Write(cfg.AuditLog, e); err != nil {
+			log.Warnf("failed recording command in the audit log: %v", err)
+		}
+	}
+
+	if cfg.AuditWebhook != "" {
+		if err := audit.Forward(ctx, cfg.AuditWebhook, e); err != nil {
+			log.Warnf("failed forwarding command to the audit webhook: %v", err)
+		}
+	}
+}
+
+// Identity identifies the user running the command from what flyctl already
+// knows, without asking the API: the email the profile logged in as, or else
+// the user ID the tokens carry. It's empty when neither is known.
+func Identity(cfg *config.Config) string {
+	if cfg.UserEmail != "" {
+		return cfg.UserEmail
+	}
+	return tokenIdentity(cfg.Tokens)
+}
+
+// tokenIdentity identifies the user tokens belong to by the user ID their
+// macaroons carry, without asking the API. It's empty for tokens that carry
+// none, such as legacy user tokens.
+func tokenIdentity(toks *tokens.Tokens) string {
+	for _, tok := range toks.GetMacaroonTokens() {
+		raws, err := macaroon.Parse(tok)
+		if err != nil {
+			continue
+		}
+		for _, raw := range raws {
+			m, err := macaroon.Decode(raw)
+			if err != nil {
+				continue
+			}
+			if id, err := flyio.DangerousUserID(&m.UnsafeCaveats); err == nil {
+				return fmt.Sprintf("user %d", id)
+			}
+		}
+	}
+
+	return ""
+}
+
+// tokenOrg identifies the organization tokens are scoped to by the ID their
+// macaroons carry, without asking the API. It's empty unless they're all
+// scoped to the same one.
+func tokenOrg(toks *tokens.Tokens) string {
+	var org uint64
+	for _, tok := range toks.GetMacaroonTokens() {
+		raws, err := macaroon.Parse(tok)
+		if err != nil {
+			continue
+		}
+		for _, raw := range raws {
+			m, err := macaroon.Decode(raw)
+			if err != nil || m.Location != flyio.LocationPermission {
+				continue
+			}
+			id, err := flyio.OrganizationScope(&m.UnsafeCaveats)
+			switch {
+			case err != nil:
+			case org != 0 && id != org:
+				return ""
+			default:
+				org = id
+			}
+		}
+	}
+
+	if org == 0 {
+		return ""
+	}
+	return fmt.Sprintf("org %d", org)
+}
@@ -0,0 +1,39 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/fly-go/tokens"
+	"github.com/superfly/macaroon"
+	"github.com/superfly/macaroon/flyio"
+	"github.com/superfly/macaroon/resset"
+)
+
+func TestTokenIdentity(t *testing.T) {
+	m, err := macaroon.New([]byte("kid"), flyio.LocationPermission, macaroon.NewSigningKey())
+	require.NoError(t, err)
+	require.NoError(t, m.Add(&flyio.IsUser{ID: 123}))
+	tok, err := m.String()
+	require.NoError(t, err)
+
+	assert.Equal(t, "user 123", tokenIdentity(tokens.Parse(tok)))
+	assert.Empty(t, tokenIdentity(tokens.Parse("fo1_legacy")))
+}
+
+func TestTokenOrg(t *testing.T) {
+	orgToken := func(id uint64) string {
+		m, err := macaroon.New([]byte("kid"), flyio.LocationPermission, macaroon.NewSigningKey())
+		require.NoError(t, err)
+		require.NoError(t, m.Add(&flyio.Organization{ID: id, Mask: resset.ActionAll}))
+		tok, err := m.String()
+		require.NoError(t, err)
+		return tok
+	}
+
+	assert.Equal(t, "org 42", tokenOrg(tokens.Parse(orgToken(42))))
+	assert.Equal(t, "org 42", tokenOrg(tokens.Parse(orgToken(42)+","+orgToken(42))))
+	assert.Empty(t, tokenOrg(tokens.Parse(orgToken(42)+","+orgToken(7))), "tokens for several orgs name none")
+	assert.Empty(t, tokenOrg(tokens.Parse("fo1_legacy")))
+}
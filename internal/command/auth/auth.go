@@ -26,6 +26,10 @@ If you do have an account, begin with the AUTH LOGIN subcommand.
 		newDocker(),
 		newLogout(),
 		newSignup(),
+		newSwitch(),
+		newProfiles(),
+		newMigrateTokens(),
+		newOIDC(),
 	)
 
 	return auth
@@ -23,6 +23,7 @@ browser-based authentication.
 	)
 
 	cmd := command.New("login", short, long, runLogin)
+	command.AllowUnknownProfile(cmd)
 
 	flag.Add(cmd,
 		flag.Bool{
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/spf13/cobra"
@@ -13,7 +14,6 @@ import (
 	"github.com/superfly/flyctl/internal/env"
 	"github.com/superfly/flyctl/internal/flyutil"
 	"github.com/superfly/flyctl/internal/logger"
-	"github.com/superfly/flyctl/internal/state"
 	"github.com/superfly/flyctl/iostreams"
 )
 
@@ -50,8 +50,12 @@ func runLogout(ctx context.Context) (err error) {
 		}
 	}
 
-	path := state.ConfigFile(ctx)
-	if err = config.Clear(path); err != nil {
+	path := config.FromContext(ctx).ProfileFile
+	switch err = config.Clear(path); {
+	case errors.Is(err, config.ErrCredentialStoreUnavailable):
+		log.Warnf("Logged out, but the token is still in its credential store: %v", err)
+		err = nil
+	case err != nil:
 		err = fmt.Errorf("failed clearing config file at %s: %w\n", path, err)
 
 		return
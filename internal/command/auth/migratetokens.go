@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/superfly/flyctl/iostreams"
+
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/state"
+)
+
+func newMigrateTokens() *cobra.Command {
+	const (
+		long = `Move the access tokens of every auth profile to another credential store,
+and keep tokens there from then on. The config store keeps them in plaintext in
+the config file, as flyctl does by default. The keychain store uses the Secret
+Service through secret-tool on Linux, and the login keychain on macOS. The
+encrypted-file store keeps them in credentials.enc next to the config file,
+encrypted with the passphrase in FLY_CREDENTIALS_PASSPHRASE.`
+		short = "Move access tokens to another credential store"
+	)
+
+	cmd := command.New("migrate-tokens", short, long, runMigrateTokens)
+
+	flag.Add(cmd,
+		flag.String{
+			Name:        "to",
+			Description: "Credential store to move tokens to: " + strings.Join(config.CredentialStores, ", "),
+			Default:     config.CredentialStoreKeychain,
+		},
+	)
+
+	cmd.Args = cobra.NoArgs
+
+	return cmd
+}
+
+func runMigrateTokens(ctx context.Context) error {
+	var (
+		io         = iostreams.FromContext(ctx)
+		configPath = state.ConfigFile(ctx)
+		to         = flag.GetString(ctx, "to")
+	)
+
+	if !slices.Contains(config.CredentialStores, to) {
+		return fmt.Errorf("unknown credential store %q, use one of %s", to, strings.Join(config.CredentialStores, ", "))
+	}
+
+	profiles, err := config.ListProfiles(configPath)
+	if err != nil {
+		return err
+	}
+
+	// the config file goes first, so that profile files created later use
+	// the new store too
+	for _, profile := range profiles {
+		path := config.ProfileFile(configPath, profile)
+		if err := config.MigrateAccessToken(path, to); err != nil {
+			return fmt.Errorf("failed moving tokens of profile %s: %w", profile, err)
+		}
+		fmt.Fprintf(io.Out, "Moved tokens of profile %s to the %s store\n", profile, to)
+	}
+
+	return nil
+}
@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/superfly/macaroon"
+	"github.com/superfly/macaroon/flyio"
+	"github.com/superfly/macaroon/tp"
+
+	"github.com/superfly/flyctl/iostreams"
+
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/flyerr"
+)
+
+func newOIDC() *cobra.Command {
+	const (
+		long = `Log in from a CI job with the OIDC identity token the CI system issues it,
+and run a command with the resulting Fly.io token in FLY_API_TOKEN. The token
+only lives in the memory of flyctl and the command; it's never written to disk.
+
+The job needs a base token: a Fly.io token with a third-party caveat that an
+OIDC discharge service clears when it's shown a valid identity token (see
+'fly tokens 3p add'). The base token is useless without the discharge, so it
+doesn't have to be kept secret. Pass it in --token-file, -t or FLY_API_TOKEN.
+
+The token the command gets expires after --ttl. On GitHub Actions, give the
+job the id-token: write permission. On GitLab CI, configure an ID token for the
+job in the variable named by --id-token-env. On Buildkite, the token comes from
+buildkite-agent oidc request-token.
+`
+		short = "Log in with a CI system's OIDC identity token"
+		usage = "oidc --provider <provider> [flags] -- <command> [args...]"
+	)
+
+	cmd := command.New(usage, short, long, runOIDC)
+
+	flag.Add(cmd,
+		flag.String{
+			Name:        "provider",
+			Description: "CI system issuing the identity token: " + strings.Join(oidcProviderNames(), ", "),
+		},
+		flag.String{
+			Name:        "audience",
+			Description: "Audience to request the identity token for, defaulting to the discharge service's location",
+		},
+		flag.String{
+			Name:        "location",
+			Description: "Location of the OIDC discharge service, when the base token has several third-party caveats",
+		},
+		flag.String{
+			Name:        "token-file",
+			Description: "File holding the base token, instead of -t or FLY_API_TOKEN",
+		},
+		flag.String{
+			Name:        "id-token-env",
+			Description: "Environment variable holding the identity token, for providers that pass it that way",
+			Default:     "FLY_ID_TOKEN",
+		},
+		flag.Duration{
+			Name:        "ttl",
+			Description: "How long the token is valid for",
+			Default:     15 * time.Minute,
+		},
+	)
+
+	cmd.Args = cobra.MinimumNArgs(1)
+
+	return cmd
+}
+
+// oidcProvider returns an identity token for the given audience.
+type oidcProvider func(ctx context.Context, audience, tokenEnv string) (string, error)
+
+var oidcProviders = map[string]oidcProvider{
+	"github":    githubIDToken,
+	"gitlab":    envIDToken,
+	"buildkite": buildkiteIDToken,
+}
+
+func oidcProviderNames() []string {
+	names := make([]string, 0, len(oidcProviders))
+	for name := range oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func runOIDC(ctx context.Context) error {
+	io := iostreams.FromContext(ctx)
+
+	providerName := flag.GetString(ctx, "provider")
+	provider, ok := oidcProviders[providerName]
+	if !ok {
+		return fmt.Errorf("unknown provider %q, use one of %s", providerName, strings.Join(oidcProviderNames(), ", "))
+	}
+
+	base, err := oidcBaseToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	location, err := oidcLocation(base, flag.GetString(ctx, "location"))
+	if err != nil {
+		return err
+	}
+
+	audience := flag.GetString(ctx, "audience")
+	if audience == "" {
+		audience = location
+	}
+
+	idToken, err := provider(ctx, audience, flag.GetString(ctx, "id-token-env"))
+	if err != nil {
+		return fmt.Errorf("failed getting an identity token from %s: %w", providerName, err)
+	}
+	if aud := jwtAudience(idToken); len(aud) > 0 && !slices.Contains(aud, audience) {
+		return fmt.Errorf("the identity token is for %s, not %s", strings.Join(aud, ", "), audience)
+	}
+
+	token, err := exchangeOIDCToken(ctx, base, location, idToken, flag.GetDuration(ctx, "ttl"), nil)
+	if err != nil {
+		return err
+	}
+
+	args := flag.Args(ctx)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = io.In
+	cmd.Stdout = io.Out
+	cmd.Stderr = io.ErrOut
+	cmd.Env = append(oidcChildEnv(os.Environ()), config.APITokenEnvKey+"="+token)
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			return flyerr.ExitCodeError{Code: exitErr.ExitCode()}
+		}
+		return err
+	}
+
+	return nil
+}
+
+func oidcBaseToken(ctx context.Context) (string, error) {
+	if path := flag.GetString(ctx, "token-file"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed reading base token: %w", err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+
+	if token := config.Tokens(ctx).MacaroonsOnly().All(); token != "" {
+		return token, nil
+	}
+
+	return "", errors.New("pass a base token in --token-file, -t or FLY_API_TOKEN")
+}
+
+// oidcLocation returns the location of the third-party caveat the identity
+// token discharges.
+func oidcLocation(base, want string) (string, error) {
+	toks, err := macaroon.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse base token: %w", err)
+	}
+
+	perms, _, _, _, err := macaroon.FindPermissionAndDischargeTokens(toks, flyio.LocationPermission)
+	if err != nil {
+		return "", fmt.Errorf("unable to decode base token: %w", err)
+	}
+
+	var locations []string
+	for _, m := range perms {
+		for _, cav := range macaroon.GetCaveats[*macaroon.Caveat3P](&m.UnsafeCaveats) {
+			if !slices.Contains(locations, cav.Location) {
+				locations = append(locations, cav.Location)
+			}
+		}
+	}
+
+	switch {
+	case want != "" && slices.Contains(locations, want):
+		return want, nil
+	case want != "":
+		return "", fmt.Errorf("the base token has no third-party caveat for %s", want)
+	case len(locations) == 1:
+		return locations[0], nil
+	case len(locations) == 0:
+		return "", errors.New("the base token has no third-party caveat to discharge, add one with 'fly tokens 3p add'")
+	default:
+		return "", fmt.Errorf("the base token has third-party caveats for %s, pick one with --location", strings.Join(locations, ", "))
+	}
+}
+
+// exchangeOIDCToken limits the base token to ttl and discharges its
+// third-party caveat at location with the identity token.
+func exchangeOIDCToken(ctx context.Context, base, location, idToken string, ttl time.Duration, httpClient *http.Client) (string, error) {
+	perm, disToks, err := flyio.ParsePermissionAndDischargeTokens(base)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse base token: %w", err)
+	}
+
+	m, err := macaroon.Decode(perm)
+	if err != nil {
+		return "", fmt.Errorf("unable to decode base token: %w", err)
+	}
+
+	now := time.Now()
+	if err := m.Add(&macaroon.ValidityWindow{NotBefore: now.Unix(), NotAfter: now.Add(ttl).Unix()}); err != nil {
+		return "", fmt.Errorf("unable to attenuate base token: %w", err)
+	}
+
+	if perm, err = m.Encode(); err != nil {
+		return "", fmt.Errorf("unable to encode token: %w", err)
+	}
+
+	opts := []tp.ClientOption{tp.WithBearerAuthentication(location, idToken)}
+	if httpClient != nil {
+		opts = append(opts, tp.WithHTTP(httpClient))
+	}
+
+	token, err := flyio.DischargeClient(opts...).FetchDischargeTokens(ctx, macaroon.ToAuthorizationHeader(append([][]byte{perm}, disToks...)...))
+	if err != nil {
+		return "", fmt.Errorf("failed exchanging the identity token at %s: %w", location, err)
+	}
+
+	return token, nil
+}
+
+// oidcChildEnv drops the tokens of the environment flyctl runs in, so that
+// the command only sees the exchanged one.
+func oidcChildEnv(environ []string) []string {
+	env := make([]string, 0, len(environ))
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if name == config.APITokenEnvKey || name == config.AccessTokenEnvKey {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// jwtAudience returns the aud claim of a JWT, without verifying it. The
+// discharge service does that.
+func jwtAudience(token string) []string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil
+	}
+
+	var claims struct {
+		Aud json.RawMessage `json:"aud"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || len(claims.Aud) == 0 {
+		return nil
+	}
+
+	var one string
+	if err := json.Unmarshal(claims.Aud, &one); err == nil {
+		return []string{one}
+	}
+
+	var many []string
+	_ = json.Unmarshal(claims.Aud, &many)
+	return many
+}
+
+// githubIDToken requests an identity token from GitHub Actions, which only
+// offers one to jobs with the id-token: write permission.
+func githubIDToken(ctx context.Context, audience, _ string) (string, error) {
+	requestURL, requestToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL"), os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if requestURL == "" || requestToken == "" {
+		return "", errors.New("ACTIONS_ID_TOKEN_REQUEST_URL isn't set, give the job the id-token: write permission")
+	}
+
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("audience", audience)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+requestToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var body struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Value == "" {
+		return "", errors.New("empty identity token")
+	}
+
+	return body.Value, nil
+}
+
+// envIDToken reads an identity token the CI system put in the environment,
+// like GitLab does with the id_tokens of a job. The audience is set where the
+// token is configured.
+func envIDToken(_ context.Context, _, tokenEnv string) (string, error) {
+	token := os.Getenv(tokenEnv)
+	if token == "" {
+		return "", fmt.Errorf("%s isn't set, configure an ID token with that name for the job", tokenEnv)
+	}
+	return token, nil
+}
+
+func buildkiteIDToken(ctx context.Context, audience, _ string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "buildkite-agent", "oidc", "request-token", "--audience", audience)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/macaroon"
+	"github.com/superfly/macaroon/flyio"
+	"github.com/superfly/macaroon/tp"
+)
+
+func TestOIDCExchange(t *testing.T) {
+	tpKey := macaroon.NewEncryptionKey()
+	server := &tp.TP{Key: tpKey}
+
+	mux := http.NewServeMux()
+	mux.Handle(tp.InitPath, server.InitRequestMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer id-token" {
+			server.RespondError(w, r, http.StatusUnauthorized, "bad identity token")
+			return
+		}
+		server.RespondDischarge(w, r)
+	})))
+	hs := httptest.NewServer(mux)
+	defer hs.Close()
+	server.Location = hs.URL
+
+	m, err := macaroon.New([]byte("kid"), flyio.LocationPermission, macaroon.NewSigningKey())
+	require.NoError(t, err)
+	require.NoError(t, m.Add3P(tpKey, hs.URL))
+	base, err := m.String()
+	require.NoError(t, err)
+
+	location, err := oidcLocation(base, "")
+	require.NoError(t, err)
+	assert.Equal(t, hs.URL, location)
+
+	_, err = oidcLocation(base, "https://elsewhere.example")
+	assert.Error(t, err)
+
+	_, err = exchangeOIDCToken(context.Background(), base, location, "wrong", time.Minute, hs.Client())
+	assert.Error(t, err)
+
+	token, err := exchangeOIDCToken(context.Background(), base, location, "id-token", time.Minute, hs.Client())
+	require.NoError(t, err)
+
+	toks, err := macaroon.Parse(token)
+	require.NoError(t, err)
+	perms, _, _, dischs, err := macaroon.FindPermissionAndDischargeTokens(toks, flyio.LocationPermission)
+	require.NoError(t, err)
+	require.Len(t, perms, 1)
+	assert.Len(t, dischs, 1)
+
+	windows := macaroon.GetCaveats[*macaroon.ValidityWindow](&perms[0].UnsafeCaveats)
+	require.Len(t, windows, 1)
+	assert.LessOrEqual(t, windows[0].NotAfter, time.Now().Add(time.Minute).Unix())
+}
+
+func TestOIDCProviders(t *testing.T) {
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer request-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"value":"` + r.URL.Query().Get("audience") + `-token"}`))
+	}))
+	defer hs.Close()
+
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", hs.URL+"?api-version=2.0")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", "request-token")
+
+	token, err := githubIDToken(context.Background(), "fly", "")
+	require.NoError(t, err)
+	assert.Equal(t, "fly-token", token)
+
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", "")
+	_, err = githubIDToken(context.Background(), "fly", "")
+	assert.Error(t, err)
+
+	t.Setenv("CI_FLY_TOKEN", "gitlab-token")
+	token, err = envIDToken(context.Background(), "fly", "CI_FLY_TOKEN")
+	require.NoError(t, err)
+	assert.Equal(t, "gitlab-token", token)
+
+	_, err = envIDToken(context.Background(), "fly", "CI_MISSING_TOKEN")
+	assert.Error(t, err)
+}
+
+func TestJWTAudience(t *testing.T) {
+	jwt := func(payload string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+	}
+
+	assert.Equal(t, []string{"fly"}, jwtAudience(jwt(`{"aud":"fly"}`)))
+	assert.Equal(t, []string{"fly", "other"}, jwtAudience(jwt(`{"aud":["fly","other"]}`)))
+	assert.Empty(t, jwtAudience(jwt(`{"sub":"repo"}`)))
+	assert.Empty(t, jwtAudience("opaque"))
+}
+
+func TestOIDCChildEnv(t *testing.T) {
+	env := oidcChildEnv([]string{"PATH=/bin", "FLY_API_TOKEN=base", "FLY_ACCESS_TOKEN=old", "FLY_APP=web"})
+	assert.Equal(t, []string{"PATH=/bin", "FLY_APP=web"}, env)
+}
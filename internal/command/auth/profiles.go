@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/superfly/flyctl/iostreams"
+
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/format"
+	"github.com/superfly/flyctl/internal/render"
+	"github.com/superfly/flyctl/internal/state"
+)
+
+func newSwitch() *cobra.Command {
+	const (
+		long = `Switch the auth profile flyctl uses. Each profile has its own tokens,
+default organization and region, and WireGuard peers. Create a profile by
+logging in to it with 'fly auth login --profile <name>'.
+
+A profile can also be picked for a single command with --profile or
+FLY_PROFILE, or for a project with a .fly/profile file, which --project writes.
+`
+		short = "Switch to another auth profile"
+	)
+
+	cmd := command.New("switch <profile>", short, long, runSwitch)
+	// switching is how a current_profile that's gone is fixed
+	command.AllowUnknownProfile(cmd)
+
+	flag.Add(cmd,
+		flag.Bool{
+			Name:        "project",
+			Description: "Use the profile for the project in the working directory only, by writing .fly/profile",
+		},
+		flag.String{
+			Name:        "default-org",
+			Description: "Set the organization the profile uses by default",
+		},
+		flag.String{
+			Name:        "default-region",
+			Description: "Set the region the profile uses by default",
+		},
+	)
+
+	cmd.Args = cobra.ExactArgs(1)
+
+	return cmd
+}
+
+func runSwitch(ctx context.Context) error {
+	var (
+		io         = iostreams.FromContext(ctx)
+		configPath = state.ConfigFile(ctx)
+		profile    = flag.FirstArg(ctx)
+	)
+
+	if err := config.ValidateProfileName(profile); err != nil {
+		return err
+	}
+	if !config.ProfileExists(configPath, profile) {
+		return fmt.Errorf("profile %s doesn't exist, create it with 'fly auth login --profile %s'", profile, profile)
+	}
+
+	profilePath := config.ProfileFile(configPath, profile)
+	if err := config.SetProfileDefaults(profilePath, flag.GetString(ctx, "default-org"), flag.GetString(ctx, "default-region")); err != nil {
+		return fmt.Errorf("failed saving profile defaults: %w", err)
+	}
+
+	if flag.GetBool(ctx, "project") {
+		if err := config.SetProjectProfile(state.WorkingDirectory(ctx), profile); err != nil {
+			return err
+		}
+		fmt.Fprintf(io.Out, "Using profile %s in %s\n", profile, state.WorkingDirectory(ctx))
+		return nil
+	}
+
+	if err := config.SetCurrentProfile(configPath, profile); err != nil {
+		return fmt.Errorf("failed saving current profile: %w", err)
+	}
+	fmt.Fprintf(io.Out, "Switched to profile %s\n", profile)
+
+	switch source := config.FromContext(ctx).ProfileSource; source {
+	case config.ProfileFromEnv, config.ProfileFromProject:
+		fmt.Fprintf(io.ErrOut, "Note: %s still selects profile %s here\n", source, config.FromContext(ctx).Profile)
+	}
+
+	return nil
+}
+
+func newProfiles() *cobra.Command {
+	const (
+		long = `List auth profiles, with the default organization and region of each and
+when it last logged in. The active profile is marked with an asterisk.
+`
+		short = "List auth profiles"
+	)
+
+	cmd := command.New("profiles", short, long, runProfiles)
+	command.AllowUnknownProfile(cmd)
+
+	flag.Add(cmd, flag.JSONOutput())
+
+	return cmd
+}
+
+type profileInfo struct {
+	Name         string `json:"name"`
+	Active       bool   `json:"active"`
+	LoggedIn     bool   `json:"logged_in"`
+	Organization string `json:"organization,omitempty"`
+	Region       string `json:"region,omitempty"`
+	LastLogin    string `json:"last_login,omitempty"`
+}
+
+func runProfiles(ctx context.Context) error {
+	var (
+		io         = iostreams.FromContext(ctx)
+		cfg        = config.FromContext(ctx)
+		configPath = state.ConfigFile(ctx)
+	)
+
+	names, err := config.ListProfiles(configPath)
+	if err != nil {
+		return err
+	}
+
+	var profiles []profileInfo
+	for _, name := range names {
+		path := config.ProfileFile(configPath, name)
+		summary, err := config.ReadProfileSummary(path)
+		if err != nil {
+			return fmt.Errorf("failed reading profile %s: %w", name, err)
+		}
+		token, _ := config.ReadAccessToken(path)
+
+		info := profileInfo{
+			Name:         name,
+			Active:       name == cfg.Profile,
+			LoggedIn:     token != "",
+			Organization: summary.Organization,
+			Region:       summary.Region,
+		}
+		if !summary.LastLogin.IsZero() {
+			info.LastLogin = format.RelativeTime(summary.LastLogin)
+		}
+		profiles = append(profiles, info)
+	}
+
+	if cfg.JSONOutput {
+		return render.JSON(io.Out, profiles)
+	}
+
+	var rows [][]string
+	for _, p := range profiles {
+		name := p.Name
+		if p.Active {
+			name += " *"
+		}
+		loggedIn := "no"
+		if p.LoggedIn {
+			loggedIn = "yes"
+		}
+		rows = append(rows, []string{name, loggedIn, p.Organization, p.Region, p.LastLogin})
+	}
+
+	return render.Table(io.Out, "", rows, "Profile", "Logged In", "Org", "Region", "Last Login")
+}
@@ -17,7 +17,10 @@ and sends the user to a form to provide appropriate credentials.
 		short = "Create a new fly account"
 	)
 
-	return command.New("signup", short, long, runSignup)
+	cmd := command.New("signup", short, long, runSignup)
+	command.AllowUnknownProfile(cmd)
+
+	return cmd
 }
 
 func runSignup(ctx context.Context) error {
@@ -25,14 +25,14 @@ func SaveToken(ctx context.Context, token string) error {
 	if ac, err := agent.DefaultClient(ctx); err == nil {
 		_ = ac.Kill(ctx)
 	}
-	config.Clear(state.ConfigFile(ctx))
+	config.Clear(config.FromContext(ctx).ProfileFile)
 
 	if err := persistAccessToken(ctx, token); err != nil {
 		return err
 	}
 
 	// Record the login timestamp
-	if err := config.SetLastLogin(state.ConfigFile(ctx), time.Now()); err != nil {
+	if err := config.SetLastLogin(config.FromContext(ctx).ProfileFile, time.Now()); err != nil {
 		return fmt.Errorf("failed persisting login timestamp: %w", err)
 	}
 
@@ -43,9 +43,17 @@ func SaveToken(ctx context.Context, token string) error {
 		return fmt.Errorf("failed retrieving current user: %w", err)
 	}
 
+	// kept for the audit log, which shouldn't look the user up every time
+	if err := config.SetUserEmail(config.FromContext(ctx).ProfileFile, user.Email); err != nil {
+		return fmt.Errorf("failed persisting user email: %w", err)
+	}
+
 	io := iostreams.FromContext(ctx)
 	colorize := io.ColorScheme()
 	fmt.Fprintf(io.Out, "successfully logged in as %s\n", colorize.Bold(user.Email))
+	if profile := config.FromContext(ctx).Profile; profile != config.DefaultProfile {
+		fmt.Fprintf(io.Out, "saved to auth profile %s\n", colorize.Bold(profile))
+	}
 
 	return nil
 }
@@ -139,7 +147,7 @@ func waitForCLISession(parent context.Context, logger *logger.Logger, w io.Write
 }
 
 func persistAccessToken(ctx context.Context, token string) (err error) {
-	path := state.ConfigFile(ctx)
+	path := config.FromContext(ctx).ProfileFile
 
 	if err = config.SetAccessToken(path, token); err != nil {
 		err = fmt.Errorf("failed persisting %s in %s: %w\n",
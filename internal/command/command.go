@@ -30,6 +30,7 @@ import (
 	"github.com/superfly/flyctl/internal/config"
 	"github.com/superfly/flyctl/internal/env"
 	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/flyerr"
 	"github.com/superfly/flyctl/internal/incidents"
 	"github.com/superfly/flyctl/internal/logger"
 	"github.com/superfly/flyctl/internal/metrics"
@@ -69,6 +70,7 @@ var commonPreparers = []preparers.Preparer{
 	ensureConfigDirPerms,
 	loadCache,
 	preparers.LoadConfig,
+	checkProfile,
 	startQueryingForNewRelease,
 	promptAndAutoUpdate,
 	startMetrics,
@@ -143,6 +145,12 @@ func newRunE(fn Runner, preparers ...preparers.Preparer) func(*cobra.Command, []
 			}
 		}()
 
+		// record the command in the audit log once it's done
+		start := time.Now()
+		defer func() {
+			recordAudit(ctx, cmd, start, err)
+		}()
+
 		// run the command
 		if err = fn(ctx); err == nil {
 			// and finally, run the finalizer
@@ -272,6 +280,20 @@ func loadCache(ctx context.Context) (context.Context, error) {
 	return cache.NewContext(ctx, c), nil
 }
 
+// checkProfile fails commands run with an auth profile nobody logged in to,
+// which is most likely a typo, rather than run them without tokens.
+func checkProfile(ctx context.Context) (context.Context, error) {
+	cfg := config.FromContext(ctx)
+	if config.ProfileExists(state.ConfigFile(ctx), cfg.Profile) || allowsUnknownProfile(FromContext(ctx)) {
+		return ctx, nil
+	}
+
+	return nil, flyerr.GenericErr{
+		Err:     fmt.Sprintf("unknown profile %q, selected by %s", cfg.Profile, cfg.ProfileSource),
+		Suggest: fmt.Sprintf("List profiles with 'fly auth profiles', or create this one with 'fly auth login --profile %s'", cfg.Profile),
+	}
+}
+
 func startQueryingForNewRelease(ctx context.Context) (context.Context, error) {
 	logger := logger.FromContext(ctx)
 
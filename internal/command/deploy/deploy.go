@@ -59,6 +59,8 @@ var CommonFlags = flag.Set{
 	flag.BuildkitImage(),
 	flag.Buildkit(),
 	flag.BuildOnly(),
+	flag.BuildOutput(),
+	flag.BuildOutputPath(),
 	flag.BpDockerHost(),
 	flag.BpVolume(),
 	flag.RecreateBuilder(),
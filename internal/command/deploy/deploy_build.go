@@ -127,6 +127,14 @@ func determineImage(ctx context.Context, app *flaps.App, appConfig *appconfig.Co
 		imgsrc.WithProvisioner(provisioner),
 	)
 
+	var export *imgsrc.ImageExport
+	if format := flag.GetBuildOutput(ctx); format != "" {
+		if export, err = imgsrc.NewImageExport(format, flag.GetBuildOutputPath(ctx), appConfig.AppName); err != nil {
+			tracing.RecordError(span, err, "failed to parse build output")
+			return
+		}
+	}
+
 	var imageRef string
 	if imageRef, err = fetchImageRef(ctx, appConfig); err != nil {
 		tracing.RecordError(span, err, "failed to fetch image ref")
@@ -141,6 +149,7 @@ func determineImage(ctx context.Context, app *flaps.App, appConfig *appconfig.Co
 			Publish:    flag.GetBool(ctx, "push") || !flag.GetBuildOnly(ctx),
 			ImageRef:   imageRef,
 			ImageLabel: flag.GetString(ctx, "image-label"),
+			Export:     export,
 		}
 
 		span.SetAttributes(opts.ToSpanAttributes()...)
@@ -183,6 +192,7 @@ func determineImage(ctx context.Context, app *flaps.App, appConfig *appconfig.Co
 		Buildpacks:           build.Buildpacks,
 		BuildpacksDockerHost: flag.GetString(ctx, flag.BuildpacksDockerHost),
 		BuildpacksVolumes:    flag.GetStringSlice(ctx, flag.BuildpacksVolume),
+		Export:               export,
 	}
 
 	if appConfig.Experimental != nil {
@@ -20,6 +20,7 @@ import (
 	fly "github.com/superfly/fly-go"
 	"github.com/superfly/flyctl/helpers"
 	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/appsecrets"
 	"github.com/superfly/flyctl/internal/buildinfo"
 	"github.com/superfly/flyctl/internal/command/deploy/statics"
 	machcmd "github.com/superfly/flyctl/internal/command/machine"
@@ -121,6 +122,13 @@ func (md *machineDeployment) DeployMachinesApp(ctx context.Context) error {
 		}
 	}
 
+	// the secrets history notes which release deployed each change
+	if err == nil && md.releaseVersion > 0 {
+		if minvers, mErr := appsecrets.GetMinvers(md.app.Name); mErr == nil {
+			appsecrets.RecordRelease(ctx, md.app.Name, minvers, md.releaseVersion)
+		}
+	}
+
 	// no need to run dns checks if the deployment failed
 	if !md.skipDNSChecks && err == nil {
 		if err := md.checkDNS(ctx); err != nil {
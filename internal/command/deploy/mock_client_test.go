@@ -59,6 +59,10 @@ func (m *mockFlapsClient) CreateVolumeSnapshot(ctx context.Context, appName, vol
 	return fmt.Errorf("failed to create volume snapshot %s", volumeId)
 }
 
+func (m *mockFlapsClient) DecryptSecretKey(ctx context.Context, appName, name string, ciphertext, assoc []byte, version *uint64) (*fly.DecryptSecretKeyResp, error) {
+	return nil, fmt.Errorf("failed to decrypt with secret key %s", name)
+}
+
 func (m *mockFlapsClient) DeleteApp(ctx context.Context, name string) error {
 	return fmt.Errorf("failed to delete app %s", name)
 }
@@ -90,6 +94,10 @@ func (m *mockFlapsClient) Destroy(ctx context.Context, appName string, input fly
 	return nil
 }
 
+func (m *mockFlapsClient) EncryptSecretKey(ctx context.Context, appName, name string, plaintext, assoc []byte, version *uint64) (*fly.EncryptSecretKeyResp, error) {
+	return nil, fmt.Errorf("failed to encrypt with secret key %s", name)
+}
+
 func (m *mockFlapsClient) Exec(ctx context.Context, appName, machineID string, in *fly.MachineExecRequest) (*fly.MachineExecResponse, error) {
 	return nil, fmt.Errorf("failed to exec %s", machineID)
 }
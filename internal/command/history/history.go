@@ -2,34 +2,105 @@
 package history
 
 import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
 	"github.com/spf13/cobra"
 
+	"github.com/superfly/flyctl/iostreams"
+
+	"github.com/superfly/flyctl/internal/audit"
 	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/config"
 	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/render"
 )
 
 func New() (cmd *cobra.Command) {
 	const (
-		long = `List the history of changes in the application. Includes autoscaling
-events and their results.
+		long = `List the commands that changed something, from the local audit log. Only
+commands run while the audit log was configured are listed; set audit_log in
+~/.fly/config.yml or FLY_AUDIT_LOG to the file to log to, and audit_webhook or
+FLY_AUDIT_WEBHOOK to a URL to also post each entry to.
+
+An app's releases are listed by 'fly apps releases'.
 `
-		short = "List an app's change history"
+		short = "List the commands that changed something"
 	)
 
-	cmd = command.New("history", short, long, nil,
-		command.RequireSession,
-		command.RequireAppName,
-	)
-	cmd.Deprecated = "Use `flyctl apps releases` instead"
-	cmd.Hidden = true
+	cmd = command.New("history", short, long, run)
 
 	cmd.Args = cobra.NoArgs
 
 	flag.Add(cmd,
 		flag.App(),
-		flag.AppConfig(),
 		flag.JSONOutput(),
+		flag.Bool{
+			Name:        "audit",
+			Description: "List the commands in the local audit log, which is the default",
+			Hidden:      true,
+		},
+		flag.Duration{
+			Name:        "since",
+			Description: "Only list commands started within this long",
+		},
+		flag.Int{
+			Name:        "limit",
+			Description: "List at most this many of the latest commands",
+		},
 	)
 
 	return
 }
+
+func run(ctx context.Context) error {
+	path := config.FromContext(ctx).AuditLog
+	if path == "" {
+		return fmt.Errorf("the audit log is off, set %s in the config file or %s to turn it on", config.AuditLogFileKey, config.AuditLogEnvKey)
+	}
+
+	entries, err := audit.Read(path)
+	if err != nil {
+		return err
+	}
+
+	entries = filterEntries(entries, flag.GetApp(ctx), flag.GetDuration(ctx, "since"), flag.GetInt(ctx, "limit"))
+
+	out := iostreams.FromContext(ctx).Out
+	if config.FromContext(ctx).JSONOutput {
+		return render.JSON(out, entries)
+	}
+
+	rows := make([][]string, 0, len(entries))
+	for _, e := range entries {
+		rows = append(rows, []string{
+			e.Start.Local().Format(time.DateTime),
+			e.Command,
+			e.App,
+			e.Identity,
+			e.Status,
+			e.End.Sub(e.Start).Round(time.Second).String(),
+			strings.Join(e.Machines, ", "),
+			strings.Join(e.Releases, ", "),
+		})
+	}
+
+	return render.Table(out, "", rows, "Started", "Command", "App", "Identity", "Status", "Took", "Machines", "Releases")
+}
+
+// filterEntries returns the entries for app, started within since, and at
+// most the latest limit of them. Empty values don't filter.
+func filterEntries(entries []audit.Entry, app string, since time.Duration, limit int) []audit.Entry {
+	entries = slices.DeleteFunc(entries, func(e audit.Entry) bool {
+		return (app != "" && e.App != app) || (since > 0 && time.Since(e.Start) > since)
+	})
+
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	return entries
+}
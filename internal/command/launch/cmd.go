@@ -177,6 +177,16 @@ func New() (cmd *cobra.Command) {
 			Name:        "secret",
 			Description: "Set of secrets in the form of NAME=VALUE pairs. Can be specified multiple times.",
 		},
+		flag.String{
+			Name:        "from-compose",
+			Description: "Launch from a Docker Compose file. Without a path, compose.yaml or docker-compose.yml in the working directory is used",
+			NoOptDefVal: "auto",
+		},
+		flag.String{
+			Name:        "compose-mapping",
+			Description: "How compose services are mapped when using --from-compose. Options: processes (launch one app with a process group per service) or apps (only write a fly.<service>.toml per service, to launch one by one)",
+			Default:     composeMappingProcesses,
+		},
 		flag.String{
 			Name:        "db",
 			Description: "Provision a Postgres database. Options: mpg (managed postgres), upg/legacy (unmanaged postgres), or true (default type)",
@@ -395,6 +405,20 @@ func run(ctx context.Context) (err error) {
 		return err
 	}
 
+	// "--from-compose" handling
+	compose, err := loadComposeLaunch(ctx)
+	if err != nil {
+		return err
+	}
+	if compose != nil {
+		if compose.mapping == composeMappingApps {
+			return compose.writeApps(ctx)
+		}
+		if ctx, err = compose.setupProcesses(ctx); err != nil {
+			return err
+		}
+	}
+
 	incompleteLaunchManifest := false
 	canEnterUi := !flag.GetBool(ctx, "manifest") && io.IsInteractive() && !env.IsCI()
 
@@ -409,6 +433,12 @@ func run(ctx context.Context) (err error) {
 			}
 		}
 
+		if compose != nil && launchManifest != nil {
+			if err := compose.applyToPlan(ctx, launchManifest); err != nil {
+				return err
+			}
+		}
+
 		manifestFlag := flag.GetBool(ctx, "manifest")
 		manifestPath := flag.GetString(ctx, "manifest-path")
 
@@ -0,0 +1,415 @@
+package launch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/command/launch/plan"
+	"github.com/superfly/flyctl/internal/containerconfig"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/flyerr"
+	"github.com/superfly/flyctl/internal/launchdarkly"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+const (
+	// composeMappingProcesses runs every compose service as a process group
+	// of a single app
+	composeMappingProcesses = "processes"
+	// composeMappingApps only writes an app config per compose service, for
+	// the user to launch one by one
+	composeMappingApps = "apps"
+)
+
+// composeLaunch holds a compose file translated for fly launch
+type composeLaunch struct {
+	path       string
+	mapping    string
+	conversion *containerconfig.ComposeConversion
+	notes      []string
+}
+
+// loadComposeLaunch reads the compose file given with --from-compose, if any
+func loadComposeLaunch(ctx context.Context) (*composeLaunch, error) {
+	if !flag.IsSpecified(ctx, "from-compose") {
+		return nil, nil
+	}
+
+	mapping := flag.GetString(ctx, "compose-mapping")
+	if mapping != composeMappingProcesses && mapping != composeMappingApps {
+		return nil, flyerr.GenericErr{
+			Err:     fmt.Sprintf("Invalid value '%s' for --compose-mapping", mapping),
+			Suggest: "Valid options: processes (launch one app with a process group per service) or apps (write a config per service to launch yourself)",
+		}
+	}
+
+	path := flag.GetString(ctx, "from-compose")
+	if path == "" || path == "auto" {
+		path = detectComposeFile(flag.GetString(ctx, "path"))
+		if path == "" {
+			return nil, fmt.Errorf("no compose file found, looked for %s", strings.Join(appconfig.WellKnownComposeFilenames, ", "))
+		}
+	}
+
+	compose, err := containerconfig.LoadComposeFile(path)
+	if err != nil {
+		return nil, err
+	}
+	conversion, err := containerconfig.ConvertCompose(compose, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &composeLaunch{path: path, mapping: mapping, conversion: conversion}, nil
+}
+
+func detectComposeFile(dir string) string {
+	for _, name := range appconfig.WellKnownComposeFilenames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// setupProcesses builds a fly.toml with a process group per compose service
+// and hands it to the regular launch flow as if it had been copied.
+func (c *composeLaunch) setupProcesses(ctx context.Context) (context.Context, error) {
+	cfg, secrets := c.processConfig(filepath.Dir(c.path))
+	if err := cfg.SetMachinesPlatform(); err != nil {
+		return ctx, err
+	}
+
+	flags := flag.FromContext(ctx)
+	if err := flags.Set("copy-config", "true"); err != nil {
+		return ctx, err
+	}
+
+	// Secrets given on the command line win over the ones found in compose
+	specified := map[string]bool{}
+	for _, secret := range flag.GetStringArray(ctx, "secret") {
+		key, _, _ := strings.Cut(secret, "=")
+		specified[key] = true
+	}
+	for _, key := range sortedKeys(secrets) {
+		if specified[key] {
+			continue
+		}
+		if err := flags.Set("secret", key+"="+secrets[key]); err != nil {
+			return ctx, err
+		}
+	}
+
+	c.printReport(ctx, nil)
+	return appconfig.WithConfig(ctx, cfg), nil
+}
+
+// processConfig translates the conversion into a single app config where
+// every service is a process group. Services that can't share the app's
+// image are left out and reported.
+func (c *composeLaunch) processConfig(composeDir string) (*appconfig.Config, map[string]string) {
+	cfg := appconfig.NewConfig()
+	cfg.Env = map[string]string{}
+	cfg.Processes = map[string]string{}
+	secrets := map[string]string{}
+
+	var (
+		image     string
+		build     *containerconfig.ConvertedService
+		hasDeps   bool
+		httpGroup bool
+	)
+	for _, svc := range c.conversion.Services {
+		if svc.Build {
+			build = svc
+			break
+		}
+	}
+	if build != nil {
+		dockerfile := build.Dockerfile
+		if dockerfile == "" {
+			if _, err := os.Stat(filepath.Join(composeDir, "Dockerfile")); err == nil {
+				dockerfile = "Dockerfile"
+			}
+		}
+		if dockerfile != "" {
+			cfg.Build = &appconfig.Build{Dockerfile: dockerfile}
+		}
+	} else {
+		image = c.conversion.Services[0].Image
+		cfg.Build = &appconfig.Build{Image: image}
+	}
+
+	for _, svc := range c.conversion.Services {
+		switch {
+		case build != nil && svc.Build && svc.Dockerfile != build.Dockerfile:
+			c.note("service %q: builds from a different Dockerfile than %q, write a config for it with --compose-mapping=apps", svc.Name, build.Name)
+			continue
+		case build != nil && !svc.Build, build == nil && svc.Image != image:
+			c.note("service %q: runs image %s which differs from the app's image, write a config for it with --compose-mapping=apps", svc.Name, svc.Image)
+			continue
+		}
+
+		group := sanitizeAppName(svc.Name)
+		cfg.Processes[group] = svc.Command
+
+		for _, k := range sortedKeys(svc.Env) {
+			if v, ok := cfg.Env[k]; ok && v != svc.Env[k] {
+				c.note("service %q: %s differs from another service, [env] is shared by all process groups and keeps %q", svc.Name, k, v)
+				continue
+			}
+			cfg.Env[k] = svc.Env[k]
+		}
+		for k, v := range svc.Secrets {
+			secrets[k] = v
+		}
+
+		for _, m := range svc.Mounts {
+			cfg.Mounts = append(cfg.Mounts, appconfig.Mount{Source: m.Source, Destination: m.Destination, Processes: []string{group}})
+		}
+		for _, f := range svc.Files {
+			cfg.Files = append(cfg.Files, appconfig.File{LocalPath: f.LocalPath, GuestPath: f.GuestPath, Processes: []string{group}})
+		}
+		httpGroup = addComposePorts(cfg, group, svc.Ports, !httpGroup) || httpGroup
+
+		for _, dep := range svc.DependsOn {
+			c.note("service %q: process groups start together, depends_on %q isn't enforced", svc.Name, dep)
+			hasDeps = true
+		}
+		c.noteServiceReferences(svc, func(name string) string {
+			return fmt.Sprintf("%s.process.<app>.internal", sanitizeAppName(name))
+		})
+	}
+	if hasDeps {
+		c.note("use --compose-mapping=apps to write a config per service and launch them in dependency order")
+	}
+
+	return cfg, secrets
+}
+
+// writeApps writes a fly.<service>.toml for every compose service and
+// reports how to launch them in dependency order. It doesn't launch anything
+// itself: each app goes through fly launch on its own, so that it can be
+// reviewed first.
+func (c *composeLaunch) writeApps(ctx context.Context) error {
+	io := iostreams.FromContext(ctx)
+
+	workingDir := flag.GetString(ctx, "path")
+	baseName := flag.GetString(ctx, "name")
+	if baseName == "" {
+		absDir, err := filepath.Abs(workingDir)
+		if err != nil {
+			return err
+		}
+		baseName = sanitizeAppName(filepath.Base(absDir))
+	}
+
+	var order []string
+	for _, svc := range c.conversion.Services {
+		cfg := c.appConfig(baseName, svc)
+		if err := cfg.SetMachinesPlatform(); err != nil {
+			return err
+		}
+		filename := filepath.Join(workingDir, fmt.Sprintf("fly.%s.toml", sanitizeAppName(svc.Name)))
+		if err := cfg.WriteToFile(filename); err != nil {
+			return err
+		}
+		fmt.Fprintf(io.Out, "Wrote %s for app %s\n", filename, cfg.AppName)
+
+		if len(svc.Secrets) > 0 {
+			c.note("service %q: set %s with 'fly secrets set -a %s'", svc.Name, strings.Join(sortedKeys(svc.Secrets), ", "), cfg.AppName)
+		}
+		order = append(order, fmt.Sprintf("fly launch --copy-config --config %s", filename))
+	}
+
+	c.printReport(ctx, order)
+	return nil
+}
+
+// appConfig translates a single compose service into its own app
+func (c *composeLaunch) appConfig(baseName string, svc *containerconfig.ConvertedService) *appconfig.Config {
+	cfg := appconfig.NewConfig()
+	cfg.AppName = baseName + "-" + sanitizeAppName(svc.Name)
+	if len(svc.Env) > 0 {
+		cfg.Env = svc.Env
+	}
+
+	switch {
+	case svc.Image != "":
+		cfg.Build = &appconfig.Build{Image: svc.Image}
+	case svc.Dockerfile != "":
+		cfg.Build = &appconfig.Build{Dockerfile: svc.Dockerfile}
+	}
+
+	if svc.Command != "" {
+		cfg.Processes = map[string]string{fly.MachineProcessGroupApp: svc.Command}
+	}
+	for _, m := range svc.Mounts {
+		cfg.Mounts = append(cfg.Mounts, appconfig.Mount{Source: m.Source, Destination: m.Destination})
+	}
+	for _, f := range svc.Files {
+		cfg.Files = append(cfg.Files, appconfig.File{LocalPath: f.LocalPath, GuestPath: f.GuestPath})
+	}
+	addComposePorts(cfg, fly.MachineProcessGroupApp, svc.Ports, true)
+
+	c.noteServiceReferences(svc, func(name string) string {
+		return fmt.Sprintf("%s-%s.internal", baseName, sanitizeAppName(name))
+	})
+	return cfg
+}
+
+// addComposePorts turns published ports into services. The first port
+// published on 80 or 443, or else the first port at all, becomes the
+// http_service when allowHTTP is set; it returns whether it did so.
+func addComposePorts(cfg *appconfig.Config, group string, ports []containerconfig.ConvertedPort, allowHTTP bool) bool {
+	httpIndex := -1
+	if allowHTTP {
+		for i, p := range ports {
+			if p.Protocol == "tcp" && (p.ExternalPort == 80 || p.ExternalPort == 443) {
+				httpIndex = i
+				break
+			}
+		}
+		if httpIndex < 0 && len(ports) > 0 && ports[0].Protocol == "tcp" {
+			httpIndex = 0
+		}
+	}
+
+	for i, p := range ports {
+		if i == httpIndex {
+			cfg.HTTPService = &appconfig.HTTPService{
+				InternalPort:       p.InternalPort,
+				ForceHTTPS:         true,
+				AutoStopMachines:   fly.Pointer(fly.MachineAutostopStop),
+				AutoStartMachines:  fly.Pointer(true),
+				MinMachinesRunning: fly.Pointer(0),
+				Processes:          []string{group},
+			}
+			continue
+		}
+		if httpIndex >= 0 && p.InternalPort == ports[httpIndex].InternalPort && (p.ExternalPort == 80 || p.ExternalPort == 443) {
+			// already covered by the http_service
+			continue
+		}
+		cfg.Services = append(cfg.Services, appconfig.Service{
+			Protocol:     p.Protocol,
+			InternalPort: p.InternalPort,
+			Ports:        []fly.MachinePort{{Port: fly.Pointer(p.ExternalPort)}},
+			Processes:    []string{group},
+		})
+	}
+	return httpIndex >= 0
+}
+
+// noteServiceReferences reports environment values that use another compose
+// service's name as a hostname
+func (c *composeLaunch) noteServiceReferences(svc *containerconfig.ConvertedService, hostname func(string) string) {
+	for _, other := range c.conversion.Services {
+		if other == svc {
+			continue
+		}
+		pattern := containerconfig.ServiceHostPattern(other.Name)
+		for _, k := range sortedKeys(svc.Env) {
+			if pattern.MatchString(svc.Env[k]) {
+				c.note("service %q: %s refers to %q, reach it at %s", svc.Name, k, other.Name, hostname(other.Name))
+			}
+		}
+	}
+}
+
+// applyToPlan adds the managed databases replacing compose services to the
+// launch plan.
+func (c *composeLaunch) applyToPlan(ctx context.Context, manifest *LaunchManifest) error {
+	lp := manifest.Plan
+	for _, managed := range c.conversion.Managed {
+		switch managed.Kind {
+		case containerconfig.ManagedServicePostgres:
+			if flag.GetBool(ctx, "no-db") || lp.Postgres.Provider() != nil {
+				continue
+			}
+			ldClient, err := launchdarkly.NewServiceClient()
+			if err != nil {
+				return err
+			}
+			lp.Postgres, err = plan.DefaultPostgres(ctx, lp, ldClient.ManagedPostgresEnabled())
+			if err != nil {
+				return err
+			}
+			if lp.Postgres.ManagedPostgres != nil && lp.Postgres.ManagedPostgres.Region != lp.RegionCode {
+				lp.RegionCode = lp.Postgres.ManagedPostgres.Region
+			}
+			if manifest.PlanSource != nil {
+				manifest.PlanSource.postgresSource = fmt.Sprintf("replaces compose service %s", managed.Name)
+			}
+		case containerconfig.ManagedServiceRedis:
+			if flag.GetBool(ctx, "no-redis") || lp.Redis.Provider() != nil {
+				continue
+			}
+			lp.Redis = plan.DefaultRedis(lp)
+			if manifest.PlanSource != nil {
+				manifest.PlanSource.redisSource = fmt.Sprintf("replaces compose service %s", managed.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *composeLaunch) note(format string, args ...interface{}) {
+	c.notes = append(c.notes, fmt.Sprintf(format, args...))
+}
+
+// printReport lists the managed services, what couldn't be translated and,
+// for separate apps, the order to launch them in.
+func (c *composeLaunch) printReport(ctx context.Context, order []string) {
+	io := iostreams.FromContext(ctx)
+	colorize := io.ColorScheme()
+
+	fmt.Fprintf(io.Out, "Converted %s with %d service(s) mapped to %s\n", c.path, len(c.conversion.Services), c.mapping)
+
+	if len(c.conversion.Managed) > 0 {
+		fmt.Fprintln(io.Out, colorize.Bold("Managed services:"))
+		for _, managed := range c.conversion.Managed {
+			fmt.Fprintf(io.Out, "  * %s\n", managed.MigrationNote())
+		}
+	}
+
+	notes := append(append([]string{}, c.conversion.Untranslated...), c.notes...)
+	if len(notes) > 0 {
+		fmt.Fprintln(io.Out, colorize.Yellow("Not translated:"))
+		for _, n := range notes {
+			fmt.Fprintf(io.Out, "  * %s\n", n)
+		}
+	}
+
+	if len(order) > 0 {
+		fmt.Fprintln(io.Out, colorize.Bold("Launch the apps in this order:"))
+		for i, cmd := range order {
+			fmt.Fprintf(io.Out, "  %d. %s\n", i+1, cmd)
+		}
+		for _, managed := range c.conversion.Managed {
+			switch managed.Kind {
+			case containerconfig.ManagedServicePostgres:
+				fmt.Fprintln(io.Out, "  Then create a database with 'fly mpg create' and attach it with 'fly mpg attach'")
+			case containerconfig.ManagedServiceRedis:
+				fmt.Fprintln(io.Out, "  Then create a Redis database with 'fly redis create' and set REDIS_URL with 'fly secrets set'")
+			}
+		}
+	}
+	fmt.Fprintln(io.Out)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
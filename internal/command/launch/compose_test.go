@@ -0,0 +1,97 @@
+package launch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/containerconfig"
+)
+
+func testComposeConversion() *containerconfig.ComposeConversion {
+	return &containerconfig.ComposeConversion{
+		Services: []*containerconfig.ConvertedService{
+			{
+				Name:    "worker",
+				Build:   true,
+				Command: "bin/jobs",
+				Env:     map[string]string{"QUEUE": "default", "RAILS_ENV": "production"},
+				Secrets: map[string]string{},
+			},
+			{
+				Name:      "web",
+				Build:     true,
+				Command:   "bin/rails server",
+				Env:       map[string]string{"RAILS_ENV": "production", "SEARCH_URL": "http://search:9200"},
+				Secrets:   map[string]string{"SECRET_KEY_BASE": "abc"},
+				Mounts:    []containerconfig.ConvertedMount{{Source: "uploads", Destination: "/app/uploads"}},
+				Ports:     []containerconfig.ConvertedPort{{InternalPort: 3000, ExternalPort: 80, Protocol: "tcp"}},
+				DependsOn: []string{"worker"},
+			},
+			{
+				Name:    "search",
+				Image:   "elasticsearch:8",
+				Env:     map[string]string{},
+				Secrets: map[string]string{},
+				Ports:   []containerconfig.ConvertedPort{{InternalPort: 9200, ExternalPort: 9200, Protocol: "tcp"}},
+			},
+		},
+	}
+}
+
+func TestComposeProcessConfig(t *testing.T) {
+	c := &composeLaunch{conversion: testComposeConversion()}
+	cfg, secrets := c.processConfig(t.TempDir())
+
+	assert.Equal(t, map[string]string{"worker": "bin/jobs", "web": "bin/rails server"}, cfg.Processes)
+	assert.Equal(t, map[string]string{"QUEUE": "default", "RAILS_ENV": "production", "SEARCH_URL": "http://search:9200"}, cfg.Env)
+	assert.Equal(t, map[string]string{"SECRET_KEY_BASE": "abc"}, secrets)
+	assert.Equal(t, []appconfig.Mount{{Source: "uploads", Destination: "/app/uploads", Processes: []string{"web"}}}, cfg.Mounts)
+
+	require.NotNil(t, cfg.HTTPService)
+	assert.Equal(t, 3000, cfg.HTTPService.InternalPort)
+	assert.Equal(t, []string{"web"}, cfg.HTTPService.Processes)
+	assert.Empty(t, cfg.Services)
+
+	assert.Contains(t, c.notes, `service "search": runs image elasticsearch:8 which differs from the app's image, write a config for it with --compose-mapping=apps`)
+	assert.Contains(t, c.notes, `service "web": process groups start together, depends_on "worker" isn't enforced`)
+	assert.Contains(t, c.notes, `service "web": SEARCH_URL refers to "search", reach it at search.process.<app>.internal`)
+}
+
+func TestComposeAppConfig(t *testing.T) {
+	c := &composeLaunch{conversion: testComposeConversion()}
+	search := c.conversion.Services[2]
+
+	cfg := c.appConfig("shop", search)
+	assert.Equal(t, "shop-search", cfg.AppName)
+	assert.Equal(t, &appconfig.Build{Image: "elasticsearch:8"}, cfg.Build)
+	assert.Nil(t, cfg.Processes)
+	require.NotNil(t, cfg.HTTPService)
+	assert.Equal(t, 9200, cfg.HTTPService.InternalPort)
+
+	web := c.conversion.Services[1]
+	cfg = c.appConfig("shop", web)
+	assert.Nil(t, cfg.Build)
+	assert.Equal(t, map[string]string{fly.MachineProcessGroupApp: "bin/rails server"}, cfg.Processes)
+	assert.Contains(t, c.notes, `service "web": SEARCH_URL refers to "search", reach it at shop-search.internal`)
+}
+
+func TestAddComposePorts(t *testing.T) {
+	cfg := appconfig.NewConfig()
+	ports := []containerconfig.ConvertedPort{
+		{InternalPort: 5432, ExternalPort: 5432, Protocol: "tcp"},
+		{InternalPort: 8080, ExternalPort: 443, Protocol: "tcp"},
+		{InternalPort: 8080, ExternalPort: 80, Protocol: "tcp"},
+		{InternalPort: 53, ExternalPort: 53, Protocol: "udp"},
+	}
+
+	assert.True(t, addComposePorts(cfg, "app", ports, true))
+	require.NotNil(t, cfg.HTTPService)
+	assert.Equal(t, 8080, cfg.HTTPService.InternalPort)
+	require.Len(t, cfg.Services, 2)
+	assert.Equal(t, 5432, cfg.Services[0].InternalPort)
+	assert.Equal(t, "udp", cfg.Services[1].Protocol)
+	assert.Equal(t, 53, *cfg.Services[1].Ports[0].Port)
+}
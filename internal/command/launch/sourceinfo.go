@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path/filepath"
 	"regexp"
 	"strings"
 
 	"github.com/cavaliergopher/grab/v3"
 	"github.com/logrusorgru/aurora"
+	"github.com/superfly/flyctl/flyctl"
 	"github.com/superfly/flyctl/internal/appconfig"
 	"github.com/superfly/flyctl/internal/command/launch/plan"
 	"github.com/superfly/flyctl/internal/flag"
@@ -26,6 +28,7 @@ func determineSourceInfo(ctx context.Context, appConfig *appconfig.Config, copyC
 		ExistingPort: appConfig.InternalPort(),
 		Mode:         "launch",
 		Colorize:     io.ColorScheme(),
+		RuleDirs:     []string{filepath.Join(flyctl.ConfigDir(), "detectors")},
 	}
 	// Detect if --copy-config and --now flags are set. If so, limited set of
 	// fly.toml file updates. Helpful for deploying PRs when the project is
@@ -101,6 +104,9 @@ func determineSourceInfo(ctx context.Context, appConfig *appconfig.Config, copyC
 
 	if planStep == "" || planStep == "generate" {
 		fmt.Fprintf(io.Out, "Detected %s %s app\n", articleFor(srcInfo.Family), aurora.Green(appType))
+		if explanation := srcInfo.Detection.Explain(); explanation != "" {
+			fmt.Fprintln(io.Out, explanation)
+		}
 	}
 
 	if srcInfo.Builder != "" {
@@ -20,3 +20,14 @@ func IsAppsV1Command(cmd *cobra.Command) bool {
 	_, ok := cmd.Annotations["apps_v1"]
 	return ok
 }
+
+// AllowUnknownProfile lets cmd run with an auth profile that doesn't exist
+// yet, for the commands that create or pick profiles.
+func AllowUnknownProfile(cmd *cobra.Command) {
+	AnnotateCommand(cmd, "allow_unknown_profile", "1")
+}
+
+func allowsUnknownProfile(cmd *cobra.Command) bool {
+	_, ok := cmd.Annotations["allow_unknown_profile"]
+	return ok
+}
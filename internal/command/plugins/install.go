@@ -0,0 +1,154 @@
+package plugins
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/superfly/flyctl/iostreams"
+
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/prompt"
+)
+
+func newInstall() *cobra.Command {
+	const (
+		long = `Download a plugin executable and install it, so that it runs as 'fly <name>'.
+The name defaults to the file name of the URL without its fly- prefix. Plugins
+run with your Fly.io credentials, so only install plugins you trust. Plain
+http URLs need the executable's checksum in --sha256.
+`
+		short = "Install a plugin from a URL"
+		usage = "install <url>"
+	)
+
+	cmd := command.New(usage, short, long, runInstall)
+	cmd.Args = cobra.ExactArgs(1)
+
+	flag.Add(cmd,
+		flag.Yes(),
+		flag.String{
+			Name:        "name",
+			Description: "Name to install the plugin as",
+		},
+		flag.String{
+			Name:        "sha256",
+			Description: "Expected SHA-256 checksum of the executable, in hex",
+		},
+	)
+
+	return cmd
+}
+
+func runInstall(ctx context.Context) error {
+	io := iostreams.FromContext(ctx)
+
+	u, err := url.Parse(flag.FirstArg(ctx))
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return fmt.Errorf("%q isn't an http or https URL", flag.FirstArg(ctx))
+	}
+	if u.Scheme == "http" && flag.GetString(ctx, "sha256") == "" {
+		return fmt.Errorf("%s could be tampered with on the way, use https or pass the executable's checksum with --sha256", u)
+	}
+
+	name := flag.GetString(ctx, "name")
+	if name == "" {
+		base := strings.TrimSuffix(path.Base(u.Path), ".exe")
+		if name = strings.TrimPrefix(base, Prefix); name == base {
+			return fmt.Errorf("can't tell the plugin's name from %s, pass it with --name", u)
+		}
+	}
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid plugin name %q, use lowercase letters, digits, - and _", name)
+	}
+
+	if isBuiltin(command.FromContext(ctx).Root(), name) {
+		return fmt.Errorf("flyctl has a %s command already, install the plugin with another --name", name)
+	}
+
+	if !flag.GetYes(ctx) {
+		switch confirmed, err := prompt.Confirmf(ctx, "Install %s as 'fly %s'? It will run with your Fly.io credentials.", u, name); {
+		case err == nil:
+			if !confirmed {
+				return nil
+			}
+		case prompt.IsNonInteractive(err):
+			return prompt.NonInteractiveError("yes flag must be specified when not running interactively")
+		default:
+			return err
+		}
+	}
+
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+
+	dest := filepath.Join(dir, fileName(name))
+	if err := download(ctx, u.String(), dest, flag.GetString(ctx, "sha256")); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(io.Out, "Installed %s to %s, run it with 'fly %s'\n", name, dest, name)
+
+	return nil
+}
+
+// download fetches the executable at rawURL to dest, making sure it has the
+// given checksum if there is one.
+func download(ctx context.Context, rawURL, dest, checksum string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed downloading plugin: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed downloading plugin: %s", resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o700); err != nil {
+		return fmt.Errorf("failed creating plugin directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), resp.Body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed downloading plugin: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); checksum != "" && !strings.EqualFold(sum, checksum) {
+		return fmt.Errorf("the plugin's checksum is %s, not %s", sum, checksum)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0o755); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dest)
+}
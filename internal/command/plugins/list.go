@@ -0,0 +1,61 @@
+package plugins
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"github.com/superfly/flyctl/iostreams"
+
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/render"
+)
+
+func newList() *cobra.Command {
+	const (
+		long = `List the plugins installed with 'fly plugins install' and those on your
+PATH. Plugins named after a flyctl command don't run, and are listed as shadowed.
+`
+		short = "List plugins"
+	)
+
+	cmd := command.New("list", short, long, runList)
+	cmd.Aliases = []string{"ls"}
+	cmd.Args = cobra.NoArgs
+
+	flag.Add(cmd, flag.JSONOutput())
+
+	return cmd
+}
+
+type listedPlugin struct {
+	Plugin
+	Shadowed bool `json:"shadowed"`
+}
+
+func runList(ctx context.Context) error {
+	root := command.FromContext(ctx).Root()
+
+	var plugins []listedPlugin
+	for _, p := range List() {
+		plugins = append(plugins, listedPlugin{Plugin: p, Shadowed: isBuiltin(root, p.Name)})
+	}
+
+	out := iostreams.FromContext(ctx).Out
+	if config.FromContext(ctx).JSONOutput {
+		return render.JSON(out, plugins)
+	}
+
+	rows := make([][]string, 0, len(plugins))
+	for _, p := range plugins {
+		command := "fly " + p.Name
+		if p.Shadowed {
+			command = "(shadowed)"
+		}
+		rows = append(rows, []string{p.Name, command, p.Source, p.Path})
+	}
+
+	return render.Table(out, "", rows, "Name", "Command", "Source", "Path")
+}
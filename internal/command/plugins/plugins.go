@@ -0,0 +1,386 @@
+// Package plugins implements the plugins command chain and runs external
+// plugins: executables called fly-<name> that run as fly <name>.
+package plugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/superfly/flyctl/agent"
+	"github.com/superfly/flyctl/helpers"
+	"github.com/superfly/flyctl/iostreams"
+
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flyerr"
+	"github.com/superfly/flyctl/internal/state"
+)
+
+// New initializes and returns a new plugins Command.
+func New() *cobra.Command {
+	const (
+		long = `Plugins add commands to flyctl. Any executable called fly-<name>, either
+installed with 'fly plugins install' or on your PATH, runs as 'fly <name>',
+unless flyctl has a command of that name already. Installed plugins take
+precedence over those on your PATH. Plugins get all their arguments and flags
+as they are.
+
+Besides flyctl's environment, plugins get FLY_API_TOKEN with the token flyctl
+uses, FLY_APP with the app of the current directory's fly.toml or FLY_APP,
+FLY_ORG with the default organization, FLY_PROFILE with the auth profile in
+use, FLY_API_BASE_URL, FLY_FLAPS_BASE_URL and FLY_METRICS_BASE_URL with the
+API endpoints, FLY_AGENT_SOCKET with the path of the agent's socket,
+FLY_CONFIG_DIR with flyctl's config directory and FLYCTL_PATH with the path of
+flyctl itself. Variables with no value aren't set.
+
+Installed plugins always get FLY_API_TOKEN. The first time a plugin on your
+PATH runs, flyctl asks whether it may have it, and remembers the answer; when
+it can't ask, the plugin runs without it.
+`
+		short = "Manage flyctl plugins"
+	)
+
+	cmd := command.New("plugins", short, long, nil)
+	cmd.Aliases = []string{"plugin"}
+
+	cmd.AddCommand(
+		newList(),
+		newInstall(),
+		newUninstall(),
+	)
+
+	return cmd
+}
+
+// Prefix is what the names of plugin executables start with.
+const Prefix = "fly-"
+
+const (
+	SourceInstalled = "installed"
+	SourcePath      = "PATH"
+)
+
+// Plugin is an executable that runs as a flyctl command.
+type Plugin struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Source string `json:"source"`
+}
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Dir returns the directory plugins are installed to.
+func Dir() (string, error) {
+	dir, err := helpers.GetConfigDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "plugins"), nil
+}
+
+// fileName returns the name of the executable of the plugin called name.
+func fileName(name string) string {
+	if runtime.GOOS == "windows" {
+		return Prefix + name + ".exe"
+	}
+	return Prefix + name
+}
+
+// pluginName returns the name of the plugin the executable called file is,
+// if it is one.
+func pluginName(file string) (string, bool) {
+	if runtime.GOOS == "windows" {
+		ext := filepath.Ext(file)
+		if !strings.EqualFold(ext, ".exe") {
+			return "", false
+		}
+		file = strings.TrimSuffix(file, ext)
+	}
+
+	name, ok := strings.CutPrefix(file, Prefix)
+	if !ok || !validName.MatchString(name) {
+		return "", false
+	}
+	return name, true
+}
+
+func isExecutable(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil || fi.IsDir() {
+		return false
+	}
+	return runtime.GOOS == "windows" || fi.Mode()&0o111 != 0
+}
+
+// List returns the installed plugins and those on PATH, sorted by name. Of
+// several plugins with the same name, only the one that runs is returned.
+func List() []Plugin {
+	var dirs []string
+	if dir, err := Dir(); err == nil {
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, filepath.SplitList(os.Getenv("PATH"))...)
+
+	seen := map[string]bool{}
+	var plugins []Plugin
+	for i, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, e := range entries {
+			name, ok := pluginName(e.Name())
+			if !ok || seen[name] {
+				continue
+			}
+
+			path := filepath.Join(dir, e.Name())
+			if !isExecutable(path) {
+				continue
+			}
+
+			source := SourcePath
+			if i == 0 {
+				source = SourceInstalled
+			}
+
+			seen[name] = true
+			plugins = append(plugins, Plugin{Name: name, Path: path, Source: source})
+		}
+	}
+
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Name < plugins[j].Name
+	})
+
+	return plugins
+}
+
+// Find returns the plugin called name.
+func Find(name string) (Plugin, bool) {
+	if !validName.MatchString(name) {
+		return Plugin{}, false
+	}
+
+	if dir, err := Dir(); err == nil {
+		if path := filepath.Join(dir, fileName(name)); isExecutable(path) {
+			return Plugin{Name: name, Path: path, Source: SourceInstalled}, true
+		}
+	}
+
+	if path, err := exec.LookPath(Prefix + name); err == nil {
+		return Plugin{Name: name, Path: path, Source: SourcePath}, true
+	}
+
+	return Plugin{}, false
+}
+
+// isBuiltin reports whether root has a command called name. Plugins can't
+// replace those.
+func isBuiltin(root *cobra.Command, name string) bool {
+	switch name {
+	case "help", "completion":
+		return true
+	}
+
+	for _, c := range root.Commands() {
+		if c.Name() == name || c.HasAlias(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Register adds the plugin args run to root, unless args run a built-in
+// command. Only that plugin is looked up, so that flyctl doesn't search PATH
+// every time it runs.
+func Register(root *cobra.Command, args []string) {
+	name, i := pluginArg(root, args)
+	if name == "" || isBuiltin(root, name) {
+		return
+	}
+
+	if p, ok := Find(name); ok {
+		root.AddCommand(newPluginCommand(p, args[:i]))
+	}
+}
+
+// pluginArg returns the first argument of args that isn't a flag, or the
+// value of one, and its index.
+func pluginArg(root *cobra.Command, args []string) (string, int) {
+	for i := 0; i < len(args); {
+		switch arg := args[i]; {
+		case arg == "--":
+			return "", 0
+		case !strings.HasPrefix(arg, "-") || arg == "-":
+			return arg, i
+		}
+
+		n, _ := leadingFlag(root.PersistentFlags(), args[i:])
+		i += n
+	}
+
+	return "", 0
+}
+
+// leadingFlag returns the number of args the flag args start with spans, and
+// the flag if fs defines it. Flags fs doesn't define are assumed to take a
+// value, as cobra does when it looks for the command to run.
+func leadingFlag(fs *pflag.FlagSet, args []string) (int, *pflag.Flag) {
+	arg := args[0]
+	name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+
+	var f *pflag.Flag
+	switch {
+	case strings.HasPrefix(arg, "--"):
+		f = fs.Lookup(name)
+	case len(name) == 1:
+		f = fs.ShorthandLookup(name)
+	}
+
+	if hasValue || (f != nil && f.NoOptDefVal != "") || len(args) == 1 {
+		return 1, f
+	}
+	return 2, f
+}
+
+// newPluginCommand returns the command running p. leading are the flags that
+// came before the plugin's name: flyctl's own are applied to flyctl, others
+// are passed on to the plugin.
+func newPluginCommand(p Plugin, leading []string) *cobra.Command {
+	var args []string
+
+	short := fmt.Sprintf("Run the %s plugin", p.Name)
+	long := fmt.Sprintf("Run the %s plugin at %s.\n", p.Name, p.Path)
+
+	cmd := command.New(p.Name, short, long, func(ctx context.Context) error {
+		return run(ctx, p, args)
+	},
+		command.LoadAppNameIfPresentNoFlag,
+	)
+
+	// flags belong to the plugin, so cobra has to pass them on rather than
+	// parse them
+	cmd.DisableFlagParsing = true
+	runE := cmd.RunE
+	cmd.RunE = func(cmd *cobra.Command, a []string) error {
+		own, passed := splitFlags(cmd.Root().PersistentFlags(), leading)
+		if err := cmd.Root().PersistentFlags().Parse(own); err != nil {
+			return err
+		}
+
+		args = append(passed, a[min(len(leading), len(a)):]...)
+		return runE(cmd, a)
+	}
+
+	return cmd
+}
+
+// splitFlags splits leading into the flags fs defines and the others, each
+// with its value.
+func splitFlags(fs *pflag.FlagSet, leading []string) (own, others []string) {
+	for i := 0; i < len(leading); {
+		n, f := leadingFlag(fs, leading[i:])
+		if f != nil {
+			own = append(own, leading[i:i+n]...)
+		} else {
+			others = append(others, leading[i:i+n]...)
+		}
+		i += n
+	}
+
+	return own, others
+}
+
+func run(ctx context.Context, p Plugin, args []string) error {
+	io := iostreams.FromContext(ctx)
+
+	token, err := getsToken(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, p.Path, args...)
+	cmd.Stdin = io.In
+	cmd.Stdout = io.Out
+	cmd.Stderr = io.ErrOut
+	cmd.Env = Environ(ctx, os.Environ(), token)
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			return flyerr.ExitCodeError{Code: exitErr.ExitCode()}
+		}
+		return fmt.Errorf("failed running plugin %s: %w", p.Name, err)
+	}
+
+	return nil
+}
+
+// Environ returns environ with the variables plugins get set to the values
+// ctx carries. The token variables are always cleared, and FLY_API_TOKEN is
+// only set if token is true.
+func Environ(ctx context.Context, environ []string, token bool) []string {
+	cfg := config.FromContext(ctx)
+
+	vars := map[string]string{
+		config.APITokenEnvKey:    "",
+		config.AccessTokenEnvKey: "",
+		"FLY_APP":                appconfig.NameFromContext(ctx),
+		"FLY_ORG":                cfg.Organization,
+		config.ProfileEnvKey:     cfg.Profile,
+		"FLY_API_BASE_URL":       cfg.APIBaseURL,
+		"FLY_FLAPS_BASE_URL":     cfg.FlapsBaseURL,
+		"FLY_METRICS_BASE_URL":   cfg.MetricsBaseURL,
+		"FLY_CONFIG_DIR":         state.ConfigDirectory(ctx),
+	}
+	if token {
+		vars[config.APITokenEnvKey] = cfg.Tokens.All()
+	}
+	if dir := state.ConfigDirectory(ctx); dir != "" {
+		vars["FLY_AGENT_SOCKET"] = filepath.Join(dir, agent.SocketName(cfg.Profile))
+	}
+	if exe, err := os.Executable(); err == nil {
+		vars["FLYCTL_PATH"] = exe
+	}
+
+	env := make([]string, 0, len(environ)+len(vars))
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if _, ok := vars[name]; !ok {
+			env = append(env, kv)
+		}
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if vars[name] != "" {
+			env = append(env, name+"="+vars[name])
+		}
+	}
+
+	return env
+}
@@ -0,0 +1,193 @@
+package plugins
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/fly-go/tokens"
+
+	"github.com/superfly/flyctl/iostreams"
+
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/state"
+)
+
+func writeExecutable(t *testing.T, dir, name string) string {
+	t.Helper()
+
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	path := filepath.Join(dir, fileName(name))
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"), 0o755))
+	return path
+}
+
+func TestFindAndList(t *testing.T) {
+	configDir, pathDir := t.TempDir(), t.TempDir()
+	t.Setenv("FLY_CONFIG_DIR", configDir)
+	t.Setenv("PATH", pathDir)
+
+	pluginDir, err := Dir()
+	require.NoError(t, err)
+
+	installed := writeExecutable(t, pluginDir, "preview")
+	writeExecutable(t, pathDir, "preview")
+	onPath := writeExecutable(t, pathDir, "deploy")
+	require.NoError(t, os.WriteFile(filepath.Join(pathDir, fileName("notes")), nil, 0o644))
+
+	p, ok := Find("preview")
+	require.True(t, ok)
+	assert.Equal(t, Plugin{Name: "preview", Path: installed, Source: SourceInstalled}, p)
+
+	_, ok = Find("notes")
+	assert.False(t, ok, "files that aren't executable aren't plugins")
+	_, ok = Find("../preview")
+	assert.False(t, ok)
+
+	assert.Equal(t, []Plugin{
+		{Name: "deploy", Path: onPath, Source: SourcePath},
+		{Name: "preview", Path: installed, Source: SourceInstalled},
+	}, List())
+
+	root := &cobra.Command{Use: "fly"}
+	root.AddCommand(&cobra.Command{Use: "deploy"})
+
+	Register(root, []string{"deploy"})
+	Register(root, []string{"preview", "--pr", "12"})
+	Register(root, []string{"missing"})
+
+	var names []string
+	for _, c := range root.Commands() {
+		names = append(names, c.Name())
+	}
+	assert.Equal(t, []string{"deploy", "preview"}, names)
+	assert.True(t, isBuiltin(root, "help"))
+}
+
+func TestPluginArg(t *testing.T) {
+	root := &cobra.Command{Use: "fly"}
+	fs := root.PersistentFlags()
+	fs.StringP("access-token", "t", "", "")
+	fs.Bool("debug", false, "")
+
+	cases := []struct {
+		args    []string
+		name    string
+		leading []string
+	}{
+		{args: []string{"preview", "--pr", "12"}, name: "preview"},
+		{args: []string{"--debug", "preview"}, name: "preview", leading: []string{"--debug"}},
+		{args: []string{"-t", "fo1_x", "preview"}, name: "preview", leading: []string{"-t", "fo1_x"}},
+		{args: []string{"--access-token=fo1_x", "preview"}, name: "preview", leading: []string{"--access-token=fo1_x"}},
+		{args: []string{"-a", "web", "preview"}, name: "preview", leading: []string{"-a", "web"}},
+		{args: []string{"--debug"}},
+		{args: []string{"--", "preview"}},
+	}
+	for _, tc := range cases {
+		name, i := pluginArg(root, tc.args)
+		assert.Equal(t, tc.name, name, tc.args)
+		if name != "" {
+			assert.Equal(t, tc.leading, append([]string(nil), tc.args[:i]...), tc.args)
+		}
+	}
+
+	own, others := splitFlags(fs, []string{"--debug", "-a", "web", "-t", "fo1_x"})
+	assert.Equal(t, []string{"--debug", "-t", "fo1_x"}, own)
+	assert.Equal(t, []string{"-a", "web"}, others)
+}
+
+func TestEnviron(t *testing.T) {
+	cfg := &config.Config{
+		Tokens:       tokens.Parse("fo1_plugin"),
+		Organization: "acme",
+		Profile:      "work",
+		APIBaseURL:   "https://api.fly.io",
+	}
+
+	ctx := config.NewContext(context.Background(), cfg)
+	ctx = state.WithConfigDirectory(ctx, "/home/me/.fly")
+	ctx = appconfig.WithName(ctx, "web")
+
+	environ := []string{"PATH=/bin", "FLY_ACCESS_TOKEN=old", "FLY_API_TOKEN=old", "FLY_APP=other"}
+	env := Environ(ctx, environ, true)
+
+	assert.Contains(t, env, "PATH=/bin")
+	assert.Contains(t, env, "FLY_API_TOKEN=fo1_plugin")
+	assert.Contains(t, env, "FLY_APP=web")
+	assert.Contains(t, env, "FLY_ORG=acme")
+	assert.Contains(t, env, "FLY_PROFILE=work")
+	assert.Contains(t, env, "FLY_AGENT_SOCKET="+filepath.Join("/home/me/.fly", "fly-agent-work.sock"))
+	assert.NotContains(t, env, "FLY_ACCESS_TOKEN=old")
+	assert.NotContains(t, env, "FLY_FLAPS_BASE_URL=")
+
+	// untrusted plugins get no token at all, not even the one flyctl was given
+	env = Environ(ctx, environ, false)
+	assert.NotContains(t, env, "FLY_API_TOKEN=fo1_plugin")
+	assert.NotContains(t, env, "FLY_API_TOKEN=old")
+	assert.NotContains(t, env, "FLY_ACCESS_TOKEN=old")
+	assert.Contains(t, env, "FLY_APP=web")
+}
+
+func TestGetsToken(t *testing.T) {
+	t.Setenv("FLY_CONFIG_DIR", t.TempDir())
+
+	ctx := context.Background()
+	ctx = iostreams.NewContext(ctx, iostreams.System())
+
+	ok, err := getsToken(ctx, Plugin{Name: "preview", Path: "/plugins/fly-preview", Source: SourceInstalled})
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// nobody to ask, so no token, and nothing remembered
+	onPath := Plugin{Name: "deploy", Path: "/bin/fly-deploy", Source: SourcePath}
+	ok, err = getsToken(ctx, onPath)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, setTrusted(onPath, true))
+	ok, err = getsToken(ctx, onPath)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = getsToken(ctx, Plugin{Name: "deploy", Path: "/usr/bin/fly-deploy", Source: SourcePath})
+	require.NoError(t, err)
+	assert.False(t, ok, "trust goes by path")
+}
+
+func TestDownload(t *testing.T) {
+	const body = "#!/bin/sh\necho hello\n"
+
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/fly-hello" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer hs.Close()
+
+	sum := sha256.Sum256([]byte(body))
+	dest := filepath.Join(t.TempDir(), "plugins", fileName("hello"))
+
+	err := download(context.Background(), hs.URL+"/fly-hello", dest, "00")
+	assert.ErrorContains(t, err, "checksum")
+	assert.NoFileExists(t, dest)
+
+	assert.Error(t, download(context.Background(), hs.URL+"/missing", dest, ""))
+
+	require.NoError(t, download(context.Background(), hs.URL+"/fly-hello", dest, hex.EncodeToString(sum[:])))
+	assert.True(t, isExecutable(dest))
+
+	b, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(b))
+}
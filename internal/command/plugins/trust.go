@@ -0,0 +1,104 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/superfly/flyctl/helpers"
+	"github.com/superfly/flyctl/iostreams"
+
+	"github.com/superfly/flyctl/internal/prompt"
+)
+
+// Plugins installed with 'fly plugins install' were confirmed to run with the
+// user's credentials when they were installed. Plugins on PATH weren't, so
+// the first time one runs the user is asked whether it gets the token, and
+// the answer is kept by the plugin's path.
+
+func trustFile() (string, error) {
+	dir, err := helpers.GetConfigDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "plugins-trusted.json"), nil
+}
+
+func readTrusted(path string) (map[string]bool, error) {
+	trusted := map[string]bool{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return trusted, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &trusted); err != nil {
+		return nil, fmt.Errorf("%s is corrupt: %w", path, err)
+	}
+	return trusted, nil
+}
+
+func setTrusted(p Plugin, trusted bool) error {
+	path, err := trustFile()
+	if err != nil {
+		return err
+	}
+
+	all, err := readTrusted(path)
+	if err != nil {
+		return err
+	}
+	all[p.Path] = trusted
+
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// getsToken reports whether p runs with the user's token, asking the user the
+// first time a plugin on PATH runs. Plugins on PATH don't get it when there's
+// no one to ask.
+func getsToken(ctx context.Context, p Plugin) (bool, error) {
+	if p.Source == SourceInstalled {
+		return true, nil
+	}
+
+	path, err := trustFile()
+	if err != nil {
+		return false, err
+	}
+
+	all, err := readTrusted(path)
+	if err != nil {
+		return false, err
+	}
+	if trusted, ok := all[p.Path]; ok {
+		return trusted, nil
+	}
+
+	trusted, err := prompt.Confirmf(ctx, "The %s plugin at %s wasn't installed with 'fly plugins install'. Let it use your Fly.io credentials?", p.Name, p.Path)
+	switch {
+	case prompt.IsNonInteractive(err):
+		fmt.Fprintf(iostreams.FromContext(ctx).ErrOut, "Running the %s plugin without your Fly.io credentials, run it interactively once to allow it to use them\n", p.Name)
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	if err := setTrusted(p, trusted); err != nil {
+		return false, fmt.Errorf("failed saving your answer: %w", err)
+	}
+	return trusted, nil
+}
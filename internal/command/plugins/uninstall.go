@@ -0,0 +1,50 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/superfly/flyctl/iostreams"
+
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/flag"
+)
+
+func newUninstall() *cobra.Command {
+	const (
+		long = `Remove a plugin installed with 'fly plugins install'. Plugins on your PATH
+aren't flyctl's to remove.
+`
+		short = "Remove an installed plugin"
+		usage = "uninstall <name>"
+	)
+
+	cmd := command.New(usage, short, long, runUninstall)
+	cmd.Aliases = []string{"remove", "rm"}
+	cmd.Args = cobra.ExactArgs(1)
+
+	return cmd
+}
+
+func runUninstall(ctx context.Context) error {
+	name := flag.FirstArg(ctx)
+
+	p, ok := Find(name)
+	switch {
+	case !ok:
+		return fmt.Errorf("no plugin called %s", name)
+	case p.Source != SourceInstalled:
+		return fmt.Errorf("the %s plugin at %s is on your PATH rather than installed by flyctl, remove it yourself", name, p.Path)
+	}
+
+	if err := os.Remove(p.Path); err != nil {
+		return fmt.Errorf("failed removing plugin: %w", err)
+	}
+
+	fmt.Fprintf(iostreams.FromContext(ctx).Out, "Uninstalled %s\n", name)
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package command
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/state"
+)
+
+func TestCheckProfile(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, config.FileName)
+	require.NoError(t, config.SetProfileDefaults(config.ProfileFile(configPath, "work"), "acme", ""))
+
+	check := func(cmd *cobra.Command, profile string) error {
+		ctx := state.WithConfigDirectory(context.Background(), dir)
+		ctx = NewContext(ctx, cmd)
+		ctx = config.NewContext(ctx, &config.Config{Profile: profile, ProfileSource: config.ProfileFromFlag})
+		_, err := checkProfile(ctx)
+		return err
+	}
+
+	cmd := &cobra.Command{Use: "deploy"}
+	assert.NoError(t, check(cmd, config.DefaultProfile))
+	assert.NoError(t, check(cmd, "work"))
+	assert.ErrorContains(t, check(cmd, "wrok"), `unknown profile "wrok", selected by --profile`)
+
+	login := &cobra.Command{Use: "login"}
+	AllowUnknownProfile(login)
+	assert.NoError(t, check(login, "new"))
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/flag/flagnames"
+	"github.com/superfly/flyctl/internal/render"
+	"github.com/superfly/flyctl/internal/state"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+func newPresets() *cobra.Command {
+	const (
+		short = "Manage proxy presets"
+		long  = `Manage named sets of port forwards that 'fly proxy up' starts together.
+Presets are stored in the proxy_presets section of the flyctl config file.
+
+Presets are forwarding profiles. They aren't called profiles because that name
+belongs to auth profiles, which --profile and FLY_PROFILE pick.`
+	)
+
+	cmd := command.New("presets", short, long, nil)
+
+	cmd.AddCommand(
+		newPresetsList(),
+		newPresetsAdd(),
+		newPresetsRemove(),
+	)
+
+	return cmd
+}
+
+func newPresetsList() *cobra.Command {
+	const (
+		short = "List proxy presets and their forwards"
+		long  = short + "\n"
+	)
+
+	cmd := command.New("list", short, long, runPresetsList)
+	cmd.Aliases = []string{"ls"}
+	cmd.Args = cobra.NoArgs
+
+	flag.Add(cmd, flag.JSONOutput())
+
+	return cmd
+}
+
+func runPresetsList(ctx context.Context) error {
+	out := iostreams.FromContext(ctx).Out
+
+	presets, err := config.ReadProxyPresets(state.ConfigFile(ctx))
+	if err != nil {
+		return err
+	}
+
+	if config.FromContext(ctx).JSONOutput {
+		return render.JSON(out, presets)
+	}
+
+	if len(presets) == 0 {
+		fmt.Fprintln(out, "No proxy presets. Add one with 'fly proxy presets add'.")
+		return nil
+	}
+
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rows [][]string
+	for _, name := range names {
+		for _, f := range presets[name].Forwards {
+			target := f.App
+			if target == "" {
+				target = f.Org
+			}
+			rows = append(rows, []string{name, target, f.Ports, f.RemoteHost, f.BindAddr, f.Network})
+		}
+	}
+
+	return render.Table(out, "", rows, "Preset", "App/Org", "Ports", "Remote Host", "Bind Address", "Network")
+}
+
+func newPresetsAdd() *cobra.Command {
+	const (
+		short = "Add a forward to a proxy preset, creating the preset if needed"
+		long  = short + "\n"
+	)
+
+	cmd := command.New("add <preset> <local:remote> [remote_host]", short, long, runPresetsAdd,
+		command.LoadAppNameIfPresent)
+	cmd.Args = cobra.RangeArgs(2, 3)
+
+	flag.Add(cmd,
+		flag.App(),
+		flag.AppConfig(),
+		flag.Org(),
+		flag.String{
+			Name:        flagnames.BindAddr,
+			Shorthand:   "b",
+			Description: "Local address to bind to. Defaults to 127.0.0.1",
+		},
+		flag.String{
+			Name:        "network",
+			Description: "Custom network to use, defaults to the app's network",
+		},
+	)
+
+	return cmd
+}
+
+func runPresetsAdd(ctx context.Context) error {
+	var (
+		io   = iostreams.FromContext(ctx)
+		args = flag.Args(ctx)
+		name = args[0]
+		path = state.ConfigFile(ctx)
+	)
+
+	forward := config.ProxyForward{
+		App:      appconfig.NameFromContext(ctx),
+		Org:      flag.GetOrg(ctx),
+		Ports:    args[1],
+		BindAddr: flag.GetString(ctx, flagnames.BindAddr),
+		Network:  flag.GetString(ctx, "network"),
+	}
+	if len(args) > 2 {
+		forward.RemoteHost = args[2]
+	}
+
+	if _, err := newSupervisedForward(forward); err != nil {
+		return err
+	}
+
+	presets, err := config.ReadProxyPresets(path)
+	if err != nil {
+		return err
+	}
+
+	preset := presets[name]
+	preset.Forwards = append(preset.Forwards, forward)
+	presets[name] = preset
+
+	if err := config.SetProxyPresets(path, presets); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(io.Out, "Added %s to proxy preset %s; start it with 'fly proxy up %s'\n", forward.Ports, name, name)
+	return nil
+}
+
+func newPresetsRemove() *cobra.Command {
+	const (
+		short = "Remove a proxy preset"
+		long  = short + "\n"
+	)
+
+	cmd := command.New("remove <preset>", short, long, runPresetsRemove)
+	cmd.Aliases = []string{"rm"}
+	cmd.Args = cobra.ExactArgs(1)
+
+	return cmd
+}
+
+func runPresetsRemove(ctx context.Context) error {
+	var (
+		io   = iostreams.FromContext(ctx)
+		name = flag.FirstArg(ctx)
+		path = state.ConfigFile(ctx)
+	)
+
+	presets, err := config.ReadProxyPresets(path)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := presets[name]; !ok {
+		return fmt.Errorf("no proxy preset named %q", name)
+	}
+	delete(presets, name)
+
+	if err := config.SetProxyPresets(path, presets); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(io.Out, "Removed proxy preset %s\n", name)
+	return nil
+}
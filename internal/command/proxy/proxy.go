@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -17,19 +19,36 @@ import (
 	"github.com/superfly/flyctl/internal/prompt"
 	"github.com/superfly/flyctl/iostreams"
 	"github.com/superfly/flyctl/proxy"
+	"golang.org/x/sync/errgroup"
 )
 
 func New() *cobra.Command {
 	var (
 		long = strings.Trim(`Proxies connections to a Fly Machine through a WireGuard tunnel. By default,
-connects to the first Machine address returned by an internal DNS query on the app.`, "\n")
+connects to the first Machine address returned by an internal DNS query on the app.
+
+With --socks or --http-proxy, runs a local SOCKS5 or HTTP proxy instead, so any
+tool configured to use it can reach .internal and .flycast addresses of the
+organization's private network.
+
+With --udp, forwards UDP datagrams instead of TCP connections. Each local
+client gets its own session to the remote, which ends after --udp-idle-timeout
+without traffic.
+
+Use 'fly proxy up <preset>' to run a saved set of forwards in one supervised
+process.`, "\n")
 		short = `Proxies connections to a Fly Machine.`
 	)
 
-	cmd := command.New("proxy <local:remote> [remote_host]", short, long, run,
+	cmd := command.New("proxy [<local:remote> [remote_host]]", short, long, run,
 		command.RequireSession, command.LoadAppNameIfPresent)
 
-	cmd.Args = cobra.RangeArgs(1, 2)
+	cmd.Args = cobra.RangeArgs(0, 2)
+
+	cmd.AddCommand(
+		newUp(),
+		newPresets(),
+	)
 
 	flag.Add(cmd,
 		flag.App(),
@@ -52,6 +71,23 @@ connects to the first Machine address returned by an internal DNS query on the a
 			Default:     "127.0.0.1",
 			Description: "Local address to bind to",
 		},
+		flag.Int{
+			Name:        "socks",
+			Description: "Run a SOCKS5 proxy to the private network on this local port",
+		},
+		flag.Int{
+			Name:        "http-proxy",
+			Description: "Run an HTTP proxy, supporting CONNECT, to the private network on this local port",
+		},
+		flag.Bool{
+			Name:        "udp",
+			Description: "Forward UDP datagrams instead of TCP connections",
+		},
+		flag.Duration{
+			Name:        "udp-idle-timeout",
+			Default:     proxy.DefaultUDPIdleTimeout,
+			Description: "How long a UDP session lives without traffic",
+		},
 		flag.Bool{
 			Name:        "watch-stdin",
 			Default:     false,
@@ -71,6 +107,19 @@ func run(ctx context.Context) (err error) {
 	args := flag.Args(ctx)
 	promptInstance := flag.GetBool(ctx, "select")
 
+	socksPort, httpPort := flag.GetInt(ctx, "socks"), flag.GetInt(ctx, "http-proxy")
+	forward := flag.IsSpecified(ctx, "socks") || flag.IsSpecified(ctx, "http-proxy")
+	switch {
+	case forward && len(args) > 0:
+		return errors.New("<local:remote> can't be used with --socks or --http-proxy")
+	case forward && promptInstance:
+		return errors.New("--select can't be used with --socks or --http-proxy")
+	case !forward && len(args) == 0:
+		return errors.New("requires <local:remote>, --socks or --http-proxy")
+	case forward && flag.GetBool(ctx, "udp"):
+		return errors.New("--udp can't be used with --socks or --http-proxy")
+	}
+
 	if promptInstance && appName == "" {
 		return errors.New("--app required when --select flag provided")
 	}
@@ -120,6 +169,14 @@ func run(ctx context.Context) (err error) {
 		return err
 	}
 
+	if flag.GetBool(ctx, "watch-stdin") {
+		ctx = watchStdinAndAbortOnClose(ctx)
+	}
+
+	if forward {
+		return runForwardProxies(ctx, agentclient, dialer, orgSlug, *network, socksPort, httpPort)
+	}
+
 	ports := strings.Split(args[0], ":")
 
 	params := &proxy.ConnectParams{
@@ -130,6 +187,8 @@ func run(ctx context.Context) (err error) {
 		Dialer:           dialer,
 		PromptInstance:   promptInstance,
 		Network:          *network,
+		UDP:              flag.GetBool(ctx, "udp"),
+		UDPIdleTimeout:   flag.GetDuration(ctx, "udp-idle-timeout"),
 	}
 
 	if len(args) > 1 {
@@ -138,11 +197,55 @@ func run(ctx context.Context) (err error) {
 		params.RemoteHost = fmt.Sprintf("%s.internal", appName)
 	}
 
-	if flag.GetBool(ctx, "watch-stdin") {
-		ctx = watchStdinAndAbortOnClose(ctx)
+	return proxy.Connect(ctx, params)
+}
+
+// runForwardProxies serves SOCKS5 and HTTP proxies to the organization's
+// private network until the context is cancelled.
+func runForwardProxies(ctx context.Context, agentclient *agent.Client, dialer agent.Dialer, orgSlug, network string, socksPort, httpPort int) error {
+	io := iostreams.FromContext(ctx)
+
+	resolve := func(ctx context.Context, host string) (string, error) {
+		return agentclient.Resolve(ctx, orgSlug, host, network)
 	}
 
-	return proxy.Connect(ctx, params)
+	var servers []*proxy.ForwardServer
+	for _, p := range []struct {
+		mode proxy.ForwardMode
+		flag string
+		port int
+	}{
+		{proxy.ForwardSOCKS5, "socks", socksPort},
+		{proxy.ForwardHTTP, "http-proxy", httpPort},
+	} {
+		if !flag.IsSpecified(ctx, p.flag) {
+			continue
+		}
+
+		listener, err := net.Listen("tcp", net.JoinHostPort(flag.GetBindAddr(ctx), strconv.Itoa(p.port)))
+		if err != nil {
+			for _, srv := range servers {
+				srv.Listener.Close()
+			}
+			return err
+		}
+		fmt.Fprintf(io.Out, "%s proxy to the %s private network listening on %s\n", p.mode, orgSlug, listener.Addr())
+
+		servers = append(servers, &proxy.ForwardServer{
+			Mode:     p.mode,
+			Listener: listener,
+			Dial:     dialer.DialContext,
+			Resolve:  resolve,
+		})
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, srv := range servers {
+		eg.Go(func() error {
+			return srv.Serve(ctx)
+		})
+	}
+	return eg.Wait()
 }
 
 // Asynchronously watches stdin and abort when it closes.
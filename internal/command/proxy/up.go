@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/superfly/flyctl/agent"
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/flyutil"
+	"github.com/superfly/flyctl/internal/state"
+	"github.com/superfly/flyctl/iostreams"
+	"github.com/superfly/flyctl/proxy"
+)
+
+func newUp() *cobra.Command {
+	const (
+		short = "Start every forward of a proxy preset"
+		long  = `Start every port forward of a named proxy preset in one process. Tunnels are
+probed periodically and rebuilt automatically when they stop working.
+
+Presets live in the proxy_presets section of the flyctl config file and can be
+managed with 'fly proxy presets'.`
+	)
+
+	cmd := command.New("up <preset>", short, long, runUp, command.RequireSession)
+	cmd.Args = cobra.ExactArgs(1)
+
+	flag.Add(cmd,
+		flag.Duration{
+			Name:        "probe-interval",
+			Default:     30 * time.Second,
+			Description: "How often to check that tunnels still work",
+		},
+		flag.Bool{
+			Name:        "watch-stdin",
+			Default:     false,
+			Description: "Watches stdin and terminates once it gets closed",
+		},
+	)
+
+	return cmd
+}
+
+func runUp(ctx context.Context) error {
+	var (
+		io     = iostreams.FromContext(ctx)
+		client = flyutil.ClientFromContext(ctx)
+		name   = flag.FirstArg(ctx)
+	)
+
+	presets, err := config.ReadProxyPresets(state.ConfigFile(ctx))
+	if err != nil {
+		return err
+	}
+
+	preset, ok := presets[name]
+	if !ok {
+		return fmt.Errorf("no proxy preset named %q; see 'fly proxy presets list'", name)
+	}
+	if len(preset.Forwards) == 0 {
+		return fmt.Errorf("proxy preset %q has no forwards", name)
+	}
+
+	forwards, err := resolveForwards(ctx, preset.Forwards)
+	if err != nil {
+		return err
+	}
+
+	agentclient, err := agent.Establish(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	dialers := map[[2]string]agent.Dialer{}
+	for _, f := range forwards {
+		key := [2]string{f.Org, f.Network}
+		if _, ok := dialers[key]; ok {
+			continue
+		}
+
+		dialer, err := agentclient.ConnectToTunnel(ctx, f.Org, f.Network, false)
+		if err != nil {
+			return err
+		}
+		dialers[key] = dialer
+	}
+
+	if flag.GetBool(ctx, "watch-stdin") {
+		ctx = watchStdinAndAbortOnClose(ctx)
+	}
+
+	supervisor := &proxy.Supervisor{
+		Forwards:      forwards,
+		ProbeInterval: flag.GetDuration(ctx, "probe-interval"),
+		Dial: func(ctx context.Context, org, network, addr string) (net.Conn, error) {
+			return dialers[[2]string{org, network}].DialContext(ctx, "tcp", addr)
+		},
+		Resolve: func(ctx context.Context, org, network, host string) (string, error) {
+			return agentclient.Resolve(ctx, org, host, network)
+		},
+		Probe: func(ctx context.Context, org, network string) error {
+			return agentclient.Probe(ctx, org, network)
+		},
+		Reestablish: func(ctx context.Context, org, network string) error {
+			// the agent itself may have gone away; this restarts it if needed
+			ac, err := agent.Establish(ctx, client)
+			if err != nil {
+				return err
+			}
+			if _, err := ac.Reestablish(ctx, org, network); err != nil {
+				return err
+			}
+			return ac.WaitForTunnel(ctx, org, network)
+		},
+		Status: func(s proxy.ForwardStatus) {
+			line := fmt.Sprintf("%s %-14s %-12s %s -> %s", time.Now().Format(time.TimeOnly), s.Forward.Name, s.State, s.Forward.Local(), s.Forward.Remote())
+			if s.Connections > 0 {
+				line += fmt.Sprintf(" (%d connections)", s.Connections)
+			}
+			if s.Err != nil {
+				line += ": " + s.Err.Error()
+			}
+			fmt.Fprintln(io.Out, line)
+		},
+	}
+
+	fmt.Fprintf(io.Out, "Starting proxy preset %s with %d forwards, press Ctrl+C to stop\n", name, len(forwards))
+
+	return supervisor.Run(ctx)
+}
+
+// resolveForwards turns a preset's forwards into supervised forwards, looking
+// up the organization and network of their apps.
+func resolveForwards(ctx context.Context, presetForwards []config.ProxyForward) ([]*proxy.SupervisedForward, error) {
+	client := flyutil.ClientFromContext(ctx)
+
+	type appInfo struct{ org, network string }
+	apps := map[string]appInfo{}
+
+	var forwards []*proxy.SupervisedForward
+	for i, pf := range presetForwards {
+		f, err := newSupervisedForward(pf)
+		if err != nil {
+			return nil, fmt.Errorf("forward %d: %w", i+1, err)
+		}
+
+		if pf.App != "" {
+			info, ok := apps[pf.App]
+			if !ok {
+				app, err := client.GetAppBasic(ctx, pf.App)
+				if err != nil {
+					return nil, fmt.Errorf("forward %s: %w", f.Name, err)
+				}
+				network, err := client.GetAppNetwork(ctx, pf.App)
+				if err != nil {
+					return nil, fmt.Errorf("forward %s: %w", f.Name, err)
+				}
+				info = appInfo{org: app.Organization.Slug, network: *network}
+				apps[pf.App] = info
+			}
+
+			if f.Org == "" {
+				f.Org = info.org
+			}
+			if f.Network == "" {
+				f.Network = info.network
+			}
+		}
+
+		forwards = append(forwards, f)
+	}
+
+	return forwards, nil
+}
+
+// newSupervisedForward validates a preset forward and fills in the defaults
+// fly proxy uses.
+func newSupervisedForward(pf config.ProxyForward) (*proxy.SupervisedForward, error) {
+	if pf.App == "" && (pf.Org == "" || pf.RemoteHost == "") {
+		return nil, fmt.Errorf("either app, or org and remote_host, are required")
+	}
+
+	local, remote, err := parseForwardPorts(pf.Ports)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &proxy.SupervisedForward{
+		BindAddr:   pf.BindAddr,
+		LocalPort:  local,
+		RemoteHost: pf.RemoteHost,
+		RemotePort: remote,
+		Org:        pf.Org,
+		Network:    pf.Network,
+	}
+
+	if f.BindAddr == "" {
+		f.BindAddr = "127.0.0.1"
+	}
+	if f.RemoteHost == "" {
+		f.RemoteHost = pf.App + ".internal"
+	}
+
+	f.Name = pf.App
+	if f.Name == "" {
+		f.Name = strings.TrimSuffix(f.RemoteHost, ".internal")
+	}
+	f.Name += ":" + remote
+
+	return f, nil
+}
+
+// parseForwardPorts splits "<local:remote>", or a single port used for both.
+func parseForwardPorts(ports string) (local, remote string, err error) {
+	local, remote, found := strings.Cut(ports, ":")
+	if !found {
+		remote = local
+	}
+
+	for _, p := range []string{local, remote} {
+		if n, err := strconv.Atoi(p); err != nil || n < 0 || n > 65535 {
+			return "", "", fmt.Errorf("invalid ports %q, expected <local:remote> or <port>", ports)
+		}
+	}
+
+	return local, remote, nil
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/superfly/flyctl/internal/config"
+)
+
+func TestParseForwardPorts(t *testing.T) {
+	local, remote, err := parseForwardPorts("15432:5432")
+	require.NoError(t, err)
+	assert.Equal(t, "15432", local)
+	assert.Equal(t, "5432", remote)
+
+	local, remote, err = parseForwardPorts("6379")
+	require.NoError(t, err)
+	assert.Equal(t, "6379", local)
+	assert.Equal(t, "6379", remote)
+
+	for _, bad := range []string{"", "db", "5432:", "70000:80"} {
+		_, _, err := parseForwardPorts(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestNewSupervisedForward(t *testing.T) {
+	f, err := newSupervisedForward(config.ProxyForward{App: "my-db", Ports: "15432:5432"})
+	require.NoError(t, err)
+	assert.Equal(t, "my-db:5432", f.Name)
+	assert.Equal(t, "127.0.0.1", f.BindAddr)
+	assert.Equal(t, "my-db.internal", f.RemoteHost)
+	assert.Equal(t, "15432", f.LocalPort)
+
+	f, err = newSupervisedForward(config.ProxyForward{Org: "acme", RemoteHost: "top1.nearest.of.cache.internal", Ports: "6379", BindAddr: "0.0.0.0"})
+	require.NoError(t, err)
+	assert.Equal(t, "top1.nearest.of.cache:6379", f.Name)
+	assert.Equal(t, "0.0.0.0", f.BindAddr)
+	assert.Equal(t, "acme", f.Org)
+
+	_, err = newSupervisedForward(config.ProxyForward{Org: "acme", Ports: "6379"})
+	assert.Error(t, err)
+}
@@ -50,6 +50,7 @@ import (
 	"github.com/superfly/flyctl/internal/command/orgs"
 	"github.com/superfly/flyctl/internal/command/ping"
 	"github.com/superfly/flyctl/internal/command/platform"
+	"github.com/superfly/flyctl/internal/command/plugins"
 	"github.com/superfly/flyctl/internal/command/postgres"
 	"github.com/superfly/flyctl/internal/command/proxy"
 	"github.com/superfly/flyctl/internal/command/redis"
@@ -99,6 +100,7 @@ func New() *cobra.Command {
 
 	fs := root.PersistentFlags()
 	_ = fs.StringP(flagnames.AccessToken, "t", "", "Fly API Access Token")
+	_ = fs.String(flagnames.Profile, "", "Auth profile to use, overriding FLY_PROFILE and .fly/profile")
 	_ = fs.BoolP(flagnames.Verbose, "", false, "Verbose output")
 	_ = fs.BoolP(flagnames.Debug, "", false, "Print additional logs and traces")
 
@@ -118,6 +120,7 @@ func New() *cobra.Command {
 		group(status.New(), "deploy"),
 		group(logs.New(), "upkeep"),
 		group(doctor.New(), "more_help"),
+		group(plugins.New(), "configuring"),
 		group(dig.New(), "upkeep"),
 		group(volumes.New(), "configuring"),
 		group(lfsc.New(), "dbs_and_extensions"),
@@ -0,0 +1,211 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/appsecrets"
+	"github.com/superfly/flyctl/internal/build/imgsrc"
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/flapsutil"
+	"github.com/superfly/flyctl/internal/render"
+	"github.com/superfly/flyctl/internal/state"
+	"github.com/superfly/flyctl/iostreams"
+	"github.com/superfly/flyctl/scanner"
+	"github.com/superfly/flyctl/terminal"
+)
+
+func newAudit() (cmd *cobra.Command) {
+	const (
+		long = `Find secrets the app's code never reads, and environment variables the code
+reads that nothing sets.
+
+The source tree is searched for environment variable reads, like os.Getenv,
+process.env, ENV[...] and System.get_env. Variables are considered set by app
+secrets, the [env] section of fly.toml, the environment of the app's machines
+and their image, and the Fly.io runtime.
+
+Detection is heuristic: a secret only a library reads, like DATABASE_URL,
+shows up as unreferenced, and a variable with a default in code shows up as
+missing.`
+		short = `Find unused secrets and unset environment variables`
+		usage = "audit [flags]"
+	)
+
+	cmd = command.New(usage, short, long, runAudit, command.RequireSession, command.RequireAppName)
+
+	flag.Add(cmd,
+		flag.App(),
+		flag.AppConfig(),
+		flag.JSONOutput(),
+		flag.String{
+			Name:        "source",
+			Description: "Source directory to search, defaulting to the directory of fly.toml",
+		},
+	)
+
+	return cmd
+}
+
+// runtimeEnv is set on every machine by the OS or Fly.io, on top of the
+// FLY_ variables.
+var runtimeEnv = []string{"HOME", "HOSTNAME", "LANG", "PATH", "PRIMARY_REGION", "PWD", "SHELL", "TERM", "TZ", "USER"}
+
+type missingEnv struct {
+	Name       string                 `json:"name"`
+	References []scanner.EnvReference `json:"references"`
+}
+
+type auditReport struct {
+	Unreferenced []string     `json:"unreferenced_secrets"`
+	Missing      []missingEnv `json:"missing"`
+}
+
+func runAudit(ctx context.Context) error {
+	var (
+		io          = iostreams.FromContext(ctx)
+		appName     = appconfig.NameFromContext(ctx)
+		flapsClient = flapsutil.ClientFromContext(ctx)
+		cfg         = appconfig.ConfigFromContext(ctx)
+	)
+
+	sourceDir := flag.GetString(ctx, "source")
+	if sourceDir == "" {
+		sourceDir = state.WorkingDirectory(ctx)
+		if cfg != nil && cfg.ConfigFilePath() != "" {
+			sourceDir = filepath.Dir(cfg.ConfigFilePath())
+		}
+	}
+
+	refs, err := scanner.FindEnvReferences(sourceDir)
+	if err != nil {
+		return fmt.Errorf("search %s: %w", sourceDir, err)
+	}
+
+	secrets, err := appsecrets.List(ctx, flapsClient, appName)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		names = append(names, secret.Name)
+	}
+
+	if cfg == nil {
+		if cfg, err = appconfig.FromRemoteApp(ctx, appName); err != nil {
+			return fmt.Errorf("error loading app config: %w", err)
+		}
+	}
+
+	provided := map[string]bool{}
+	for name := range cfg.Env {
+		provided[name] = true
+	}
+	for _, name := range runtimeEnv {
+		provided[name] = true
+	}
+
+	machines, err := flapsClient.ListActive(ctx, appName)
+	if err != nil {
+		return err
+	}
+
+	images := map[string]bool{}
+	for _, machine := range machines {
+		if machine.Config == nil {
+			continue
+		}
+		for name := range machine.Config.Env {
+			provided[name] = true
+		}
+		images[machine.FullImageRef()] = true
+	}
+
+	for ref := range images {
+		env, err := imgsrc.RemoteImageEnv(ctx, ref)
+		if err != nil {
+			terminal.Warnf("Could not read the environment of image %s: %v\n", ref, err)
+			continue
+		}
+		for _, kv := range env {
+			name, _, _ := strings.Cut(kv, "=")
+			provided[name] = true
+		}
+	}
+
+	report := auditSecrets(names, provided, refs)
+
+	if config.FromContext(ctx).JSONOutput {
+		return render.JSON(io.Out, report)
+	}
+
+	if len(report.Unreferenced) == 0 && len(report.Missing) == 0 {
+		fmt.Fprintf(io.Out, "Every secret is referenced in %s, and every variable it reads is set\n", sourceDir)
+		return nil
+	}
+
+	if len(report.Unreferenced) > 0 {
+		var rows [][]string
+		for _, name := range report.Unreferenced {
+			rows = append(rows, []string{name})
+		}
+		if err := render.Table(io.Out, "Secrets not referenced in the source", rows, "Name"); err != nil {
+			return err
+		}
+	}
+
+	if len(report.Missing) > 0 {
+		var rows [][]string
+		for _, m := range report.Missing {
+			where := fmt.Sprintf("%s:%d", m.References[0].File, m.References[0].Line)
+			if n := len(m.References) - 1; n > 0 {
+				where += fmt.Sprintf(" (+%d more)", n)
+			}
+			rows = append(rows, []string{m.Name, where})
+		}
+		if err := render.Table(io.Out, "Variables read but not set", rows, "Name", "Referenced At"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// auditSecrets compares the app's secrets and the variables set some other
+// way against the references found in the source.
+func auditSecrets(secrets []string, provided map[string]bool, refs []scanner.EnvReference) auditReport {
+	referenced := map[string][]scanner.EnvReference{}
+	for _, ref := range refs {
+		referenced[ref.Name] = append(referenced[ref.Name], ref)
+	}
+
+	isSecret := map[string]bool{}
+	report := auditReport{Unreferenced: []string{}, Missing: []missingEnv{}}
+
+	for _, name := range secrets {
+		isSecret[name] = true
+		if len(referenced[name]) == 0 {
+			report.Unreferenced = append(report.Unreferenced, name)
+		}
+	}
+	sort.Strings(report.Unreferenced)
+
+	for name, refs := range referenced {
+		if isSecret[name] || provided[name] || strings.HasPrefix(name, "FLY_") {
+			continue
+		}
+		report.Missing = append(report.Missing, missingEnv{Name: name, References: refs})
+	}
+	sort.Slice(report.Missing, func(i, j int) bool {
+		return report.Missing[i].Name < report.Missing[j].Name
+	})
+
+	return report
+}
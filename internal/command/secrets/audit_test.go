@@ -0,0 +1,28 @@
+package secrets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/superfly/flyctl/scanner"
+)
+
+func TestAuditSecrets(t *testing.T) {
+	refs := []scanner.EnvReference{
+		{Name: "API_KEY", File: "app.js", Line: 3},
+		{Name: "FLY_REGION", File: "app.js", Line: 4},
+		{Name: "LOG_LEVEL", File: "app.js", Line: 5},
+		{Name: "PORT", File: "main.go", Line: 10},
+		{Name: "SENTRY_DSN", File: "app.js", Line: 1},
+		{Name: "SENTRY_DSN", File: "worker.js", Line: 9},
+	}
+	provided := map[string]bool{"PORT": true}
+
+	report := auditSecrets([]string{"OLD_TOKEN", "API_KEY", "DATABASE_URL"}, provided, refs)
+
+	assert.Equal(t, []string{"DATABASE_URL", "OLD_TOKEN"}, report.Unreferenced)
+	assert.Equal(t, []missingEnv{
+		{Name: "LOG_LEVEL", References: refs[2:3]},
+		{Name: "SENTRY_DSN", References: refs[4:6]},
+	}, report.Missing)
+}
@@ -0,0 +1,140 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/appsecrets"
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/flapsutil"
+	"github.com/superfly/flyctl/internal/format"
+	"github.com/superfly/flyctl/internal/render"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+func newHistory() (cmd *cobra.Command) {
+	const (
+		long = `Show when secrets changed and the release each change went out in. Only
+digests of values are shown, never the values themselves.
+
+The history is local only: it's kept in flyctl's config directory and covers
+the changes made from this machine. Teammates and CI keep their own. Secrets
+whose digest no longer matches the history were changed elsewhere, like the
+dashboard or another machine, and are flagged.`
+		short = `Show the history of changes to app secrets made from this machine`
+		usage = "history [flags] [NAME]"
+	)
+
+	cmd = command.New(usage, short, long, runHistory, command.RequireSession, command.RequireAppName)
+
+	flag.Add(cmd,
+		flag.App(),
+		flag.AppConfig(),
+		flag.JSONOutput(),
+	)
+
+	cmd.Args = cobra.MaximumNArgs(1)
+
+	return cmd
+}
+
+func runHistory(ctx context.Context) error {
+	var (
+		io      = iostreams.FromContext(ctx)
+		appName = appconfig.NameFromContext(ctx)
+		only    = flag.FirstArg(ctx)
+	)
+
+	entries, err := appsecrets.History(ctx, appName)
+	if err != nil {
+		return err
+	}
+
+	if only != "" {
+		var filtered []appsecrets.HistoryEntry
+		for _, entry := range entries {
+			if entry.Name == only {
+				filtered = append(filtered, entry)
+			}
+		}
+		entries = filtered
+	}
+
+	if config.FromContext(ctx).JSONOutput {
+		return render.JSON(io.Out, entries)
+	}
+
+	fmt.Fprintln(io.ErrOut, "Only changes made with flyctl from this machine are recorded; changes from CI, teammates or the dashboard aren't shown.")
+
+	if len(entries) == 0 {
+		fmt.Fprintf(io.ErrOut, "No secret changes recorded for %s\n", appName)
+	} else {
+		var table [][]string
+		for _, row := range entries {
+			release := "-"
+			if row.Release != 0 {
+				release = fmt.Sprintf("v%d", row.Release)
+			}
+			table = append(table, []string{
+				row.Name,
+				row.Action,
+				row.Digest,
+				fmt.Sprint(row.Version),
+				release,
+				format.RelativeTime(row.Time),
+			})
+		}
+		if err := render.Table(io.Out, "", table, "Name", "Action", "Digest", "Secrets Version", "Release", "Date"); err != nil {
+			return err
+		}
+	}
+
+	current, err := appsecrets.List(ctx, flapsutil.ClientFromContext(ctx), appName)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range driftedSecrets(entries, current) {
+		fmt.Fprintf(io.ErrOut, "Warning: %s was changed outside this flyctl since its last recorded change\n", name)
+	}
+
+	return nil
+}
+
+// driftedSecrets returns the names of secrets whose current digest differs
+// from the last one recorded for them.
+func driftedSecrets(entries []appsecrets.HistoryEntry, current []fly.AppSecret) []string {
+	last := map[string]appsecrets.HistoryEntry{}
+	for _, entry := range entries {
+		last[entry.Name] = entry
+	}
+
+	digests := map[string]string{}
+	for _, secret := range current {
+		digests[secret.Name] = secret.Digest
+	}
+
+	var drifted []string
+	for name, entry := range last {
+		digest, exists := digests[name]
+		unset := entry.Action == "unset" || entry.Action == "expire"
+
+		switch {
+		case unset && exists:
+		case !unset && !exists:
+		case !unset && entry.Digest != "" && digest != entry.Digest:
+		default:
+			continue
+		}
+		drifted = append(drifted, name)
+	}
+	sort.Strings(drifted)
+
+	return drifted
+}
@@ -0,0 +1,28 @@
+package secrets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/flyctl/internal/appsecrets"
+)
+
+func TestDriftedSecrets(t *testing.T) {
+	entries := []appsecrets.HistoryEntry{
+		{Name: "SAME", Action: "set", Digest: "1"},
+		{Name: "CHANGED", Action: "set", Digest: "old"},
+		{Name: "CHANGED", Action: "rotate", Digest: "2"},
+		{Name: "REMOVED", Action: "set", Digest: "3"},
+		{Name: "BACK", Action: "unset"},
+		{Name: "GONE_PREVIOUS", Action: "expire"},
+	}
+	current := []fly.AppSecret{
+		{Name: "SAME", Digest: "1"},
+		{Name: "CHANGED", Digest: "changed"},
+		{Name: "BACK", Digest: "4"},
+		{Name: "UNKNOWN", Digest: "5"},
+	}
+
+	assert.Equal(t, []string{"BACK", "CHANGED", "REMOVED"}, driftedSecrets(entries, current))
+}
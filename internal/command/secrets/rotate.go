@@ -0,0 +1,269 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/shlex"
+	"github.com/spf13/cobra"
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/appsecrets"
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/flapsutil"
+	"github.com/superfly/flyctl/internal/flyutil"
+	"github.com/superfly/flyctl/internal/prompt"
+	"github.com/superfly/flyctl/internal/secretsource"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+func newRotate() (cmd *cobra.Command) {
+	const (
+		long = `Replace a secret with a newly generated value and deploy it.
+
+Generators:
+  random:N    N random letters and digits (the default is random:32)
+  exec:CMD    the output of CMD, minus a trailing newline
+
+With --grace-period, the old value stays available as NAME_PREVIOUS while
+clients move over. Once the grace period is over, finish the rotation with
+'fly secrets rotate NAME --finish', which unsets NAME_PREVIOUS and deploys
+again. When the grace period ends is remembered on this machine only, so
+--finish elsewhere asks for confirmation, or --yes, since it can't check it.`
+		short = `Rotate a secret to a newly generated value`
+		usage = "rotate [flags] NAME"
+	)
+
+	cmd = command.New(usage, short, long, runRotate, command.RequireSession, command.RequireAppName)
+
+	flag.Add(cmd,
+		sharedFlags,
+		flag.String{
+			Name:        "generator",
+			Default:     "random:32",
+			Description: "How to generate the new value: random:N or exec:CMD",
+		},
+		flag.Duration{
+			Name:        "grace-period",
+			Description: "Keep the old value as NAME_PREVIOUS until the rotation is finished after this long",
+		},
+		flag.Bool{
+			Name:        "finish",
+			Description: "Finish a rotation started with --grace-period by unsetting NAME_PREVIOUS",
+		},
+		flag.Yes(),
+	)
+
+	cmd.Args = cobra.ExactArgs(1)
+
+	return cmd
+}
+
+func runRotate(ctx context.Context) error {
+	var (
+		io          = iostreams.FromContext(ctx)
+		appName     = appconfig.NameFromContext(ctx)
+		name        = flag.FirstArg(ctx)
+		previous    = name + "_PREVIOUS"
+		gracePeriod = flag.GetDuration(ctx, "grace-period")
+		args        = DeploymentArgs{
+			Stage:    flag.GetBool(ctx, "stage"),
+			Detach:   flag.GetBool(ctx, "detach"),
+			CheckDNS: flag.GetBool(ctx, "dns-checks"),
+		}
+	)
+
+	if flag.GetBool(ctx, "finish") {
+		if gracePeriod > 0 || flag.IsSpecified(ctx, "generator") {
+			return errors.New("--finish can't be used with --grace-period or --generator")
+		}
+		return finishRotation(ctx, name, args)
+	}
+
+	generate, err := parseGenerator(flag.GetString(ctx, "generator"))
+	if err != nil {
+		return err
+	}
+
+	app, err := flyutil.ClientFromContext(ctx).GetAppCompact(ctx, appName)
+	if err != nil {
+		return err
+	}
+
+	flapsClient := flapsutil.ClientFromContext(ctx)
+
+	value, err := generate(ctx)
+	if err != nil {
+		return fmt.Errorf("generate new value for %s: %w", name, err)
+	}
+
+	update := map[string]string{name: value}
+
+	if gracePeriod > 0 {
+		old, err := currentValue(ctx, flapsClient, appName, name)
+		if err != nil {
+			return err
+		}
+		update[previous] = old
+	}
+
+	if err := appsecrets.UpdateWithReason(ctx, flapsClient, appName, update, nil, "rotate"); err != nil {
+		return fmt.Errorf("update secrets: %w", err)
+	}
+	fmt.Fprintf(io.Out, "Rotated %s\n", name)
+
+	if gracePeriod > 0 {
+		until := time.Now().Add(gracePeriod)
+		if err := appsecrets.SetPendingRotation(ctx, appName, name, until); err != nil {
+			return fmt.Errorf("record grace period: %w", err)
+		}
+		fmt.Fprintf(io.Out, "Keeping the old value as %s. After %s, finish the rotation with 'fly secrets rotate %s --finish'.\n",
+			previous, until.Format(time.DateTime), name)
+	}
+
+	return DeploySecrets(ctx, app, args)
+}
+
+// finishRotation unsets the old value of a secret kept by a rotation, once its
+// grace period is over.
+func finishRotation(ctx context.Context, name string, args DeploymentArgs) error {
+	var (
+		io       = iostreams.FromContext(ctx)
+		appName  = appconfig.NameFromContext(ctx)
+		previous = name + "_PREVIOUS"
+	)
+
+	until, pending, err := appsecrets.PendingRotation(ctx, appName, name)
+	if err != nil {
+		return err
+	}
+	if pending && time.Now().Before(until) {
+		return fmt.Errorf("the grace period of %s lasts until %s; unset %s with 'fly secrets unset %s' to end it early",
+			name, until.Format(time.DateTime), previous, previous)
+	}
+
+	app, err := flyutil.ClientFromContext(ctx).GetAppCompact(ctx, appName)
+	if err != nil {
+		return err
+	}
+
+	flapsClient := flapsutil.ClientFromContext(ctx)
+
+	current, err := appsecrets.List(ctx, flapsClient, appName)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(current, func(s fly.AppSecret) bool { return s.Name == previous }) {
+		if err := appsecrets.FinishRotation(ctx, appName, name); err != nil {
+			return err
+		}
+		fmt.Fprintf(io.Out, "%s isn't set, nothing to finish\n", previous)
+		return nil
+	}
+
+	if !pending && !flag.GetYes(ctx) {
+		const msg = "No rotation of %s was started from this machine, so its grace period can't be checked. Unset %s anyway?"
+		switch confirmed, err := prompt.Confirmf(ctx, msg, name, previous); {
+		case prompt.IsNonInteractive(err):
+			return fmt.Errorf("no rotation of %s was started from this machine, pass --yes to unset %s without checking its grace period", name, previous)
+		case err != nil:
+			return err
+		case !confirmed:
+			return nil
+		}
+	}
+
+	if err := appsecrets.UpdateWithReason(ctx, flapsClient, appName, nil, []string{previous}, "expire"); err != nil {
+		return fmt.Errorf("update secrets: %w", err)
+	}
+	if err := appsecrets.FinishRotation(ctx, appName, name); err != nil {
+		return err
+	}
+	fmt.Fprintf(io.Out, "Removed %s\n", previous)
+
+	return DeploySecrets(ctx, app, args)
+}
+
+// currentValue reads the value a secret has now.
+func currentValue(ctx context.Context, flapsClient flapsutil.FlapsClient, appName, name string) (string, error) {
+	minver, err := appsecrets.GetMinvers(appName)
+	if err != nil {
+		return "", err
+	}
+
+	secrets, err := flapsClient.ListAppSecrets(ctx, appName, minver, true)
+	if err != nil {
+		return "", err
+	}
+
+	for _, secret := range secrets {
+		if secret.Name != name {
+			continue
+		}
+		if secret.Value == nil {
+			return "", fmt.Errorf("can't read the current value of %s to keep it", name)
+		}
+		return *secret.Value, nil
+	}
+
+	return "", fmt.Errorf("app has no secret %s to keep the old value of", name)
+}
+
+type generator func(ctx context.Context) (string, error)
+
+// parseGenerator parses a --generator spec.
+func parseGenerator(spec string) (generator, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+
+	switch kind {
+	case "random":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > 4096 {
+			return nil, fmt.Errorf("invalid generator %q, expected random:N with N from 1 to 4096", spec)
+		}
+		return func(context.Context) (string, error) { return randomString(n) }, nil
+	case "exec":
+		argv, err := shlex.Split(arg)
+		if err != nil || len(argv) == 0 {
+			return nil, fmt.Errorf("invalid generator %q, expected exec:CMD", spec)
+		}
+		return func(ctx context.Context) (string, error) {
+			out, err := secretsource.RunCommand(ctx, argv[0], argv[1:]...)
+			if err != nil {
+				return "", err
+			}
+			value := strings.TrimSuffix(string(out), "\n")
+			if value == "" {
+				return "", fmt.Errorf("%s printed nothing", argv[0])
+			}
+			return value, nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown generator %q, expected random:N or exec:CMD", spec)
+	}
+}
+
+const randomAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+func randomString(n int) (string, error) {
+	size := big.NewInt(int64(len(randomAlphabet)))
+
+	b := make([]byte, n)
+	for i := range b {
+		j, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+		b[i] = randomAlphabet[j.Int64()]
+	}
+
+	return string(b), nil
+}
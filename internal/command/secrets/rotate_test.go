@@ -0,0 +1,40 @@
+package secrets
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGenerator(t *testing.T) {
+	ctx := context.Background()
+
+	gen, err := parseGenerator("random:32")
+	require.NoError(t, err)
+
+	a, err := gen(ctx)
+	require.NoError(t, err)
+	b, err := gen(ctx)
+	require.NoError(t, err)
+	assert.Len(t, a, 32)
+	assert.NotEqual(t, a, b)
+	assert.Regexp(t, "^[A-Za-z0-9]+$", a)
+
+	gen, err = parseGenerator(`exec:echo "new value"`)
+	require.NoError(t, err)
+	v, err := gen(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "new value", v)
+
+	gen, err = parseGenerator("exec:true")
+	require.NoError(t, err)
+	_, err = gen(ctx)
+	assert.ErrorContains(t, err, "printed nothing")
+
+	for _, spec := range []string{"random", "random:0", "random:x", "exec:", "uuid"} {
+		_, err := parseGenerator(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,334 @@
+package secrets
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/flapsutil"
+	"github.com/superfly/flyctl/internal/flyutil"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+// sealedPrefix marks a sealed value. Sealed values are
+// sealedPrefix<key label>:<base64 ciphertext>. Key labels can't contain
+// colons, so the label always ends at the first one.
+const sealedPrefix = "fly-sealed:v1:"
+
+// keyCipher encrypts and decrypts with app secret keys. The keys never
+// leave Fly.io; flaps does the encryption.
+type keyCipher interface {
+	EncryptSecretKey(ctx context.Context, appName, name string, plaintext, assoc []byte, version *uint64) (*fly.EncryptSecretKeyResp, error)
+	DecryptSecretKey(ctx context.Context, appName, name string, ciphertext, assoc []byte, version *uint64) (*fly.DecryptSecretKeyResp, error)
+}
+
+func newSeal() (cmd *cobra.Command) {
+	const (
+		long = `Encrypt the secrets in a dotenv file with one of the app's encrypting keys, so
+the sealed file can be committed to git. Set the sealed secrets on the app with
+'fly secrets apply'.
+
+Values are encrypted by Fly.io with a key managed by 'fly secrets keys'; the
+key itself never leaves Fly.io. Without --key, the latest version of the app's
+only encrypting key is used. Create one with:
+
+  fly secrets keys generate encrypting sealed
+
+Reads the dotenv file from stdin if no file is given.`
+		short = `Encrypt a dotenv file of secrets so it can be committed`
+		usage = "seal [flags] [dotenv-file]"
+	)
+
+	cmd = command.New(usage, short, long, runSeal, command.RequireSession, command.RequireAppName)
+
+	flag.Add(cmd,
+		flag.App(),
+		flag.AppConfig(),
+		flag.String{
+			Name:        "key",
+			Description: "Label of the encrypting key to seal with. Unversioned labels use the latest version",
+		},
+		flag.String{
+			Name:        "output",
+			Shorthand:   "o",
+			Description: "Write the sealed file here instead of stdout",
+		},
+	)
+
+	cmd.Args = cobra.MaximumNArgs(1)
+
+	return cmd
+}
+
+func runSeal(ctx context.Context) error {
+	appName := appconfig.NameFromContext(ctx)
+	flapsClient := flapsutil.ClientFromContext(ctx)
+
+	var in io.Reader = os.Stdin
+	if path := flag.FirstArg(ctx); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	secrets, err := parseSecrets(in)
+	if err != nil {
+		return err
+	}
+	if len(secrets) == 0 {
+		return errors.New("requires at least one SECRET=VALUE pair")
+	}
+
+	keys, err := flapsClient.ListSecretKeys(ctx, appName, nil)
+	if err != nil {
+		return err
+	}
+
+	label, err := pickSealingKey(keys, flag.GetString(ctx, "key"))
+	if err != nil {
+		return err
+	}
+
+	sealed, err := sealSecrets(ctx, flapsClient, appName, label, secrets)
+	if err != nil {
+		return err
+	}
+
+	out := iostreams.FromContext(ctx).Out
+	if path := flag.GetString(ctx, "output"); path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	return writeSealed(out, appName, sealed)
+}
+
+func newApply() (cmd *cobra.Command) {
+	const (
+		long = `Decrypt a file sealed with 'fly secrets seal' and set its secrets on the app.
+Values are decrypted in memory and never written to disk.`
+		short = `Set the secrets in a sealed file`
+		usage = "apply [flags] <sealed-file>"
+	)
+
+	cmd = command.New(usage, short, long, runApply, command.RequireSession, command.RequireAppName)
+
+	flag.Add(cmd,
+		sharedFlags,
+	)
+
+	cmd.Aliases = []string{"unseal"}
+	cmd.Args = cobra.ExactArgs(1)
+
+	return cmd
+}
+
+func runApply(ctx context.Context) error {
+	appName := appconfig.NameFromContext(ctx)
+
+	f, err := os.Open(flag.FirstArg(ctx))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sealed, err := readSealed(f)
+	if err != nil {
+		return err
+	}
+	if len(sealed) == 0 {
+		return fmt.Errorf("%s has no sealed secrets", f.Name())
+	}
+
+	flapsClient := flapsutil.ClientFromContext(ctx)
+
+	secrets, err := unsealSecrets(ctx, flapsClient, appName, sealed)
+	if err != nil {
+		return err
+	}
+
+	app, err := flyutil.ClientFromContext(ctx).GetAppCompact(ctx, appName)
+	if err != nil {
+		return err
+	}
+
+	return SetSecretsAndDeploy(ctx, flapsClient, app, secrets, DeploymentArgs{
+		Stage:    flag.GetBool(ctx, "stage"),
+		Detach:   flag.GetBool(ctx, "detach"),
+		CheckDNS: flag.GetBool(ctx, "dns-checks"),
+	})
+}
+
+// pickSealingKey returns the label of the encrypting key to seal with. A
+// fully versioned label is used as is; otherwise the latest version of the
+// key with that label, or of the app's only encrypting key, is used.
+func pickSealingKey(keys []fly.SecretKey, want string) (string, error) {
+	wantVer, wantPrefix := KeyverUnspec, ""
+	if want != "" {
+		var err error
+		if wantVer, wantPrefix, err = SplitLabelKeyver(want); err != nil {
+			return "", fmt.Errorf("key %q: %w", want, err)
+		}
+	}
+
+	latest := map[string]fly.SecretKey{}
+	latestVer := map[string]Keyver{}
+
+	for _, key := range keys {
+		if semType, _ := SecretTypeToSemanticType(key.Type); semType != SemTypeEncrypting {
+			if key.Name == want {
+				return "", fmt.Errorf("key %s is not an encrypting key", want)
+			}
+			continue
+		}
+
+		if key.Name == want {
+			return want, nil
+		}
+
+		ver, prefix, err := SplitLabelKeyver(key.Name)
+		if err != nil {
+			continue
+		}
+		if cur, ok := latestVer[prefix]; !ok || CompareKeyver(ver, cur) > 0 {
+			latest[prefix] = key
+			latestVer[prefix] = ver
+		}
+	}
+
+	prefixes := make([]string, 0, len(latest))
+	for prefix := range latest {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	switch {
+	case want != "" && wantVer != KeyverUnspec:
+		return "", fmt.Errorf("no encrypting key %s", want)
+	case want != "":
+		key, ok := latest[wantPrefix]
+		if !ok {
+			return "", fmt.Errorf("no encrypting key %s", want)
+		}
+		return key.Name, nil
+	case len(prefixes) == 0:
+		return "", errors.New("app has no encrypting keys, create one with 'fly secrets keys generate encrypting <label>'")
+	case len(prefixes) > 1:
+		return "", fmt.Errorf("app has several encrypting keys (%s), pick one with --key", strings.Join(prefixes, ", "))
+	default:
+		return latest[prefixes[0]].Name, nil
+	}
+}
+
+// sealedAssocData binds a ciphertext to the app and secret name it was
+// sealed for, so it can't be applied under another name.
+func sealedAssocData(appName, name string) []byte {
+	return []byte(appName + "\x00" + name)
+}
+
+// sealSecrets encrypts each secret with the key label and returns the sealed
+// values, keyed by secret name.
+func sealSecrets(ctx context.Context, c keyCipher, appName, label string, secrets map[string]string) (map[string]string, error) {
+	sealed := make(map[string]string, len(secrets))
+
+	for name, value := range secrets {
+		resp, err := c.EncryptSecretKey(ctx, appName, label, []byte(value), sealedAssocData(appName, name), nil)
+		if err != nil {
+			return nil, fmt.Errorf("seal %s: %w", name, err)
+		}
+		sealed[name] = sealedPrefix + label + ":" + base64.StdEncoding.EncodeToString(resp.Ciphertext)
+	}
+
+	return sealed, nil
+}
+
+// unsealSecrets decrypts sealed values, keyed by secret name.
+func unsealSecrets(ctx context.Context, c keyCipher, appName string, sealed map[string]string) (map[string]string, error) {
+	secrets := make(map[string]string, len(sealed))
+
+	for name, value := range sealed {
+		label, ciphertext, err := splitSealed(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		resp, err := c.DecryptSecretKey(ctx, appName, label, ciphertext, sealedAssocData(appName, name), nil)
+		if err != nil {
+			return nil, fmt.Errorf("unseal %s: %w", name, err)
+		}
+		secrets[name] = string(resp.Plaintext)
+	}
+
+	return secrets, nil
+}
+
+func splitSealed(value string) (label string, ciphertext []byte, err error) {
+	rest, ok := strings.CutPrefix(value, sealedPrefix)
+	if !ok {
+		return "", nil, errors.New("value is not sealed")
+	}
+
+	label, encoded, ok := strings.Cut(rest, ":")
+	if !ok || ValidKeyLabel(label) != nil {
+		return "", nil, errors.New("sealed value has no key label")
+	}
+
+	if ciphertext, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+		return "", nil, fmt.Errorf("bad sealed value encoding: %w", err)
+	}
+
+	return label, ciphertext, nil
+}
+
+// writeSealed writes sealed values as a dotenv file, sorted by name so the
+// file diffs well.
+func writeSealed(w io.Writer, appName string, sealed map[string]string) error {
+	names := make([]string, 0, len(sealed))
+	for name := range sealed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# Secrets for %s sealed by 'fly secrets seal'. Set them with 'fly secrets apply'.\n", appName)
+	for _, name := range names {
+		fmt.Fprintf(bw, "%s=%s\n", name, sealed[name])
+	}
+
+	return bw.Flush()
+}
+
+// readSealed reads a sealed file. Every value in it must be sealed, so
+// plaintext that ends up in the file by mistake isn't applied silently.
+func readSealed(r io.Reader) (map[string]string, error) {
+	sealed, err := parseSecrets(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range sealed {
+		if !strings.HasPrefix(value, sealedPrefix) {
+			return nil, fmt.Errorf("%s is not sealed, seal it with 'fly secrets seal'", name)
+		}
+	}
+
+	return sealed, nil
+}
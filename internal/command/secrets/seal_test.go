@@ -0,0 +1,107 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	fly "github.com/superfly/fly-go"
+)
+
+// fakeCipher "encrypts" by tagging the plaintext with the key label and
+// associated data, and refuses to decrypt if either differs.
+type fakeCipher struct{}
+
+func (fakeCipher) EncryptSecretKey(ctx context.Context, appName, name string, plaintext, assoc []byte, version *uint64) (*fly.EncryptSecretKeyResp, error) {
+	ct := append([]byte(name+"|"+string(assoc)+"|"), plaintext...)
+	return &fly.EncryptSecretKeyResp{Ciphertext: ct}, nil
+}
+
+func (fakeCipher) DecryptSecretKey(ctx context.Context, appName, name string, ciphertext, assoc []byte, version *uint64) (*fly.DecryptSecretKeyResp, error) {
+	pt, ok := bytes.CutPrefix(ciphertext, []byte(name+"|"+string(assoc)+"|"))
+	if !ok {
+		return nil, errors.New("decryption failed")
+	}
+	return &fly.DecryptSecretKeyResp{Plaintext: pt}, nil
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	secrets := map[string]string{
+		"DATABASE_URL": "postgres://user:pass@db/app",
+		"CERT":         "-----BEGIN-----\nabc\n-----END-----\n",
+	}
+
+	sealed, err := sealSecrets(ctx, fakeCipher{}, "my-app", "sealedv2", secrets)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, writeSealed(&buf, "my-app", sealed))
+
+	assert.NotContains(t, buf.String(), "postgres://")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "# "))
+	assert.True(t, strings.HasPrefix(lines[1], "CERT=fly-sealed:v1:sealedv2:"))
+	assert.True(t, strings.HasPrefix(lines[2], "DATABASE_URL=fly-sealed:v1:sealedv2:"))
+
+	read, err := readSealed(&buf)
+	require.NoError(t, err)
+
+	unsealed, err := unsealSecrets(ctx, fakeCipher{}, "my-app", read)
+	require.NoError(t, err)
+	assert.Equal(t, secrets, unsealed)
+
+	// a ciphertext is bound to the app and the name it was sealed under
+	_, err = unsealSecrets(ctx, fakeCipher{}, "other-app", read)
+	assert.Error(t, err)
+	_, err = unsealSecrets(ctx, fakeCipher{}, "my-app", map[string]string{"OTHER": read["CERT"]})
+	assert.Error(t, err)
+}
+
+func TestReadSealedRejectsPlaintext(t *testing.T) {
+	_, err := readSealed(strings.NewReader("A=fly-sealed:v1:k:YQ==\nB=plaintext\n"))
+	assert.ErrorContains(t, err, "B is not sealed")
+
+	_, err = unsealSecrets(context.Background(), fakeCipher{}, "my-app", map[string]string{"A": "fly-sealed:v1:YQ=="})
+	assert.Error(t, err)
+}
+
+func TestPickSealingKey(t *testing.T) {
+	keys := []fly.SecretKey{
+		{Name: "sealedv1", Type: SECRETKEY_TYPE_NACL_SECRETBOX},
+		{Name: "sealedv3", Type: SECRETKEY_TYPE_NACL_SECRETBOX},
+		{Name: "sealedv2", Type: SECRETKEY_TYPE_NACL_SECRETBOX},
+		{Name: "signingv1", Type: SECRETKEY_TYPE_HS256},
+	}
+
+	label, err := pickSealingKey(keys, "")
+	require.NoError(t, err)
+	assert.Equal(t, "sealedv3", label)
+
+	label, err = pickSealingKey(keys, "sealed")
+	require.NoError(t, err)
+	assert.Equal(t, "sealedv3", label)
+
+	label, err = pickSealingKey(keys, "sealedv2")
+	require.NoError(t, err)
+	assert.Equal(t, "sealedv2", label)
+
+	_, err = pickSealingKey(keys, "sealedv9")
+	assert.Error(t, err)
+
+	_, err = pickSealingKey(keys, "signingv1")
+	assert.ErrorContains(t, err, "not an encrypting key")
+
+	keys = append(keys, fly.SecretKey{Name: "otherv1", Type: SECRETKEY_TYPE_XAES256GCM})
+	_, err = pickSealingKey(keys, "")
+	assert.ErrorContains(t, err, "--key")
+
+	_, err = pickSealingKey(keys[3:4], "")
+	assert.ErrorContains(t, err, "no encrypting keys")
+}
@@ -50,6 +50,11 @@ func New() *cobra.Command {
 		newImport(),
 		newDeploy(),
 		newKeys(),
+		newSeal(),
+		newApply(),
+		newRotate(),
+		newHistory(),
+		newAudit(),
 	)
 
 	return secrets
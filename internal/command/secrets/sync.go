@@ -2,20 +2,40 @@ package secrets
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 
 	"github.com/spf13/cobra"
+	fly "github.com/superfly/fly-go"
 	"github.com/superfly/flyctl/internal/appconfig"
 	"github.com/superfly/flyctl/internal/appsecrets"
 	"github.com/superfly/flyctl/internal/command"
 	"github.com/superfly/flyctl/internal/flag"
 	"github.com/superfly/flyctl/internal/flapsutil"
+	"github.com/superfly/flyctl/internal/flyutil"
+	"github.com/superfly/flyctl/internal/secretsource"
+	"github.com/superfly/flyctl/iostreams"
 )
 
 func newSync() (cmd *cobra.Command) {
 	const (
-		long  = `Sync flyctl with the latest versions of app secrets, even if they were set elsewhere`
-		short = long
+		short = `Sync flyctl with the latest versions of app secrets, even if they were set elsewhere`
+		long  = short + `
+
+With --from, sync app secrets from external secret managers instead. The file
+maps app secret names to references in HashiCorp Vault, AWS Secrets Manager,
+1Password, SOPS encrypted files or the output of a command:
+
+  [[sources]]
+  provider = "vault"       # or aws, 1password, sops, exec
+  mount = "secret"
+
+    [sources.secrets]
+    DATABASE_URL = "my-app/db#url"
+
+Only secrets whose values differ from the app's are set. Providers use their
+own CLI (vault, aws, op, sops), which must be installed and logged in.`
 		usage = "sync [flags]"
 	)
 
@@ -23,6 +43,14 @@ func newSync() (cmd *cobra.Command) {
 
 	flag.Add(cmd,
 		sharedFlags,
+		flag.String{
+			Name:        "from",
+			Description: "Sync secrets from the external sources mapped in this file",
+		},
+		flag.Bool{
+			Name:        "dry-run",
+			Description: "With --from, show which secrets would change without setting them",
+		},
 	)
 
 	return cmd
@@ -37,8 +65,128 @@ func runSync(ctx context.Context) (err error) {
 	appName := appconfig.NameFromContext(ctx)
 	flapsClient := flapsutil.ClientFromContext(ctx)
 
+	if path := flag.GetString(ctx, "from"); path != "" {
+		return runSyncFrom(ctx, path)
+	}
+	if flag.GetBool(ctx, "dry-run") {
+		return errors.New("--dry-run requires --from")
+	}
+
 	if err := appsecrets.Sync(ctx, flapsClient, appName); err != nil {
 		return fmt.Errorf("sync secrets: %w", err)
 	}
 	return nil
 }
+
+func runSyncFrom(ctx context.Context, path string) error {
+	var (
+		io          = iostreams.FromContext(ctx)
+		appName     = appconfig.NameFromContext(ctx)
+		flapsClient = flapsutil.ClientFromContext(ctx)
+	)
+
+	mapping, err := secretsource.Load(path)
+	if err != nil {
+		return err
+	}
+
+	desired, err := mapping.Resolve(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	minver, err := appsecrets.GetMinvers(appName)
+	if err != nil {
+		return err
+	}
+
+	current, err := flapsClient.ListAppSecrets(ctx, appName, minver, true)
+	if err != nil {
+		return err
+	}
+
+	plan := planSecretSync(current, desired)
+
+	for _, change := range plan.changes {
+		fmt.Fprintf(io.Out, "%s %s\n", change.action, change.name)
+	}
+	fmt.Fprintf(io.Out, "%d to add, %d to update, %d unchanged\n", plan.count(secretAdd), plan.count(secretUpdate), plan.unchanged)
+
+	if flag.GetBool(ctx, "dry-run") || len(plan.changes) == 0 {
+		return nil
+	}
+
+	app, err := flyutil.ClientFromContext(ctx).GetAppCompact(ctx, appName)
+	if err != nil {
+		return err
+	}
+
+	return SetSecretsAndDeploy(ctx, flapsClient, app, plan.values(desired), DeploymentArgs{
+		Stage:    flag.GetBool(ctx, "stage"),
+		Detach:   flag.GetBool(ctx, "detach"),
+		CheckDNS: flag.GetBool(ctx, "dns-checks"),
+	})
+}
+
+const (
+	secretAdd    = "+"
+	secretUpdate = "~"
+)
+
+type secretChange struct {
+	name   string
+	action string
+}
+
+type secretSyncPlan struct {
+	changes   []secretChange
+	unchanged int
+}
+
+// planSecretSync compares the desired secrets with the app's. Secrets whose
+// current value isn't visible to this token are always updated.
+func planSecretSync(current []fly.AppSecret, desired map[string]string) secretSyncPlan {
+	existing := make(map[string]*string, len(current))
+	for _, s := range current {
+		existing[s.Name] = s.Value
+	}
+
+	names := make([]string, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var plan secretSyncPlan
+	for _, name := range names {
+		value, ok := existing[name]
+		switch {
+		case !ok:
+			plan.changes = append(plan.changes, secretChange{name: name, action: secretAdd})
+		case value == nil || *value != desired[name]:
+			plan.changes = append(plan.changes, secretChange{name: name, action: secretUpdate})
+		default:
+			plan.unchanged++
+		}
+	}
+
+	return plan
+}
+
+func (p secretSyncPlan) count(action string) (n int) {
+	for _, c := range p.changes {
+		if c.action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// values returns the desired values of the changed secrets.
+func (p secretSyncPlan) values(desired map[string]string) map[string]string {
+	values := make(map[string]string, len(p.changes))
+	for _, c := range p.changes {
+		values[c.name] = desired[c.name]
+	}
+	return values
+}
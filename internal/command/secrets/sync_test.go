@@ -0,0 +1,37 @@
+package secrets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	fly "github.com/superfly/fly-go"
+)
+
+func TestPlanSecretSync(t *testing.T) {
+	value := func(s string) *string { return &s }
+
+	current := []fly.AppSecret{
+		{Name: "SAME", Value: value("1")},
+		{Name: "CHANGED", Value: value("old")},
+		{Name: "HIDDEN"},
+		{Name: "UNMANAGED", Value: value("x")},
+	}
+	desired := map[string]string{
+		"SAME":    "1",
+		"CHANGED": "new",
+		"HIDDEN":  "h",
+		"NEW":     "n",
+	}
+
+	plan := planSecretSync(current, desired)
+
+	assert.Equal(t, []secretChange{
+		{name: "CHANGED", action: secretUpdate},
+		{name: "HIDDEN", action: secretUpdate},
+		{name: "NEW", action: secretAdd},
+	}, plan.changes)
+	assert.Equal(t, 1, plan.unchanged)
+	assert.Equal(t, 1, plan.count(secretAdd))
+	assert.Equal(t, 2, plan.count(secretUpdate))
+	assert.Equal(t, map[string]string{"CHANGED": "new", "HIDDEN": "h", "NEW": "n"}, plan.values(desired))
+}
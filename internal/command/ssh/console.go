@@ -83,19 +83,19 @@ func quiet(ctx context.Context) bool {
 	return flag.GetBool(ctx, "quiet")
 }
 
-func lookupAddressAndContainer(ctx context.Context, cli *agent.Client, dialer agent.Dialer, app *fly.AppCompact, console bool) (addr string, container string, err error) {
-	selectedMachine, err := selectMachine(ctx, app)
+func lookupAddressAndContainer(ctx context.Context, cli *agent.Client, dialer agent.Dialer, app *fly.AppCompact, console bool) (addr string, container string, selectedMachine *fly.Machine, err error) {
+	selectedMachine, err = selectMachine(ctx, app)
 	if err != nil {
-		return "", "", err
+		return "", "", nil, err
 	}
 
 	container, err = selectContainer(ctx, selectedMachine)
 	if err != nil {
-		return "", "", err
+		return "", "", nil, err
 	}
 
 	if addr = flag.GetString(ctx, "address"); addr != "" {
-		return addr, container, nil
+		return addr, container, selectedMachine, nil
 	}
 
 	if addr == "" {
@@ -110,7 +110,7 @@ func lookupAddressAndContainer(ctx context.Context, cli *agent.Client, dialer ag
 	if !ip.IsV6(addr) {
 		if err := cli.WaitForDNS(ctx, dialer, app.Organization.Slug, addr, ""); err != nil {
 			captureError(ctx, err, app)
-			return "", "", errors.Wrapf(err, "host unavailable at %s", addr)
+			return "", "", nil, errors.Wrapf(err, "host unavailable at %s", addr)
 		}
 	}
 
@@ -119,8 +119,24 @@ func lookupAddressAndContainer(ctx context.Context, cli *agent.Client, dialer ag
 
 func newConsole() *cobra.Command {
 	const (
-		long  = `Connect to a running instance of the current app.`
-		short = long
+		short = `Connect to a running instance of the current app.`
+		long  = short + `
+
+Use -L and -R to forward ports like OpenSSH does. -L 9229:localhost:9229
+makes port 9229 inside the machine reachable on localhost:9229, and
+-R 8080:localhost:3000 makes local port 3000 reachable inside the machine on
+port 8080. Add -N to only forward ports without starting a shell.
+
+--forward-agent makes the local ssh-agent available inside the machine, for
+example for git over ssh. Unlike OpenSSH, it has no -A shorthand: -A is short
+for --address, and stays that way so existing scripts keep working.
+
+--record saves the session's output as an asciicast v2 file that
+'fly ssh replay' and asciinema can play back; --record-input also records what
+you type, passwords included. Setting ssh_recording_dir in the flyctl config
+file, or FLY_SSH_RECORDING_DIR, records every session to that directory and
+refuses to connect if the recording can't be written. Sessions with -N have no
+terminal to record, so they're refused then too.`
 		usage = "console"
 	)
 
@@ -130,6 +146,36 @@ func newConsole() *cobra.Command {
 
 	stdArgsSSH(cmd)
 
+	flag.Add(cmd,
+		flag.StringArray{
+			Name:        "local-forward",
+			Shorthand:   "L",
+			Description: "Forward a local port to the machine, as [bind_address:]port:host:hostport",
+		},
+		flag.StringArray{
+			Name:        "remote-forward",
+			Shorthand:   "R",
+			Description: "Forward a port on the machine to this host, as [bind_address:]port:host:hostport",
+		},
+		flag.Bool{
+			Name:        "no-shell",
+			Shorthand:   "N",
+			Description: "Don't run a shell or command, only forward ports",
+		},
+		flag.Bool{
+			Name:        "forward-agent",
+			Description: "Forward the local ssh-agent to the machine (-A is --address, not this)",
+		},
+		flag.String{
+			Name:        "record",
+			Description: "Record the session to this asciicast file",
+		},
+		flag.Bool{
+			Name:        "record-input",
+			Description: "Record keyboard input as well as output",
+		},
+	)
+
 	return cmd
 }
 
@@ -176,7 +222,7 @@ func runConsole(ctx context.Context) error {
 		return err
 	}
 
-	addr, container, err := lookupAddressAndContainer(ctx, agentclient, dialer, app, true)
+	addr, container, machine, err := lookupAddressAndContainer(ctx, agentclient, dialer, app, true)
 	if err != nil {
 		return err
 	}
@@ -201,13 +247,39 @@ func runConsole(ctx context.Context) error {
 		Container:      container,
 		AppNames:       []string{app.Name},
 	}
+	forwards, err := parseForwards(ctx)
+	if err != nil {
+		return err
+	}
+
+	noShell := flag.GetBool(ctx, "no-shell")
+	if noShell && len(forwards.local)+len(forwards.remote) == 0 {
+		return errors.New("--no-shell requires at least one -L or -R forward")
+	}
+	if noShell {
+		if err := refuseUnrecordable(ctx, "--no-shell sessions"); err != nil {
+			return err
+		}
+	}
+
 	sshc, err := Connect(params, addr)
 	if err != nil {
 		captureError(ctx, err, app)
 		return err
 	}
 
-	if err := Console(ctx, sshc, cmd, allocPTY, params.Container); err != nil {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := startForwards(ctx, sshc, forwards); err != nil {
+		return err
+	}
+
+	if noShell {
+		return sshc.Wait(ctx)
+	}
+
+	if err := Console(ctx, sshc, app.Name, machine, cmd, allocPTY, params.Container); err != nil {
 		captureError(ctx, err, app)
 		return err
 	}
@@ -215,7 +287,78 @@ func runConsole(ctx context.Context) error {
 	return nil
 }
 
-func Console(ctx context.Context, sshClient *ssh.Client, cmd string, allocPTY bool, container string) error {
+type consoleForwards struct {
+	local, remote []ssh.Forward
+}
+
+func parseForwards(ctx context.Context) (forwards consoleForwards, err error) {
+	for _, spec := range flag.GetStringArray(ctx, "local-forward") {
+		f, err := ssh.ParseForward(spec)
+		if err != nil {
+			return forwards, err
+		}
+		forwards.local = append(forwards.local, f)
+	}
+
+	for _, spec := range flag.GetStringArray(ctx, "remote-forward") {
+		f, err := ssh.ParseForward(spec)
+		if err != nil {
+			return forwards, err
+		}
+		forwards.remote = append(forwards.remote, f)
+	}
+
+	return forwards, nil
+}
+
+// startForwards sets up port and agent forwarding on the connection before
+// the shell starts. Forwards live until the context is cancelled.
+func startForwards(ctx context.Context, sshc *ssh.Client, forwards consoleForwards) error {
+	io := iostreams.FromContext(ctx)
+
+	for _, f := range forwards.local {
+		if err := sshc.ForwardLocal(ctx, f); err != nil {
+			return err
+		}
+		fmt.Fprintf(io.ErrOut, "Forwarding local %s to %s on the machine\n", f.Listen, f.Target)
+	}
+
+	for _, f := range forwards.remote {
+		if err := sshc.ForwardRemote(ctx, f); err != nil {
+			return err
+		}
+		fmt.Fprintf(io.ErrOut, "Forwarding %s on the machine to local %s\n", f.Listen, f.Target)
+	}
+
+	if flag.GetBool(ctx, "forward-agent") {
+		if err := sshc.ForwardAgent(ctx, os.Getenv("SSH_AUTH_SOCK")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Console runs cmd, or a shell, over sshClient on the app's machine, which may
+// be nil if it isn't known. The session is recorded if --record is set or the
+// config makes recording mandatory.
+func Console(ctx context.Context, sshClient *ssh.Client, appName string, machine *fly.Machine, cmd string, allocPTY bool, container string) error {
+	recording, err := startRecording(ctx, appName, machine, cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := console(ctx, sshClient, cmd, allocPTY, container, recording); err != nil {
+		if ferr := recording.finish(ctx); ferr != nil {
+			return fmt.Errorf("%w; %w", err, ferr)
+		}
+		return err
+	}
+
+	return recording.finish(ctx)
+}
+
+func console(ctx context.Context, sshClient *ssh.Client, cmd string, allocPTY bool, container string, recording *sessionRecording) error {
 	currentStdin, currentStdout, currentStderr, err := setupConsole()
 	defer func() error {
 		if err := cleanupConsole(currentStdin, currentStdout, currentStderr); err != nil {
@@ -230,10 +373,12 @@ func Console(ctx context.Context, sshClient *ssh.Client, cmd string, allocPTY bo
 		// Otherwise, virtual terminal emulation provided by the package will break UTF-8 encoding.
 		// If flyctl targets Windows 10+ only then we can avoid using this package at all
 		// because Windows 10+ already provides virtual terminal support.
-		Stdout:   ioutils.NewWriteCloserWrapper(colorable.NewColorableStdout(), func() error { return nil }),
-		Stderr:   ioutils.NewWriteCloserWrapper(colorable.NewColorableStderr(), func() error { return nil }),
-		AllocPTY: allocPTY,
-		TermEnv:  determineTermEnv(),
+		Stdout:      ioutils.NewWriteCloserWrapper(colorable.NewColorableStdout(), func() error { return nil }),
+		Stderr:      ioutils.NewWriteCloserWrapper(colorable.NewColorableStderr(), func() error { return nil }),
+		AllocPTY:    allocPTY,
+		TermEnv:     determineTermEnv(),
+		Recorder:    recording.recorder(),
+		RecordInput: recording.recordInput(),
 	}
 
 	if err := sshClient.Shell(ctx, sessIO, cmd, container); err != nil {
@@ -0,0 +1,313 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/flapsutil"
+	"github.com/superfly/flyctl/internal/flyutil"
+	"github.com/superfly/flyctl/internal/render"
+	"github.com/superfly/flyctl/iostreams"
+	"golang.org/x/sync/errgroup"
+)
+
+const defaultExecConcurrency = 8
+
+func newExec() *cobra.Command {
+	const (
+		short = "Run a command on one or more machines"
+		long  = `Run a command on a machine of the current app, or with --all on every
+started machine, optionally narrowed down with --region, --process-group and
+--metadata. Output lines are prefixed with the machine they came from; use
+--json for a report instead. The command fails if it fails on any machine.`
+		usage = "exec <command>"
+	)
+
+	cmd := command.New(usage, short, long, runExec, command.RequireSession, command.RequireAppName)
+
+	cmd.Args = cobra.ExactArgs(1)
+
+	flag.Add(cmd,
+		flag.App(),
+		flag.AppConfig(),
+		flag.JSONOutput(),
+		flag.Region(),
+		flag.ProcessGroup(""),
+		flag.Bool{
+			Name:        "all",
+			Description: "Run the command on every started machine matching the filters",
+		},
+		flag.String{
+			Name:        "machine",
+			Description: "Run the command on the machine with the specified ID",
+		},
+		flag.Bool{
+			Name:        "select",
+			Shorthand:   "s",
+			Description: "Select the machine to run the command on",
+		},
+		flag.StringArray{
+			Name:        "metadata",
+			Description: "Only run on machines with this metadata, as key=value. Can be repeated",
+		},
+		flag.Int{
+			Name:        "concurrency",
+			Default:     defaultExecConcurrency,
+			Description: "Maximum number of machines to run the command on at once",
+		},
+		flag.Int{
+			Name:        "timeout",
+			Description: "Timeout in seconds",
+		},
+		flag.String{
+			Name:        "container",
+			Description: "Container to run the command in",
+		},
+	)
+
+	return cmd
+}
+
+// execResult is the outcome of running the command on one machine.
+type execResult struct {
+	MachineID    string `json:"machine_id"`
+	Region       string `json:"region"`
+	ProcessGroup string `json:"process_group"`
+	ExitCode     int32  `json:"exit_code"`
+	Stdout       string `json:"stdout"`
+	Stderr       string `json:"stderr"`
+	Error        string `json:"error,omitempty"`
+}
+
+func (r *execResult) failed() bool {
+	return r.Error != "" || r.ExitCode != 0
+}
+
+func runExec(ctx context.Context) error {
+	var (
+		io      = iostreams.FromContext(ctx)
+		client  = flyutil.ClientFromContext(ctx)
+		appName = appconfig.NameFromContext(ctx)
+		jsonOut = config.FromContext(ctx).JSONOutput
+	)
+
+	if flag.GetBool(ctx, "all") && (flag.IsSpecified(ctx, "machine") || flag.GetBool(ctx, "select")) {
+		return errors.New("--all can't be used with --machine or --select")
+	}
+
+	metadata, err := parseMetadataFilters(flag.GetStringArray(ctx, "metadata"))
+	if err != nil {
+		return err
+	}
+
+	concurrency := flag.GetInt(ctx, "concurrency")
+	if concurrency < 1 {
+		return errors.New("--concurrency must be at least 1")
+	}
+
+	app, err := client.GetAppCompact(ctx, appName)
+	if err != nil {
+		return fmt.Errorf("get app: %w", err)
+	}
+
+	var machines []*fly.Machine
+	if flag.GetBool(ctx, "all") {
+		active, err := flapsutil.ClientFromContext(ctx).ListActive(ctx, app.Name)
+		if err != nil {
+			return err
+		}
+
+		machines = filterExecMachines(active, flag.GetRegion(ctx), flag.GetProcessGroup(ctx), metadata)
+		if len(machines) == 0 {
+			return fmt.Errorf("no started machines in %s match the filters", app.Name)
+		}
+	} else {
+		if len(metadata) > 0 {
+			return errors.New("--metadata requires --all")
+		}
+
+		machine, err := selectMachine(ctx, app)
+		if err != nil {
+			return err
+		}
+		machines = []*fly.Machine{machine}
+	}
+
+	req := &fly.MachineExecRequest{
+		Cmd:       flag.FirstArg(ctx),
+		Timeout:   flag.GetInt(ctx, "timeout"),
+		Container: flag.GetString(ctx, "container"),
+	}
+
+	results, err := execOnMachines(ctx, io, machines, concurrency, !jsonOut, func(ctx context.Context, machine *fly.Machine) *execResult {
+		return execOnMachine(ctx, app.Name, machine, req)
+	})
+	if err != nil {
+		return err
+	}
+
+	if jsonOut {
+		if err := render.JSON(io.Out, results); err != nil {
+			return err
+		}
+	}
+
+	return execStatus(results)
+}
+
+// execOnMachines runs exec on machines, at most concurrency at a time, and
+// returns the results in the order of machines. Unless print is false, each
+// result is printed as soon as it's in, prefixed with its machine when there
+// are several.
+func execOnMachines(ctx context.Context, io *iostreams.IOStreams, machines []*fly.Machine, concurrency int, print bool, exec func(context.Context, *fly.Machine) *execResult) ([]*execResult, error) {
+	var (
+		mu      sync.Mutex
+		results = make([]*execResult, len(machines))
+		prefix  = len(machines) > 1
+	)
+
+	eg, ectx := errgroup.WithContext(ctx)
+	eg.SetLimit(concurrency)
+
+	for i, machine := range machines {
+		eg.Go(func() error {
+			// machines still waiting for their turn when the command is
+			// interrupted aren't run on at all
+			if err := ectx.Err(); err != nil {
+				return err
+			}
+
+			result := exec(ectx, machine)
+			results[i] = result
+
+			if print {
+				mu.Lock()
+				printExecResult(io, result, prefix)
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// execStatus sums results up into the error the command fails with, if any.
+func execStatus(results []*execResult) error {
+	failed := lo.CountBy(results, func(r *execResult) bool { return r.failed() })
+
+	switch {
+	case failed == 0:
+		return nil
+	case len(results) == 1 && results[0].Error != "":
+		return errors.New(results[0].Error)
+	case len(results) == 1:
+		return fmt.Errorf("command exited with code %d", results[0].ExitCode)
+	default:
+		return fmt.Errorf("command failed on %d of %d machines", failed, len(results))
+	}
+}
+
+func execOnMachine(ctx context.Context, appName string, machine *fly.Machine, req *fly.MachineExecRequest) *execResult {
+	result := &execResult{
+		MachineID:    machine.ID,
+		Region:       machine.Region,
+		ProcessGroup: machine.ProcessGroup(),
+	}
+
+	out, err := flapsutil.ClientFromContext(ctx).Exec(ctx, appName, machine.ID, req)
+	if err != nil {
+		result.Error = fmt.Sprintf("could not exec command on machine %s: %v", machine.ID, err)
+		return result
+	}
+
+	result.ExitCode = out.ExitCode
+	result.Stdout = out.StdOut
+	result.Stderr = out.StdErr
+
+	return result
+}
+
+func printExecResult(io *iostreams.IOStreams, r *execResult, prefix bool) {
+	var p string
+	if prefix {
+		p = fmt.Sprintf("%s %s | ", r.MachineID, r.Region)
+	}
+
+	writePrefixed(io.Out, p, r.Stdout)
+	writePrefixed(io.ErrOut, p, r.Stderr)
+
+	switch {
+	case r.Error != "":
+		fmt.Fprintf(io.ErrOut, "%s%s\n", p, r.Error)
+	case r.ExitCode != 0:
+		fmt.Fprintf(io.ErrOut, "%sExit code: %d\n", p, r.ExitCode)
+	}
+}
+
+func writePrefixed(w io.Writer, prefix, s string) {
+	if s == "" {
+		return
+	}
+
+	if prefix == "" {
+		fmt.Fprint(w, s)
+		return
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		fmt.Fprintf(w, "%s%s\n", prefix, line)
+	}
+}
+
+// parseMetadataFilters parses key=value pairs from --metadata.
+func parseMetadataFilters(pairs []string) (map[string]string, error) {
+	filters := make(map[string]string, len(pairs))
+
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --metadata %q, expected key=value", pair)
+		}
+		filters[key] = value
+	}
+
+	return filters, nil
+}
+
+// filterExecMachines keeps the started machines matching the region, process
+// group and metadata filters. Empty filters match everything.
+func filterExecMachines(machines []*fly.Machine, region, group string, metadata map[string]string) []*fly.Machine {
+	return lo.Filter(machines, func(m *fly.Machine, _ int) bool {
+		if m.State != "started" {
+			return false
+		}
+		if region != "" && m.Region != region {
+			return false
+		}
+		if group != "" && m.ProcessGroup() != group {
+			return false
+		}
+		for key, value := range metadata {
+			if m.Config == nil || m.Config.Metadata[key] != value {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,131 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+func TestFilterExecMachines(t *testing.T) {
+	machine := func(id, state, region, group, role string) *fly.Machine {
+		return &fly.Machine{
+			ID:     id,
+			State:  state,
+			Region: region,
+			Config: &fly.MachineConfig{
+				Metadata: map[string]string{fly.MachineConfigMetadataKeyFlyProcessGroup: group, "role": role},
+			},
+		}
+	}
+
+	machines := []*fly.Machine{
+		machine("a", "started", "iad", "app", "primary"),
+		machine("b", "started", "ord", "app", "replica"),
+		machine("c", "started", "iad", "worker", "replica"),
+		machine("d", "stopped", "iad", "app", "replica"),
+	}
+
+	ids := func(ms []*fly.Machine) []string {
+		return lo.Map(ms, func(m *fly.Machine, _ int) string { return m.ID })
+	}
+
+	assert.Equal(t, []string{"a", "b", "c"}, ids(filterExecMachines(machines, "", "", nil)))
+	assert.Equal(t, []string{"a", "c"}, ids(filterExecMachines(machines, "iad", "", nil)))
+	assert.Equal(t, []string{"a", "b"}, ids(filterExecMachines(machines, "", "app", nil)))
+	assert.Equal(t, []string{"b", "c"}, ids(filterExecMachines(machines, "", "", map[string]string{"role": "replica"})))
+	assert.Equal(t, []string{"c"}, ids(filterExecMachines(machines, "iad", "", map[string]string{"role": "replica"})))
+}
+
+func TestParseMetadataFilters(t *testing.T) {
+	filters, err := parseMetadataFilters([]string{"role=primary", "tier="})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"role": "primary", "tier": ""}, filters)
+
+	_, err = parseMetadataFilters([]string{"role"})
+	assert.Error(t, err)
+	_, err = parseMetadataFilters([]string{"=primary"})
+	assert.Error(t, err)
+}
+
+func TestWritePrefixed(t *testing.T) {
+	var buf bytes.Buffer
+	writePrefixed(&buf, "abc iad | ", "one\ntwo\n")
+	assert.Equal(t, "abc iad | one\nabc iad | two\n", buf.String())
+
+	buf.Reset()
+	writePrefixed(&buf, "", "one\ntwo")
+	assert.Equal(t, "one\ntwo", buf.String())
+}
+
+func TestExecOnMachines(t *testing.T) {
+	machines := []*fly.Machine{
+		{ID: "a", Region: "iad"},
+		{ID: "b", Region: "ord"},
+		{ID: "c", Region: "syd"},
+	}
+
+	// a fake session per machine: b exits with 3, c can't be reached
+	var running, most atomic.Int32
+	fake := func(ctx context.Context, m *fly.Machine) *execResult {
+		defer running.Add(-1)
+		if n := running.Add(1); n > most.Load() {
+			most.Store(n)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		r := &execResult{MachineID: m.ID, Region: m.Region}
+		switch m.ID {
+		case "a":
+			r.Stdout = "one\ntwo\n"
+		case "b":
+			r.Stderr = "oops\n"
+			r.ExitCode = 3
+		case "c":
+			r.Error = "could not exec command on machine c: unreachable"
+		}
+		return r
+	}
+
+	ios, _, stdout, stderr := iostreams.Test()
+	results, err := execOnMachines(context.Background(), ios, machines, 2, true, fake)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a", "b", "c"}, lo.Map(results, func(r *execResult, _ int) string { return r.MachineID }))
+	assert.LessOrEqual(t, most.Load(), int32(2))
+
+	assert.Equal(t, "a iad | one\na iad | two\n", stdout.String())
+	assert.Contains(t, stderr.String(), "b ord | oops\n")
+	assert.Contains(t, stderr.String(), "b ord | Exit code: 3\n")
+	assert.Contains(t, stderr.String(), "c syd | could not exec command on machine c: unreachable\n")
+
+	assert.EqualError(t, execStatus(results), "command failed on 2 of 3 machines")
+	assert.NoError(t, execStatus(results[:1]))
+	assert.EqualError(t, execStatus(results[1:2]), "command exited with code 3")
+	assert.EqualError(t, execStatus(results[2:]), "could not exec command on machine c: unreachable")
+
+	// a single machine's output isn't prefixed, and nothing's printed for --json
+	ios, _, stdout, _ = iostreams.Test()
+	_, err = execOnMachines(context.Background(), ios, machines[:1], 2, true, fake)
+	require.NoError(t, err)
+	assert.Equal(t, "one\ntwo\n", stdout.String())
+
+	ios, _, stdout, stderr = iostreams.Test()
+	_, err = execOnMachines(context.Background(), ios, machines, 2, false, fake)
+	require.NoError(t, err)
+	assert.Empty(t, stdout.String())
+	assert.Empty(t, stderr.String())
+
+	// an interrupted command fails rather than report a partial result
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = execOnMachines(ctx, ios, machines, 1, false, fake)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,226 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/iostreams"
+	"github.com/superfly/flyctl/ssh"
+	"github.com/superfly/flyctl/terminal"
+)
+
+// sessionRecording is a console session being recorded to a file. A nil
+// sessionRecording records nothing.
+type sessionRecording struct {
+	path      string
+	file      *os.File
+	rec       *ssh.Recorder
+	mandatory bool
+	input     bool
+}
+
+// startRecording opens the recording for a console session, if --record is
+// set or the config makes recording mandatory. Only output is recorded,
+// unless --record-input is set. machine may be nil.
+func startRecording(ctx context.Context, appName string, machine *fly.Machine, cmd string) (*sessionRecording, error) {
+	var (
+		path      = flag.GetString(ctx, "record")
+		dir       = config.FromContext(ctx).SSHRecordingDir
+		mandatory = dir != ""
+	)
+
+	if path == "" && !mandatory {
+		return nil, nil
+	}
+
+	var machineID, region string
+	if machine != nil {
+		machineID, region = machine.ID, machine.Region
+	}
+
+	if path == "" {
+		path = filepath.Join(dir, recordingFileName(appName, machineID, time.Now()))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create recording directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("create session recording: %w", err)
+	}
+
+	header := ssh.CastHeader{
+		Command: cmd,
+		Title:   strings.TrimSpace(fmt.Sprintf("fly ssh console: %s %s", appName, machineID)),
+		Env:     map[string]string{"TERM": determineTermEnv()},
+		Fly: &ssh.CastMetadata{
+			User:    recordingUser(ctx),
+			App:     appName,
+			Machine: machineID,
+			Region:  region,
+		},
+	}
+
+	terminal.Debugf("Recording session to %s\n", path)
+
+	return &sessionRecording{
+		path:      path,
+		file:      f,
+		rec:       ssh.NewRecorder(f, header),
+		mandatory: mandatory,
+		input:     flag.GetBool(ctx, "record-input"),
+	}, nil
+}
+
+func recordingFileName(appName, machineID string, at time.Time) string {
+	stamp := at.UTC().Format("20060102T150405Z")
+	if machineID == "" {
+		return fmt.Sprintf("%s-%s.cast", appName, stamp)
+	}
+	return fmt.Sprintf("%s-%s-%s.cast", appName, machineID, stamp)
+}
+
+// recordingUser identifies who opened the session the way the audit log does,
+// so recording doesn't cost an API call.
+func recordingUser(ctx context.Context) string {
+	if user := command.Identity(config.FromContext(ctx)); user != "" {
+		return user
+	}
+	return "unknown"
+}
+
+// refuseUnrecordable fails sessions that can't be recorded, such as ones that
+// only forward ports, when --record is set or the config makes recording
+// mandatory.
+func refuseUnrecordable(ctx context.Context, what string) error {
+	switch {
+	case flag.GetString(ctx, "record") != "":
+		return fmt.Errorf("%s can't be recorded, drop --record", what)
+	case config.FromContext(ctx).SSHRecordingDir != "":
+		return fmt.Errorf("%s can't be recorded, and ssh_recording_dir makes recording mandatory", what)
+	default:
+		return nil
+	}
+}
+
+func (r *sessionRecording) recorder() *ssh.Recorder {
+	if r == nil {
+		return nil
+	}
+
+	return r.rec
+}
+
+func (r *sessionRecording) recordInput() bool {
+	return r != nil && r.input
+}
+
+// finish closes the recording. Failing to write a mandatory recording is an
+// error; otherwise it's only a warning.
+func (r *sessionRecording) finish(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	io := iostreams.FromContext(ctx)
+
+	err := r.rec.Err()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+
+	switch {
+	case err == nil:
+		fmt.Fprintf(io.ErrOut, "Session recorded to %s\n", r.path)
+		return nil
+	case r.mandatory:
+		return fmt.Errorf("session recording %s is incomplete: %w", r.path, err)
+	default:
+		fmt.Fprintf(io.ErrOut, "Warning: session recording %s is incomplete: %v\n", r.path, err)
+		return nil
+	}
+}
+
+func newReplay() *cobra.Command {
+	const (
+		short = "Play back a recorded SSH session"
+		long  = short + `, as recorded by 'fly ssh console --record' or to the
+configured ssh_recording_dir.
+`
+		usage = "replay <file>"
+	)
+
+	cmd := command.New(usage, short, long, runReplay)
+
+	cmd.Args = cobra.ExactArgs(1)
+
+	flag.Add(cmd,
+		flag.Float64{
+			Name:        "speed",
+			Default:     1,
+			Description: "Playback speed multiplier",
+		},
+		flag.Duration{
+			Name:        "idle-limit",
+			Description: "Shorten pauses longer than this, e.g. 2s",
+		},
+		flag.Bool{
+			Name:        "info",
+			Description: "Only print who recorded the session, where and when",
+		},
+	)
+
+	return cmd
+}
+
+func runReplay(ctx context.Context) error {
+	io := iostreams.FromContext(ctx)
+
+	f, err := os.Open(flag.FirstArg(ctx))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	header, err := ssh.ReadCastHeader(r)
+	if err != nil {
+		return err
+	}
+
+	if flag.GetBool(ctx, "info") {
+		printRecordingInfo(io, header)
+		return nil
+	}
+
+	return ssh.Replay(ctx, io.Out, r, ssh.ReplayOptions{
+		Speed:     flag.GetFloat64(ctx, "speed"),
+		IdleLimit: flag.GetDuration(ctx, "idle-limit"),
+	})
+}
+
+func printRecordingInfo(io *iostreams.IOStreams, header *ssh.CastHeader) {
+	fmt.Fprintf(io.Out, "Recorded:  %s\n", time.Unix(header.Timestamp, 0).Format(time.RFC3339))
+	if header.Fly != nil {
+		fmt.Fprintf(io.Out, "User:      %s\n", header.Fly.User)
+		fmt.Fprintf(io.Out, "App:       %s\n", header.Fly.App)
+		fmt.Fprintf(io.Out, "Machine:   %s (%s)\n", header.Fly.Machine, header.Fly.Region)
+	}
+	if header.Command != "" {
+		fmt.Fprintf(io.Out, "Command:   %s\n", header.Command)
+	}
+	fmt.Fprintf(io.Out, "Terminal:  %dx%d\n", header.Width, header.Height)
+}
@@ -39,6 +39,7 @@ func NewSFTP() *cobra.Command {
 		newSFTPShell(),
 		newGet(),
 		newPut(),
+		newSync(),
 	)
 
 	return cmd
@@ -129,27 +130,34 @@ func newPut() *cobra.Command {
 }
 
 func newSFTPConnection(ctx context.Context) (*sftp.Client, error) {
+	ftp, _, err := newSFTPSession(ctx)
+	return ftp, err
+}
+
+// newSFTPSession returns an SFTP connection and a function that runs commands
+// on the same machine over the same SSH connection.
+func newSFTPSession(ctx context.Context) (*sftp.Client, func(cmd string) ([]byte, error), error) {
 	client := flyutil.ClientFromContext(ctx)
 	appName := appconfig.NameFromContext(ctx)
 
 	app, err := client.GetAppCompact(ctx, appName)
 	if err != nil {
-		return nil, fmt.Errorf("get app: %w", err)
+		return nil, nil, fmt.Errorf("get app: %w", err)
 	}
 
 	network, err := client.GetAppNetwork(ctx, appName)
 	if err != nil {
-		return nil, fmt.Errorf("get app network: %w", err)
+		return nil, nil, fmt.Errorf("get app network: %w", err)
 	}
 
 	agentclient, dialer, err := agent.BringUpAgent(ctx, client, app, *network, quiet(ctx))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	addr, container, err := lookupAddressAndContainer(ctx, agentclient, dialer, app, false)
+	addr, container, _, err := lookupAddressAndContainer(ctx, agentclient, dialer, app, false)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	params := &ConnectParams{
@@ -165,13 +173,34 @@ func newSFTPConnection(ctx context.Context) (*sftp.Client, error) {
 	conn, err := Connect(params, addr)
 	if err != nil {
 		captureError(ctx, err, app)
-		return nil, err
+		return nil, nil, err
 	}
 
-	return sftp.NewClient(conn.Client,
+	ftp, err := sftp.NewClient(conn.Client,
 		sftp.UseConcurrentReads(true),
 		sftp.UseConcurrentWrites(true),
 	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	output := func(cmd string) ([]byte, error) {
+		sess, err := conn.Client.NewSession()
+		if err != nil {
+			return nil, err
+		}
+		defer sess.Close()
+
+		if container != "" {
+			if err := sess.Setenv("FLY_SSH_CONTAINER", container); err != nil {
+				return nil, err
+			}
+		}
+
+		return sess.Output(cmd)
+	}
+
+	return ftp, output, nil
 }
 
 func runLs(ctx context.Context) error {
@@ -0,0 +1,648 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/spf13/cobra"
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+// partialSuffix marks files that are still being uploaded. An interrupted
+// sync leaves them behind so the next run can pick up where it stopped.
+const partialSuffix = ".flyctl-partial"
+
+// sourceSuffix marks the file next to a partial file that records the size
+// and modification time of the file being uploaded, so that a partial upload
+// of a file that changed since isn't resumed.
+const sourceSuffix = partialSuffix + "-source"
+
+func newSync() *cobra.Command {
+	const (
+		short = "Sync a local directory to a remote VM"
+		long  = `The SFTP SYNC command uploads the files in a local directory that are
+missing or different on the remote VM, leaving unchanged files alone.
+
+Files are compared by size and modification time, or by content with
+--checksum. Interrupted uploads of large files are resumed on the next sync,
+unless the file changed since.
+--include and --exclude take glob patterns matched against the path relative
+to the directory and against the file name; excluded directories are skipped
+entirely.`
+		usage = "sync <local-dir> <remote-dir>"
+	)
+
+	cmd := command.New(usage, short, long, runSync, command.RequireSession, command.RequireAppName)
+
+	cmd.Args = cobra.ExactArgs(2)
+
+	flag.Add(cmd,
+		flag.Bool{
+			Name:        "checksum",
+			Description: "Compare files by content instead of size and modification time",
+		},
+		flag.Bool{
+			Name:        "delete",
+			Description: "Delete remote files that don't exist locally",
+		},
+		flag.StringArray{
+			Name:        "include",
+			Description: "Only sync files matching this glob. Can be repeated",
+		},
+		flag.StringArray{
+			Name:        "exclude",
+			Description: "Skip files and directories matching this glob. Can be repeated",
+		},
+		flag.Bool{
+			Name:        "dry-run",
+			Shorthand:   "n",
+			Description: "Show what would be transferred and deleted without changing anything",
+		},
+	)
+
+	stdArgsSSH(cmd)
+
+	return cmd
+}
+
+func runSync(ctx context.Context) error {
+	args := flag.Args(ctx)
+
+	info, err := os.Stat(args[0])
+	if err != nil {
+		return fmt.Errorf("sync: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("sync: %s is not a directory; use 'fly ssh sftp put' for single files", args[0])
+	}
+
+	ftp, output, err := newSFTPSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer ftp.Close()
+
+	s := &syncer{
+		ftp:      ftp,
+		output:   output,
+		out:      iostreams.FromContext(ctx).Out,
+		checksum: flag.GetBool(ctx, "checksum"),
+		delete:   flag.GetBool(ctx, "delete"),
+		dryRun:   flag.GetBool(ctx, "dry-run"),
+		include:  flag.GetStringArray(ctx, "include"),
+		exclude:  flag.GetStringArray(ctx, "exclude"),
+	}
+
+	return s.sync(ctx, args[0], args[1])
+}
+
+type syncer struct {
+	ftp *sftp.Client
+	out io.Writer
+
+	// output, if set, runs a command on the machine and returns its output.
+	// Remote files are hashed with it rather than read back over SFTP.
+	output func(cmd string) ([]byte, error)
+
+	checksum, delete, dryRun bool
+	include, exclude         []string
+
+	uploaded, skipped, resumed, deleted int
+	bytes                               int64
+}
+
+// syncEntry is a file or directory found under the local or remote root,
+// keyed by its slash separated path relative to the root.
+type syncEntry struct {
+	rel  string
+	info fs.FileInfo
+}
+
+func (s *syncer) sync(ctx context.Context, localDir, remoteDir string) error {
+	for _, pattern := range append(slices.Clone(s.include), s.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	local, err := s.walkLocal(localDir)
+	if err != nil {
+		return err
+	}
+
+	remote, err := s.walkRemote(remoteDir)
+	if err != nil {
+		return err
+	}
+
+	if !s.dryRun {
+		if err := s.ftp.MkdirAll(remoteDir); err != nil {
+			return fmt.Errorf("create remote directory %s: %w", remoteDir, err)
+		}
+	}
+
+	for _, e := range local {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		remotePath := path.Join(remoteDir, e.rel)
+		r, exists := remote[e.rel]
+
+		if e.info.IsDir() {
+			if exists && r.info.IsDir() {
+				continue
+			}
+			if exists {
+				return fmt.Errorf("remote %s is a file, but %s is a directory locally", remotePath, e.rel)
+			}
+			fmt.Fprintf(s.out, "created directory %s\n", remotePath)
+			if !s.dryRun {
+				if err := s.ftp.MkdirAll(remotePath); err != nil {
+					return fmt.Errorf("create remote directory %s: %w", remotePath, err)
+				}
+			}
+			continue
+		}
+
+		localPath := filepath.Join(localDir, filepath.FromSlash(e.rel))
+
+		if exists {
+			if r.info.IsDir() {
+				return fmt.Errorf("remote %s is a directory, but %s is a file locally", remotePath, e.rel)
+			}
+
+			same, err := s.same(localPath, remotePath, e.info, r.info)
+			if err != nil {
+				return err
+			}
+			if same {
+				s.skipped++
+				continue
+			}
+		}
+
+		if err := s.upload(localPath, remotePath, e.info); err != nil {
+			return err
+		}
+	}
+
+	if s.delete {
+		if err := s.deleteExtraneous(remoteDir, local, remote); err != nil {
+			return err
+		}
+	}
+
+	verb := "synced"
+	if s.dryRun {
+		verb = "would be synced (dry run)"
+	}
+	fmt.Fprintf(s.out, "%d files %s (%d bytes, %d resumed), %d unchanged, %d deleted\n",
+		s.uploaded, verb, s.bytes, s.resumed, s.skipped, s.deleted)
+
+	return nil
+}
+
+// walkLocal lists the local directory in lexical order, applying the include
+// and exclude patterns.
+func (s *syncer) walkLocal(root string) ([]syncEntry, error) {
+	var entries []syncEntry
+
+	err := filepath.Walk(root, func(p string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("walk local directory: %w", err)
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case s.excluded(rel):
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		case !info.IsDir() && !info.Mode().IsRegular():
+			// sockets, devices and symlinks aren't synced
+			return nil
+		case !info.IsDir() && !s.included(rel):
+			return nil
+		}
+
+		entries = append(entries, syncEntry{rel: rel, info: info})
+		return nil
+	})
+
+	return entries, err
+}
+
+// walkRemote lists the remote directory, which may not exist yet.
+func (s *syncer) walkRemote(root string) (map[string]syncEntry, error) {
+	entries := map[string]syncEntry{}
+
+	info, err := s.ftp.Stat(root)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return entries, nil
+	case err != nil:
+		return nil, fmt.Errorf("stat remote directory %s: %w", root, err)
+	case !info.IsDir():
+		return nil, fmt.Errorf("remote %s is not a directory", root)
+	}
+
+	walker := s.ftp.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, fmt.Errorf("walk remote directory: %w", err)
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), root), "/")
+		if rel == "" {
+			continue
+		}
+
+		entries[rel] = syncEntry{rel: rel, info: walker.Stat()}
+	}
+
+	return entries, nil
+}
+
+func (s *syncer) excluded(rel string) bool {
+	return matchAny(s.exclude, rel)
+}
+
+func (s *syncer) included(rel string) bool {
+	return len(s.include) == 0 || matchAny(s.include, rel)
+}
+
+// matchAny matches patterns against the whole relative path and against the
+// base name, so "*.log" matches logs in any directory.
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// same reports whether the remote file is up to date.
+func (s *syncer) same(localPath, remotePath string, local, remote fs.FileInfo) (bool, error) {
+	if local.Size() != remote.Size() {
+		return false, nil
+	}
+
+	if !s.checksum {
+		// SFTP only keeps whole seconds
+		return local.ModTime().Unix() == remote.ModTime().Unix(), nil
+	}
+
+	localSum, err := localChecksum(localPath, -1)
+	if err != nil {
+		return false, err
+	}
+
+	remoteSum, err := s.remoteChecksum(remotePath, -1)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(localSum, remoteSum), nil
+}
+
+// upload copies the file through a partial file next to its destination,
+// resuming from an earlier partial upload when there is one.
+func (s *syncer) upload(localPath, remotePath string, info fs.FileInfo) error {
+	partial := remotePath + partialSuffix
+
+	offset, err := s.resumeOffset(localPath, remotePath, info)
+	if err != nil {
+		return err
+	}
+
+	if offset > 0 {
+		fmt.Fprintf(s.out, "resuming %s at %d of %d bytes\n", remotePath, offset, info.Size())
+		s.resumed++
+	} else {
+		fmt.Fprintf(s.out, "uploading %s (%d bytes)\n", remotePath, info.Size())
+	}
+
+	s.uploaded++
+	s.bytes += info.Size() - offset
+
+	if s.dryRun {
+		return nil
+	}
+
+	if err := s.ftp.MkdirAll(path.Dir(remotePath)); err != nil {
+		return fmt.Errorf("create remote directory %s: %w", path.Dir(remotePath), err)
+	}
+
+	lf, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("open local file %s: %w", localPath, err)
+	}
+	defer lf.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+
+		if err := s.writeSource(remotePath, info); err != nil {
+			return err
+		}
+	}
+
+	rf, err := s.ftp.OpenFile(partial, flags)
+	if err != nil {
+		return fmt.Errorf("create remote file %s: %w", partial, err)
+	}
+
+	if offset > 0 {
+		if _, err := lf.Seek(offset, io.SeekStart); err != nil {
+			rf.Close()
+			return err
+		}
+		if _, err := rf.Seek(offset, io.SeekStart); err != nil {
+			rf.Close()
+			return err
+		}
+	}
+
+	if n, err := rf.ReadFrom(lf); err != nil {
+		rf.Close()
+		return fmt.Errorf("copy file %s: %w (%d bytes written, rerun sync to resume)", localPath, err, n)
+	}
+
+	if err := rf.Close(); err != nil {
+		return fmt.Errorf("close remote file %s: %w", partial, err)
+	}
+
+	if err := s.ftp.Chmod(partial, info.Mode().Perm()); err != nil {
+		fmt.Fprintf(s.out, "warning: set permissions for %s: %s\n", remotePath, err)
+	}
+
+	if err := s.ftp.Chtimes(partial, time.Now(), info.ModTime()); err != nil {
+		return fmt.Errorf("set modification time of %s: %w", remotePath, err)
+	}
+
+	if err := s.ftp.PosixRename(partial, remotePath); err != nil {
+		return fmt.Errorf("rename %s: %w", partial, err)
+	}
+
+	_ = s.ftp.Remove(remotePath + sourceSuffix)
+
+	return nil
+}
+
+// sourceStamp identifies the version of a local file being uploaded.
+func sourceStamp(info fs.FileInfo) string {
+	return fmt.Sprintf("%d %d\n", info.Size(), info.ModTime().Unix())
+}
+
+func (s *syncer) writeSource(remotePath string, info fs.FileInfo) error {
+	p := remotePath + sourceSuffix
+
+	f, err := s.ftp.Create(p)
+	if err != nil {
+		return fmt.Errorf("create remote file %s: %w", p, err)
+	}
+
+	if _, err := io.WriteString(f, sourceStamp(info)); err != nil {
+		f.Close()
+		return fmt.Errorf("write remote file %s: %w", p, err)
+	}
+
+	return f.Close()
+}
+
+// sameSource reports whether the partial upload of remotePath is of the local
+// file as it is now.
+func (s *syncer) sameSource(remotePath string, info fs.FileInfo) bool {
+	f, err := s.ftp.Open(remotePath + sourceSuffix)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(io.LimitReader(f, 64))
+	return err == nil && string(b) == sourceStamp(info)
+}
+
+// resumeOffset returns how much of a partial upload can be kept. Partial
+// uploads of a different version of the file, by size and modification time,
+// are started over, and with --checksum so are those whose content doesn't
+// match the start of the local file.
+func (s *syncer) resumeOffset(localPath, remotePath string, source fs.FileInfo) (int64, error) {
+	partial := remotePath + partialSuffix
+
+	info, err := s.ftp.Stat(partial)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return 0, nil
+	case err != nil:
+		return 0, fmt.Errorf("stat %s: %w", partial, err)
+	case info.Size() > source.Size(), !s.sameSource(remotePath, source):
+		return 0, nil
+	}
+
+	if s.checksum {
+		localSum, err := localChecksum(localPath, info.Size())
+		if err != nil {
+			return 0, err
+		}
+
+		remoteSum, err := s.remoteChecksum(partial, info.Size())
+		if err != nil {
+			return 0, err
+		}
+
+		if !bytes.Equal(localSum, remoteSum) {
+			return 0, nil
+		}
+	}
+
+	return info.Size(), nil
+}
+
+// deleteExtraneous removes remote files and directories that aren't in the
+// local directory. Excluded paths are left alone.
+func (s *syncer) deleteExtraneous(remoteDir string, local []syncEntry, remote map[string]syncEntry) error {
+	keep := make(map[string]bool, len(local))
+	for _, e := range local {
+		keep[e.rel] = true
+	}
+
+	var extraneous []string
+	for rel := range remote {
+		if keep[rel] || isPartial(rel) || s.excluded(rel) || s.inExcludedDir(rel) {
+			continue
+		}
+		if !remote[rel].info.IsDir() && !s.included(rel) {
+			continue
+		}
+		extraneous = append(extraneous, rel)
+	}
+
+	// deepest first, so directories are empty by the time they're removed
+	sort.Sort(sort.Reverse(sort.StringSlice(extraneous)))
+
+	for _, rel := range extraneous {
+		remotePath := path.Join(remoteDir, rel)
+
+		if remote[rel].info.IsDir() && s.hasKeptChild(rel, remote, keep) {
+			continue
+		}
+
+		fmt.Fprintf(s.out, "deleting %s\n", remotePath)
+		s.deleted++
+
+		if s.dryRun {
+			continue
+		}
+
+		var err error
+		if remote[rel].info.IsDir() {
+			err = s.ftp.RemoveDirectory(remotePath)
+		} else {
+			err = s.ftp.Remove(remotePath)
+		}
+		if err != nil {
+			return fmt.Errorf("delete %s: %w", remotePath, err)
+		}
+	}
+
+	return nil
+}
+
+// isPartial reports whether rel belongs to an interrupted upload.
+func isPartial(rel string) bool {
+	return strings.HasSuffix(rel, partialSuffix) || strings.HasSuffix(rel, sourceSuffix)
+}
+
+func (s *syncer) inExcludedDir(rel string) bool {
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if s.excluded(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasKeptChild reports whether an extraneous directory still holds something
+// that isn't being deleted, like excluded files.
+func (s *syncer) hasKeptChild(dir string, remote map[string]syncEntry, keep map[string]bool) bool {
+	for rel, e := range remote {
+		if !strings.HasPrefix(rel, dir+"/") {
+			continue
+		}
+		if keep[rel] || isPartial(rel) || s.excluded(rel) || s.inExcludedDir(rel) {
+			return true
+		}
+		if !e.info.IsDir() && !s.included(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// localChecksum hashes the first n bytes of the file, or all of it if n < 0.
+func localChecksum(p string, n int64) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return checksum(f, n)
+}
+
+// remoteChecksum hashes the first n bytes of the remote file, or all of it if
+// n < 0. It runs sha256sum on the machine when it can, and only reads the file
+// back over SFTP when that fails.
+func (s *syncer) remoteChecksum(p string, n int64) ([]byte, error) {
+	if s.output != nil {
+		sum, err := s.remoteSHA256(p, n)
+		if err == nil {
+			return sum, nil
+		}
+		// the machine may lack sha256sum, don't try again
+		s.output = nil
+	}
+
+	f, err := s.ftp.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("open remote file %s: %w", p, err)
+	}
+	defer f.Close()
+
+	return checksum(f, n)
+}
+
+func (s *syncer) remoteSHA256(p string, n int64) ([]byte, error) {
+	script := "sha256sum < " + shellQuote(p)
+	if n >= 0 {
+		script = fmt.Sprintf("head -c %d < %s | sha256sum", n, shellQuote(p))
+	}
+
+	// commands run over SSH aren't interpreted by a shell, which the
+	// redirect and pipe need
+	out, err := s.output("sh -c " + shellQuote(script))
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("unexpected sha256sum output %q", out)
+	}
+
+	sum, err := hex.DecodeString(fields[0])
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("unexpected sha256sum output %q", out)
+	}
+	return sum, nil
+}
+
+// shellQuote quotes s as a single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func checksum(r io.Reader, n int64) ([]byte, error) {
+	if n >= 0 {
+		r = io.LimitReader(r, n)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
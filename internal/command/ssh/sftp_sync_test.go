@@ -0,0 +1,258 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/shlex"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSFTPClient serves the local filesystem over an in-process SFTP
+// server.
+func newTestSFTPClient(t *testing.T) *sftp.Client {
+	t.Helper()
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw})
+	require.NoError(t, err)
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(cr, cw)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		// closing the server side first ends the client's receive loop
+		server.Close()
+		client.Close()
+	})
+
+	return client
+}
+
+func writeTestFile(t *testing.T, p, content string, mtime time.Time) {
+	t.Helper()
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+	require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	require.NoError(t, os.Chtimes(p, mtime, mtime))
+}
+
+func readTestFile(t *testing.T, p string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(p)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestSyncUploadsChangedFiles(t *testing.T) {
+	var (
+		local  = t.TempDir()
+		remote = filepath.Join(t.TempDir(), "data")
+		mtime  = time.Now().Add(-time.Hour).Truncate(time.Second)
+		ftp    = newTestSFTPClient(t)
+	)
+
+	writeTestFile(t, filepath.Join(local, "a.txt"), "alpha", mtime)
+	writeTestFile(t, filepath.Join(local, "sub", "b.txt"), "bravo", mtime)
+	writeTestFile(t, filepath.Join(local, "debug.log"), "noise", mtime)
+
+	newSyncer := func() *syncer {
+		return &syncer{ftp: ftp, out: io.Discard, exclude: []string{"*.log"}}
+	}
+
+	s := newSyncer()
+	require.NoError(t, s.sync(context.Background(), local, remote))
+	assert.Equal(t, 2, s.uploaded)
+	assert.Equal(t, "alpha", readTestFile(t, filepath.Join(remote, "a.txt")))
+	assert.Equal(t, "bravo", readTestFile(t, filepath.Join(remote, "sub", "b.txt")))
+	assert.NoFileExists(t, filepath.Join(remote, "debug.log"))
+
+	info, err := os.Stat(filepath.Join(remote, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, mtime.Unix(), info.ModTime().Unix())
+
+	// nothing changed, nothing to upload
+	s = newSyncer()
+	require.NoError(t, s.sync(context.Background(), local, remote))
+	assert.Equal(t, 0, s.uploaded)
+	assert.Equal(t, 2, s.skipped)
+
+	writeTestFile(t, filepath.Join(local, "a.txt"), "alpha2", mtime)
+
+	s = newSyncer()
+	s.dryRun = true
+	require.NoError(t, s.sync(context.Background(), local, remote))
+	assert.Equal(t, 1, s.uploaded)
+	assert.Equal(t, "alpha", readTestFile(t, filepath.Join(remote, "a.txt")))
+
+	s = newSyncer()
+	require.NoError(t, s.sync(context.Background(), local, remote))
+	assert.Equal(t, 1, s.uploaded)
+	assert.Equal(t, "alpha2", readTestFile(t, filepath.Join(remote, "a.txt")))
+}
+
+func TestSyncChecksum(t *testing.T) {
+	var (
+		local  = t.TempDir()
+		remote = t.TempDir()
+		mtime  = time.Now().Add(-time.Hour).Truncate(time.Second)
+		ftp    = newTestSFTPClient(t)
+	)
+
+	// same size and mtime, different content
+	writeTestFile(t, filepath.Join(local, "a.txt"), "alpha", mtime)
+	writeTestFile(t, filepath.Join(remote, "a.txt"), "ALPHA", mtime)
+
+	s := &syncer{ftp: ftp, out: io.Discard}
+	require.NoError(t, s.sync(context.Background(), local, remote))
+	assert.Equal(t, 0, s.uploaded)
+
+	s = &syncer{ftp: ftp, out: io.Discard, checksum: true}
+	require.NoError(t, s.sync(context.Background(), local, remote))
+	assert.Equal(t, 1, s.uploaded)
+	assert.Equal(t, "alpha", readTestFile(t, filepath.Join(remote, "a.txt")))
+}
+
+func TestSyncDelete(t *testing.T) {
+	var (
+		local  = t.TempDir()
+		remote = t.TempDir()
+		mtime  = time.Now().Add(-time.Hour).Truncate(time.Second)
+		ftp    = newTestSFTPClient(t)
+	)
+
+	writeTestFile(t, filepath.Join(local, "keep.txt"), "keep", mtime)
+	writeTestFile(t, filepath.Join(remote, "keep.txt"), "keep", mtime)
+	writeTestFile(t, filepath.Join(remote, "stale.txt"), "stale", mtime)
+	writeTestFile(t, filepath.Join(remote, "old", "stale.txt"), "stale", mtime)
+	writeTestFile(t, filepath.Join(remote, "cache", "x.bin"), "cached", mtime)
+
+	s := &syncer{ftp: ftp, out: io.Discard, delete: true, exclude: []string{"cache"}}
+	require.NoError(t, s.sync(context.Background(), local, remote))
+	assert.Equal(t, 3, s.deleted)
+
+	assert.FileExists(t, filepath.Join(remote, "keep.txt"))
+	assert.NoFileExists(t, filepath.Join(remote, "stale.txt"))
+	assert.NoDirExists(t, filepath.Join(remote, "old"))
+	assert.FileExists(t, filepath.Join(remote, "cache", "x.bin"))
+}
+
+func TestSyncResumesPartialUpload(t *testing.T) {
+	var (
+		local  = t.TempDir()
+		remote = t.TempDir()
+		mtime  = time.Now().Add(-time.Hour).Truncate(time.Second)
+		ftp    = newTestSFTPClient(t)
+	)
+
+	stamp := func(name string) string {
+		info, err := os.Stat(filepath.Join(local, name))
+		require.NoError(t, err)
+		return sourceStamp(info)
+	}
+
+	writeTestFile(t, filepath.Join(local, "big.bin"), "0123456789", mtime)
+	writeTestFile(t, filepath.Join(remote, "big.bin"+partialSuffix), "01234", mtime)
+	writeTestFile(t, filepath.Join(remote, "big.bin"+sourceSuffix), stamp("big.bin"), mtime)
+
+	s := &syncer{ftp: ftp, out: io.Discard, checksum: true}
+	require.NoError(t, s.sync(context.Background(), local, remote))
+	assert.Equal(t, 1, s.resumed)
+	assert.Equal(t, int64(5), s.bytes)
+	assert.Equal(t, "0123456789", readTestFile(t, filepath.Join(remote, "big.bin")))
+	assert.NoFileExists(t, filepath.Join(remote, "big.bin"+partialSuffix))
+	assert.NoFileExists(t, filepath.Join(remote, "big.bin"+sourceSuffix))
+
+	// a partial file that doesn't match the source is started over
+	writeTestFile(t, filepath.Join(local, "other.bin"), "abcdefghij", mtime)
+	writeTestFile(t, filepath.Join(remote, "other.bin"+partialSuffix), "XXXXX", mtime)
+	writeTestFile(t, filepath.Join(remote, "other.bin"+sourceSuffix), stamp("other.bin"), mtime)
+
+	s = &syncer{ftp: ftp, out: io.Discard, checksum: true}
+	require.NoError(t, s.sync(context.Background(), local, remote))
+	assert.Equal(t, 0, s.resumed)
+	assert.Equal(t, "abcdefghij", readTestFile(t, filepath.Join(remote, "other.bin")))
+
+	// so is one of a file that changed since, even without --checksum
+	writeTestFile(t, filepath.Join(local, "edited.bin"), "abcdefghij", mtime)
+	writeTestFile(t, filepath.Join(remote, "edited.bin"+partialSuffix), "XXXXX", mtime)
+	writeTestFile(t, filepath.Join(remote, "edited.bin"+sourceSuffix), stamp("edited.bin"), mtime)
+	writeTestFile(t, filepath.Join(local, "edited.bin"), "abcdefghij", mtime.Add(time.Minute))
+
+	s = &syncer{ftp: ftp, out: io.Discard}
+	require.NoError(t, s.sync(context.Background(), local, remote))
+	assert.Equal(t, 0, s.resumed)
+	assert.Equal(t, "abcdefghij", readTestFile(t, filepath.Join(remote, "edited.bin")))
+}
+
+func TestSyncHashesOnMachine(t *testing.T) {
+	var (
+		local  = t.TempDir()
+		remote = t.TempDir()
+		mtime  = time.Now().Add(-time.Hour).Truncate(time.Second)
+		ftp    = newTestSFTPClient(t)
+	)
+
+	writeTestFile(t, filepath.Join(local, "it's.txt"), "alpha", mtime)
+	writeTestFile(t, filepath.Join(remote, "it's.txt"), "ALPHA", mtime)
+
+	// the test's SFTP server serves the local filesystem, so commands can run
+	// locally. Like commands run over SSH, they're split into words but not
+	// run by a shell.
+	var cmds []string
+	output := func(cmd string) ([]byte, error) {
+		cmds = append(cmds, cmd)
+		args, err := shlex.Split(cmd)
+		if err != nil {
+			return nil, err
+		}
+		return exec.Command(args[0], args[1:]...).Output()
+	}
+
+	s := &syncer{ftp: ftp, out: io.Discard, checksum: true, output: output}
+	require.NoError(t, s.sync(context.Background(), local, remote))
+	assert.Equal(t, 1, s.uploaded)
+	assert.Len(t, cmds, 1)
+	assert.NotNil(t, s.output, "hashed on the machine rather than read back over SFTP")
+	assert.Equal(t, "alpha", readTestFile(t, filepath.Join(remote, "it's.txt")))
+
+	// without sha256sum, files are read back over SFTP
+	broken := func(string) ([]byte, error) {
+		return nil, errors.New("sh: sha256sum: not found")
+	}
+
+	s = &syncer{ftp: ftp, out: io.Discard, checksum: true, output: broken}
+	require.NoError(t, s.sync(context.Background(), local, remote))
+	assert.Equal(t, 0, s.uploaded)
+	assert.Equal(t, 1, s.skipped)
+
+	// partial uploads are hashed on the machine too
+	writeTestFile(t, filepath.Join(local, "big.bin"), "0123456789", mtime)
+	writeTestFile(t, filepath.Join(remote, "big.bin"+partialSuffix), "01234", mtime)
+	info, err := os.Stat(filepath.Join(local, "big.bin"))
+	require.NoError(t, err)
+	writeTestFile(t, filepath.Join(remote, "big.bin"+sourceSuffix), sourceStamp(info), mtime)
+
+	cmds = nil
+	s = &syncer{ftp: ftp, out: io.Discard, checksum: true, output: output}
+	require.NoError(t, s.sync(context.Background(), local, remote))
+	assert.Equal(t, 1, s.resumed)
+	assert.NotEmpty(t, cmds)
+	assert.NotNil(t, s.output, "hashed on the machine rather than read back over SFTP")
+	assert.Equal(t, "0123456789", readTestFile(t, filepath.Join(remote, "big.bin")))
+}
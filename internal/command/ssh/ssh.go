@@ -16,9 +16,11 @@ func New() *cobra.Command {
 
 	cmd.AddCommand(
 		newConsole(),
+		newExec(),
 		newIssue(),
 		newLog(),
 		NewSFTP(),
+		newReplay(),
 	)
 
 	return cmd
@@ -39,6 +39,7 @@ func newCreate() *cobra.Command {
 		newOrgRead(),
 		newLiteFSCloud(),
 		newSSH(),
+		newCustom(),
 	)
 
 	return cmd
@@ -584,7 +585,34 @@ func getCommandCaveat(ctx context.Context) (macaroon.Caveat, error) {
 		commands = append(commands, cav)
 	}
 
-	for _, cmd := range flag.GetStringSlice(ctx, "command") {
+	parsed, err := parseCommands(flag.GetStringSlice(ctx, "command"), flag.GetStringSlice(ctx, "command-prefix"))
+	if err != nil {
+		return nil, err
+	}
+	commands = append(commands, parsed...)
+
+	if len(commands) == 0 {
+		cav := flyio.Command{
+			Args:  []string{},
+			Exact: false,
+		}
+		commands = append(commands, cav)
+	}
+
+	cav := &resset.IfPresent{
+		Ifs:  macaroon.NewCaveatSet(&commands),
+		Else: resset.ActionRead,
+	}
+
+	return cav, nil
+}
+
+// parseCommands parses commands that must match exactly and commands that
+// must match the prefix of what's executed.
+func parseCommands(exact, prefixes []string) (flyio.Commands, error) {
+	var commands flyio.Commands
+
+	for _, cmd := range exact {
 		args, err := shlex.Split(cmd)
 		if err != nil {
 			return nil, fmt.Errorf("cant parse `%s`: %w", cmd, err)
@@ -597,7 +625,7 @@ func getCommandCaveat(ctx context.Context) (macaroon.Caveat, error) {
 		commands = append(commands, cav)
 	}
 
-	for _, cmd := range flag.GetStringSlice(ctx, "command-prefix") {
+	for _, cmd := range prefixes {
 		args, err := shlex.Split(cmd)
 		if err != nil {
 			return nil, fmt.Errorf("cant parse `%s`: %w", cmd, err)
@@ -610,20 +638,7 @@ func getCommandCaveat(ctx context.Context) (macaroon.Caveat, error) {
 		commands = append(commands, cav)
 	}
 
-	if len(commands) == 0 {
-		cav := flyio.Command{
-			Args:  []string{},
-			Exact: false,
-		}
-		commands = append(commands, cav)
-	}
-
-	cav := &resset.IfPresent{
-		Ifs:  macaroon.NewCaveatSet(&commands),
-		Else: resset.ActionRead,
-	}
-
-	return cav, nil
+	return commands, nil
 }
 
 func runLiteFSCloud(ctx context.Context) (err error) {
@@ -0,0 +1,483 @@
+package tokens
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/macaroon"
+	"github.com/superfly/macaroon/flyio"
+	"github.com/superfly/macaroon/resset"
+
+	"github.com/superfly/flyctl/gql"
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/command/orgs"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/internal/flyutil"
+	"github.com/superfly/flyctl/internal/prompt"
+	"github.com/superfly/flyctl/internal/render"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+func newCustom() *cobra.Command {
+	const (
+		short = "Create a token limited to the permissions you pick"
+		long  = `Create an API token limited to the apps, machines, access levels,
+GraphQL mutations and machine commands you pick, after previewing what it can
+and cannot do. When none of those flags are passed, they're prompted for. The
+preview assumes the organization token the API issues has full access to the
+organization. If the token it creates turns out to have other restrictions,
+they're shown once it's created.
+
+Access levels are made of the letters r (read), w (write), c (create), d
+(delete) and C (control, like starting and stopping machines), or * for all of
+them. Tokens are valid for 20 years by default. We recommend using a shorter
+expiry if practical.`
+		usage = "custom"
+	)
+
+	cmd := command.New(usage, short, long, runCustom,
+		command.RequireSession,
+	)
+
+	flag.Add(cmd,
+		flag.JSONOutput(),
+		flag.Org(),
+		flag.Yes(),
+		flag.String{
+			Name:        "name",
+			Shorthand:   "n",
+			Description: "Token name",
+			Default:     "Custom token",
+		},
+		flag.Duration{
+			Name:        "expiry",
+			Shorthand:   "x",
+			Description: "The duration that the token will be valid",
+			Default:     time.Hour * 24 * 365 * 20,
+		},
+		flag.Bool{
+			Name:        "from-existing",
+			Description: "Attenuate the token passed in -t or FLY_API_TOKEN instead of creating an org token",
+		},
+		flag.StringSlice{
+			Name:        "app",
+			Description: "An app the token can access, as NAME or NAME:ACCESS. It can't access other apps",
+		},
+		flag.StringSlice{
+			Name:        "machine",
+			Description: "A machine the token can access, as ID or ID:ACCESS. It can't access other machines",
+		},
+		flag.String{
+			Name:        "access",
+			Description: "Access level the token has to everything it can reach, like r for read-only",
+		},
+		flag.StringSlice{
+			Name:        "mutation",
+			Description: "A GraphQL mutation the token can run. It can't do anything but run these mutations",
+		},
+		flag.StringSlice{
+			Name:        "command",
+			Description: "A command the token can execute on machines. This command must match exactly",
+		},
+		flag.StringSlice{
+			Name:        "command-prefix",
+			Description: "A command the token can execute on machines. This command must match the prefix of a command",
+		},
+		flag.Bool{
+			Name:        "dry-run",
+			Description: "Preview what the token can do without creating it",
+		},
+	)
+
+	cmd.Args = cobra.NoArgs
+
+	return cmd
+}
+
+// grant is access to a single resource.
+type grant struct {
+	ID     string
+	Action resset.Action
+}
+
+// parseGrant parses ID or ID:ACCESS, where ACCESS defaults to everything.
+func parseGrant(s string) (grant, error) {
+	id, access, found := strings.Cut(s, ":")
+	if id == "" {
+		return grant{}, fmt.Errorf("invalid grant %q, use ID or ID:ACCESS", s)
+	}
+
+	if !found {
+		return grant{ID: id, Action: resset.ActionAll}, nil
+	}
+
+	action, err := parseAccess(access)
+	if err != nil {
+		return grant{}, err
+	}
+
+	return grant{ID: id, Action: action}, nil
+}
+
+func parseAccess(s string) (resset.Action, error) {
+	if s == "" || strings.Trim(s, "rwcdC*") != "" {
+		return 0, fmt.Errorf("invalid access level %q, use letters of rwcdC or *", s)
+	}
+	return resset.ActionFromString(s) & resset.ActionAll, nil
+}
+
+// tokenSpec is the set of limits a custom token is built from.
+type tokenSpec struct {
+	apps      []grant
+	machines  []grant
+	access    *resset.Action
+	mutations []string
+	commands  flyio.Commands
+}
+
+func (s tokenSpec) empty() bool {
+	return len(s.apps) == 0 && len(s.machines) == 0 && s.access == nil && len(s.mutations) == 0 && len(s.commands) == 0
+}
+
+func tokenSpecFromFlags(ctx context.Context) (spec tokenSpec, err error) {
+	for _, s := range flag.GetStringSlice(ctx, "app") {
+		g, err := parseGrant(s)
+		if err != nil {
+			return spec, err
+		}
+		spec.apps = append(spec.apps, g)
+	}
+
+	for _, s := range flag.GetStringSlice(ctx, "machine") {
+		g, err := parseGrant(s)
+		if err != nil {
+			return spec, err
+		}
+		spec.machines = append(spec.machines, g)
+	}
+
+	if access := flag.GetString(ctx, "access"); access != "" {
+		action, err := parseAccess(access)
+		if err != nil {
+			return spec, err
+		}
+		spec.access = &action
+	}
+
+	spec.mutations = flag.GetStringSlice(ctx, "mutation")
+
+	spec.commands, err = parseCommands(flag.GetStringSlice(ctx, "command"), flag.GetStringSlice(ctx, "command-prefix"))
+
+	return spec, err
+}
+
+// caveats returns the caveats enforcing spec. Apps are identified by the
+// numeric IDs in appIDs.
+//
+// Machine and command limits only apply to requests for machines and command
+// execution, so that they don't take away access to everything else.
+func (s tokenSpec) caveats(appIDs map[string]uint64) []macaroon.Caveat {
+	var cavs []macaroon.Caveat
+
+	if len(s.apps) > 0 {
+		apps := resset.ResourceSet[uint64, resset.Action]{}
+		for _, g := range s.apps {
+			apps[appIDs[g.ID]] = g.Action
+		}
+		cavs = append(cavs, &flyio.Apps{Apps: apps})
+	}
+
+	if len(s.machines) > 0 {
+		machines := resset.ResourceSet[string, resset.Action]{}
+		for _, g := range s.machines {
+			machines[g.ID] = g.Action
+		}
+		cavs = append(cavs, &resset.IfPresent{
+			Ifs:  macaroon.NewCaveatSet(&flyio.Machines{Machines: machines}),
+			Else: resset.ActionAll,
+		})
+	}
+
+	if s.access != nil && *s.access != resset.ActionAll {
+		cavs = append(cavs, ptr(*s.access))
+	}
+
+	if len(s.mutations) > 0 {
+		cavs = append(cavs, &flyio.Mutations{Mutations: s.mutations})
+	}
+
+	if len(s.commands) > 0 {
+		commands := s.commands
+		cavs = append(cavs, &resset.IfPresent{
+			Ifs:  macaroon.NewCaveatSet(&commands),
+			Else: resset.ActionAll,
+		})
+	}
+
+	return cavs
+}
+
+var accessChoices = []struct {
+	label  string
+	action resset.Action
+}{
+	{"Full access", resset.ActionAll},
+	{"Read-only", resset.ActionRead},
+	{"Read, and start and stop machines", resset.ActionRead | resset.ActionControl},
+	{"Read and write, but don't create or delete anything", resset.ActionRead | resset.ActionWrite | resset.ActionControl},
+}
+
+var expiryChoices = []struct {
+	label  string
+	expiry time.Duration
+}{
+	{"1 hour", time.Hour},
+	{"1 day", 24 * time.Hour},
+	{"30 days", 30 * 24 * time.Hour},
+	{"1 year", 365 * 24 * time.Hour},
+	{"20 years", 20 * 365 * 24 * time.Hour},
+}
+
+func promptTokenSpec(ctx context.Context, apiClient flyutil.Client, org *fly.Organization) (spec tokenSpec, expiry time.Duration, err error) {
+	apps, err := apiClient.GetAppsForOrganization(ctx, org.ID)
+	if err != nil {
+		return spec, 0, fmt.Errorf("failed retrieving apps of %s: %w", org.Slug, err)
+	}
+
+	var accessLabels []string
+	for _, c := range accessChoices {
+		accessLabels = append(accessLabels, c.label)
+	}
+
+	var selected []int
+	if len(apps) > 0 {
+		var appNames []string
+		for _, app := range apps {
+			appNames = append(appNames, app.Name)
+		}
+		if err := prompt.MultiSelect(ctx, &selected, "Which apps can the token access? Select none for every app", nil, appNames...); err != nil {
+			return spec, 0, err
+		}
+	}
+
+	var index int
+	msg := fmt.Sprintf("What can the token do in %s?", org.Slug)
+	if len(selected) > 0 {
+		msg = "What can the token do with these apps?"
+	}
+	if err := prompt.Select(ctx, &index, msg, "", accessLabels...); err != nil {
+		return spec, 0, err
+	}
+	action := accessChoices[index].action
+
+	if len(selected) > 0 {
+		for _, i := range selected {
+			spec.apps = append(spec.apps, grant{ID: apps[i].Name, Action: action})
+		}
+	} else if action != resset.ActionAll {
+		spec.access = &action
+	}
+
+	var prefixes string
+	if err := prompt.String(ctx, &prefixes, "Which commands can the token execute on machines? Separate them with commas, or leave blank for any", "", false); err != nil {
+		return spec, 0, err
+	}
+	if prefixes = strings.TrimSpace(prefixes); prefixes != "" {
+		if spec.commands, err = parseCommands(nil, strings.Split(prefixes, ",")); err != nil {
+			return spec, 0, err
+		}
+	}
+
+	if flag.IsSpecified(ctx, "expiry") {
+		return spec, flag.GetDuration(ctx, "expiry"), nil
+	}
+
+	var expiryLabels []string
+	for _, c := range expiryChoices {
+		expiryLabels = append(expiryLabels, c.label)
+	}
+	if err := prompt.Select(ctx, &index, "How long should the token be valid?", "30 days", expiryLabels...); err != nil {
+		return spec, 0, err
+	}
+
+	return spec, expiryChoices[index].expiry, nil
+}
+
+// permissionCaveats returns the caveats of the permission token in header,
+// but for validity windows.
+func permissionCaveats(header string) ([]macaroon.Caveat, error) {
+	macTok, _, err := flyio.ParsePermissionAndDischargeTokens(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing token from API: %w", err)
+	}
+
+	mac, err := macaroon.Decode(macTok)
+	if err != nil {
+		return nil, err
+	}
+
+	return withoutValidityWindows(mac.UnsafeCaveats.Caveats), nil
+}
+
+func withoutValidityWindows(cavs []macaroon.Caveat) []macaroon.Caveat {
+	var ret []macaroon.Caveat
+	for _, cav := range cavs {
+		if _, ok := cav.(*macaroon.ValidityWindow); !ok {
+			ret = append(ret, cav)
+		}
+	}
+	return ret
+}
+
+func runCustom(ctx context.Context) error {
+	var (
+		io           = iostreams.FromContext(ctx)
+		apiClient    = flyutil.ClientFromContext(ctx)
+		expiry       = flag.GetDuration(ctx, "expiry")
+		fromExisting = flag.GetBool(ctx, "from-existing")
+		names        = resourceNames{orgs: map[uint64]string{}, apps: map[uint64]string{}}
+		base         []macaroon.Caveat
+		org          *fly.Organization
+		perms        []*macaroon.Macaroon
+		disToks      [][]byte
+	)
+
+	spec, err := tokenSpecFromFlags(ctx)
+	if err != nil {
+		return err
+	}
+
+	if fromExisting {
+		toks, err := getTokens(ctx)
+		if err != nil {
+			return err
+		}
+		if perms, _, _, disToks, err = macaroon.FindPermissionAndDischargeTokens(toks, flyio.LocationPermission); err != nil {
+			return fmt.Errorf("unable to decode token: %w", err)
+		}
+		if len(perms) == 0 {
+			return fmt.Errorf("no %s permission tokens found", flyio.LocationPermission)
+		}
+		base = perms[0].UnsafeCaveats.Caveats
+	} else {
+		if org, err = orgs.OrgFromFlagOrSelect(ctx); err != nil {
+			return fmt.Errorf("failed retrieving org %w", err)
+		}
+
+		if spec.empty() && io.IsInteractive() {
+			if spec, expiry, err = promptTokenSpec(ctx, apiClient, org); err != nil {
+				return err
+			}
+		}
+
+		orgID, err := strconv.ParseUint(org.InternalNumericID, 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed parsing ID of org %s: %w", org.Slug, err)
+		}
+		names.orgs[orgID] = org.Slug
+		base = []macaroon.Caveat{&flyio.Organization{ID: orgID, Mask: resset.ActionAll}}
+	}
+
+	appIDs := map[string]uint64{}
+	for _, g := range spec.apps {
+		app, err := apiClient.GetAppCompact(ctx, g.ID)
+		if err != nil {
+			return fmt.Errorf("failed retrieving app %s: %w", g.ID, err)
+		}
+		if org != nil && app.Organization != nil && app.Organization.Slug != org.Slug {
+			return fmt.Errorf("app %s doesn't belong to organization %s", g.ID, org.Slug)
+		}
+		appIDs[g.ID] = uint64(app.InternalNumericID)
+		names.apps[uint64(app.InternalNumericID)] = g.ID
+	}
+
+	cavs := spec.caveats(appIDs)
+	if expiry != 0 && (!fromExisting || flag.IsSpecified(ctx, "expiry")) {
+		now := time.Now()
+		window := &macaroon.ValidityWindow{NotBefore: now.Unix(), NotAfter: now.Add(expiry).Unix()}
+		if fromExisting {
+			cavs = append(cavs, window)
+		} else {
+			// the API adds the validity window to tokens it issues
+			base = append(base, window)
+		}
+	}
+
+	previewed := append(base, cavs...)
+	printSummaries(io.ErrOut, []tokenSummary{explainCaveats(flyio.LocationPermission, previewed, names)})
+
+	if flag.GetBool(ctx, "dry-run") {
+		return nil
+	}
+
+	if io.IsInteractive() && !flag.GetYes(ctx) {
+		switch confirmed, err := prompt.Confirm(ctx, "Create this token?"); {
+		case err != nil:
+			return err
+		case !confirmed:
+			return nil
+		}
+	}
+
+	var token string
+	if fromExisting {
+		for _, m := range perms {
+			if err := m.Add(cavs...); err != nil {
+				return fmt.Errorf("unable to attenuate macaroon: %w", err)
+			}
+		}
+
+		var macToks [][]byte
+		for _, m := range perms {
+			tok, err := m.Encode()
+			if err != nil {
+				return fmt.Errorf("unable to encode macaroon: %w", err)
+			}
+			macToks = append(macToks, tok)
+		}
+		token = macaroon.ToAuthorizationHeader(append(macToks, disToks...)...)
+	} else {
+		expiryStr := ""
+		if expiry != 0 {
+			expiryStr = expiry.String()
+		}
+
+		resp, err := makeToken(ctx, apiClient, org.ID, expiryStr, "deploy_organization", &gql.LimitedAccessTokenOptions{})
+		if err != nil {
+			return err
+		}
+		token = resp.CreateLimitedAccessToken.LimitedAccessToken.TokenHeader
+
+		if len(cavs) > 0 {
+			if token, err = attenuate(token, cavs...); err != nil {
+				return err
+			}
+		}
+
+		// the preview assumed what the API puts on organization tokens, show
+		// what the created token has if that was wrong
+		created, err := permissionCaveats(token)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(created, withoutValidityWindows(previewed)) {
+			fmt.Fprintln(io.ErrOut, "The created token differs from the preview, it has these permissions:")
+			printSummaries(io.ErrOut, []tokenSummary{explainCaveats(flyio.LocationPermission, created, names)})
+		}
+	}
+
+	if config.FromContext(ctx).JSONOutput {
+		render.JSON(io.Out, map[string]string{"token": token})
+	} else {
+		fmt.Fprintln(io.Out, token)
+	}
+
+	return nil
+}
@@ -16,9 +16,10 @@ import (
 func newDebug() *cobra.Command {
 	const (
 		short = "Debug Fly.io API tokens"
-		long  = `Decode and print a Fly.io API token. The token to be
-				debugged may either be passed in the -t argument or in FLY_API_TOKEN.
-				See https://github.com/superfly/macaroon for details Fly.io macaroon
+		long  = `Decode and print a Fly.io API token, followed by a summary of
+				what it can and cannot do. The token to be debugged may either be
+				passed in the -t argument or in FLY_API_TOKEN. See
+				https://github.com/superfly/macaroon for details Fly.io macaroon
 				tokens.`
 		usage = "debug"
 	)
@@ -62,5 +63,8 @@ func runDebug(ctx context.Context) error {
 	}
 	fmt.Println(buf.String())
 
+	// the summary goes to stderr, so the JSON can still be piped elsewhere
+	printSummaries(os.Stderr, explainMacaroons(macs, resourceNames{}))
+
 	return nil
 }
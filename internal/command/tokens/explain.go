@@ -0,0 +1,295 @@
+package tokens
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/superfly/macaroon"
+	"github.com/superfly/macaroon/flyio"
+	"github.com/superfly/macaroon/resset"
+)
+
+// tokenSummary is what a single macaroon allows, in plain English.
+type tokenSummary struct {
+	Location string   `json:"location"`
+	Can      []string `json:"can"`
+	Cannot   []string `json:"cannot"`
+}
+
+// resourceNames maps the numeric IDs caveats use to names, where they're
+// known.
+type resourceNames struct {
+	orgs map[uint64]string
+	apps map[uint64]string
+}
+
+func (n resourceNames) org(id uint64) string {
+	if name, ok := n.orgs[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("#%d", id)
+}
+
+func (n resourceNames) app(id uint64) string {
+	if name, ok := n.apps[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("#%d", id)
+}
+
+func explainMacaroons(macs []*macaroon.Macaroon, names resourceNames) []tokenSummary {
+	summaries := make([]tokenSummary, 0, len(macs))
+	for _, m := range macs {
+		summaries = append(summaries, explainCaveats(m.Location, m.UnsafeCaveats.Caveats, names))
+	}
+	return summaries
+}
+
+// explainCaveats describes what a macaroon with the given caveats can and
+// cannot do. Every caveat only takes permissions away, so the summary starts
+// from the permissions of the token's issuer.
+func explainCaveats(location string, cavs []macaroon.Caveat, names resourceNames) tokenSummary {
+	s := tokenSummary{Location: location, Can: []string{}, Cannot: []string{}}
+	s.explain(cavs, names, false)
+
+	switch {
+	case location != flyio.LocationPermission:
+		s.Can = append([]string{"satisfy third-party caveats of other tokens that require " + location}, s.Can...)
+	case len(s.Can) == 0:
+		s.Can = append(s.Can, "do anything the user or token it was issued to can")
+	}
+
+	s.Can, s.Cannot = dedupe(s.Can), dedupe(s.Cannot)
+
+	return s
+}
+
+func dedupe(lines []string) []string {
+	seen := map[string]bool{}
+	out := lines[:0]
+	for _, line := range lines {
+		if !seen[line] {
+			seen[line] = true
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// explain adds the descriptions of cavs to s. Caveats wrapped in IfPresent
+// only apply to requests for the resources they name, so they don't prohibit
+// everything else.
+func (s *tokenSummary) explain(cavs []macaroon.Caveat, names resourceNames, wrapped bool) {
+	for _, cav := range cavs {
+		switch c := cav.(type) {
+		case *flyio.Organization:
+			s.Can = append(s.Can, fmt.Sprintf("%s resources of organization %s", describeAction(c.Mask), names.org(c.ID)))
+			s.Cannot = append(s.Cannot, "access other organizations")
+		case *flyio.Apps:
+			s.Can = append(s.Can, describeResources(c.Apps, "app", names.app)...)
+			s.Cannot = append(s.Cannot, "access other apps")
+		case *flyio.Machines:
+			s.Can = append(s.Can, describeResources(c.Machines, "machine", nil)...)
+			s.Cannot = append(s.Cannot, "access other machines")
+		case *flyio.Volumes:
+			s.Can = append(s.Can, describeResources(c.Volumes, "volume", nil)...)
+			s.Cannot = append(s.Cannot, "access other volumes")
+		case *flyio.Clusters:
+			s.Can = append(s.Can, describeResources(c.Clusters, "LiteFS cluster", nil)...)
+			s.Cannot = append(s.Cannot, "access other LiteFS clusters")
+		case *flyio.FeatureSet:
+			s.Can = append(s.Can, describeResources(c.Features, "organization feature", nil)...)
+			s.Cannot = append(s.Cannot, "use other organization features")
+		case *flyio.AppFeatureSet:
+			s.Can = append(s.Can, describeResources(c.Features, "app feature", nil)...)
+			s.Cannot = append(s.Cannot, "use other app features")
+		case *flyio.MachineFeatureSet:
+			s.Can = append(s.Can, describeResources(c.Features, "machine feature", nil)...)
+			s.Cannot = append(s.Cannot, "use other machine features")
+		case *flyio.StorageObjects:
+			s.Can = append(s.Can, describeResources(c.Prefixes, "storage objects under", nil)...)
+			s.Cannot = append(s.Cannot, "access other storage objects")
+		case *resset.Action:
+			if *c&resset.ActionAll != resset.ActionAll {
+				s.Cannot = append(s.Cannot, fmt.Sprintf("%s anything", joinWords(actionWords(resset.Remove(resset.ActionAll, *c)), "or")))
+			}
+		case *flyio.Mutations:
+			s.Can = append(s.Can, "run the GraphQL mutations "+strings.Join(c.Mutations, ", "))
+			s.Cannot = append(s.Cannot, "run other GraphQL mutations")
+			if !wrapped {
+				s.Cannot = append(s.Cannot, "do anything but run those mutations")
+			}
+		case *flyio.Commands:
+			if len(*c) == 0 {
+				s.Cannot = append(s.Cannot, "execute any command on machines")
+				break
+			}
+			s.Can = append(s.Can, "execute "+describeCommands(*c)+" on machines")
+			s.Cannot = append(s.Cannot, "execute other commands on machines")
+			if !wrapped {
+				s.Cannot = append(s.Cannot, "do anything but execute those commands")
+			}
+		case *resset.IfPresent:
+			s.explain(c.Ifs.Caveats, names, true)
+			switch elseAction := c.Else & resset.ActionAll; elseAction {
+			case resset.ActionAll:
+			case resset.ActionNone:
+				s.Cannot = append(s.Cannot, "do anything else")
+			default:
+				s.Can = append(s.Can, fmt.Sprintf("%s everything else", describeAction(elseAction)))
+				s.Cannot = append(s.Cannot, fmt.Sprintf("%s anything else", joinWords(actionWords(resset.Remove(resset.ActionAll, elseAction)), "or")))
+			}
+		case *macaroon.ValidityWindow:
+			notBefore, notAfter := time.Unix(c.NotBefore, 0), time.Unix(c.NotAfter, 0)
+			now := time.Now()
+			switch {
+			case now.After(notAfter):
+				s.Cannot = append(s.Cannot, fmt.Sprintf("be used at all: it expired at %s", notAfter.Format(time.RFC3339)))
+			case now.Before(notBefore):
+				s.Cannot = append(s.Cannot, fmt.Sprintf("be used before %s", notBefore.Format(time.RFC3339)))
+				s.Cannot = append(s.Cannot, fmt.Sprintf("be used after %s", notAfter.Format(time.RFC3339)))
+			default:
+				s.Cannot = append(s.Cannot, fmt.Sprintf("be used after %s", notAfter.Format(time.RFC3339)))
+			}
+		case *flyio.FromMachine:
+			s.Cannot = append(s.Cannot, fmt.Sprintf("be used from anywhere but machine %s", c.ID))
+		case *flyio.FlySrc:
+			s.Cannot = append(s.Cannot, "be used by anything but Flycast requests from "+describeFlySrc(c))
+		case *flyio.AllowedRoles:
+			s.Cannot = append(s.Cannot, fmt.Sprintf("be used by a user without the %s role", flyio.Role(*c)))
+		case *flyio.IsMember:
+			s.Cannot = append(s.Cannot, "be used by anyone who isn't a member of the organization")
+		case *macaroon.Caveat3P:
+			s.Cannot = append(s.Cannot, "be used without a discharge token from "+c.Location)
+		case *flyio.IsUser, *macaroon.BindToParentToken:
+			// metadata, these don't limit what the token can do
+		default:
+			s.Cannot = append(s.Cannot, fmt.Sprintf("do what its %s caveat prohibits", cav.Name()))
+		}
+	}
+}
+
+// actionWords returns the words for the actions in a.
+func actionWords(a resset.Action) []string {
+	var words []string
+	for _, w := range []struct {
+		action resset.Action
+		word   string
+	}{
+		{resset.ActionRead, "read"},
+		{resset.ActionWrite, "write"},
+		{resset.ActionCreate, "create"},
+		{resset.ActionDelete, "delete"},
+		{resset.ActionControl, "control"},
+	} {
+		if a&w.action != 0 {
+			words = append(words, w.word)
+		}
+	}
+	return words
+}
+
+func describeAction(a resset.Action) string {
+	switch a & resset.ActionAll {
+	case resset.ActionAll:
+		return "do anything with"
+	case resset.ActionNone:
+		return "do nothing with"
+	default:
+		return joinWords(actionWords(a), "and")
+	}
+}
+
+// joinWords joins words the way a sentence lists them.
+func joinWords(words []string, conjunction string) string {
+	switch len(words) {
+	case 0:
+		return ""
+	case 1:
+		return words[0]
+	default:
+		return strings.Join(words[:len(words)-1], ", ") + " " + conjunction + " " + words[len(words)-1]
+	}
+}
+
+func describeResources[I resset.ID](rs resset.ResourceSet[I, resset.Action], kind string, name func(I) string) []string {
+	ids := make([]I, 0, len(rs))
+	for id := range rs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return fmt.Sprint(ids[i]) < fmt.Sprint(ids[j])
+	})
+
+	lines := make([]string, 0, len(ids))
+	for _, id := range ids {
+		var what string
+		switch {
+		case id == resset.ZeroID[I]():
+			what = "any " + kind
+		case name != nil:
+			what = kind + " " + name(id)
+		default:
+			what = fmt.Sprintf("%s %v", kind, id)
+		}
+		lines = append(lines, describeAction(rs[id])+" "+what)
+	}
+	return lines
+}
+
+func describeCommands(cmds flyio.Commands) string {
+	var described []string
+	for _, cmd := range cmds {
+		switch {
+		case len(cmd.Args) == 0 && !cmd.Exact:
+			described = append(described, "any command")
+		case cmd.Exact:
+			described = append(described, "`"+strings.Join(cmd.Args, " ")+"`")
+		default:
+			described = append(described, "`"+strings.Join(cmd.Args, " ")+" ...`")
+		}
+	}
+	return joinWords(described, "or")
+}
+
+func describeFlySrc(c *flyio.FlySrc) string {
+	var parts []string
+	if c.Organization != "" {
+		parts = append(parts, "organization "+c.Organization)
+	}
+	if c.App != "" {
+		parts = append(parts, "app "+c.App)
+	}
+	if c.Instance != "" {
+		parts = append(parts, "machine "+c.Instance)
+	}
+	if len(parts) == 0 {
+		return "any machine"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func printSummaries(w io.Writer, summaries []tokenSummary) {
+	for i, s := range summaries {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if len(summaries) == 1 {
+			fmt.Fprintln(w, "This token can:")
+		} else {
+			fmt.Fprintf(w, "Token %d (%s) can:\n", i+1, s.Location)
+		}
+		for _, line := range s.Can {
+			fmt.Fprintf(w, "  - %s\n", line)
+		}
+		if len(s.Cannot) > 0 {
+			fmt.Fprintln(w, "and cannot:")
+			for _, line := range s.Cannot {
+				fmt.Fprintf(w, "  - %s\n", line)
+			}
+		}
+	}
+}
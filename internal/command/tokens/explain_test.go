@@ -0,0 +1,122 @@
+package tokens
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/macaroon"
+	"github.com/superfly/macaroon/flyio"
+	"github.com/superfly/macaroon/resset"
+)
+
+func TestExplainCaveats(t *testing.T) {
+	names := resourceNames{
+		orgs: map[uint64]string{1: "acme"},
+		apps: map[uint64]string{2: "web"},
+	}
+
+	notAfter := time.Now().Add(time.Hour)
+
+	s := explainCaveats(flyio.LocationPermission, []macaroon.Caveat{
+		&flyio.Organization{ID: 1, Mask: resset.ActionAll},
+		&flyio.Apps{Apps: resset.ResourceSet[uint64, resset.Action]{2: resset.ActionRead | resset.ActionControl}},
+		&resset.IfPresent{
+			Ifs:  macaroon.NewCaveatSet(&flyio.Commands{{Args: []string{"ls"}, Exact: true}, {Args: []string{"cat"}}}),
+			Else: resset.ActionRead,
+		},
+		&macaroon.ValidityWindow{NotBefore: time.Now().Unix(), NotAfter: notAfter.Unix()},
+	}, names)
+
+	assert.Equal(t, []string{
+		"do anything with resources of organization acme",
+		"read and control app web",
+		"execute `ls` or `cat ...` on machines",
+		"read everything else",
+	}, s.Can)
+	assert.Equal(t, []string{
+		"access other organizations",
+		"access other apps",
+		"execute other commands on machines",
+		"write, create, delete or control anything else",
+		"be used after " + notAfter.Format(time.RFC3339),
+	}, s.Cannot)
+
+	s = explainCaveats(flyio.LocationPermission, []macaroon.Caveat{
+		&flyio.Organization{ID: 3, Mask: resset.ActionRead},
+		&flyio.Mutations{Mutations: []string{"addWireGuardPeer"}},
+	}, resourceNames{})
+
+	assert.Equal(t, []string{
+		"read resources of organization #3",
+		"run the GraphQL mutations addWireGuardPeer",
+	}, s.Can)
+	assert.Contains(t, s.Cannot, "do anything but run those mutations")
+}
+
+func TestCustomTokenCaveats(t *testing.T) {
+	app, err := parseGrant("web:rC")
+	require.NoError(t, err)
+	machine, err := parseGrant("148e")
+	require.NoError(t, err)
+	assert.Equal(t, grant{ID: "148e", Action: resset.ActionAll}, machine)
+
+	_, err = parseGrant("web:rx")
+	assert.Error(t, err)
+	_, err = parseGrant(":r")
+	assert.Error(t, err)
+
+	readOnly := resset.ActionRead
+	spec := tokenSpec{
+		apps:     []grant{app},
+		machines: []grant{machine},
+		access:   &readOnly,
+		commands: flyio.Commands{{Args: []string{"bin/rails", "console"}}},
+	}
+
+	cavs := spec.caveats(map[string]uint64{"web": 2})
+	require.Len(t, cavs, 4)
+	assert.Equal(t, &flyio.Apps{Apps: resset.ResourceSet[uint64, resset.Action]{2: resset.ActionRead | resset.ActionControl}}, cavs[0])
+	assert.Equal(t, &readOnly, cavs[2])
+
+	s := explainCaveats(flyio.LocationPermission, cavs, resourceNames{apps: map[uint64]string{2: "web"}})
+	assert.Equal(t, []string{
+		"read and control app web",
+		"do anything with machine 148e",
+		"execute `bin/rails console ...` on machines",
+	}, s.Can)
+	assert.Equal(t, []string{
+		"access other apps",
+		"access other machines",
+		"write, create, delete or control anything",
+		"execute other commands on machines",
+	}, s.Cannot)
+
+	assert.True(t, tokenSpec{}.empty())
+	assert.Empty(t, tokenSpec{}.caveats(nil))
+}
+
+func TestPermissionCaveats(t *testing.T) {
+	m, err := macaroon.New([]byte("kid"), flyio.LocationPermission, macaroon.NewSigningKey())
+	require.NoError(t, err)
+
+	org := &flyio.Organization{ID: 1, Mask: resset.ActionRead | resset.ActionWrite}
+	require.NoError(t, m.Add(
+		org,
+		&macaroon.ValidityWindow{NotBefore: time.Now().Unix(), NotAfter: time.Now().Add(time.Minute).Unix()},
+		&flyio.IsMember{},
+	))
+
+	tok, err := m.Encode()
+	require.NoError(t, err)
+
+	// what the API put on a created token is compared to the preview as it
+	// is, but for its validity window
+	cavs, err := permissionCaveats(macaroon.ToAuthorizationHeader(tok))
+	require.NoError(t, err)
+	assert.Equal(t, []macaroon.Caveat{org, &flyio.IsMember{}}, cavs)
+
+	_, err = permissionCaveats("FlyV1 nope")
+	assert.Error(t, err)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"io/fs"
+	"os"
 	"sync"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/superfly/flyctl/internal/env"
 	"github.com/superfly/flyctl/internal/flag/flagctx"
 	"github.com/superfly/flyctl/internal/flag/flagnames"
+	"github.com/superfly/flyctl/internal/logger"
 )
 
 const (
@@ -36,6 +38,14 @@ const (
 	WireGuardStateFileKey      = "wire_guard_state"
 	WireGuardWebsocketsFileKey = "wire_guard_websockets"
 	LastLoginFileKey           = "last_login"
+	UserEmailFileKey           = "user_email"
+	ProxyPresetsFileKey        = "proxy_presets"
+	SSHRecordingDirFileKey     = "ssh_recording_dir"
+	SSHRecordingDirEnvKey      = "FLY_SSH_RECORDING_DIR"
+	AuditLogFileKey            = "audit_log"
+	AuditLogEnvKey             = "FLY_AUDIT_LOG"
+	AuditWebhookFileKey        = "audit_webhook"
+	AuditWebhookEnvKey         = "FLY_AUDIT_WEBHOOK"
 	APITokenEnvKey             = "FLY_API_TOKEN"
 	orgEnvKey                  = "FLY_ORG"
 	registryHostEnvKey         = "FLY_REGISTRY_HOST"
@@ -113,6 +123,30 @@ type Config struct {
 
 	// LastLogin denotes the timestamp of the last successful login.
 	LastLogin time.Time
+
+	// UserEmail denotes the email of the user the profile logged in as. It's
+	// empty when Tokens don't come from the profile.
+	UserEmail string
+
+	// SSHRecordingDir denotes the directory interactive SSH sessions are
+	// recorded to. Recording is mandatory when it's set.
+	SSHRecordingDir string
+
+	// AuditLog denotes the file mutating commands are logged to, and
+	// AuditWebhook the URL their log entries are posted to. Neither is
+	// set by default.
+	AuditLog     string
+	AuditWebhook string
+
+	// Profile denotes the name of the auth profile in use, and ProfileSource
+	// what selected it.
+	Profile       string
+	ProfileSource ProfileSource
+
+	// ProfileFile denotes the file the profile's tokens, defaults and
+	// WireGuard state are kept in. For the default profile, it's the config
+	// file itself.
+	ProfileFile string
 }
 
 func Load(ctx context.Context, path string) (*Config, error) {
@@ -125,16 +159,39 @@ func Load(ctx context.Context, path string) (*Config, error) {
 		Tokens:            new(tokens.Tokens),
 	}
 
+	flags := flagctx.FromContext(ctx)
+
+	var profileFlag string
+	if flags != nil && flags.Changed(flagnames.Profile) {
+		profileFlag, _ = flags.GetString(flagnames.Profile)
+	}
+	wd, _ := os.Getwd()
+
+	profile, source, err := ResolveProfile(profileFlag, path, wd)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Profile = profile
+	cfg.ProfileSource = source
+	cfg.ProfileFile = ProfileFile(path, profile)
+
 	// Apply config from the config file, if it exists
-	if err := cfg.applyFile(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+	if err := cfg.applyFile(ctx, path); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return nil, err
 	}
 
+	// Then the account state of the profile, if it's not the default one
+	if cfg.ProfileFile != path {
+		if err := cfg.applyProfileFile(ctx, cfg.ProfileFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
 	// Apply config from the environment, overriding anything from the file
 	cfg.applyEnv()
 
 	// Finally, apply command line options, overriding any previous setting
-	cfg.applyFlags(flagctx.FromContext(ctx))
+	cfg.applyFlags(flags)
 
 	return cfg, nil
 }
@@ -149,6 +206,7 @@ func (cfg *Config) applyEnv() {
 
 	if token := env.First(AccessTokenEnvKey, APITokenEnvKey); token != "" {
 		cfg.Tokens = tokens.Parse(token)
+		cfg.UserEmail = ""
 	}
 
 	cfg.VerboseOutput = env.IsTruthy(verboseOutputEnvKey) || cfg.VerboseOutput
@@ -171,22 +229,32 @@ func (cfg *Config) applyEnv() {
 		cfg.SyntheticsAgent = env.IsTruthy(SyntheticsAgentEnvKey)
 	}
 	cfg.SyntheticsBaseURL = env.FirstOrDefault(cfg.SyntheticsBaseURL, syntheticsBaseURLEnvKey)
+	cfg.SSHRecordingDir = env.FirstOrDefault(cfg.SSHRecordingDir, SSHRecordingDirEnvKey)
+	cfg.AuditLog = env.FirstOrDefault(cfg.AuditLog, AuditLogEnvKey)
+	cfg.AuditWebhook = env.FirstOrDefault(cfg.AuditWebhook, AuditWebhookEnvKey)
 }
 
 // applyFile sets the properties of cfg which may be set via configuration file
 // to the values the file at the given path contains.
-func (cfg *Config) applyFile(path string) (err error) {
+func (cfg *Config) applyFile(ctx context.Context, path string) (err error) {
 	cfg.mu.Lock()
 	defer cfg.mu.Unlock()
 
 	var w struct {
 		AccessToken            string    `yaml:"access_token"`
 		MetricsToken           string    `yaml:"metrics_token"`
+		CredentialStore        string    `yaml:"credential_store"`
 		SendMetrics            bool      `yaml:"send_metrics"`
 		AutoUpdate             bool      `yaml:"auto_update"`
 		SyntheticsAgent        bool      `yaml:"synthetics_agent"`
 		DisableManagedBuilders bool      `yaml:"disable_managed_builders"`
 		LastLogin              time.Time `yaml:"last_login"`
+		UserEmail              string    `yaml:"user_email"`
+		SSHRecordingDir        string    `yaml:"ssh_recording_dir"`
+		AuditLog               string    `yaml:"audit_log"`
+		AuditWebhook           string    `yaml:"audit_webhook"`
+		Organization           string    `yaml:"organization"`
+		Region                 string    `yaml:"region"`
 	}
 	w.SendMetrics = true
 	w.AutoUpdate = true
@@ -194,18 +262,73 @@ func (cfg *Config) applyFile(path string) (err error) {
 	w.DisableManagedBuilders = false
 
 	if err = unmarshal(path, &w); err == nil {
-		cfg.Tokens = tokens.ParseFromFile(w.AccessToken, path)
+		token := fileAccessToken(ctx, path, w.AccessToken, w.CredentialStore)
+		cfg.Tokens = tokens.ParseFromFile(token, path)
 		cfg.MetricsToken = w.MetricsToken
 		cfg.SendMetrics = w.SendMetrics
 		cfg.AutoUpdate = w.AutoUpdate
 		cfg.SyntheticsAgent = w.SyntheticsAgent
 		cfg.DisableManagedBuilders = w.DisableManagedBuilders
 		cfg.LastLogin = w.LastLogin
+		cfg.UserEmail = w.UserEmail
+		cfg.SSHRecordingDir = w.SSHRecordingDir
+		cfg.AuditLog = w.AuditLog
+		cfg.AuditWebhook = w.AuditWebhook
+		cfg.Organization = w.Organization
+		cfg.Region = w.Region
+	}
+
+	return
+}
+
+// applyProfileFile replaces the account state of cfg, its tokens, defaults
+// and last login, with the ones the profile file at the given path contains.
+// A profile that was never logged in has no tokens.
+func (cfg *Config) applyProfileFile(ctx context.Context, path string) (err error) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	cfg.Tokens = tokens.ParseFromFile("", path)
+	cfg.MetricsToken = ""
+	cfg.LastLogin = time.Time{}
+	cfg.UserEmail = ""
+	cfg.Organization = ""
+	cfg.Region = ""
+
+	var w struct {
+		AccessToken     string    `yaml:"access_token"`
+		MetricsToken    string    `yaml:"metrics_token"`
+		CredentialStore string    `yaml:"credential_store"`
+		LastLogin       time.Time `yaml:"last_login"`
+		UserEmail       string    `yaml:"user_email"`
+		Organization    string    `yaml:"organization"`
+		Region          string    `yaml:"region"`
+	}
+
+	if err = unmarshal(path, &w); err == nil {
+		token := fileAccessToken(ctx, path, w.AccessToken, w.CredentialStore)
+		cfg.Tokens = tokens.ParseFromFile(token, path)
+		cfg.MetricsToken = w.MetricsToken
+		cfg.LastLogin = w.LastLogin
+		cfg.UserEmail = w.UserEmail
+		cfg.Organization = w.Organization
+		cfg.Region = w.Region
 	}
 
 	return
 }
 
+// fileAccessToken returns the access token of the file at path. A credential
+// store that can't be read only costs the file's token, so that tokens from
+// the environment or flags, and commands that don't need one, keep working.
+func fileAccessToken(ctx context.Context, path, token, storeName string) string {
+	token, err := resolveAccessToken(path, token, storeName)
+	if err != nil {
+		logger.MaybeFromContext(ctx).Warnf("Ignoring the access token of %s: %v", path, err)
+	}
+	return token
+}
+
 // applyFlags sets the properties of cfg which may be set via command line flags
 // to the values the flags of the given FlagSet may contain.
 func (cfg *Config) applyFlags(fs *pflag.FlagSet) {
@@ -228,6 +351,7 @@ func (cfg *Config) applyFlags(fs *pflag.FlagSet) {
 			panic(err)
 		} else {
 			cfg.Tokens = tokens.Parse(v)
+			cfg.UserEmail = ""
 		}
 	}
 }
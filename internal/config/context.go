@@ -19,6 +19,22 @@ func FromContext(ctx context.Context) *Config {
 	return ctx.Value(contextKey{}).(*Config)
 }
 
+// ProfileFromContext returns the auth profile of the Config ctx carries, or
+// the default profile if it carries none.
+func ProfileFromContext(ctx context.Context) string {
+	if cfg, ok := ctx.Value(contextKey{}).(*Config); ok && cfg.Profile != "" {
+		return cfg.Profile
+	}
+	return DefaultProfile
+}
+
+// AuditEnabled reports whether the Config ctx carries, if any, records
+// mutating commands in the audit log or forwards them to the audit webhook.
+func AuditEnabled(ctx context.Context) bool {
+	cfg, ok := ctx.Value(contextKey{}).(*Config)
+	return ok && (cfg.AuditLog != "" || cfg.AuditWebhook != "")
+}
+
 func Tokens(ctx context.Context) *tokens.Tokens {
 	return FromContext(ctx).Tokens
 }
@@ -0,0 +1,503 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/superfly/flyctl/internal/filemu"
+)
+
+const (
+	CredentialStoreFileKey      = "credential_store"
+	CredentialsPassphraseEnvKey = "FLY_CREDENTIALS_PASSPHRASE"
+
+	// CredentialStoreConfig keeps access tokens in plaintext in the config
+	// file, as flyctl always did.
+	CredentialStoreConfig = "config"
+	// CredentialStoreKeychain keeps access tokens in the OS keychain: the
+	// Secret Service on Linux and the login keychain on macOS.
+	CredentialStoreKeychain = "keychain"
+	// CredentialStoreEncryptedFile keeps access tokens in a file next to the
+	// config file, encrypted with a passphrase from FLY_CREDENTIALS_PASSPHRASE.
+	CredentialStoreEncryptedFile = "encrypted-file"
+
+	credentialsFileName = "credentials.enc"
+	credentialService   = "flyctl"
+)
+
+// CredentialStores lists the stores access tokens can be kept in.
+var CredentialStores = []string{CredentialStoreConfig, CredentialStoreKeychain, CredentialStoreEncryptedFile}
+
+// credentialStore keeps access tokens outside of the config file. Tokens are
+// keyed by the path of the configuration or profile file they belong to.
+type credentialStore interface {
+	// Get returns an empty string when the store has no token for key.
+	Get(key string) (string, error)
+	Set(key, token string) error
+	Delete(key string) error
+}
+
+// openCredentialStore returns the named store for the tokens of the file at
+// path, or nil if they're kept in the file itself.
+func openCredentialStore(name, path string) (credentialStore, error) {
+	switch name {
+	case "", CredentialStoreConfig:
+		return nil, nil
+	case CredentialStoreKeychain:
+		return keychainStore{}, nil
+	case CredentialStoreEncryptedFile:
+		return encryptedFileStore{path: filepath.Join(configDirOf(path), credentialsFileName)}, nil
+	default:
+		return nil, fmt.Errorf("unknown credential store %q, use one of %s", name, strings.Join(CredentialStores, ", "))
+	}
+}
+
+// configDirOf returns the directory of the config file a configuration or
+// profile file belongs to.
+func configDirOf(path string) string {
+	dir := filepath.Dir(path)
+	if filepath.Base(dir) == profilesDirName {
+		return filepath.Dir(dir)
+	}
+	return dir
+}
+
+// credentialStoreName returns the store the file at path keeps its token in.
+// Profile files that don't name one use the config file's.
+func credentialStoreName(path, own string) string {
+	if own != "" {
+		return own
+	}
+
+	configPath := filepath.Join(configDirOf(path), FileName)
+	if configPath == path {
+		return ""
+	}
+
+	var s struct {
+		CredentialStore string `yaml:"credential_store"`
+	}
+	_ = unmarshal(configPath, &s)
+	return s.CredentialStore
+}
+
+func credentialKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// keychainStore shells out to the platform's keychain tool, so flyctl needs
+// neither cgo nor a D-Bus client to use it.
+type keychainStore struct{}
+
+func (keychainStore) Get(key string) (string, error) {
+	switch runtime.GOOS {
+	case "linux":
+		out, err := runKeychainTool("", "secret-tool", "lookup", "service", credentialService, "account", key)
+		if exitCode(err) == 1 && out == "" {
+			return "", nil
+		}
+		return out, err
+	case "darwin":
+		out, err := runKeychainTool("", "security", "find-generic-password", "-s", credentialService, "-a", key, "-w")
+		if exitCode(err) == 44 {
+			return "", nil
+		}
+		return out, err
+	default:
+		return "", errKeychainUnsupported
+	}
+}
+
+func (keychainStore) Set(key, token string) error {
+	switch runtime.GOOS {
+	case "linux":
+		_, err := runKeychainTool(token, "secret-tool", "store", "--label", "flyctl access token", "service", credentialService, "account", key)
+		return err
+	case "darwin":
+		// the token is fed to security's interactive mode on stdin, as an
+		// argument anyone could read it from the process list
+		line, err := securityCommandLine("add-generic-password", "-U", "-s", credentialService, "-a", key, "-w", token)
+		if err != nil {
+			return err
+		}
+		if _, err := runKeychainTool(line, "security", "-i"); err != nil {
+			return err
+		}
+
+		// interactive mode exits cleanly when its commands fail, so check the
+		// token made it in
+		stored, err := keychainStore{}.Get(key)
+		if err != nil {
+			return err
+		}
+		if stored != token {
+			return fmt.Errorf("security didn't store the access token in the keychain")
+		}
+		return nil
+	default:
+		return errKeychainUnsupported
+	}
+}
+
+func (keychainStore) Delete(key string) error {
+	switch runtime.GOOS {
+	case "linux":
+		_, err := runKeychainTool("", "secret-tool", "clear", "service", credentialService, "account", key)
+		return err
+	case "darwin":
+		_, err := runKeychainTool("", "security", "delete-generic-password", "-s", credentialService, "-a", key)
+		if exitCode(err) == 44 {
+			return nil
+		}
+		return err
+	default:
+		return errKeychainUnsupported
+	}
+}
+
+var errKeychainUnsupported = fmt.Errorf("the %s credential store isn't supported on %s, use %s", CredentialStoreKeychain, runtime.GOOS, CredentialStoreEncryptedFile)
+
+// securityCommandLine quotes args for security's interactive mode. Arguments
+// with quotes, backslashes or control characters are refused rather than
+// escaped, since access tokens and keys never have them.
+func securityCommandLine(args ...string) (string, error) {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if strings.ContainsFunc(arg, func(r rune) bool { return r == '"' || r == '\\' || r < ' ' || r == 0x7f }) {
+			return "", errors.New("security can't be passed quotes, backslashes or control characters")
+		}
+		quoted[i] = `"` + arg + `"`
+	}
+	return strings.Join(quoted, " ") + "\n", nil
+}
+
+func runKeychainTool(stdin, name string, args ...string) (string, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return "", fmt.Errorf("the %s credential store needs %s: %w", CredentialStoreKeychain, name, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%s: %w: %s", name, err, msg)
+		}
+		return strings.TrimSpace(stdout.String()), err
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return 0
+}
+
+// encryptedFileStore keeps every token in a single file, sealed with a key
+// derived from the passphrase. The file is laid out as the scrypt salt, the
+// secretbox nonce and the sealed JSON map of keys to tokens.
+type encryptedFileStore struct {
+	path string
+}
+
+const (
+	credentialsSaltSize  = 16
+	credentialsNonceSize = 24
+)
+
+func (s encryptedFileStore) Get(key string) (string, error) {
+	tokens, err := s.read()
+	if err != nil {
+		return "", err
+	}
+	return tokens[key], nil
+}
+
+func (s encryptedFileStore) Set(key, token string) error {
+	return s.update(func(tokens map[string]string) {
+		tokens[key] = token
+	})
+}
+
+func (s encryptedFileStore) Delete(key string) error {
+	return s.update(func(tokens map[string]string) {
+		delete(tokens, key)
+	})
+}
+
+func (s encryptedFileStore) update(fn func(map[string]string)) (err error) {
+	var unlock filemu.UnlockFunc
+	if unlock, err = filemu.Lock(context.Background(), s.path+".lock"); err != nil {
+		return
+	}
+	defer func() {
+		if e := unlock(); err == nil {
+			err = e
+		}
+	}()
+
+	tokens, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	fn(tokens)
+
+	return s.write(tokens)
+}
+
+func (s encryptedFileStore) read() (map[string]string, error) {
+	tokens := map[string]string{}
+
+	b, err := os.ReadFile(s.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return tokens, nil
+	case err != nil:
+		return nil, err
+	case len(b) < credentialsSaltSize+credentialsNonceSize:
+		return nil, fmt.Errorf("%s is corrupt", s.path)
+	}
+
+	salt, rest := b[:credentialsSaltSize], b[credentialsSaltSize:]
+	var nonce [credentialsNonceSize]byte
+	copy(nonce[:], rest[:credentialsNonceSize])
+
+	key, err := credentialsKey(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, ok := secretbox.Open(nil, rest[credentialsNonceSize:], &nonce, key)
+	if !ok {
+		return nil, fmt.Errorf("failed decrypting %s, check %s", s.path, CredentialsPassphraseEnvKey)
+	}
+
+	if err := json.Unmarshal(plain, &tokens); err != nil {
+		return nil, fmt.Errorf("%s is corrupt: %w", s.path, err)
+	}
+
+	return tokens, nil
+}
+
+func (s encryptedFileStore) write(tokens map[string]string) error {
+	plain, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, credentialsSaltSize)
+	var nonce [credentialsNonceSize]byte
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+
+	key, err := credentialsKey(salt)
+	if err != nil {
+		return err
+	}
+
+	b := append(salt, nonce[:]...)
+	b = secretbox.Seal(b, plain, &nonce, key)
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, b, 0o600)
+}
+
+func credentialsKey(salt []byte) (*[32]byte, error) {
+	passphrase := os.Getenv(CredentialsPassphraseEnvKey)
+	if passphrase == "" {
+		return nil, fmt.Errorf("the %s credential store needs a passphrase, set %s", CredentialStoreEncryptedFile, CredentialsPassphraseEnvKey)
+	}
+
+	derived, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	var key [32]byte
+	copy(key[:], derived)
+	return &key, nil
+}
+
+// ErrCredentialStoreUnavailable is wrapped by errors reading or removing a
+// token from a credential store that can't be used right now, e.g. because
+// the keychain tool is missing or FLY_CREDENTIALS_PASSPHRASE isn't set.
+var ErrCredentialStoreUnavailable = errors.New("credential store unavailable")
+
+// resolveAccessToken returns the access token of the file at path, given the
+// access_token and credential_store values it holds. A token in the file
+// itself wins, so files written before a store was picked keep working.
+func resolveAccessToken(path, token, storeName string) (string, error) {
+	if token != "" {
+		return token, nil
+	}
+
+	name := credentialStoreName(path, storeName)
+	if token, ok := cachedAccessToken(path, name); ok {
+		return token, nil
+	}
+
+	store, err := openCredentialStore(name, path)
+	if err == nil && store != nil {
+		token, err = store.Get(credentialKey(path))
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: failed reading token from the %s store: %w", ErrCredentialStoreUnavailable, name, err)
+	}
+
+	if store != nil {
+		cacheAccessToken(path, name, token)
+	}
+	return token, nil
+}
+
+// tokenCache keeps the tokens read from credential stores for the life of the
+// process, so that loading the config doesn't run the keychain tool or derive
+// the passphrase's key every time. Every write of a token rewrites the file it
+// belongs to, so entries are only used while that file is unchanged; that way
+// tokens updated by other processes are still picked up.
+var tokenCache = struct {
+	sync.Mutex
+	m map[string]cachedToken
+}{m: map[string]cachedToken{}}
+
+type cachedToken struct {
+	store   string
+	modTime time.Time
+	size    int64
+	token   string
+}
+
+func cachedAccessToken(path, store string) (string, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", false
+	}
+
+	tokenCache.Lock()
+	defer tokenCache.Unlock()
+
+	c, ok := tokenCache.m[credentialKey(path)]
+	if !ok || c.store != store || !c.modTime.Equal(info.ModTime()) || c.size != info.Size() {
+		return "", false
+	}
+	return c.token, true
+}
+
+func cacheAccessToken(path, store, token string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	tokenCache.Lock()
+	defer tokenCache.Unlock()
+
+	tokenCache.m[credentialKey(path)] = cachedToken{
+		store:   store,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		token:   token,
+	}
+}
+
+func forgetAccessToken(path string) {
+	tokenCache.Lock()
+	defer tokenCache.Unlock()
+
+	delete(tokenCache.m, credentialKey(path))
+}
+
+// deleteAccessToken removes the token of the file at path from the named
+// store, if it's kept in one.
+func deleteAccessToken(path, storeName string) error {
+	forgetAccessToken(path)
+
+	store, err := openCredentialStore(storeName, path)
+	if err == nil && store != nil {
+		err = store.Delete(credentialKey(path))
+	}
+	if err != nil {
+		return fmt.Errorf("%w: failed removing token from the %s store: %w", ErrCredentialStoreUnavailable, storeName, err)
+	}
+
+	return nil
+}
+
+// MigrateAccessToken moves the access token of the configuration or profile
+// file at path to the named store, and records the store in the file.
+//
+// When the old store can't be read the file is left as it is, so the token
+// isn't lost, and the returned error wraps ErrCredentialStoreUnavailable.
+func MigrateAccessToken(path, to string) error {
+	var s struct {
+		AccessToken     string `yaml:"access_token"`
+		CredentialStore string `yaml:"credential_store"`
+	}
+	if err := unmarshal(path, &s); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	newStore, err := openCredentialStore(to, path)
+	if err != nil {
+		return err
+	}
+
+	from := credentialStoreName(path, s.CredentialStore)
+	token, err := resolveAccessToken(path, s.AccessToken, s.CredentialStore)
+	if err != nil {
+		return err
+	}
+
+	if newStore == nil {
+		if err := set(path, map[string]interface{}{AccessTokenFileKey: token, CredentialStoreFileKey: to}); err != nil {
+			return err
+		}
+	} else {
+		if token != "" {
+			if err := newStore.Set(credentialKey(path), token); err != nil {
+				return fmt.Errorf("failed saving token to the %s store: %w", to, err)
+			}
+		}
+		if err := set(path, map[string]interface{}{AccessTokenFileKey: "", CredentialStoreFileKey: to}); err != nil {
+			return err
+		}
+	}
+
+	if from != to {
+		return deleteAccessToken(path, from)
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/internal/flag/flagctx"
+)
+
+func TestEncryptedFileCredentialStore(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, FileName)
+	profilePath := ProfileFile(configPath, "work")
+
+	t.Setenv(CredentialsPassphraseEnvKey, "correct horse")
+	t.Setenv(ProfileEnvKey, "")
+	t.Setenv(AccessTokenEnvKey, "")
+	t.Setenv(APITokenEnvKey, "")
+
+	require.NoError(t, os.WriteFile(configPath, []byte("access_token: fo1_default\n"), 0o600))
+	require.NoError(t, MigrateAccessToken(configPath, CredentialStoreEncryptedFile))
+
+	raw, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "fo1_default")
+	assert.FileExists(t, filepath.Join(dir, credentialsFileName))
+
+	token, err := ReadAccessToken(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "fo1_default", token)
+
+	ctx := flagctx.NewContext(context.Background(), pflag.NewFlagSet("test", pflag.ContinueOnError))
+	cfg, err := Load(ctx, configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "fo1_default", cfg.Tokens.GraphQL())
+
+	// profiles logged in later use the config file's store
+	require.NoError(t, SetAccessToken(profilePath, "fo1_work"))
+	raw, err = os.ReadFile(profilePath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "fo1_work")
+
+	token, err = ReadAccessToken(profilePath)
+	require.NoError(t, err)
+	assert.Equal(t, "fo1_work", token)
+
+	// tokens read from the store are kept for as long as the file is unchanged
+	t.Setenv(CredentialsPassphraseEnvKey, "wrong")
+	token, err = ReadAccessToken(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "fo1_default", token)
+
+	require.NoError(t, SetLastLogin(configPath, time.Now()))
+	_, err = ReadAccessToken(configPath)
+	assert.Error(t, err)
+	assert.ErrorIs(t, err, ErrCredentialStoreUnavailable)
+
+	// an unreadable store only costs the file's token
+	cfg, err = Load(ctx, configPath)
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Tokens.GraphQL())
+
+	t.Setenv(AccessTokenEnvKey, "fo1_env")
+	cfg, err = Load(ctx, configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "fo1_env", cfg.Tokens.GraphQL())
+	t.Setenv(AccessTokenEnvKey, "")
+
+	t.Setenv(CredentialsPassphraseEnvKey, "correct horse")
+	require.NoError(t, Clear(profilePath))
+	token, err = ReadAccessToken(profilePath)
+	require.NoError(t, err)
+	assert.Empty(t, token)
+
+	require.NoError(t, MigrateAccessToken(configPath, CredentialStoreConfig))
+	raw, err = os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "fo1_default")
+
+	store := encryptedFileStore{path: filepath.Join(dir, credentialsFileName)}
+	tokens, err := store.read()
+	require.NoError(t, err)
+	assert.Empty(t, tokens)
+}
+
+func TestUnavailableCredentialStore(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, FileName)
+
+	t.Setenv(CredentialsPassphraseEnvKey, "correct horse")
+	require.NoError(t, os.WriteFile(configPath, []byte("access_token: fo1_default\n"), 0o600))
+	require.NoError(t, MigrateAccessToken(configPath, CredentialStoreEncryptedFile))
+
+	t.Setenv(CredentialsPassphraseEnvKey, "")
+
+	// logging out clears the file even though the store can't be written
+	err := Clear(configPath)
+	assert.ErrorIs(t, err, ErrCredentialStoreUnavailable)
+	raw, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "fo1_default")
+
+	// migrating away from it leaves the file alone rather than lose its token
+	t.Setenv(CredentialsPassphraseEnvKey, "correct horse")
+	require.NoError(t, SetAccessToken(configPath, "fo1_default"))
+	t.Setenv(CredentialsPassphraseEnvKey, "")
+
+	err = MigrateAccessToken(configPath, CredentialStoreConfig)
+	assert.ErrorIs(t, err, ErrCredentialStoreUnavailable)
+
+	raw, err = os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "credential_store: encrypted-file")
+	assert.NotContains(t, string(raw), "fo1_default")
+
+	t.Setenv(CredentialsPassphraseEnvKey, "correct horse")
+	token, err := ReadAccessToken(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "fo1_default", token)
+}
+
+func TestCredentialStoreName(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, FileName)
+	profilePath := ProfileFile(configPath, "work")
+
+	assert.Equal(t, dir, configDirOf(configPath))
+	assert.Equal(t, dir, configDirOf(profilePath))
+
+	assert.Equal(t, "", credentialStoreName(profilePath, ""))
+
+	require.NoError(t, set(configPath, map[string]interface{}{CredentialStoreFileKey: CredentialStoreKeychain}))
+	assert.Equal(t, CredentialStoreKeychain, credentialStoreName(profilePath, ""))
+	assert.Equal(t, CredentialStoreConfig, credentialStoreName(profilePath, CredentialStoreConfig))
+
+	_, err := openCredentialStore("vault", configPath)
+	assert.Error(t, err)
+}
+
+func TestSecurityCommandLine(t *testing.T) {
+	line, err := securityCommandLine("add-generic-password", "-a", "work profile", "-w", "FlyV1 fm2_abc+/=,def")
+	require.NoError(t, err)
+	assert.Equal(t, `"add-generic-password" "-a" "work profile" "-w" "FlyV1 fm2_abc+/=,def"`+"\n", line)
+
+	for _, arg := range []string{`a"b`, `a\b`, "a\nb"} {
+		_, err := securityCommandLine("-w", arg)
+		assert.Error(t, err, arg)
+	}
+}
@@ -15,22 +15,53 @@ import (
 	"github.com/superfly/flyctl/internal/filemu"
 )
 
+// ReadAccessToken returns the access token of the configuration file found at
+// path, reading it from the credential store the file names, if any.
 func ReadAccessToken(path string) (string, error) {
 	s := struct {
-		AccessToken string `yaml:"access_token"`
+		AccessToken     string `yaml:"access_token"`
+		CredentialStore string `yaml:"credential_store"`
 	}{}
 	if err := unmarshal(path, &s); err != nil {
 		return "", err
 	}
 
-	return s.AccessToken, nil
+	return resolveAccessToken(path, s.AccessToken, s.CredentialStore)
 }
 
 // SetAccessToken sets the value of the access token at the configuration file
-// found at path.
+// found at path, or in the credential store the file names, if any.
 func SetAccessToken(path, token string) error {
+	s := struct {
+		CredentialStore string `yaml:"credential_store"`
+	}{}
+	if err := unmarshal(path, &s); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	forgetAccessToken(path)
+
+	name := credentialStoreName(path, s.CredentialStore)
+	store, err := openCredentialStore(name, path)
+	switch {
+	case err != nil:
+		return err
+	case store == nil:
+		return set(path, map[string]interface{}{
+			AccessTokenFileKey: token,
+		})
+	case token == "":
+		err = store.Delete(credentialKey(path))
+	default:
+		err = store.Set(credentialKey(path), token)
+	}
+	if err != nil {
+		return err
+	}
+
 	return set(path, map[string]interface{}{
-		AccessTokenFileKey: token,
+		AccessTokenFileKey:     "",
+		CredentialStoreFileKey: name,
 	})
 }
 
@@ -42,6 +73,14 @@ func SetLastLogin(path string, timestamp time.Time) error {
 	})
 }
 
+// SetUserEmail sets the email of the logged in user at the configuration file
+// found at path.
+func SetUserEmail(path, email string) error {
+	return set(path, map[string]interface{}{
+		UserEmailFileKey: email,
+	})
+}
+
 // SetMetricsToken sets the value of the metrics token at the configuration file
 // found at path.
 func SetMetricsToken(path, token string) error {
@@ -74,6 +113,25 @@ func SetAutoUpdate(path string, autoUpdate bool) error {
 	})
 }
 
+// ReadWireGuardState returns the WireGuard state of the configuration or
+// profile file found at path.
+func ReadWireGuardState(path string) (wg.States, error) {
+	s := struct {
+		WireGuardState wg.States `yaml:"wire_guard_state"`
+	}{}
+	switch err := unmarshal(path, &s); {
+	case err == nil, os.IsNotExist(err):
+		break
+	default:
+		return nil, err
+	}
+
+	if s.WireGuardState == nil {
+		s.WireGuardState = wg.States{}
+	}
+	return s.WireGuardState, nil
+}
+
 func SetWireGuardState(path string, state wg.States) error {
 	return set(path, map[string]interface{}{
 		WireGuardStateFileKey: state,
@@ -94,16 +152,78 @@ func SetAppSecretsMinvers(path string, minvers AppSecretsMinvers) error {
 	})
 }
 
-// Clear clears the access token, metrics token, last login timestamp, and wireguard-related keys of the configuration
-// file found at path.
-func Clear(path string) (err error) {
+// ProxyForward is a single port forward of a proxy preset.
+type ProxyForward struct {
+	App string `yaml:"app,omitempty"`
+	// Org is only required when App isn't set.
+	Org string `yaml:"org,omitempty"`
+	// Ports is "<local:remote>" or a single port used for both, as accepted by
+	// fly proxy.
+	Ports      string `yaml:"ports"`
+	RemoteHost string `yaml:"remote_host,omitempty"`
+	BindAddr   string `yaml:"bind_addr,omitempty"`
+	Network    string `yaml:"network,omitempty"`
+}
+
+// ProxyPreset is a named set of forwards started together by fly proxy up.
+type ProxyPreset struct {
+	Forwards []ProxyForward `yaml:"forwards"`
+}
+
+type ProxyPresets map[string]ProxyPreset
+
+// ReadProxyPresets returns the proxy presets of the configuration file found
+// at path.
+func ReadProxyPresets(path string) (ProxyPresets, error) {
+	s := struct {
+		ProxyPresets ProxyPresets `yaml:"proxy_presets"`
+	}{}
+	switch err := unmarshal(path, &s); {
+	case err == nil, os.IsNotExist(err):
+		break
+	default:
+		return nil, err
+	}
+
+	if s.ProxyPresets == nil {
+		s.ProxyPresets = ProxyPresets{}
+	}
+	return s.ProxyPresets, nil
+}
+
+// SetProxyPresets sets the proxy presets of the configuration file found at
+// path.
+func SetProxyPresets(path string, presets ProxyPresets) error {
 	return set(path, map[string]interface{}{
+		ProxyPresetsFileKey: presets,
+	})
+}
+
+// Clear clears the access token, the metrics token, last login timestamp, user
+// email and wireguard-related keys of the configuration file found at path, then removes
+// the access token from its credential store, if any. The file is cleared even
+// when the store is unavailable; the returned error then wraps
+// ErrCredentialStoreUnavailable.
+func Clear(path string) (err error) {
+	s := struct {
+		CredentialStore string `yaml:"credential_store"`
+	}{}
+	if err = unmarshal(path, &s); err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	if err = set(path, map[string]interface{}{
 		AccessTokenFileKey:      "",
 		MetricsTokenFileKey:     "",
 		LastLoginFileKey:        time.Time{}, // Zero value for time.Time
+		UserEmailFileKey:        "",
 		WireGuardStateFileKey:   map[string]interface{}{},
 		AppSecretsMinverFileKey: AppSecretsMinvers{},
-	})
+	}); err != nil {
+		return
+	}
+
+	return deleteAccessToken(path, credentialStoreName(path, s.CredentialStore))
 }
 
 func set(path string, vals map[string]interface{}) error {
@@ -120,6 +240,11 @@ func set(path string, vals map[string]interface{}) error {
 		m[k] = v
 	}
 
+	// profile files live in a directory of their own
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
 	return marshal(path, m)
 }
 
@@ -0,0 +1,237 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultProfile is the profile kept at the top level of the config file,
+	// as it was before there were profiles.
+	DefaultProfile = "default"
+
+	ProfileEnvKey          = "FLY_PROFILE"
+	CurrentProfileFileKey  = "current_profile"
+	OrganizationFileKey    = "organization"
+	RegionFileKey          = "region"
+	profilesDirName        = "profiles"
+	projectProfileFileName = "profile"
+)
+
+// ProfileSource denotes what selected the active profile.
+type ProfileSource string
+
+const (
+	ProfileFromFlag    ProfileSource = "--profile"
+	ProfileFromEnv     ProfileSource = ProfileEnvKey
+	ProfileFromProject ProfileSource = ".fly/profile"
+	ProfileFromConfig  ProfileSource = CurrentProfileFileKey
+	ProfileFromDefault ProfileSource = "default"
+)
+
+var profileNamePat = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidateProfileName returns an error if name can't be used as a profile
+// name.
+func ValidateProfileName(name string) error {
+	if !profileNamePat.MatchString(name) || len(name) > 64 {
+		return fmt.Errorf("invalid profile name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	return nil
+}
+
+// ProfileFile returns the file holding the tokens, defaults and WireGuard
+// state of the named profile. The default profile lives in the config file
+// itself.
+func ProfileFile(configPath, profile string) string {
+	if profile == "" || profile == DefaultProfile {
+		return configPath
+	}
+	return filepath.Join(filepath.Dir(configPath), profilesDirName, profile+".yml")
+}
+
+// ProfileExists reports whether the named profile has been created, by
+// logging in with it.
+func ProfileExists(configPath, profile string) bool {
+	if profile == DefaultProfile {
+		return true
+	}
+	_, err := os.Stat(ProfileFile(configPath, profile))
+	return err == nil
+}
+
+// ListProfiles returns the names of the profiles next to the config file,
+// the default profile first.
+func ListProfiles(configPath string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(filepath.Dir(configPath), profilesDirName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".yml")
+		if ok && !entry.IsDir() && ValidateProfileName(name) == nil && name != DefaultProfile {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return append([]string{DefaultProfile}, names...), nil
+}
+
+// ResolveProfile picks the active profile: the --profile flag, FLY_PROFILE,
+// a .fly/profile file in the working directory or one of its parents, the
+// config file's current_profile, and finally the default profile.
+func ResolveProfile(flagValue, configPath, workingDir string) (string, ProfileSource, error) {
+	name, source := flagValue, ProfileFromFlag
+
+	if name == "" {
+		name, source = os.Getenv(ProfileEnvKey), ProfileFromEnv
+	}
+
+	if name == "" && workingDir != "" {
+		var err error
+		if name, err = readProjectProfile(workingDir); err != nil {
+			return "", "", err
+		}
+		source = ProfileFromProject
+	}
+
+	if name == "" {
+		var s struct {
+			CurrentProfile string `yaml:"current_profile"`
+		}
+		if err := unmarshal(configPath, &s); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", "", err
+		}
+		name, source = s.CurrentProfile, ProfileFromConfig
+	}
+
+	if name == "" {
+		return DefaultProfile, ProfileFromDefault, nil
+	}
+
+	if err := ValidateProfileName(name); err != nil {
+		return "", "", fmt.Errorf("%s: %w", source, err)
+	}
+
+	return name, source, nil
+}
+
+// ProjectProfilePath returns where a project directory names its profile.
+func ProjectProfilePath(dir string) string {
+	return filepath.Join(dir, ".fly", projectProfileFileName)
+}
+
+// projectProfiles keeps the profile readProjectProfile found for each
+// directory, so that the parent directories are only walked once per process.
+var projectProfiles = struct {
+	sync.Mutex
+	m map[string]string
+}{m: map[string]string{}}
+
+func readProjectProfile(dir string) (string, error) {
+	projectProfiles.Lock()
+	defer projectProfiles.Unlock()
+
+	if name, ok := projectProfiles.m[dir]; ok {
+		return name, nil
+	}
+
+	name, err := findProjectProfile(dir)
+	if err != nil {
+		return "", err
+	}
+
+	projectProfiles.m[dir] = name
+	return name, nil
+}
+
+func findProjectProfile(dir string) (string, error) {
+	for {
+		b, err := os.ReadFile(ProjectProfilePath(dir))
+		switch {
+		case err == nil:
+			return strings.TrimSpace(string(b)), nil
+		case !errors.Is(err, fs.ErrNotExist):
+			return "", err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// SetProjectProfile makes the project directory dir, and the directories in
+// it, use the named profile.
+func SetProjectProfile(dir, profile string) error {
+	path := ProjectProfilePath(dir)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(profile+"\n"), 0o644); err != nil {
+		return err
+	}
+
+	projectProfiles.Lock()
+	defer projectProfiles.Unlock()
+
+	clear(projectProfiles.m)
+	return nil
+}
+
+// SetCurrentProfile sets the profile used when nothing else selects one in
+// the configuration file found at path.
+func SetCurrentProfile(path, profile string) error {
+	if profile == DefaultProfile {
+		profile = ""
+	}
+	return set(path, map[string]interface{}{
+		CurrentProfileFileKey: profile,
+	})
+}
+
+// SetProfileDefaults sets the default organization and region of the profile
+// file found at path. Empty values are left as they are.
+func SetProfileDefaults(path, org, region string) error {
+	vals := map[string]interface{}{}
+	if org != "" {
+		vals[OrganizationFileKey] = org
+	}
+	if region != "" {
+		vals[RegionFileKey] = region
+	}
+	if len(vals) == 0 {
+		return nil
+	}
+
+	return set(path, vals)
+}
+
+// ProfileSummary is what a profile file says about its account.
+type ProfileSummary struct {
+	Organization string    `yaml:"organization"`
+	Region       string    `yaml:"region"`
+	LastLogin    time.Time `yaml:"last_login"`
+}
+
+// ReadProfileSummary reads the profile file found at path.
+func ReadProfileSummary(path string) (*ProfileSummary, error) {
+	var s ProfileSummary
+	if err := unmarshal(path, &s); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return &s, nil
+}
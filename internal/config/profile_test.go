@@ -0,0 +1,124 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/internal/flag/flagctx"
+)
+
+func TestResolveProfile(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, FileName)
+	project := filepath.Join(dir, "project")
+	nested := filepath.Join(project, "web", "src")
+
+	require.NoError(t, os.MkdirAll(nested, 0o755))
+	require.NoError(t, SetCurrentProfile(configPath, "personal"))
+
+	t.Setenv(ProfileEnvKey, "")
+
+	name, source, err := ResolveProfile("", configPath, nested)
+	require.NoError(t, err)
+	assert.Equal(t, "personal", name)
+	assert.Equal(t, ProfileFromConfig, source)
+
+	require.NoError(t, SetProjectProfile(project, "client"))
+
+	name, source, err = ResolveProfile("", configPath, nested)
+	require.NoError(t, err)
+	assert.Equal(t, "client", name)
+	assert.Equal(t, ProfileFromProject, source)
+
+	// the parent directories are only walked once
+	require.NoError(t, os.WriteFile(ProjectProfilePath(project), []byte("other\n"), 0o644))
+	name, _, err = ResolveProfile("", configPath, nested)
+	require.NoError(t, err)
+	assert.Equal(t, "client", name)
+
+	t.Setenv(ProfileEnvKey, "ci")
+
+	name, source, err = ResolveProfile("", configPath, nested)
+	require.NoError(t, err)
+	assert.Equal(t, "ci", name)
+	assert.Equal(t, ProfileFromEnv, source)
+
+	name, source, err = ResolveProfile("work", configPath, nested)
+	require.NoError(t, err)
+	assert.Equal(t, "work", name)
+	assert.Equal(t, ProfileFromFlag, source)
+
+	_, _, err = ResolveProfile("../work", configPath, nested)
+	assert.Error(t, err)
+
+	t.Setenv(ProfileEnvKey, "")
+	require.NoError(t, SetCurrentProfile(configPath, DefaultProfile))
+
+	name, source, err = ResolveProfile("", configPath, dir)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultProfile, name)
+	assert.Equal(t, ProfileFromDefault, source)
+}
+
+func TestListProfiles(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, FileName)
+
+	assert.Equal(t, configPath, ProfileFile(configPath, DefaultProfile))
+	assert.Equal(t, filepath.Join(dir, "profiles", "work.yml"), ProfileFile(configPath, "work"))
+
+	profiles, err := ListProfiles(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{DefaultProfile}, profiles)
+
+	require.NoError(t, SetProfileDefaults(ProfileFile(configPath, "work"), "acme", "ord"))
+	require.NoError(t, SetProfileDefaults(ProfileFile(configPath, "client"), "", "ams"))
+
+	profiles, err = ListProfiles(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{DefaultProfile, "client", "work"}, profiles)
+	assert.True(t, ProfileExists(configPath, "work"))
+	assert.False(t, ProfileExists(configPath, "other"))
+
+	summary, err := ReadProfileSummary(ProfileFile(configPath, "work"))
+	require.NoError(t, err)
+	assert.Equal(t, "acme", summary.Organization)
+	assert.Equal(t, "ord", summary.Region)
+}
+
+func TestLoadProfile(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, FileName)
+
+	t.Setenv(ProfileEnvKey, "")
+	t.Setenv(AccessTokenEnvKey, "")
+	t.Setenv(APITokenEnvKey, "")
+
+	require.NoError(t, os.WriteFile(configPath, []byte("access_token: fo1_default\norganization: personal\ncurrent_profile: work\n"), 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "profiles"), 0o700))
+	require.NoError(t, os.WriteFile(ProfileFile(configPath, "work"), []byte("access_token: fo1_work\norganization: acme\nregion: ord\n"), 0o600))
+
+	ctx := flagctx.NewContext(context.Background(), pflag.NewFlagSet("test", pflag.ContinueOnError))
+
+	cfg, err := Load(ctx, configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "work", cfg.Profile)
+	assert.Equal(t, ProfileFile(configPath, "work"), cfg.ProfileFile)
+	assert.Equal(t, "fo1_work", cfg.Tokens.GraphQL())
+	assert.Equal(t, ProfileFile(configPath, "work"), cfg.Tokens.FromFile())
+	assert.Equal(t, "acme", cfg.Organization)
+	assert.Equal(t, "ord", cfg.Region)
+
+	t.Setenv(ProfileEnvKey, DefaultProfile)
+
+	cfg, err = Load(ctx, configPath)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultProfile, cfg.Profile)
+	assert.Equal(t, "fo1_default", cfg.Tokens.GraphQL())
+	assert.Equal(t, "personal", cfg.Organization)
+}
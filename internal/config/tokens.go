@@ -29,7 +29,8 @@ type UserURLCallback func(ctx context.Context, url string) error
 // goroutine, it continues to keep the tokens updated and fresh. The call to
 // MonitorTokens will return as soon as the tokens are ready for use and the
 // background job will run until the context is cancelled. Token updates include
-//   - Keeping the tokens synced with the config file.
+//   - Keeping the tokens synced with the config file, or the credential store
+//     it names.
 //   - Refreshing any expired discharge tokens.
 //   - Pruning expired or invalid token.
 //   - Fetching macaroons for any organizations the user has been added to.
@@ -15,7 +15,8 @@ import (
 type ComposeService struct {
 	Image       string                 `yaml:"image"`
 	Build       interface{}            `yaml:"build"`
-	Environment map[string]string      `yaml:"environment"`
+	Environment ComposeEnvironment     `yaml:"environment"`
+	EnvFile     interface{}            `yaml:"env_file"`
 	Volumes     []string               `yaml:"volumes"`
 	Ports       []string               `yaml:"ports"`
 	Command     interface{}            `yaml:"command"`
@@ -31,6 +32,44 @@ type ComposeService struct {
 	Extra       map[string]interface{} `yaml:",inline"`
 }
 
+// ComposeEnvironment holds a service's environment variables. Compose allows
+// both the map syntax and the list syntax ("KEY=value").
+type ComposeEnvironment map[string]string
+
+// UnmarshalYAML accepts both the map and list forms of environment
+func (e *ComposeEnvironment) UnmarshalYAML(value *yaml.Node) error {
+	env := ComposeEnvironment{}
+
+	switch value.Kind {
+	case yaml.MappingNode:
+		var m map[string]*string
+		if err := value.Decode(&m); err != nil {
+			return err
+		}
+		for k, v := range m {
+			if v != nil {
+				env[k] = *v
+			} else {
+				env[k] = ""
+			}
+		}
+	case yaml.SequenceNode:
+		var list []string
+		if err := value.Decode(&list); err != nil {
+			return err
+		}
+		for _, entry := range list {
+			k, v, _ := strings.Cut(entry, "=")
+			env[k] = v
+		}
+	default:
+		return fmt.Errorf("invalid environment format")
+	}
+
+	*e = env
+	return nil
+}
+
 // ComposeDependency represents a service dependency with conditions
 type ComposeDependency struct {
 	Condition string `yaml:"condition"`
@@ -0,0 +1,544 @@
+package containerconfig
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/shlex"
+)
+
+// ManagedServiceKind identifies compose services that are replaced by a Fly
+// managed offering rather than being run as an app or process group
+type ManagedServiceKind string
+
+const (
+	ManagedServicePostgres ManagedServiceKind = "postgres"
+	ManagedServiceRedis    ManagedServiceKind = "redis"
+)
+
+var managedServiceImages = map[string]ManagedServiceKind{
+	"postgres":              ManagedServicePostgres,
+	"postgis/postgis":       ManagedServicePostgres,
+	"bitnami/postgresql":    ManagedServicePostgres,
+	"timescale/timescaledb": ManagedServicePostgres,
+	"redis":                 ManagedServiceRedis,
+	"redis/redis-stack":     ManagedServiceRedis,
+	"bitnami/redis":         ManagedServiceRedis,
+	"valkey/valkey":         ManagedServiceRedis,
+}
+
+var (
+	secretEnvPattern   = regexp.MustCompile(`(?i)(password|passwd|secret|token|api_?key|private_?key|credentials?)`)
+	invalidVolumeChars = regexp.MustCompile(`[^a-z0-9_]`)
+)
+
+// ComposeConversion is the result of translating a compose file into Fly
+// concepts, independent of whether services end up as process groups of a
+// single app or as separate apps.
+type ComposeConversion struct {
+	// Services holds the services that run on Fly, in deploy order
+	Services []*ConvertedService
+	// Managed holds the services replaced by managed offerings
+	Managed []*ManagedService
+	// Untranslated lists the parts of the compose file that have no Fly
+	// equivalent and need manual attention
+	Untranslated []string
+}
+
+// ConvertedService is a compose service translated into Fly concepts
+type ConvertedService struct {
+	Name string
+	// Image is the image to run; empty when the service is built from source
+	Image      string
+	Build      bool
+	Dockerfile string
+	Command    string
+	Env        map[string]string
+	Secrets    map[string]string
+	Mounts     []ConvertedMount
+	Files      []ConvertedFile
+	Ports      []ConvertedPort
+	DependsOn  []string
+}
+
+// ConvertedMount is a named compose volume mounted into a service
+type ConvertedMount struct {
+	Source      string
+	Destination string
+}
+
+// ConvertedFile is a single file bind-mounted into a service
+type ConvertedFile struct {
+	LocalPath string
+	GuestPath string
+}
+
+// ConvertedPort is a published compose port
+type ConvertedPort struct {
+	InternalPort int
+	ExternalPort int
+	Protocol     string
+}
+
+// ManagedService is a compose service replaced by a managed offering
+type ManagedService struct {
+	Name  string
+	Kind  ManagedServiceKind
+	Image string
+	// Volume is the named volume holding the service's data, if any
+	Volume string
+}
+
+// MigrationNote describes how to move a managed service's data across
+func (m *ManagedService) MigrationNote() string {
+	switch m.Kind {
+	case ManagedServicePostgres:
+		note := fmt.Sprintf("service %q (%s) is replaced by Fly Managed Postgres; DATABASE_URL is set as a secret when the cluster is attached", m.Name, m.Image)
+		if m.Volume != "" {
+			note += fmt.Sprintf(". Move existing data from volume %q with pg_dump and restore it through 'fly mpg connect'", m.Volume)
+		}
+		return note
+	case ManagedServiceRedis:
+		note := fmt.Sprintf("service %q (%s) is replaced by Upstash Redis; REDIS_URL is set as a secret when the database is attached", m.Name, m.Image)
+		if m.Volume != "" {
+			note += fmt.Sprintf(". Data in volume %q is not migrated", m.Volume)
+		}
+		return note
+	}
+	return ""
+}
+
+// LoadComposeFile reads and parses a Docker Compose file
+func LoadComposeFile(composePath string) (*ComposeFile, error) {
+	return parseComposeFile(composePath)
+}
+
+// ConvertCompose translates a compose file into services, managed services
+// and a list of untranslated settings. Services are returned in an order
+// that honors depends_on.
+func ConvertCompose(compose *ComposeFile, composePath string) (*ComposeConversion, error) {
+	if len(compose.Services) == 0 {
+		return nil, fmt.Errorf("no services defined in compose file")
+	}
+
+	order, err := composeDeployOrder(compose)
+	if err != nil {
+		return nil, err
+	}
+
+	conv := &ComposeConversion{}
+	managedHosts := map[string]ManagedServiceKind{}
+	for _, name := range order {
+		service := compose.Services[name]
+		if kind, ok := managedServiceKind(service.Image); ok {
+			managed := &ManagedService{Name: name, Kind: kind, Image: service.Image}
+			for _, vol := range service.Volumes {
+				if source, _, ok := namedVolume(vol, compose); ok {
+					managed.Volume = source
+					break
+				}
+			}
+			conv.Managed = append(conv.Managed, managed)
+			managedHosts[name] = kind
+		}
+	}
+
+	composeDir := filepath.Dir(composePath)
+	for _, name := range order {
+		if _, ok := managedHosts[name]; ok {
+			continue
+		}
+		converted, notes, err := convertService(name, compose.Services[name], compose, composeDir, managedHosts)
+		if err != nil {
+			return nil, err
+		}
+		conv.Services = append(conv.Services, converted)
+		conv.Untranslated = append(conv.Untranslated, notes...)
+	}
+	if len(conv.Services) == 0 {
+		return nil, fmt.Errorf("compose file only has managed services, nothing to launch")
+	}
+
+	for _, key := range sortedKeys(compose.Networks) {
+		conv.Untranslated = append(conv.Untranslated, fmt.Sprintf("network %q: apps on Fly share a private network, services reach each other over .internal DNS", key))
+	}
+	for _, key := range sortedKeys(compose.Configs) {
+		conv.Untranslated = append(conv.Untranslated, fmt.Sprintf("config %q: add it to [[files]] in fly.toml", key))
+	}
+	for _, key := range sortedKeys(compose.Secrets) {
+		conv.Untranslated = append(conv.Untranslated, fmt.Sprintf("secret %q: set it with 'fly secrets set'", key))
+	}
+
+	return conv, nil
+}
+
+func convertService(name string, service ComposeService, compose *ComposeFile, composeDir string, managedHosts map[string]ManagedServiceKind) (*ConvertedService, []string, error) {
+	var notes []string
+	note := func(format string, args ...interface{}) {
+		notes = append(notes, fmt.Sprintf("service %q: ", name)+fmt.Sprintf(format, args...))
+	}
+
+	converted := &ConvertedService{
+		Name:    name,
+		Image:   service.Image,
+		Env:     map[string]string{},
+		Secrets: map[string]string{},
+	}
+
+	if service.Build != nil {
+		converted.Image = ""
+		converted.Build = true
+		context, dockerfile := parseBuild(service.Build)
+		if context != "" && filepath.Clean(context) != "." {
+			note("build context %q isn't supported, the app is built from the directory containing fly.toml", context)
+		}
+		converted.Dockerfile = dockerfile
+	} else if service.Image == "" {
+		return nil, nil, fmt.Errorf("service '%s' must specify either 'image' or 'build'", name)
+	}
+
+	if service.Entrypoint != nil {
+		note("entrypoint isn't translated, bake it into the image")
+	}
+	converted.Command = commandString(service.Command)
+
+	env := map[string]string{}
+	envFiles, err := loadEnvFiles(service.EnvFile, composeDir)
+	if err != nil {
+		note("%v", err)
+	}
+	for k, v := range envFiles {
+		env[k] = v
+	}
+	for k, v := range service.Environment {
+		env[k] = v
+	}
+	for _, k := range sortedKeys(env) {
+		v := env[k]
+		if host, kind, ok := referencesManaged(v, managedHosts); ok {
+			note("%s points at %q, which is replaced by managed %s; it is dropped in favor of the attached secret", k, host, kind)
+			continue
+		}
+		if secretEnvPattern.MatchString(k) {
+			converted.Secrets[k] = v
+		} else {
+			converted.Env[k] = v
+		}
+	}
+
+	for _, vol := range service.Volumes {
+		if source, dest, ok := namedVolume(vol, compose); ok {
+			if len(converted.Mounts) > 0 {
+				note("volume %q not mounted, Fly Machines support a single volume", source)
+				continue
+			}
+			converted.Mounts = append(converted.Mounts, ConvertedMount{Source: volumeName(source), Destination: dest})
+			continue
+		}
+
+		hostPath, containerPath, _ := parseVolume(vol)
+		if hostPath == "" {
+			note("anonymous volume %q isn't translated", containerPath)
+			continue
+		}
+		localPath := hostPath
+		if !filepath.IsAbs(localPath) {
+			localPath = filepath.Join(composeDir, localPath)
+		}
+		if info, err := os.Stat(localPath); err == nil && info.Mode().IsRegular() {
+			converted.Files = append(converted.Files, ConvertedFile{LocalPath: hostPath, GuestPath: containerPath})
+			continue
+		}
+		note("bind mount %q isn't translated, copy the directory into the image instead", vol)
+	}
+
+	for _, p := range service.Ports {
+		port, err := parsePort(p)
+		if err != nil {
+			note("%v", err)
+			continue
+		}
+		converted.Ports = append(converted.Ports, port)
+	}
+
+	deps, err := parseDependsOn(service.DependsOn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse dependencies for service '%s': %w", name, err)
+	}
+	for _, dep := range sortedKeys(deps.Dependencies) {
+		if _, ok := managedHosts[dep]; ok {
+			continue
+		}
+		converted.DependsOn = append(converted.DependsOn, dep)
+	}
+
+	if service.Healthcheck != nil {
+		note("healthcheck isn't translated, add a check to [[services]] or [checks]")
+	}
+	if service.User != "" {
+		note("user isn't translated")
+	}
+	if service.WorkingDir != "" {
+		note("working_dir isn't translated")
+	}
+	if len(service.Configs) > 0 {
+		note("configs aren't translated")
+	}
+	if len(service.Secrets) > 0 {
+		note("secrets aren't translated, set them with 'fly secrets set'")
+	}
+	if len(service.Deploy) > 0 {
+		note("deploy isn't translated, use 'fly scale' instead")
+	}
+	for _, key := range sortedKeys(service.Extra) {
+		note("%s isn't translated", key)
+	}
+
+	return converted, notes, nil
+}
+
+// composeDeployOrder sorts services so that every service comes after the
+// services it depends on. Ties are broken alphabetically.
+func composeDeployOrder(compose *ComposeFile) ([]string, error) {
+	dependencies := map[string][]string{}
+	for name, service := range compose.Services {
+		deps, err := parseDependsOn(service.DependsOn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse dependencies for service '%s': %w", name, err)
+		}
+		for dep := range deps.Dependencies {
+			if _, ok := compose.Services[dep]; !ok {
+				return nil, fmt.Errorf("service '%s' depends on undefined service '%s'", name, dep)
+			}
+			dependencies[name] = append(dependencies[name], dep)
+		}
+	}
+
+	var (
+		order   []string
+		visited = map[string]bool{}
+		visit   func(name string, path []string) error
+	)
+	visit = func(name string, path []string) error {
+		if slices.Contains(path, name) {
+			return fmt.Errorf("circular dependency between services: %s", strings.Join(append(path, name), " -> "))
+		}
+		if visited[name] {
+			return nil
+		}
+		deps := dependencies[name]
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		visited[name] = true
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range sortedKeys(compose.Services) {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+func managedServiceKind(image string) (ManagedServiceKind, bool) {
+	if image == "" {
+		return "", false
+	}
+	name, _, _ := strings.Cut(image, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	name = strings.TrimPrefix(name, "docker.io/")
+	name = strings.TrimPrefix(name, "library/")
+	kind, ok := managedServiceImages[name]
+	return kind, ok
+}
+
+// namedVolume reports whether a service volume refers to a named volume, as
+// opposed to a bind mount or an anonymous volume
+func namedVolume(volume string, compose *ComposeFile) (source, dest string, ok bool) {
+	hostPath, containerPath, _ := parseVolume(volume)
+	if hostPath == "" || strings.ContainsAny(hostPath[:1], "./~$") {
+		return "", "", false
+	}
+	if _, declared := compose.Volumes[hostPath]; !declared && strings.Contains(hostPath, "/") {
+		return "", "", false
+	}
+	return hostPath, containerPath, true
+}
+
+// volumeName turns a compose volume name into a valid Fly volume name
+func volumeName(name string) string {
+	name = strings.ToLower(name)
+	name = invalidVolumeChars.ReplaceAllString(name, "_")
+	if len(name) > 30 {
+		name = name[:30]
+	}
+	return name
+}
+
+func parseBuild(build interface{}) (context, dockerfile string) {
+	switch b := build.(type) {
+	case string:
+		return b, ""
+	case map[string]interface{}:
+		if c, ok := b["context"].(string); ok {
+			context = c
+		}
+		if d, ok := b["dockerfile"].(string); ok {
+			dockerfile = d
+		}
+	}
+	return context, dockerfile
+}
+
+func commandString(command interface{}) string {
+	switch cmd := command.(type) {
+	case string:
+		return cmd
+	case []interface{}:
+		parts := make([]string, 0, len(cmd))
+		for _, c := range cmd {
+			if str, ok := c.(string); ok {
+				parts = append(parts, shellQuote(str))
+			}
+		}
+		return strings.Join(parts, " ")
+	}
+	return ""
+}
+
+func shellQuote(s string) string {
+	if s == "" {
+		return `""`
+	}
+	if parts, err := shlex.Split(s); err == nil && len(parts) == 1 && parts[0] == s {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// parsePort parses a compose short syntax port: [[IP:]HOST:]CONTAINER[/PROTOCOL]
+func parsePort(port string) (ConvertedPort, error) {
+	spec, protocol, _ := strings.Cut(port, "/")
+	if protocol == "" {
+		protocol = "tcp"
+	}
+
+	parts := strings.Split(spec, ":")
+	if len(parts) == 3 && (parts[0] == "127.0.0.1" || parts[0] == "localhost") {
+		return ConvertedPort{}, fmt.Errorf("port %q is bound to localhost and isn't published", port)
+	}
+
+	container := parts[len(parts)-1]
+	host := container
+	if len(parts) > 1 {
+		host = parts[len(parts)-2]
+	}
+
+	internal, err := strconv.Atoi(container)
+	if err != nil {
+		return ConvertedPort{}, fmt.Errorf("port %q isn't translated, port ranges aren't supported", port)
+	}
+	external, err := strconv.Atoi(host)
+	if err != nil {
+		return ConvertedPort{}, fmt.Errorf("port %q isn't translated, port ranges aren't supported", port)
+	}
+
+	return ConvertedPort{InternalPort: internal, ExternalPort: external, Protocol: protocol}, nil
+}
+
+// loadEnvFiles reads the env_file entries of a service, relative to the
+// compose file's directory
+func loadEnvFiles(envFile interface{}, composeDir string) (map[string]string, error) {
+	var paths []string
+	switch f := envFile.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		paths = []string{f}
+	case []interface{}:
+		for _, entry := range f {
+			switch e := entry.(type) {
+			case string:
+				paths = append(paths, e)
+			case map[string]interface{}:
+				if p, ok := e["path"].(string); ok {
+					paths = append(paths, p)
+				}
+			}
+		}
+	}
+
+	env := map[string]string{}
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(composeDir, path)
+		}
+		if err := readEnvFile(path, env); err != nil {
+			return env, fmt.Errorf("could not read env_file %s: %w", path, err)
+		}
+	}
+	return env, nil
+}
+
+func readEnvFile(path string, env map[string]string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, _ := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		v = strings.TrimSpace(v)
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		env[strings.TrimSpace(k)] = v
+	}
+	return scanner.Err()
+}
+
+// referencesManaged reports whether an environment value refers to a service
+// replaced by a managed offering, such as postgres://user@db:5432/app
+func referencesManaged(value string, managedHosts map[string]ManagedServiceKind) (string, ManagedServiceKind, bool) {
+	for _, host := range sortedKeys(managedHosts) {
+		if ServiceHostPattern(host).MatchString(value) {
+			return host, managedHosts[host], true
+		}
+	}
+	return "", "", false
+}
+
+// ServiceHostPattern matches a compose service name used as a hostname
+func ServiceHostPattern(service string) *regexp.Regexp {
+	return regexp.MustCompile(`(^|[/@])` + regexp.QuoteMeta(service) + `(:\d+|/|$)`)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
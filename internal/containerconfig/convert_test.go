@@ -0,0 +1,222 @@
+package containerconfig
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeCompose(t *testing.T, content string) (*ComposeFile, string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	composePath := filepath.Join(tmpDir, "compose.yml")
+	if err := os.WriteFile(composePath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test compose file: %v", err)
+	}
+	compose, err := LoadComposeFile(composePath)
+	if err != nil {
+		t.Fatalf("Failed to parse compose file: %v", err)
+	}
+	return compose, composePath
+}
+
+func TestConvertCompose(t *testing.T) {
+	compose, composePath := writeCompose(t, `services:
+  web:
+    build: .
+    command: ["bin/rails", "server"]
+    ports:
+      - "80:3000"
+      - "127.0.0.1:9000:9000"
+    environment:
+      - RAILS_ENV=production
+      - SECRET_KEY_BASE=abc
+      - DATABASE_URL=postgres://app:pw@db:5432/app
+      - API_URL=http://api:4000
+    env_file: .env
+    volumes:
+      - uploads:/app/uploads
+      - ./config/app.yml:/app/config/app.yml
+      - ./public:/app/public
+    depends_on:
+      db:
+        condition: service_healthy
+      api:
+        condition: service_started
+    healthcheck:
+      test: ["CMD", "curl", "-f", "http://localhost:3000"]
+  api:
+    image: example/api:1.2
+    ports:
+      - "4000"
+    depends_on: [cache]
+  db:
+    image: postgres:16
+    volumes:
+      - pgdata:/var/lib/postgresql/data
+  cache:
+    image: redis:7-alpine
+volumes:
+  uploads:
+  pgdata:
+networks:
+  backend:
+`)
+	dir := filepath.Dir(composePath)
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte("# comment\nLOG_LEVEL=\"debug\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "config"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config", "app.yml"), []byte("a: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conv, err := ConvertCompose(compose, composePath)
+	if err != nil {
+		t.Fatalf("ConvertCompose failed: %v", err)
+	}
+
+	if len(conv.Services) != 2 || conv.Services[0].Name != "api" || conv.Services[1].Name != "web" {
+		t.Fatalf("Expected services api, web in deploy order, got %+v", conv.Services)
+	}
+	if len(conv.Managed) != 2 {
+		t.Fatalf("Expected 2 managed services, got %d", len(conv.Managed))
+	}
+	for _, m := range conv.Managed {
+		switch m.Name {
+		case "db":
+			if m.Kind != ManagedServicePostgres || m.Volume != "pgdata" {
+				t.Errorf("Unexpected managed service %+v", m)
+			}
+			if !strings.Contains(m.MigrationNote(), "pg_dump") {
+				t.Errorf("Expected migration note to mention pg_dump, got %q", m.MigrationNote())
+			}
+		case "cache":
+			if m.Kind != ManagedServiceRedis {
+				t.Errorf("Unexpected managed service %+v", m)
+			}
+		}
+	}
+
+	api := conv.Services[0]
+	if !reflect.DeepEqual(api.Ports, []ConvertedPort{{InternalPort: 4000, ExternalPort: 4000, Protocol: "tcp"}}) {
+		t.Errorf("Unexpected api ports %+v", api.Ports)
+	}
+	if len(api.DependsOn) != 0 {
+		t.Errorf("Expected dependency on managed cache to be dropped, got %v", api.DependsOn)
+	}
+
+	web := conv.Services[1]
+	if !web.Build || web.Image != "" {
+		t.Errorf("Expected web to be built, got %+v", web)
+	}
+	if web.Command != "bin/rails server" {
+		t.Errorf("Unexpected command %q", web.Command)
+	}
+	expectedEnv := map[string]string{"RAILS_ENV": "production", "API_URL": "http://api:4000", "LOG_LEVEL": "debug"}
+	if !reflect.DeepEqual(web.Env, expectedEnv) {
+		t.Errorf("Unexpected env %v", web.Env)
+	}
+	if !reflect.DeepEqual(web.Secrets, map[string]string{"SECRET_KEY_BASE": "abc"}) {
+		t.Errorf("Unexpected secrets %v", web.Secrets)
+	}
+	if !reflect.DeepEqual(web.Mounts, []ConvertedMount{{Source: "uploads", Destination: "/app/uploads"}}) {
+		t.Errorf("Unexpected mounts %+v", web.Mounts)
+	}
+	if !reflect.DeepEqual(web.Files, []ConvertedFile{{LocalPath: "./config/app.yml", GuestPath: "/app/config/app.yml"}}) {
+		t.Errorf("Unexpected files %+v", web.Files)
+	}
+	if !reflect.DeepEqual(web.Ports, []ConvertedPort{{InternalPort: 3000, ExternalPort: 80, Protocol: "tcp"}}) {
+		t.Errorf("Unexpected web ports %+v", web.Ports)
+	}
+	if !reflect.DeepEqual(web.DependsOn, []string{"api"}) {
+		t.Errorf("Unexpected depends_on %v", web.DependsOn)
+	}
+
+	report := strings.Join(conv.Untranslated, "\n")
+	for _, expected := range []string{
+		`DATABASE_URL points at "db"`,
+		`port "127.0.0.1:9000:9000" is bound to localhost`,
+		`bind mount "./public:/app/public"`,
+		`service "web": healthcheck`,
+		`network "backend"`,
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, report)
+		}
+	}
+}
+
+func TestComposeDeployOrderCycle(t *testing.T) {
+	compose, composePath := writeCompose(t, `services:
+  a:
+    image: a
+    depends_on: [b]
+  b:
+    image: b
+    depends_on: [a]
+`)
+	_, err := ConvertCompose(compose, composePath)
+	if err == nil || !strings.Contains(err.Error(), "circular dependency") {
+		t.Fatalf("Expected circular dependency error, got %v", err)
+	}
+}
+
+func TestConvertComposeOnlyManaged(t *testing.T) {
+	compose, composePath := writeCompose(t, `services:
+  db:
+    image: postgres:16
+    volumes:
+      - pgdata:/var/lib/postgresql/data
+volumes:
+  pgdata:
+`)
+	_, err := ConvertCompose(compose, composePath)
+	if err == nil || !strings.Contains(err.Error(), "only has managed services") {
+		t.Fatalf("Expected managed-only error, got %v", err)
+	}
+}
+
+func TestParsePort(t *testing.T) {
+	tests := []struct {
+		port     string
+		expected ConvertedPort
+		wantErr  bool
+	}{
+		{"8080", ConvertedPort{InternalPort: 8080, ExternalPort: 8080, Protocol: "tcp"}, false},
+		{"80:8080", ConvertedPort{InternalPort: 8080, ExternalPort: 80, Protocol: "tcp"}, false},
+		{"0.0.0.0:53:53/udp", ConvertedPort{InternalPort: 53, ExternalPort: 53, Protocol: "udp"}, false},
+		{"127.0.0.1:5432:5432", ConvertedPort{}, true},
+		{"8000-8010:8000-8010", ConvertedPort{}, true},
+	}
+
+	for _, tt := range tests {
+		port, err := parsePort(tt.port)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePort(%q) error = %v, wantErr %v", tt.port, err, tt.wantErr)
+			continue
+		}
+		if port != tt.expected {
+			t.Errorf("parsePort(%q) = %+v, want %+v", tt.port, port, tt.expected)
+		}
+	}
+}
+
+func TestComposeEnvironmentMapSyntax(t *testing.T) {
+	compose, _ := writeCompose(t, `services:
+  web:
+    image: nginx
+    environment:
+      PORT: 8080
+      DEBUG: true
+      EMPTY:
+`)
+	expected := ComposeEnvironment{"PORT": "8080", "DEBUG": "true", "EMPTY": ""}
+	if !reflect.DeepEqual(compose.Services["web"].Environment, expected) {
+		t.Errorf("Unexpected environment %v", compose.Services["web"].Environment)
+	}
+}
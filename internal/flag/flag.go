@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/superfly/flyctl/internal/env"
 	"github.com/superfly/flyctl/internal/flag/completion"
 	"github.com/superfly/flyctl/internal/flag/flagnames"
 )
@@ -96,7 +97,8 @@ func (b Bool) addTo(cmd *cobra.Command) {
 	}
 }
 
-// String wraps the set of string flags.
+// String wraps the set of string flags. If the environment variable named by
+// EnvName is set, its value is the flag's default.
 type String struct {
 	Name              string
 	Shorthand         string
@@ -114,10 +116,17 @@ type String struct {
 func (s String) addTo(cmd *cobra.Command) {
 	flags := cmd.Flags()
 
+	def := s.Default
+	if s.EnvName != "" {
+		if v := env.First(s.EnvName); v != "" {
+			def = v
+		}
+	}
+
 	if s.Shorthand != "" {
-		_ = flags.StringP(s.Name, s.Shorthand, s.Default, s.Description)
+		_ = flags.StringP(s.Name, s.Shorthand, def, s.Description)
 	} else {
-		_ = flags.String(s.Name, s.Default, s.Description)
+		_ = flags.String(s.Name, def, s.Description)
 	}
 
 	f := flags.Lookup(s.Name)
@@ -496,6 +505,35 @@ func GetBuildOnly(ctx context.Context) bool {
 	return GetBool(ctx, buildOnlyName)
 }
 
+const (
+	buildOutputName     = "output"
+	buildOutputPathName = "output-path"
+)
+
+// BuildOutput returns a string flag for exporting the built image to local disk
+func BuildOutput() String {
+	return String{
+		Name:        buildOutputName,
+		Description: "Export the built image to local disk. One of: oci-layout, docker-archive",
+	}
+}
+
+func GetBuildOutput(ctx context.Context) string {
+	return GetString(ctx, buildOutputName)
+}
+
+// BuildOutputPath returns a string flag for the destination of an exported image
+func BuildOutputPath() String {
+	return String{
+		Name:        buildOutputPathName,
+		Description: `Destination of the exported image. Defaults to "<app>-image" for oci-layout and "<app>-image.tar" for docker-archive`,
+	}
+}
+
+func GetBuildOutputPath(ctx context.Context) string {
+	return GetString(ctx, buildOutputPathName)
+}
+
 const pushName = "push"
 
 // Push returns a boolean flag to force pushing a build image to the registry
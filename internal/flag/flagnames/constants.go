@@ -4,6 +4,9 @@ const (
 	// AccessToken denotes the name of the access token flag.
 	AccessToken = "access-token"
 
+	// Profile denotes the name of the auth profile flag.
+	Profile = "profile"
+
 	// Verbose denotes the name of the verbose flag.
 	Verbose = "verbose"
 
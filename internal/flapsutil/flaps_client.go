@@ -18,6 +18,7 @@ type FlapsClient interface {
 	CreateApp(ctx context.Context, req flaps.CreateAppRequest) (*flaps.App, error)
 	CreateVolume(ctx context.Context, appName string, req fly.CreateVolumeRequest) (*fly.Volume, error)
 	CreateVolumeSnapshot(ctx context.Context, appName, volumeId string) error
+	DecryptSecretKey(ctx context.Context, appName, name string, ciphertext, assoc []byte, version *uint64) (*fly.DecryptSecretKeyResp, error)
 	DeleteApp(ctx context.Context, name string) error
 	DeleteMetadata(ctx context.Context, appName, machineID, key string) error
 	DeleteAppSecret(ctx context.Context, appName, name string) (*fly.DeleteAppSecretResp, error)
@@ -25,6 +26,7 @@ type FlapsClient interface {
 	DeleteSecretKey(ctx context.Context, appName, name string) error
 	DeleteVolume(ctx context.Context, appName, volumeId string) (*fly.Volume, error)
 	Destroy(ctx context.Context, appName string, input fly.RemoveMachineInput, nonce string) (err error)
+	EncryptSecretKey(ctx context.Context, appName, name string, plaintext, assoc []byte, version *uint64) (*fly.EncryptSecretKeyResp, error)
 	Exec(ctx context.Context, appName, machineID string, in *fly.MachineExecRequest) (*fly.MachineExecResponse, error)
 	ExtendVolume(ctx context.Context, appName, volumeId string, size_gb int) (*fly.Volume, bool, error)
 	FindLease(ctx context.Context, appName, machineID string) (*fly.MachineLease, error)
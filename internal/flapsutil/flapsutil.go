@@ -2,9 +2,11 @@ package flapsutil
 
 import (
 	"context"
+	"net/http"
 
 	fly "github.com/superfly/fly-go"
 	"github.com/superfly/fly-go/flaps"
+	"github.com/superfly/flyctl/internal/audit"
 	"github.com/superfly/flyctl/internal/buildinfo"
 	"github.com/superfly/flyctl/internal/config"
 	"github.com/superfly/flyctl/internal/logger"
@@ -20,6 +22,10 @@ func NewClientWithOptions(ctx context.Context, opts flaps.NewClientOpts) (*flaps
 		opts.Tokens = config.Tokens(ctx)
 	}
 
+	if opts.Transport == nil && config.AuditEnabled(ctx) {
+		opts.Transport = audit.NewTransport(http.DefaultTransport)
+	}
+
 	if v := logger.MaybeFromContext(ctx); v != nil {
 		opts.Logger = v
 	}
@@ -46,6 +46,16 @@ func GetErrorDocUrl(err error) string {
 // ErrAbort is an error for when the CLI aborts
 var ErrAbort = errors.New("abort")
 
+// ExitCodeError makes the CLI exit with Code without printing an error, e.g.
+// to pass on the exit status of a command flyctl ran on the user's behalf.
+type ExitCodeError struct {
+	Code int
+}
+
+func (e ExitCodeError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
 // ErrorDescription is an error with a detailed description that will be printed before the CLI exits
 type ErrorDescription interface {
 	error
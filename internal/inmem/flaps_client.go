@@ -49,6 +49,10 @@ func (m *FlapsClient) CreateVolumeSnapshot(ctx context.Context, appName, volumeI
 	panic("TODO")
 }
 
+func (m *FlapsClient) DecryptSecretKey(ctx context.Context, appName, name string, ciphertext, assoc []byte, version *uint64) (*fly.DecryptSecretKeyResp, error) {
+	panic("TODO")
+}
+
 func (m *FlapsClient) DeleteApp(ctx context.Context, name string) error {
 	panic("TODO")
 }
@@ -77,6 +81,10 @@ func (m *FlapsClient) Destroy(ctx context.Context, appName string, input fly.Rem
 	panic("TODO")
 }
 
+func (m *FlapsClient) EncryptSecretKey(ctx context.Context, appName, name string, plaintext, assoc []byte, version *uint64) (*fly.EncryptSecretKeyResp, error) {
+	panic("TODO")
+}
+
 func (m *FlapsClient) Exec(ctx context.Context, appName, machineID string, in *fly.MachineExecRequest) (*fly.MachineExecResponse, error) {
 	panic("TODO")
 }
@@ -9,7 +9,6 @@ import (
 	"github.com/superfly/flyctl/gql"
 	"github.com/superfly/flyctl/internal/config"
 	"github.com/superfly/flyctl/internal/flyutil"
-	"github.com/superfly/flyctl/internal/state"
 	"github.com/superfly/flyctl/terminal"
 )
 
@@ -75,7 +74,7 @@ func GetMetricsToken(parentCtx context.Context) (token string, err error) {
 }
 
 func persistMetricsToken(ctx context.Context, token string) error {
-	path := state.ConfigFile(ctx)
+	path := config.FromContext(ctx).ProfileFile
 
 	if err := config.SetMetricsToken(path, token); err != nil {
 		return fmt.Errorf("failed persisting %s in %s: %w\n",
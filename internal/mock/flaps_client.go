@@ -19,6 +19,7 @@ type FlapsClient struct {
 	CreateAppFunc            func(ctx context.Context, req flaps.CreateAppRequest) (*flaps.App, error)
 	CreateVolumeFunc         func(ctx context.Context, appName string, req fly.CreateVolumeRequest) (*fly.Volume, error)
 	CreateVolumeSnapshotFunc func(ctx context.Context, appName, volumeId string) error
+	DecryptSecretKeyFunc     func(ctx context.Context, appName, name string, ciphertext, assoc []byte, version *uint64) (*fly.DecryptSecretKeyResp, error)
 	DeleteAppFunc            func(ctx context.Context, name string) error
 	DeleteMetadataFunc       func(ctx context.Context, appName, machineID, key string) error
 	DeleteAppSecretFunc      func(ctx context.Context, appName, name string) (*fly.DeleteAppSecretResp, error)
@@ -26,6 +27,7 @@ type FlapsClient struct {
 	DeleteSecretKeyFunc      func(ctx context.Context, appName, name string) error
 	DeleteVolumeFunc         func(ctx context.Context, appName, volumeId string) (*fly.Volume, error)
 	DestroyFunc              func(ctx context.Context, appName string, input fly.RemoveMachineInput, nonce string) (err error)
+	EncryptSecretKeyFunc     func(ctx context.Context, appName, name string, plaintext, assoc []byte, version *uint64) (*fly.EncryptSecretKeyResp, error)
 	ExecFunc                 func(ctx context.Context, appName, machineID string, in *fly.MachineExecRequest) (*fly.MachineExecResponse, error)
 	ExtendVolumeFunc         func(ctx context.Context, appName, volumeId string, size_gb int) (*fly.Volume, bool, error)
 	FindLeaseFunc            func(ctx context.Context, appName, machineID string) (*fly.MachineLease, error)
@@ -92,6 +94,10 @@ func (m *FlapsClient) CreateVolumeSnapshot(ctx context.Context, appName, volumeI
 	return m.CreateVolumeSnapshotFunc(ctx, appName, volumeId)
 }
 
+func (m *FlapsClient) DecryptSecretKey(ctx context.Context, appName, name string, ciphertext, assoc []byte, version *uint64) (*fly.DecryptSecretKeyResp, error) {
+	return m.DecryptSecretKeyFunc(ctx, appName, name, ciphertext, assoc, version)
+}
+
 func (m *FlapsClient) DeleteApp(ctx context.Context, name string) error {
 	return m.DeleteAppFunc(ctx, name)
 }
@@ -120,6 +126,10 @@ func (m *FlapsClient) Destroy(ctx context.Context, appName string, input fly.Rem
 	return m.DestroyFunc(ctx, appName, input, nonce)
 }
 
+func (m *FlapsClient) EncryptSecretKey(ctx context.Context, appName, name string, plaintext, assoc []byte, version *uint64) (*fly.EncryptSecretKeyResp, error) {
+	return m.EncryptSecretKeyFunc(ctx, appName, name, plaintext, assoc, version)
+}
+
 func (m *FlapsClient) Exec(ctx context.Context, appName, machineID string, in *fly.MachineExecRequest) (*fly.MachineExecResponse, error) {
 	return m.ExecFunc(ctx, appName, machineID, in)
 }
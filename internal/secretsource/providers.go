@@ -0,0 +1,174 @@
+package secretsource
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// vaultProvider reads HashiCorp Vault KV secrets with the vault CLI, so the
+// usual VAULT_ADDR, VAULT_TOKEN and token helper setup applies. References
+// are path#field.
+type vaultProvider struct {
+	mount string
+	run   Runner
+}
+
+func (p *vaultProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	path, field := splitRef(ref)
+	if path == "" || field == "" {
+		return "", fmt.Errorf("invalid vault reference %q, expected path#field", ref)
+	}
+
+	mount := p.mount
+	if mount == "" {
+		mount = "secret"
+	}
+
+	out, err := p.run(ctx, "vault", "kv", "get", "-mount="+mount, "-field="+field, path)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// awsProvider reads AWS Secrets Manager secrets with the aws CLI. References
+// are secret-id, or secret-id#key to pick a key out of a JSON secret.
+type awsProvider struct {
+	region, profile string
+	run             Runner
+}
+
+func (p *awsProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	id, key := splitRef(ref)
+	if id == "" {
+		return "", fmt.Errorf("invalid aws reference %q, expected secret-id[#key]", ref)
+	}
+
+	args := []string{"secretsmanager", "get-secret-value", "--secret-id", id, "--query", "SecretString", "--output", "text"}
+	if p.region != "" {
+		args = append(args, "--region", p.region)
+	}
+	if p.profile != "" {
+		args = append(args, "--profile", p.profile)
+	}
+
+	out, err := p.run(ctx, "aws", args...)
+	if err != nil {
+		return "", err
+	}
+
+	// the text output ends with a newline the secret doesn't have
+	value := strings.TrimSuffix(string(out), "\n")
+	if key == "" {
+		return value, nil
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object, can't read key %s", id, key)
+	}
+
+	return jsonField(fields, key)
+}
+
+// onePasswordProvider reads secrets with the 1Password CLI. References are
+// op://vault/item/field secret references.
+type onePasswordProvider struct {
+	account string
+	run     Runner
+}
+
+func (p *onePasswordProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	if !strings.HasPrefix(ref, "op://") {
+		return "", fmt.Errorf("invalid 1password reference %q, expected op://vault/item/field", ref)
+	}
+
+	args := []string{"read", "--no-newline", ref}
+	if p.account != "" {
+		args = append(args, "--account", p.account)
+	}
+
+	out, err := p.run(ctx, "op", args...)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// sopsProvider decrypts a SOPS file once with the sops CLI and reads values
+// out of it. References are dotted key paths, like database.password.
+type sopsProvider struct {
+	file string
+	run  Runner
+
+	once   sync.Once
+	fields map[string]any
+	err    error
+}
+
+func (p *sopsProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	p.once.Do(func() {
+		var out []byte
+		if out, p.err = p.run(ctx, "sops", "--decrypt", "--output-type", "json", p.file); p.err != nil {
+			return
+		}
+		if err := json.Unmarshal(out, &p.fields); err != nil {
+			p.err = fmt.Errorf("decrypted %s is not a JSON object: %w", p.file, err)
+		}
+	})
+	if p.err != nil {
+		return "", p.err
+	}
+
+	return jsonField(p.fields, ref)
+}
+
+// execProvider runs a command with the reference as its last argument and
+// uses its output, minus a trailing newline, as the value.
+type execProvider struct {
+	command []string
+	run     Runner
+}
+
+func (p *execProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	args := append(append([]string{}, p.command[1:]...), ref)
+
+	out, err := p.run(ctx, p.command[0], args...)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// jsonField looks up a dotted key path in decoded JSON. Strings are returned
+// as is, anything else as JSON.
+func jsonField(fields map[string]any, path string) (string, error) {
+	var v any = fields
+
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("key %s not found", path)
+		}
+		if v, ok = m[key]; !ok {
+			return "", fmt.Errorf("key %s not found", path)
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", errors.New("key " + path + " is null")
+	default:
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+}
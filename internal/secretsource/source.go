@@ -0,0 +1,176 @@
+// Package secretsource reads secret values from external secret managers so
+// they can be synced into app secrets.
+package secretsource
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Provider fetches secret values from one secret manager. What a reference
+// looks like depends on the provider.
+type Provider interface {
+	Fetch(ctx context.Context, ref string) (string, error)
+}
+
+// Runner runs an external command and returns its standard output.
+type Runner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+// Mapping is a file of sources, each mapping app secret names to references
+// in an external secret manager:
+//
+//	[[sources]]
+//	provider = "vault"
+//	mount = "secret"
+//
+//	  [sources.secrets]
+//	  DATABASE_URL = "my-app/db#url"
+type Mapping struct {
+	Sources []Source `toml:"sources"`
+}
+
+// Source configures one provider and the secrets read from it.
+type Source struct {
+	// Provider is one of vault, aws, 1password, sops or exec.
+	Provider string `toml:"provider"`
+
+	// Secrets maps app secret names to provider references.
+	Secrets map[string]string `toml:"secrets"`
+
+	// Mount is the Vault KV mount, defaulting to "secret".
+	Mount string `toml:"mount,omitempty"`
+	// Region and Profile select the AWS region and CLI profile.
+	Region  string `toml:"region,omitempty"`
+	Profile string `toml:"profile,omitempty"`
+	// Account selects the 1Password account.
+	Account string `toml:"account,omitempty"`
+	// File is the SOPS encrypted file to read.
+	File string `toml:"file,omitempty"`
+	// Command is run with the reference as its last argument by the exec
+	// provider, which uses what it prints as the value.
+	Command []string `toml:"command,omitempty"`
+}
+
+// Load reads a mapping file.
+func Load(path string) (*Mapping, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Mapping
+	if err := toml.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	if len(m.Sources) == 0 {
+		return nil, fmt.Errorf("%s has no [[sources]]", path)
+	}
+
+	seen := map[string]bool{}
+	for i, src := range m.Sources {
+		if len(src.Secrets) == 0 {
+			return nil, fmt.Errorf("source %d (%s) maps no secrets", i+1, src.Provider)
+		}
+		for name := range src.Secrets {
+			if seen[name] {
+				return nil, fmt.Errorf("secret %s is mapped more than once", name)
+			}
+			seen[name] = true
+		}
+	}
+
+	return &m, nil
+}
+
+// NewProvider returns the provider for a source.
+func NewProvider(src Source, run Runner) (Provider, error) {
+	if run == nil {
+		run = RunCommand
+	}
+
+	switch src.Provider {
+	case "vault":
+		return &vaultProvider{mount: src.Mount, run: run}, nil
+	case "aws":
+		return &awsProvider{region: src.Region, profile: src.Profile, run: run}, nil
+	case "1password":
+		return &onePasswordProvider{account: src.Account, run: run}, nil
+	case "sops":
+		if src.File == "" {
+			return nil, errors.New("sops source requires file")
+		}
+		return &sopsProvider{file: src.File, run: run}, nil
+	case "exec":
+		if len(src.Command) == 0 {
+			return nil, errors.New("exec source requires command")
+		}
+		return &execProvider{command: src.Command, run: run}, nil
+	case "":
+		return nil, errors.New("source is missing provider")
+	default:
+		return nil, fmt.Errorf("unknown secret provider %q, expected vault, aws, 1password, sops or exec", src.Provider)
+	}
+}
+
+// Resolve fetches the value of every secret in the mapping, keyed by app
+// secret name.
+func (m *Mapping) Resolve(ctx context.Context, run Runner) (map[string]string, error) {
+	values := map[string]string{}
+
+	for _, src := range m.Sources {
+		provider, err := NewProvider(src, run)
+		if err != nil {
+			return nil, err
+		}
+
+		names := make([]string, 0, len(src.Secrets))
+		for name := range src.Secrets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			value, err := provider.Fetch(ctx, src.Secrets[name])
+			if err != nil {
+				return nil, fmt.Errorf("%s from %s: %w", name, src.Provider, err)
+			}
+			values[name] = value
+		}
+	}
+
+	return values, nil
+}
+
+// RunCommand is the default Runner. Errors include what the command printed
+// to stderr.
+func RunCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %w: %s", name, err, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return out, nil
+}
+
+// splitRef splits a reference into a path and an optional #field.
+func splitRef(ref string) (path, field string) {
+	path, field, _ = strings.Cut(ref, "#")
+	return path, field
+}
@@ -0,0 +1,137 @@
+package secretsource
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRunner answers commands from a table keyed by the full command line.
+func fakeRunner(t *testing.T, outputs map[string]string) (Runner, *[]string) {
+	var calls []string
+
+	return func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		line := strings.Join(append([]string{name}, args...), " ")
+		calls = append(calls, line)
+
+		out, ok := outputs[line]
+		if !ok {
+			t.Logf("unexpected command: %s", line)
+			return nil, errors.New("exit status 1")
+		}
+		return []byte(out), nil
+	}, &calls
+}
+
+func TestResolve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.toml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+[[sources]]
+provider = "vault"
+mount = "kv"
+  [sources.secrets]
+  DATABASE_URL = "my-app/db#url"
+
+[[sources]]
+provider = "aws"
+region = "us-east-1"
+  [sources.secrets]
+  STRIPE_KEY = "prod/stripe#secret_key"
+  SESSION_SECRET = "prod/session"
+
+[[sources]]
+provider = "1password"
+  [sources.secrets]
+  SMTP_PASSWORD = "op://Prod/SMTP/password"
+
+[[sources]]
+provider = "sops"
+file = "secrets.enc.yaml"
+  [sources.secrets]
+  REDIS_PASSWORD = "redis.password"
+  REDIS_PORT = "redis.port"
+
+[[sources]]
+provider = "exec"
+command = ["./get-secret", "--env", "prod"]
+  [sources.secrets]
+  API_TOKEN = "api-token"
+`), 0o600))
+
+	run, calls := fakeRunner(t, map[string]string{
+		"vault kv get -mount=kv -field=url my-app/db": "postgres://db",
+		"aws secretsmanager get-secret-value --secret-id prod/stripe --query SecretString --output text --region us-east-1":  `{"secret_key":"sk_live"}` + "\n",
+		"aws secretsmanager get-secret-value --secret-id prod/session --query SecretString --output text --region us-east-1": "s3ss10n\n",
+		"op read --no-newline op://Prod/SMTP/password":       "hunter2",
+		"sops --decrypt --output-type json secrets.enc.yaml": `{"redis":{"password":"r3d1s","port":6379}}`,
+		"./get-secret --env prod api-token":                  "tok\n",
+	})
+
+	mapping, err := Load(path)
+	require.NoError(t, err)
+
+	values, err := mapping.Resolve(context.Background(), run)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"DATABASE_URL":   "postgres://db",
+		"STRIPE_KEY":     "sk_live",
+		"SESSION_SECRET": "s3ss10n",
+		"SMTP_PASSWORD":  "hunter2",
+		"REDIS_PASSWORD": "r3d1s",
+		"REDIS_PORT":     "6379",
+		"API_TOKEN":      "tok",
+	}, values)
+
+	// the sops file is only decrypted once
+	sops := 0
+	for _, call := range *calls {
+		if strings.HasPrefix(call, "sops ") {
+			sops++
+		}
+	}
+	assert.Equal(t, 1, sops)
+}
+
+func TestLoadRejectsBadMappings(t *testing.T) {
+	for name, content := range map[string]string{
+		"no sources": ``,
+		"no secrets": "[[sources]]\nprovider = \"vault\"\n",
+		"duplicate":  "[[sources]]\nprovider = \"vault\"\n[sources.secrets]\nA = \"x#y\"\n[[sources]]\nprovider = \"aws\"\n[sources.secrets]\nA = \"z\"\n",
+		"bad toml":   "[[sources]\n",
+	} {
+		path := filepath.Join(t.TempDir(), "secrets.toml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+		_, err := Load(path)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestNewProviderValidates(t *testing.T) {
+	for _, src := range []Source{
+		{Provider: ""},
+		{Provider: "keepass"},
+		{Provider: "sops"},
+		{Provider: "exec"},
+	} {
+		_, err := NewProvider(src, nil)
+		assert.Error(t, err, src.Provider)
+	}
+
+	p, err := NewProvider(Source{Provider: "vault"}, nil)
+	require.NoError(t, err)
+	_, err = p.Fetch(context.Background(), "no-field")
+	assert.Error(t, err)
+
+	p, err = NewProvider(Source{Provider: "1password"}, nil)
+	require.NoError(t, err)
+	_, err = p.Fetch(context.Background(), "Prod/SMTP/password")
+	assert.Error(t, err)
+}
@@ -2,7 +2,9 @@ package uiexutil
 
 import (
 	"context"
+	"net/http"
 
+	"github.com/superfly/flyctl/internal/audit"
 	"github.com/superfly/flyctl/internal/config"
 	"github.com/superfly/flyctl/internal/logger"
 	"github.com/superfly/flyctl/internal/uiex"
@@ -13,6 +15,10 @@ func NewClientWithOptions(ctx context.Context, opts uiex.NewClientOpts) (*uiex.C
 		opts.Tokens = config.Tokens(ctx)
 	}
 
+	if opts.Transport == nil && config.AuditEnabled(ctx) {
+		opts.Transport = audit.NewTransport(http.DefaultTransport)
+	}
+
 	if v := logger.MaybeFromContext(ctx); v != nil && opts.Logger == nil {
 		opts.Logger = v
 	}
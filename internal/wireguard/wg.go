@@ -16,7 +16,6 @@ import (
 	"github.com/superfly/flyctl/flyctl"
 	"github.com/superfly/flyctl/internal/config"
 	"github.com/superfly/flyctl/internal/flyutil"
-	"github.com/superfly/flyctl/internal/state"
 	"github.com/superfly/flyctl/terminal"
 	"github.com/superfly/flyctl/wg"
 	"golang.org/x/crypto/curve25519"
@@ -161,8 +160,8 @@ func getWireGuardStateForOrg(orgSlug string, network string) (*wg.WireGuardState
 
 func setWireGuardState(ctx context.Context, s wg.States) error {
 	viper.Set(flyctl.ConfigWireGuardState, s)
-	configPath := state.ConfigFile(ctx)
-	if err := config.SetWireGuardState(configPath, s); err != nil {
+	profilePath := config.FromContext(ctx).ProfileFile
+	if err := config.SetWireGuardState(profilePath, s); err != nil {
 		return errors.Wrap(err, "error saving config file")
 	}
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"time"
 
 	"github.com/superfly/flyctl/agent"
 	"github.com/superfly/flyctl/internal/flyutil"
@@ -23,11 +24,20 @@ type ConnectParams struct {
 	PromptInstance   bool
 	DisableSpinner   bool
 	Network          string
+	// UDP forwards datagrams instead of TCP connections
+	UDP bool
+	// UDPIdleTimeout ends UDP sessions without traffic, defaults to
+	// DefaultUDPIdleTimeout
+	UDPIdleTimeout time.Duration
 }
 
 // Binds to a local port and runs a proxy to a remote address over Wireguard.
 // Blocks until context is cancelled.
 func Connect(ctx context.Context, p *ConnectParams) (err error) {
+	if p.UDP {
+		return connectUDP(ctx, p)
+	}
+
 	server, err := NewServer(ctx, p)
 	if err != nil {
 		return err
@@ -56,7 +66,6 @@ func NewServer(ctx context.Context, p *ConnectParams) (*Server, error) {
 	var (
 		io            = iostreams.FromContext(ctx)
 		client        = flyutil.ClientFromContext(ctx)
-		orgSlug       = p.OrganizationSlug
 		localBindAddr = p.BindAddr
 		localPort     = p.Ports[0]
 		remotePort    = localPort
@@ -72,27 +81,8 @@ func NewServer(ctx context.Context, p *ConnectParams) (*Server, error) {
 		return nil, err
 	}
 
-	// Prompt for a specific instance and set it as the remote target
-	if p.PromptInstance {
-		instance, err := selectInstance(ctx, p.OrganizationSlug, p.AppName, agentclient)
-		if err != nil {
-			return nil, err
-		}
-
-		remoteAddr = fmt.Sprintf("[%s]:%s", instance, remotePort)
-	}
-
-	if remoteAddr == "" && p.RemoteHost != "" {
-
-		// If a host is specified that isn't an IpV6 address, assume it's a DNS entry and wait for that
-		// entry to resolve
-		if !ip.IsV6(p.RemoteHost) {
-			if err := agentclient.WaitForDNS(ctx, p.Dialer, orgSlug, p.RemoteHost, p.Network); err != nil {
-				return nil, fmt.Errorf("%s: %w", p.RemoteHost, err)
-			}
-		}
-
-		remoteAddr = fmt.Sprintf("[%s]:%s", p.RemoteHost, remotePort)
+	if remoteAddr, err = resolveRemoteAddr(ctx, p, agentclient, remotePort); err != nil {
+		return nil, err
 	}
 
 	var listener net.Listener
@@ -134,6 +124,79 @@ func NewServer(ctx context.Context, p *ConnectParams) (*Server, error) {
 	}, nil
 }
 
+// resolveRemoteAddr picks the remote address, prompting for an instance if
+// asked to and waiting for the remote host to show up in DNS.
+func resolveRemoteAddr(ctx context.Context, p *ConnectParams, agentclient *agent.Client, remotePort string) (string, error) {
+	// Prompt for a specific instance and set it as the remote target
+	if p.PromptInstance {
+		instance, err := selectInstance(ctx, p.OrganizationSlug, p.AppName, agentclient)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("[%s]:%s", instance, remotePort), nil
+	}
+
+	if p.RemoteHost == "" {
+		return "", nil
+	}
+
+	// If a host is specified that isn't an IpV6 address, assume it's a DNS entry and wait for that
+	// entry to resolve
+	if !ip.IsV6(p.RemoteHost) {
+		if err := agentclient.WaitForDNS(ctx, p.Dialer, p.OrganizationSlug, p.RemoteHost, p.Network); err != nil {
+			return "", fmt.Errorf("%s: %w", p.RemoteHost, err)
+		}
+	}
+
+	return fmt.Sprintf("[%s]:%s", p.RemoteHost, remotePort), nil
+}
+
+// connectUDP binds a local UDP port and forwards datagrams to the remote
+// address until the context is cancelled.
+func connectUDP(ctx context.Context, p *ConnectParams) error {
+	var (
+		io         = iostreams.FromContext(ctx)
+		client     = flyutil.ClientFromContext(ctx)
+		localPort  = p.Ports[0]
+		remotePort = localPort
+	)
+
+	if len(p.Ports) > 1 {
+		remotePort = p.Ports[1]
+	}
+
+	if _, err := strconv.Atoi(localPort); err != nil {
+		return fmt.Errorf("invalid local port %q: UDP forwarding requires a port number", localPort)
+	}
+
+	agentclient, err := agent.Establish(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	remoteAddr, err := resolveRemoteAddr(ctx, p, agentclient, remotePort)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(p.BindAddr, localPort))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(io.Out, "Proxying UDP %s to remote %s\n", conn.LocalAddr(), remoteAddr)
+
+	server := &UDPServer{
+		Addr:        remoteAddr,
+		Conn:        conn,
+		Dial:        p.Dialer.DialContext,
+		IdleTimeout: p.UDPIdleTimeout,
+	}
+
+	return server.Serve(ctx)
+}
+
 func selectInstance(ctx context.Context, org, app string, c *agent.Client) (instance string, err error) {
 	instances, err := c.Instances(ctx, org, app)
 	if err != nil {
@@ -0,0 +1,302 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/superfly/flyctl/terminal"
+)
+
+// ForwardMode selects the protocol a ForwardServer speaks to local clients
+type ForwardMode int
+
+const (
+	// ForwardSOCKS5 accepts SOCKS5 CONNECT requests
+	ForwardSOCKS5 ForwardMode = iota
+	// ForwardHTTP accepts HTTP CONNECT requests and plain HTTP proxy requests
+	ForwardHTTP
+)
+
+func (m ForwardMode) String() string {
+	if m == ForwardHTTP {
+		return "HTTP"
+	}
+	return "SOCKS5"
+}
+
+// ForwardServer is a local proxy that lets any tool reach the private network
+// of an organization. Unlike Server, the destination is chosen by the client
+// for every connection.
+type ForwardServer struct {
+	Mode     ForwardMode
+	Listener net.Listener
+	Dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Resolve turns a hostname into an address reachable through the tunnel,
+	// typically by querying the tunnel's DNS server
+	Resolve func(ctx context.Context, host string) (string, error)
+}
+
+var errUnsupportedSocks = errors.New("unsupported SOCKS request")
+
+const (
+	socksVersion = 0x05
+
+	socksAuthNone         = 0x00
+	socksAuthUnacceptable = 0xff
+
+	socksCmdConnect = 0x01
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	socksReplySucceeded           = 0x00
+	socksReplyHostUnreachable     = 0x04
+	socksReplyCommandNotSupported = 0x07
+	socksReplyAddrNotSupported    = 0x08
+)
+
+// Serve accepts connections until the context is cancelled.
+func (srv *ForwardServer) Serve(ctx context.Context) error {
+	defer srv.Listener.Close() //skipcq: GO-S2307
+
+	go func() {
+		<-ctx.Done()
+		srv.Listener.Close()
+	}()
+
+	for {
+		conn, err := srv.Listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		terminal.Debug("accepted new proxy connection from: ", conn.RemoteAddr())
+
+		go func() {
+			defer conn.Close() //skipcq: GO-S2307
+
+			var err error
+			if srv.Mode == ForwardHTTP {
+				err = srv.handleHTTP(ctx, conn)
+			} else {
+				err = srv.handleSOCKS(ctx, conn)
+			}
+			if err != nil {
+				terminal.Debugf("%s proxy connection failed: %v\n", srv.Mode, err)
+			}
+		}()
+	}
+}
+
+// dial resolves host through the tunnel, unless it's already an IP address,
+// and connects to it
+func (srv *ForwardServer) dial(ctx context.Context, host, port string) (net.Conn, error) {
+	addr := host
+	if net.ParseIP(host) == nil {
+		resolved, err := srv.Resolve(ctx, host)
+		if err != nil {
+			return nil, fmt.Errorf("resolve %s: %w", host, err)
+		}
+		addr = resolved
+	}
+	return srv.Dial(ctx, "tcp", net.JoinHostPort(addr, port))
+}
+
+func (srv *ForwardServer) handleSOCKS(ctx context.Context, conn net.Conn) error {
+	r := bufio.NewReader(conn)
+
+	// greeting: VER NMETHODS METHODS...
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if header[0] != socksVersion {
+		return fmt.Errorf("%w: version %d", errUnsupportedSocks, header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return err
+	}
+	method := byte(socksAuthUnacceptable)
+	for _, m := range methods {
+		if m == socksAuthNone {
+			method = socksAuthNone
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return err
+	}
+	if method == socksAuthUnacceptable {
+		return fmt.Errorf("%w: no acceptable authentication method", errUnsupportedSocks)
+	}
+
+	// request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(r, request); err != nil {
+		return err
+	}
+	if request[1] != socksCmdConnect {
+		writeSocksReply(conn, socksReplyCommandNotSupported)
+		return fmt.Errorf("%w: command %d", errUnsupportedSocks, request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		size := net.IPv4len
+		if request[3] == socksAddrIPv6 {
+			size = net.IPv6len
+		}
+		ip := make(net.IP, size)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return err
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		length, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		name := make([]byte, length)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return err
+		}
+		host = string(name)
+	default:
+		writeSocksReply(conn, socksReplyAddrNotSupported)
+		return fmt.Errorf("%w: address type %d", errUnsupportedSocks, request[3])
+	}
+
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(r, portBytes); err != nil {
+		return err
+	}
+	port := strconv.Itoa(int(binary.BigEndian.Uint16(portBytes)))
+
+	target, err := srv.dial(ctx, host, port)
+	if err != nil {
+		writeSocksReply(conn, socksReplyHostUnreachable)
+		return err
+	}
+	defer target.Close() //skipcq: GO-S2307
+
+	if err := writeSocksReply(conn, socksReplySucceeded); err != nil {
+		return err
+	}
+
+	pipe(target, &bufferedConn{Conn: conn, r: r})
+	return nil
+}
+
+// writeSocksReply sends a reply with an unspecified bind address, which
+// clients ignore for CONNECT
+func writeSocksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion, code, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func (srv *ForwardServer) handleHTTP(ctx context.Context, conn net.Conn) error {
+	r := bufio.NewReader(conn)
+
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return err
+	}
+
+	host, port := req.URL.Hostname(), req.URL.Port()
+	if req.Method == http.MethodConnect {
+		host, port, err = net.SplitHostPort(req.Host)
+		if err != nil {
+			writeHTTPError(conn, http.StatusBadRequest)
+			return err
+		}
+	} else if port == "" {
+		port = "80"
+	}
+	if host == "" {
+		writeHTTPError(conn, http.StatusBadRequest)
+		return fmt.Errorf("request for %s has no host", req.URL)
+	}
+
+	target, err := srv.dial(ctx, host, port)
+	if err != nil {
+		writeHTTPError(conn, http.StatusBadGateway)
+		return err
+	}
+	defer target.Close() //skipcq: GO-S2307
+
+	if req.Method == http.MethodConnect {
+		if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+			return err
+		}
+	} else {
+		// Plain proxy request: forward it once and let the connection close
+		// afterwards so we never have to parse the response.
+		req.Header.Del("Proxy-Connection")
+		req.Header.Del("Proxy-Authorization")
+		req.Close = true
+		if err := req.Write(target); err != nil {
+			return err
+		}
+	}
+
+	pipe(target, &bufferedConn{Conn: conn, r: r})
+	return nil
+}
+
+func writeHTTPError(conn net.Conn, status int) {
+	fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nConnection: close\r\nContent-Length: 0\r\n\r\n", status, http.StatusText(status))
+}
+
+// bufferedConn reads through the bufio.Reader used to parse the handshake so
+// that bytes the client sent early aren't lost
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(ClosableWrite); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// pipe copies data in both directions until both sides are done
+func pipe(a, b net.Conn) {
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+
+	copyFunc := func(dst net.Conn, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+
+		// close the write half if it exports a CloseWrite() method
+		if conn, ok := dst.(ClosableWrite); ok {
+			conn.CloseWrite()
+		}
+	}
+
+	go copyFunc(a, b)
+	go copyFunc(b, a)
+
+	wg.Wait()
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	xproxy "golang.org/x/net/proxy"
+)
+
+// startForwardServer runs a ForwardServer whose tunnel is the loopback
+// interface and whose DNS only knows about echo.internal.
+func startForwardServer(t *testing.T, mode ForwardMode) (proxyAddr string, targetPort string) {
+	t.Helper()
+
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { target.Close() })
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				fmt.Fprintf(conn, "echo: %s", line)
+			}()
+		}
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &ForwardServer{
+		Mode:     mode,
+		Listener: listener,
+		Dial:     (&net.Dialer{}).DialContext,
+		Resolve: func(ctx context.Context, host string) (string, error) {
+			if host == "echo.internal" {
+				return "127.0.0.1", nil
+			}
+			return "", fmt.Errorf("no such host")
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go srv.Serve(ctx)
+
+	_, port, err := net.SplitHostPort(target.Addr().String())
+	require.NoError(t, err)
+	return listener.Addr().String(), port
+}
+
+func TestForwardServerSOCKS5(t *testing.T) {
+	proxyAddr, port := startForwardServer(t, ForwardSOCKS5)
+
+	dialer, err := xproxy.SOCKS5("tcp", proxyAddr, nil, xproxy.Direct)
+	require.NoError(t, err)
+
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("echo.internal", port))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, "hello\n")
+	require.NoError(t, err)
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: hello\n", reply)
+
+	_, err = dialer.Dial("tcp", net.JoinHostPort("missing.internal", port))
+	assert.Error(t, err)
+}
+
+func TestForwardServerHTTPConnect(t *testing.T) {
+	proxyAddr, port := startForwardServer(t, ForwardHTTP)
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	target := net.JoinHostPort("echo.internal", port)
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\nhello\n", target, target)
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, &http.Request{Method: http.MethodConnect})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	reply, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: hello\n", reply)
+}
+
+func TestForwardServerHTTPBadGateway(t *testing.T) {
+	proxyAddr, _ := startForwardServer(t, ForwardHTTP)
+
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: proxyAddr})}}
+	resp, err := client.Get("http://missing.internal/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
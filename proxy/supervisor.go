@@ -0,0 +1,293 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/superfly/flyctl/terminal"
+)
+
+// ForwardState is the state of a supervised forward
+type ForwardState string
+
+const (
+	ForwardStarting     ForwardState = "starting"
+	ForwardUp           ForwardState = "up"
+	ForwardReconnecting ForwardState = "reconnecting"
+	ForwardStopped      ForwardState = "stopped"
+)
+
+// SupervisedForward forwards a local port to a host on an organization's
+// private network
+type SupervisedForward struct {
+	Name       string
+	BindAddr   string
+	LocalPort  string
+	RemoteHost string
+	RemotePort string
+	Org        string
+	Network    string
+
+	listener net.Listener
+}
+
+// Local is the address the forward listens on
+func (f *SupervisedForward) Local() string {
+	if f.listener != nil {
+		return f.listener.Addr().String()
+	}
+	return net.JoinHostPort(f.BindAddr, f.LocalPort)
+}
+
+// Remote is the address connections are forwarded to
+func (f *SupervisedForward) Remote() string {
+	return net.JoinHostPort(f.RemoteHost, f.RemotePort)
+}
+
+// ForwardStatus is reported whenever a forward changes state
+type ForwardStatus struct {
+	Forward     *SupervisedForward
+	State       ForwardState
+	Connections int
+	Err         error
+}
+
+// Supervisor runs a set of forwards in one process and rebuilds their tunnels
+// when they stop working.
+type Supervisor struct {
+	Forwards []*SupervisedForward
+
+	Dial        func(ctx context.Context, org, network, addr string) (net.Conn, error)
+	Resolve     func(ctx context.Context, org, network, host string) (string, error)
+	Probe       func(ctx context.Context, org, network string) error
+	Reestablish func(ctx context.Context, org, network string) error
+
+	// Status, if set, is called on every state change
+	Status func(ForwardStatus)
+
+	// ProbeInterval defaults to 30 seconds
+	ProbeInterval time.Duration
+	// MaxBackoff bounds the wait between reconnection attempts and defaults
+	// to 30 seconds
+	MaxBackoff time.Duration
+
+	mu       sync.Mutex
+	statuses map[*SupervisedForward]*ForwardStatus
+}
+
+type supervisedTunnel struct {
+	org, network string
+	forwards     []*SupervisedForward
+	// recheck asks the monitor to probe right away
+	recheck chan struct{}
+}
+
+// Run binds every forward and serves them until the context is cancelled.
+func (s *Supervisor) Run(ctx context.Context) error {
+	if s.ProbeInterval == 0 {
+		s.ProbeInterval = 30 * time.Second
+	}
+	if s.MaxBackoff == 0 {
+		s.MaxBackoff = 30 * time.Second
+	}
+	s.statuses = make(map[*SupervisedForward]*ForwardStatus, len(s.Forwards))
+
+	for _, f := range s.Forwards {
+		listener, err := net.Listen("tcp", net.JoinHostPort(f.BindAddr, f.LocalPort))
+		if err != nil {
+			for _, f := range s.Forwards {
+				if f.listener != nil {
+					f.listener.Close()
+				}
+			}
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		f.listener = listener
+		s.statuses[f] = &ForwardStatus{Forward: f}
+	}
+
+	var tunnels []*supervisedTunnel
+	byKey := map[[2]string]*supervisedTunnel{}
+	for _, f := range s.Forwards {
+		key := [2]string{f.Org, f.Network}
+		t, ok := byKey[key]
+		if !ok {
+			t = &supervisedTunnel{org: f.Org, network: f.Network, recheck: make(chan struct{}, 1)}
+			byKey[key] = t
+			tunnels = append(tunnels, t)
+		}
+		t.forwards = append(t.forwards, f)
+		s.setState(f, ForwardStarting, nil)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, t := range tunnels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.monitor(ctx, t)
+		}()
+
+		for _, f := range t.forwards {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.serve(ctx, t, f)
+			}()
+		}
+	}
+
+	<-ctx.Done()
+	for _, f := range s.Forwards {
+		f.listener.Close()
+	}
+	wg.Wait()
+
+	for _, f := range s.Forwards {
+		s.setState(f, ForwardStopped, nil)
+	}
+
+	return nil
+}
+
+// monitor probes a tunnel and rebuilds it, with backoff, when probes fail
+func (s *Supervisor) monitor(ctx context.Context, t *supervisedTunnel) {
+	ticker := time.NewTicker(s.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		err := s.Probe(ctx, t.org, t.network)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			s.setTunnelState(t, ForwardUp, nil)
+		} else {
+			s.setTunnelState(t, ForwardReconnecting, err)
+
+			backoff := time.Second
+			for {
+				if err = s.Reestablish(ctx, t.org, t.network); err == nil {
+					break
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				s.setTunnelState(t, ForwardReconnecting, err)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				backoff = min(backoff*2, s.MaxBackoff)
+			}
+			s.setTunnelState(t, ForwardUp, nil)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-t.recheck:
+		}
+	}
+}
+
+// serve accepts connections for a forward until its listener is closed
+func (s *Supervisor) serve(ctx context.Context, t *supervisedTunnel, f *SupervisedForward) {
+	for {
+		source, err := f.listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			terminal.Debugf("%s: error accepting connection: %v\n", f.Name, err)
+			continue
+		}
+
+		go func() {
+			defer source.Close() //skipcq: GO-S2307
+
+			target, err := s.dial(ctx, f)
+			if err != nil {
+				terminal.Debugf("%s: failed to connect to %s: %v\n", f.Name, f.Remote(), err)
+
+				// the tunnel might be gone; don't wait for the next probe
+				select {
+				case t.recheck <- struct{}{}:
+				default:
+				}
+				return
+			}
+			defer target.Close() //skipcq: GO-S2307
+
+			s.addConnection(f, 1)
+			defer s.addConnection(f, -1)
+
+			pipe(source, target)
+		}()
+	}
+}
+
+// dial resolves the remote host on every connection, so that forwards follow
+// Machines that moved while the tunnel was down
+func (s *Supervisor) dial(ctx context.Context, f *SupervisedForward) (net.Conn, error) {
+	addr := f.RemoteHost
+	if net.ParseIP(addr) == nil {
+		resolved, err := s.Resolve(ctx, f.Org, f.Network, f.RemoteHost)
+		if err != nil {
+			return nil, fmt.Errorf("resolve %s: %w", f.RemoteHost, err)
+		}
+		addr = resolved
+	}
+
+	return s.Dial(ctx, f.Org, f.Network, net.JoinHostPort(addr, f.RemotePort))
+}
+
+func (s *Supervisor) setTunnelState(t *supervisedTunnel, state ForwardState, err error) {
+	for _, f := range t.forwards {
+		s.setState(f, state, err)
+	}
+}
+
+func (s *Supervisor) setState(f *SupervisedForward, state ForwardState, err error) {
+	s.mu.Lock()
+	status := s.statuses[f]
+	changed := status.State != state || (err != nil && (status.Err == nil || status.Err.Error() != err.Error()))
+	status.State, status.Err = state, err
+	snapshot := *status
+	s.mu.Unlock()
+
+	if changed && s.Status != nil {
+		s.Status(snapshot)
+	}
+}
+
+func (s *Supervisor) addConnection(f *SupervisedForward, delta int) {
+	s.mu.Lock()
+	s.statuses[f].Connections += delta
+	s.mu.Unlock()
+}
+
+// Statuses returns the current state of every forward
+func (s *Supervisor) Statuses() []ForwardStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]ForwardStatus, 0, len(s.Forwards))
+	for _, f := range s.Forwards {
+		if status, ok := s.statuses[f]; ok {
+			statuses = append(statuses, *status)
+		}
+	}
+	return statuses
+}
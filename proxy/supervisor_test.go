@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupervisorReconnects(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				fmt.Fprintf(conn, "echo: %s", line)
+			}()
+		}
+	}()
+	_, targetPort, err := net.SplitHostPort(target.Addr().String())
+	require.NoError(t, err)
+
+	var (
+		tunnelUp      atomic.Bool
+		reestablished atomic.Int32
+		mu            sync.Mutex
+		states        []ForwardState
+		up            = make(chan struct{}, 10)
+	)
+
+	forward := &SupervisedForward{
+		Name:       "echo:" + targetPort,
+		BindAddr:   "127.0.0.1",
+		LocalPort:  "0",
+		RemoteHost: "echo.internal",
+		RemotePort: targetPort,
+		Org:        "personal",
+	}
+
+	s := &Supervisor{
+		Forwards: []*SupervisedForward{forward},
+		Dial: func(ctx context.Context, org, network, addr string) (net.Conn, error) {
+			if !tunnelUp.Load() {
+				return nil, errors.New("tunnel unavailable")
+			}
+			return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		},
+		Resolve: func(ctx context.Context, org, network, host string) (string, error) {
+			return "127.0.0.1", nil
+		},
+		Probe: func(ctx context.Context, org, network string) error {
+			if !tunnelUp.Load() {
+				return errors.New("tunnel unavailable")
+			}
+			return nil
+		},
+		Reestablish: func(ctx context.Context, org, network string) error {
+			reestablished.Add(1)
+			tunnelUp.Store(true)
+			return nil
+		},
+		Status: func(status ForwardStatus) {
+			mu.Lock()
+			states = append(states, status.State)
+			mu.Unlock()
+			if status.State == ForwardUp {
+				up <- struct{}{}
+			}
+		},
+		ProbeInterval: time.Hour,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	// the tunnel starts out broken, so the first probe triggers a reconnect
+	waitFor(t, up)
+	assert.Equal(t, int32(1), reestablished.Load())
+
+	echo := func() (string, error) {
+		conn, err := net.Dial("tcp", forward.Local())
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		if _, err := io.WriteString(conn, "hello\n"); err != nil {
+			return "", err
+		}
+		return bufio.NewReader(conn).ReadString('\n')
+	}
+
+	reply, err := echo()
+	require.NoError(t, err)
+	assert.Equal(t, "echo: hello\n", reply)
+
+	// a failed dial makes the supervisor probe, notice and reconnect right away
+	tunnelUp.Store(false)
+	_, err = echo()
+	assert.Error(t, err)
+	waitFor(t, up)
+	assert.Equal(t, int32(2), reestablished.Load())
+
+	reply, err = echo()
+	require.NoError(t, err)
+	assert.Equal(t, "echo: hello\n", reply)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []ForwardState{
+		ForwardStarting, ForwardReconnecting, ForwardUp, ForwardReconnecting, ForwardUp, ForwardStopped,
+	}, states)
+}
+
+func waitFor(t *testing.T, ch <-chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+}
@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/superfly/flyctl/terminal"
+)
+
+// DefaultUDPIdleTimeout is how long a UDP session lives without traffic in
+// either direction
+const DefaultUDPIdleTimeout = time.Minute
+
+// UDPServer forwards datagrams from local clients to a remote address. Each
+// client address gets its own session, and with it its own connection to the
+// remote, so replies find their way back to the right client.
+type UDPServer struct {
+	Addr string
+	Conn net.PacketConn
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// IdleTimeout defaults to DefaultUDPIdleTimeout
+	IdleTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession
+}
+
+type udpSession struct {
+	client net.Addr
+	remote net.Conn
+
+	mu       sync.Mutex
+	lastSeen time.Time
+}
+
+func (s *udpSession) touch() {
+	s.mu.Lock()
+	s.lastSeen = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *udpSession) idleSince() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastSeen
+}
+
+// Serve forwards datagrams until the context is cancelled.
+func (srv *UDPServer) Serve(ctx context.Context) error {
+	if srv.IdleTimeout == 0 {
+		srv.IdleTimeout = DefaultUDPIdleTimeout
+	}
+
+	srv.mu.Lock()
+	srv.sessions = make(map[string]*udpSession)
+	srv.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		srv.closeSessions()
+		wg.Wait()
+	}()
+
+	go func() {
+		<-ctx.Done()
+		srv.Conn.Close()
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		srv.expireSessions(ctx)
+	}()
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, client, err := srv.Conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		session, err := srv.session(ctx, client, &wg)
+		if err != nil {
+			terminal.Debugf("udp: failed to connect to %s for %s: %v\n", srv.Addr, client, err)
+			continue
+		}
+
+		session.touch()
+		if _, err := session.remote.Write(buf[:n]); err != nil {
+			terminal.Debugf("udp: failed forwarding datagram from %s: %v\n", client, err)
+			srv.dropSession(client.String(), session)
+		}
+	}
+}
+
+// session returns the client's session, dialing the remote for new clients.
+func (srv *UDPServer) session(ctx context.Context, client net.Addr, wg *sync.WaitGroup) (*udpSession, error) {
+	key := client.String()
+
+	srv.mu.Lock()
+	session, ok := srv.sessions[key]
+	srv.mu.Unlock()
+	if ok {
+		return session, nil
+	}
+
+	remote, err := srv.Dial(ctx, "udp", srv.Addr)
+	if err != nil {
+		return nil, err
+	}
+	terminal.Debug("udp: new session for ", client)
+
+	session = &udpSession{client: client, remote: remote, lastSeen: time.Now()}
+
+	srv.mu.Lock()
+	srv.sessions[key] = session
+	srv.mu.Unlock()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		srv.relayReplies(session)
+	}()
+
+	return session, nil
+}
+
+// relayReplies sends datagrams from the remote back to the session's client
+// until the session is closed.
+func (srv *UDPServer) relayReplies(session *udpSession) {
+	defer srv.dropSession(session.client.String(), session)
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := session.remote.Read(buf)
+		if err != nil {
+			return
+		}
+
+		session.touch()
+		if _, err := srv.Conn.WriteTo(buf[:n], session.client); err != nil {
+			return
+		}
+	}
+}
+
+func (srv *UDPServer) dropSession(key string, session *udpSession) {
+	srv.mu.Lock()
+	if srv.sessions[key] == session {
+		delete(srv.sessions, key)
+	}
+	srv.mu.Unlock()
+
+	session.remote.Close()
+}
+
+func (srv *UDPServer) expireSessions(ctx context.Context) {
+	interval := srv.IdleTimeout / 2
+	if interval > 10*time.Second {
+		interval = 10 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			srv.mu.Lock()
+			var idle []*udpSession
+			for _, session := range srv.sessions {
+				if now.Sub(session.idleSince()) >= srv.IdleTimeout {
+					idle = append(idle, session)
+				}
+			}
+			srv.mu.Unlock()
+
+			for _, session := range idle {
+				terminal.Debug("udp: session timed out for ", session.client)
+				srv.dropSession(session.client.String(), session)
+			}
+		}
+	}
+}
+
+func (srv *UDPServer) closeSessions() {
+	srv.mu.Lock()
+	sessions := srv.sessions
+	srv.sessions = map[string]*udpSession{}
+	srv.mu.Unlock()
+
+	for _, session := range sessions {
+		session.remote.Close()
+	}
+}
+
+// Sessions returns the number of active client sessions
+func (srv *UDPServer) Sessions() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	return len(srv.sessions)
+}
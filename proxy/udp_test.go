@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startUDPEcho(t *testing.T) net.PacketConn {
+	t.Helper()
+
+	target, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { target.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := target.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			target.WriteTo(append([]byte("echo: "), buf[:n]...), addr)
+		}
+	}()
+
+	return target
+}
+
+func TestUDPServerSessions(t *testing.T) {
+	target := startUDPEcho(t)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &UDPServer{
+		Addr: target.LocalAddr().String(),
+		Conn: conn,
+		Dial: (&net.Dialer{}).DialContext,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- srv.Serve(ctx) }()
+
+	exchange := func(client net.Conn, msg string) string {
+		t.Helper()
+
+		_, err := client.Write([]byte(msg))
+		require.NoError(t, err)
+
+		require.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+		buf := make([]byte, 1500)
+		n, err := client.Read(buf)
+		require.NoError(t, err)
+		return string(buf[:n])
+	}
+
+	a, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer a.Close()
+	b, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer b.Close()
+
+	assert.Equal(t, "echo: one", exchange(a, "one"))
+	assert.Equal(t, "echo: two", exchange(b, "two"))
+	assert.Equal(t, "echo: three", exchange(a, "three"))
+	assert.Equal(t, 2, srv.Sessions())
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Equal(t, 0, srv.Sessions())
+}
+
+func TestUDPServerIdleTimeout(t *testing.T) {
+	target := startUDPEcho(t)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &UDPServer{
+		Addr:        target.LocalAddr().String(),
+		Conn:        conn,
+		Dial:        (&net.Dialer{}).DialContext,
+		IdleTimeout: 50 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.Serve(ctx)
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = client.Read(make([]byte, 1500))
+	require.NoError(t, err)
+	assert.Equal(t, 1, srv.Sessions())
+
+	assert.Eventually(t, func() bool { return srv.Sessions() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,200 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// EnvReference is a place in the source tree that reads an environment
+// variable.
+type EnvReference struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+const envName = `([A-Za-z_][A-Za-z0-9_]*)`
+
+// envPatterns are the ways common languages read environment variables, by
+// file extension. Each pattern captures the variable name.
+var envPatterns = map[string][]*regexp.Regexp{
+	".go": {
+		regexp.MustCompile(`os\.(?:Getenv|LookupEnv)\(\s*"` + envName + `"`),
+	},
+	".js": jsEnvPatterns, ".jsx": jsEnvPatterns, ".mjs": jsEnvPatterns, ".cjs": jsEnvPatterns,
+	".ts": jsEnvPatterns, ".tsx": jsEnvPatterns, ".mts": jsEnvPatterns, ".vue": jsEnvPatterns, ".svelte": jsEnvPatterns,
+	".rb": rubyEnvPatterns, ".erb": rubyEnvPatterns, ".rake": rubyEnvPatterns,
+	".py": {
+		regexp.MustCompile(`os\.environ(?:\.get)?[\[(]\s*["']` + envName + `["']`),
+		regexp.MustCompile(`(?:os\.getenv|env(?:\.\w+)?)\(\s*["']` + envName + `["']`),
+	},
+	".ex": elixirEnvPatterns, ".exs": elixirEnvPatterns, ".heex": elixirEnvPatterns,
+	".php": {
+		regexp.MustCompile(`\b(?:env|getenv)\(\s*["']` + envName + `["']`),
+		regexp.MustCompile(`\$_(?:ENV|SERVER)\[\s*["']` + envName + `["']`),
+	},
+	".rs": {
+		regexp.MustCompile(`env::var(?:_os)?\(\s*"` + envName + `"`),
+		regexp.MustCompile(`\b(?:option_)?env!\(\s*"` + envName + `"`),
+	},
+	".java": jvmEnvPatterns, ".kt": jvmEnvPatterns, ".scala": jvmEnvPatterns,
+	".cs": {
+		regexp.MustCompile(`Environment\.GetEnvironmentVariable\(\s*"` + envName + `"`),
+	},
+	".sh": shellEnvPatterns, ".bash": shellEnvPatterns,
+	".properties": placeholderEnvPatterns,
+}
+
+var (
+	jsEnvPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?:process|import\.meta)\.env\.` + envName),
+		regexp.MustCompile(`(?:process|import\.meta)\.env\[\s*["'` + "`" + `]` + envName + `["'` + "`" + `]`),
+		regexp.MustCompile(`Deno\.env\.get\(\s*["']` + envName + `["']`),
+		regexp.MustCompile(`Bun\.env\.` + envName),
+	}
+	rubyEnvPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`ENV(?:\.fetch\(\s*|\[\s*)["']` + envName + `["']`),
+	}
+	elixirEnvPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`System\.(?:get_env|fetch_env!?)\(\s*"` + envName + `"`),
+	}
+	jvmEnvPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`System\.getenv\(\s*"` + envName + `"`),
+	}
+	// Spring style placeholders in properties and YAML config.
+	placeholderEnvPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\$\{([A-Z_][A-Z0-9_]*)(?::[^}]*)?\}`),
+	}
+	// Shell variables are only counted when upper case, as locals usually
+	// aren't.
+	shellEnvPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\$\{?([A-Z_][A-Z0-9_]*)`),
+	}
+	composeEnvPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\$\{` + envName + `(?::?-[^}]*)?\}`),
+	}
+)
+
+// skippedDirs are never searched for references.
+var skippedDirs = map[string]bool{
+	".git":         true,
+	"node_modules": true,
+	"vendor":       true,
+	"_build":       true,
+	"deps":         true,
+	"target":       true,
+	"dist":         true,
+	".next":        true,
+	"__pycache__":  true,
+	".venv":        true,
+	"venv":         true,
+}
+
+// maxEnvScanFileSize skips generated and minified files, which are large and
+// don't hold references worth reporting.
+const maxEnvScanFileSize = 1 << 20
+
+// FindEnvReferences searches the source tree for environment variables the
+// code reads, with a per-language heuristic. References are sorted by name,
+// then file and line. Files are relative to sourceDir.
+func FindEnvReferences(sourceDir string) ([]EnvReference, error) {
+	var refs []EnvReference
+
+	err := filepath.WalkDir(sourceDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != sourceDir && skippedDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		patterns := envPatternsFor(d.Name())
+		if patterns == nil {
+			return nil
+		}
+
+		if info, err := d.Info(); err != nil || info.Size() > maxEnvScanFileSize {
+			return nil
+		}
+
+		rel, err := filepath.Rel(sourceDir, path)
+		if err != nil {
+			return err
+		}
+
+		found, err := scanEnvReferences(path, filepath.ToSlash(rel), patterns)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, found...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(refs, func(i, j int) bool {
+		if refs[i].Name != refs[j].Name {
+			return refs[i].Name < refs[j].Name
+		}
+		if refs[i].File != refs[j].File {
+			return refs[i].File < refs[j].File
+		}
+		return refs[i].Line < refs[j].Line
+	})
+
+	return refs, nil
+}
+
+func envPatternsFor(filename string) []*regexp.Regexp {
+	switch base := strings.ToLower(filename); {
+	case base == "procfile" || strings.HasPrefix(base, "procfile."):
+		return shellEnvPatterns
+	case base == "docker-compose.yml" || base == "compose.yml":
+		return composeEnvPatterns
+	case strings.HasSuffix(base, ".yml") || strings.HasSuffix(base, ".yaml"):
+		// ERB templated config, like Rails' database.yml, or placeholders
+		return append(append([]*regexp.Regexp{}, rubyEnvPatterns...), placeholderEnvPatterns...)
+	default:
+		return envPatterns[filepath.Ext(base)]
+	}
+}
+
+func scanEnvReferences(path, rel string, patterns []*regexp.Regexp) ([]EnvReference, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		// binary
+		return nil, nil
+	}
+
+	var refs []EnvReference
+
+	lines := bufio.NewScanner(bytes.NewReader(data))
+	lines.Buffer(nil, maxEnvScanFileSize)
+	for line := 1; lines.Scan(); line++ {
+		text := lines.Text()
+		seen := map[string]bool{}
+		for _, re := range patterns {
+			for _, m := range re.FindAllStringSubmatch(text, -1) {
+				if name := m[1]; !seen[name] {
+					seen[name] = true
+					refs = append(refs, EnvReference{Name: name, File: rel, Line: line})
+				}
+			}
+		}
+	}
+
+	return refs, lines.Err()
+}
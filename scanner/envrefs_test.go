@@ -0,0 +1,48 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindEnvReferences(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"main.go":                 "port := os.Getenv(\"PORT\")\n_, ok := os.LookupEnv(\"DEBUG\")\n",
+		"web/app.ts":              "const url = process.env.DATABASE_URL\nconst k = process.env['API_KEY'] ?? import.meta.env.VITE_HOST\n",
+		"config/database.yml":     "url: <%= ENV['DATABASE_URL'] %>\npool: <%= ENV.fetch(\"RAILS_MAX_THREADS\") { 5 } %>\n",
+		"app/settings.py":         "SECRET = os.environ[\"SECRET_KEY\"]\nDEBUG = os.getenv('DJANGO_DEBUG')\n",
+		"config/runtime.exs":      "System.fetch_env!(\"SECRET_KEY_BASE\")\n",
+		"bin/start.sh":            "exec app --port $PORT --name ${APP_NAME} $local\n",
+		"node_modules/x/index.js": "process.env.IGNORED\n",
+		"README.md":               "process.env.NOT_CODE\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	refs, err := FindEnvReferences(dir)
+	require.NoError(t, err)
+
+	assert.Equal(t, []EnvReference{
+		{Name: "API_KEY", File: "web/app.ts", Line: 2},
+		{Name: "APP_NAME", File: "bin/start.sh", Line: 1},
+		{Name: "DATABASE_URL", File: "config/database.yml", Line: 1},
+		{Name: "DATABASE_URL", File: "web/app.ts", Line: 1},
+		{Name: "DEBUG", File: "main.go", Line: 2},
+		{Name: "DJANGO_DEBUG", File: "app/settings.py", Line: 2},
+		{Name: "PORT", File: "bin/start.sh", Line: 1},
+		{Name: "PORT", File: "main.go", Line: 1},
+		{Name: "RAILS_MAX_THREADS", File: "config/database.yml", Line: 2},
+		{Name: "SECRET_KEY", File: "app/settings.py", Line: 1},
+		{Name: "SECRET_KEY_BASE", File: "config/runtime.exs", Line: 1},
+		{Name: "VITE_HOST", File: "web/app.ts", Line: 2},
+	}, refs)
+}
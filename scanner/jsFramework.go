@@ -57,6 +57,26 @@ func detectPortFromSource() int {
 	return 0
 }
 
+// hasStartScript reports whether the package.json in sourceDir has a start
+// script, which configureJsFramework needs to launch the app. It doesn't look
+// for node, so it's the cheap check of the source alone.
+func hasStartScript(sourceDir string) bool {
+	data, err := os.ReadFile(filepath.Join(sourceDir, "package.json"))
+	if err != nil {
+		return false
+	}
+
+	var pkg struct {
+		Scripts map[string]interface{} `json:"scripts"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return false
+	}
+
+	start, _ := pkg.Scripts["start"].(string)
+	return start != ""
+}
+
 // Handle js frameworks separate from other node applications.  Currently the requirements
 // for a framework is pretty low: to have a "start" script.  Because we are actually
 // going to be running a js application to generate a Dockerfile there is one more
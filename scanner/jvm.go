@@ -0,0 +1,296 @@
+package scanner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/superfly/flyctl/internal/command/launch/plan"
+)
+
+const defaultJavaVersion = "21"
+
+type jvmFramework struct {
+	name       string
+	family     string
+	dependency *regexp.Regexp
+}
+
+var jvmFrameworks = []jvmFramework{
+	{name: "spring", family: "Spring Boot", dependency: regexp.MustCompile(`org\.springframework\.boot`)},
+	{name: "quarkus", family: "Quarkus", dependency: regexp.MustCompile(`io\.quarkus`)},
+	{name: "micronaut", family: "Micronaut", dependency: regexp.MustCompile(`io\.micronaut`)},
+}
+
+var (
+	javaVersionPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`<java\.version>\s*(\d+)`),
+		regexp.MustCompile(`<maven\.compiler\.release>\s*(\d+)`),
+		regexp.MustCompile(`<maven\.compiler\.target>\s*(?:1\.)?(\d+)`),
+		regexp.MustCompile(`<release>\s*(\d+)\s*</release>`),
+		regexp.MustCompile(`JavaLanguageVersion\.of\(\s*(\d+)\s*\)`),
+		regexp.MustCompile(`JavaVersion\.VERSION_(?:1_)?(\d+)`),
+		regexp.MustCompile(`(?:source|target)Compatibility\s*=\s*['"]?(?:1\.)?(\d+)`),
+		regexp.MustCompile(`jvmToolchain\(\s*(\d+)\s*\)`),
+	}
+	springBootVersionPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?s)<artifactId>spring-boot-starter-parent</artifactId>\s*<version>(\d+)\.(\d+)`),
+		regexp.MustCompile(`org\.springframework\.boot['"]?\)?\s*version\s*['"](\d+)\.(\d+)`),
+	}
+	portPatterns = map[string]*regexp.Regexp{
+		"spring":    regexp.MustCompile(`(?m)^\s*server\.port\s*[=:]\s*(\d+)|(?m)^server:\s*\n\s+port:\s*(\d+)`),
+		"quarkus":   regexp.MustCompile(`(?m)^\s*quarkus\.http\.port\s*[=:]\s*(\d+)`),
+		"micronaut": regexp.MustCompile(`(?m)^\s*micronaut\.server\.port\s*[=:]\s*(\d+)`),
+	}
+	postgresPattern = regexp.MustCompile(`jdbc:postgresql:|r2dbc:postgresql:|db-kind\s*[=:]\s*['"]?postgresql|dialect\s*[=:]\s*['"]?POSTGRES`)
+	mysqlPattern    = regexp.MustCompile(`jdbc:(mysql|mariadb):|r2dbc:(mysql|mariadb):|db-kind\s*[=:]\s*['"]?(mysql|mariadb)|dialect\s*[=:]\s*['"]?MYSQL`)
+	sqlitePattern   = regexp.MustCompile(`jdbc:sqlite:`)
+	redisPattern    = regexp.MustCompile(`spring\.(data\.)?redis|quarkus\.redis|redis\.uri|redis://`)
+)
+
+// configureJvm detects Spring Boot, Quarkus and Micronaut applications built
+// with Maven or Gradle.
+func configureJvm(sourceDir string, _ *ScannerConfig) (*SourceInfo, error) {
+	buildTool, buildFile := detectJvmBuildTool(sourceDir)
+	if buildTool == "" {
+		return nil, nil
+	}
+
+	buildData, err := os.ReadFile(buildFile)
+	if err != nil {
+		return nil, nil
+	}
+	build := string(buildData)
+
+	var framework *jvmFramework
+	for i := range jvmFrameworks {
+		if jvmFrameworks[i].dependency.MatchString(build) {
+			framework = &jvmFrameworks[i]
+			break
+		}
+	}
+	if framework == nil {
+		return nil, nil
+	}
+
+	javaVersion := extractJavaVersion(build)
+	config := readJvmApplicationConfig(sourceDir)
+
+	port := 8080
+	if m := portPatterns[framework.name].FindStringSubmatch(config); m != nil {
+		for _, group := range m[1:] {
+			if p, err := strconv.Atoi(group); err == nil && p > 0 {
+				port = p
+				break
+			}
+		}
+	}
+
+	language := "java"
+	if strings.HasSuffix(buildFile, ".kts") || strings.Contains(build, "kotlin") {
+		language = "kotlin"
+	}
+
+	s := &SourceInfo{
+		Family: framework.family,
+		Port:   port,
+		Env: map[string]string{
+			"PORT": strconv.Itoa(port),
+		},
+		Runtime:       plan.RuntimeStruct{Language: language, Version: javaVersion},
+		HttpCheckPath: jvmHealthCheckPath(framework.name, build, config),
+		Callback:      jvmCallback,
+	}
+
+	switch framework.name {
+	case "spring":
+		s.Env["SERVER_PORT"] = strconv.Itoa(port)
+	case "quarkus":
+		s.Env["QUARKUS_HTTP_PORT"] = strconv.Itoa(port)
+	case "micronaut":
+		s.Env["MICRONAUT_SERVER_PORT"] = strconv.Itoa(port)
+	}
+
+	switch {
+	case postgresPattern.MatchString(config) || strings.Contains(build, "org.postgresql"):
+		s.DatabaseDesired = DatabaseKindPostgres
+	case mysqlPattern.MatchString(config) || strings.Contains(build, "mysql-connector") || strings.Contains(build, "mariadb-java-client"):
+		s.DatabaseDesired = DatabaseKindMySQL
+	case sqlitePattern.MatchString(config) || strings.Contains(build, "sqlite-jdbc"):
+		s.DatabaseDesired = DatabaseKindSqlite
+	default:
+		s.SkipDatabase = true
+	}
+	s.RedisDesired = redisPattern.MatchString(config) || strings.Contains(build, "spring-boot-starter-data-redis")
+
+	vars := map[string]interface{}{
+		"buildTool":   buildTool,
+		"wrapper":     jvmWrapperExists(sourceDir, buildTool),
+		"javaVersion": javaVersion,
+		"framework":   framework.name,
+		"port":        port,
+	}
+	if buildTool == "maven" {
+		vars["jarDir"] = "target"
+		vars["quarkusDir"] = "target/quarkus-app"
+	} else {
+		vars["jarDir"] = "build/libs"
+		vars["quarkusDir"] = "build/quarkus-app"
+	}
+	switch framework.name {
+	case "spring":
+		vars["gradleTask"] = "bootJar"
+		vars["springLauncher"] = springBootLauncher(build)
+	case "quarkus":
+		vars["gradleTask"] = "build"
+	default:
+		vars["gradleTask"] = "assemble"
+	}
+
+	s.Files = templatesExecute("templates/jvm", vars)
+
+	return s, nil
+}
+
+func detectJvmBuildTool(sourceDir string) (tool string, buildFile string) {
+	if path := filepath.Join(sourceDir, "pom.xml"); absFileExists(path) {
+		return "maven", path
+	}
+	for _, name := range []string{"build.gradle.kts", "build.gradle"} {
+		if path := filepath.Join(sourceDir, name); absFileExists(path) {
+			return "gradle", path
+		}
+	}
+	return "", ""
+}
+
+func jvmWrapperExists(sourceDir, buildTool string) bool {
+	if buildTool == "maven" {
+		return checksPass(sourceDir, fileExists("mvnw")) && dirExists(filepath.Join(sourceDir, ".mvn"))
+	}
+	return checksPass(sourceDir, fileExists("gradlew")) && dirExists(filepath.Join(sourceDir, "gradle"))
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func extractJavaVersion(build string) string {
+	for _, re := range javaVersionPatterns {
+		if m := re.FindStringSubmatch(build); m != nil {
+			return m[1]
+		}
+	}
+	return defaultJavaVersion
+}
+
+// springBootLauncher returns the main class of the extracted Spring Boot jar,
+// which moved packages in Spring Boot 3.2.
+func springBootLauncher(build string) string {
+	for _, re := range springBootVersionPatterns {
+		m := re.FindStringSubmatch(build)
+		if m == nil {
+			continue
+		}
+		major, _ := strconv.Atoi(m[1])
+		minor, _ := strconv.Atoi(m[2])
+		if major < 3 || (major == 3 && minor < 2) {
+			return "org.springframework.boot.loader.JarLauncher"
+		}
+		break
+	}
+	return "org.springframework.boot.loader.launch.JarLauncher"
+}
+
+// readJvmApplicationConfig concatenates the application.properties and
+// application.yml files found in the project's resources.
+func readJvmApplicationConfig(sourceDir string) string {
+	var config strings.Builder
+	resources := filepath.Join(sourceDir, "src", "main", "resources")
+	for _, name := range []string{"application.properties", "application.yml", "application.yaml"} {
+		data, err := os.ReadFile(filepath.Join(resources, name))
+		if err != nil {
+			continue
+		}
+		config.Write(data)
+		config.WriteString("\n")
+	}
+	return config.String()
+}
+
+func jvmHealthCheckPath(framework, build, config string) string {
+	switch framework {
+	case "spring":
+		if !strings.Contains(build, "spring-boot-starter-actuator") {
+			return ""
+		}
+		basePath := "/actuator"
+		if m := regexp.MustCompile(`(?m)^\s*management\.endpoints\.web\.base-path\s*[=:]\s*(\S+)`).FindStringSubmatch(config); m != nil {
+			basePath = strings.TrimRight(m[1], "/")
+		}
+		return basePath + "/health"
+	case "quarkus":
+		if strings.Contains(build, "quarkus-smallrye-health") {
+			return "/q/health"
+		}
+	case "micronaut":
+		if strings.Contains(build, "micronaut-management") {
+			return "/health"
+		}
+	}
+	return ""
+}
+
+// jvmCallback sets JVM memory flags once the machine size has been chosen.
+func jvmCallback(appName string, srcInfo *SourceInfo, plan *plan.LaunchPlan, flags []string) error {
+	if plan == nil || len(plan.Compute) == 0 || plan.Compute[0].MachineGuest == nil {
+		return nil
+	}
+	guest := plan.Compute[0].MachineGuest
+	if srcInfo.Env == nil {
+		srcInfo.Env = map[string]string{}
+	}
+	if _, ok := srcInfo.Env["JAVA_TOOL_OPTIONS"]; !ok {
+		srcInfo.Env["JAVA_TOOL_OPTIONS"] = JvmMemoryFlags(guest.MemoryMB, guest.CPUs)
+	}
+	return nil
+}
+
+// JvmMemoryFlags returns JVM options sized for a machine with the given memory
+// and CPUs. The heap gets three quarters of memory on larger machines, leaving
+// room for metaspace, thread stacks and native memory; small machines keep a
+// fixed 128MB of headroom instead.
+func JvmMemoryFlags(memoryMB, cpus int) string {
+	if memoryMB <= 0 {
+		return "-XX:MaxRAMPercentage=75.0"
+	}
+
+	heap := memoryMB * 3 / 4
+	if memoryMB-heap < 128 {
+		heap = memoryMB - 128
+	}
+	if heap < 64 {
+		heap = 64
+	}
+
+	gc := "-XX:+UseSerialGC"
+	if cpus >= 2 && memoryMB >= 2048 {
+		gc = "-XX:+UseG1GC"
+	}
+
+	flags := []string{
+		fmt.Sprintf("-Xms%dm", heap),
+		fmt.Sprintf("-Xmx%dm", heap),
+		"-Xss512k",
+		"-XX:MaxMetaspaceSize=" + strconv.Itoa(max(64, memoryMB/8)) + "m",
+		gc,
+	}
+	if cpus > 0 {
+		flags = append(flags, fmt.Sprintf("-XX:ActiveProcessorCount=%d", cpus))
+	}
+	return strings.Join(flags, " ")
+}
@@ -0,0 +1,98 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeJvmFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+	}
+}
+
+func TestScanSpringBootMaven(t *testing.T) {
+	dir := t.TempDir()
+	writeJvmFiles(t, dir, map[string]string{
+		"pom.xml": `<project>
+  <parent>
+    <groupId>org.springframework.boot</groupId>
+    <artifactId>spring-boot-starter-parent</artifactId>
+    <version>3.3.1</version>
+  </parent>
+  <properties><java.version>17</java.version></properties>
+  <dependencies>
+    <dependency><artifactId>spring-boot-starter-actuator</artifactId></dependency>
+    <dependency><groupId>org.postgresql</groupId></dependency>
+  </dependencies>
+</project>`,
+		"src/main/resources/application.properties": "server.port=9090\nspring.datasource.url=jdbc:postgresql://localhost/app\n",
+	})
+
+	t.Setenv("OPT_OUT_GITHUB_ACTIONS", "1")
+	si, err := Scan(dir, &ScannerConfig{})
+	require.NoError(t, err)
+	require.NotNil(t, si)
+
+	assert.Equal(t, "Spring Boot", si.Family)
+	assert.Equal(t, 9090, si.Port)
+	assert.Equal(t, "9090", si.Env["SERVER_PORT"])
+	assert.Equal(t, "/actuator/health", si.HttpCheckPath)
+	assert.Equal(t, DatabaseKindPostgres, si.DatabaseDesired)
+	assert.Equal(t, "17", si.Runtime.Version)
+
+	var dockerfile string
+	for _, f := range si.Files {
+		if f.Path == "Dockerfile" {
+			dockerfile = string(f.Contents)
+		}
+	}
+	assert.Contains(t, dockerfile, "FROM maven:3-eclipse-temurin-${JAVA_VERSION} AS build")
+	assert.Contains(t, dockerfile, "ARG JAVA_VERSION=17")
+	assert.Contains(t, dockerfile, "org.springframework.boot.loader.launch.JarLauncher")
+}
+
+func TestScanQuarkusGradle(t *testing.T) {
+	dir := t.TempDir()
+	writeJvmFiles(t, dir, map[string]string{
+		"build.gradle.kts": `dependencies {
+    implementation("io.quarkus:quarkus-rest")
+    implementation("io.quarkus:quarkus-smallrye-health")
+}
+kotlin { jvmToolchain(21) }
+`,
+	})
+
+	t.Setenv("OPT_OUT_GITHUB_ACTIONS", "1")
+	si, err := Scan(dir, &ScannerConfig{})
+	require.NoError(t, err)
+	require.NotNil(t, si)
+
+	assert.Equal(t, "Quarkus", si.Family)
+	assert.Equal(t, 8080, si.Port)
+	assert.Equal(t, "/q/health", si.HttpCheckPath)
+	assert.Equal(t, "kotlin", si.Runtime.Language)
+	assert.True(t, si.SkipDatabase)
+}
+
+func TestSpringBootLauncher(t *testing.T) {
+	assert.Equal(t, "org.springframework.boot.loader.JarLauncher",
+		springBootLauncher(`id("org.springframework.boot") version "3.1.5"`))
+	assert.Equal(t, "org.springframework.boot.loader.launch.JarLauncher",
+		springBootLauncher(`id("org.springframework.boot") version "3.2.0"`))
+	assert.Equal(t, "org.springframework.boot.loader.launch.JarLauncher", springBootLauncher(""))
+}
+
+func TestJvmMemoryFlags(t *testing.T) {
+	assert.Equal(t, "-XX:MaxRAMPercentage=75.0", JvmMemoryFlags(0, 1))
+	assert.Equal(t, "-Xms128m -Xmx128m -Xss512k -XX:MaxMetaspaceSize=64m -XX:+UseSerialGC -XX:ActiveProcessorCount=1", JvmMemoryFlags(256, 1))
+	assert.Equal(t, "-Xms768m -Xmx768m -Xss512k -XX:MaxMetaspaceSize=128m -XX:+UseSerialGC -XX:ActiveProcessorCount=1", JvmMemoryFlags(1024, 1))
+	assert.Equal(t, "-Xms3072m -Xmx3072m -Xss512k -XX:MaxMetaspaceSize=512m -XX:+UseG1GC -XX:ActiveProcessorCount=2", JvmMemoryFlags(4096, 2))
+}
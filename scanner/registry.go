@@ -0,0 +1,173 @@
+package scanner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ScanFunc inspects sourceDir and returns a SourceInfo describing how to launch
+// it, or nil if the source isn't recognized.
+type ScanFunc func(sourceDir string, config *ScannerConfig) (*SourceInfo, error)
+
+// Detector is a named framework or runtime detector. Detectors are tried from
+// highest to lowest Priority and the first one whose Scan returns a SourceInfo
+// wins.
+type Detector struct {
+	Name string
+	// Priority orders detectors; higher priorities are tried first.
+	Priority int
+	// Confidence is the default confidence, between 0 and 1, reported when
+	// this detector matches. A scanner may override it by setting
+	// SourceInfo.Confidence.
+	Confidence float64
+	// Match is an optional cheap check used to report other detectors that
+	// would also have matched the source. It must not have side effects.
+	Match func(sourceDir string) bool
+	Scan  ScanFunc
+}
+
+// Candidate is a detector that matched a source directory.
+type Candidate struct {
+	Name       string
+	Priority   int
+	Confidence float64
+}
+
+func (c Candidate) String() string {
+	return fmt.Sprintf("%s (priority %d, confidence %.2f)", c.Name, c.Priority, c.Confidence)
+}
+
+// Detection records which detector was picked for a source directory and
+// which others also matched.
+type Detection struct {
+	Chosen       Candidate
+	Alternatives []Candidate
+}
+
+// Explain returns a human readable reason for the choice, or an empty string
+// when no other detector matched.
+func (d *Detection) Explain() string {
+	if d == nil || len(d.Alternatives) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(d.Alternatives))
+	for _, alt := range d.Alternatives {
+		names = append(names, alt.String())
+	}
+
+	reason := "it has the highest priority"
+	if top := d.Alternatives[0]; top.Priority == d.Chosen.Priority {
+		reason = "it has the same priority but was registered first"
+		if top.Confidence < d.Chosen.Confidence {
+			reason = "it has the same priority and a higher confidence"
+		}
+	}
+
+	return fmt.Sprintf("Picked %s over %s because %s", d.Chosen, strings.Join(names, ", "), reason)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   []Detector
+)
+
+// Register adds detectors to the set used by Scan. It's intended to be called
+// from init functions of packages that extend fly launch.
+func Register(detectors ...Detector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, detectors...)
+}
+
+// Detectors returns all registered detectors sorted by priority.
+func Detectors() []Detector {
+	registryMu.RLock()
+	detectors := append([]Detector{}, builtinDetectors()...)
+	detectors = append(detectors, registry...)
+	registryMu.RUnlock()
+
+	sortDetectors(detectors)
+	return detectors
+}
+
+func sortDetectors(detectors []Detector) {
+	sort.SliceStable(detectors, func(i, j int) bool {
+		if detectors[i].Priority != detectors[j].Priority {
+			return detectors[i].Priority > detectors[j].Priority
+		}
+		return detectors[i].Confidence > detectors[j].Confidence
+	})
+}
+
+// alternatives returns the detectors, other than the chosen one, whose Match
+// check passes for sourceDir. Detectors without a Match are skipped since
+// running their Scan may have side effects.
+func alternatives(detectors []Detector, sourceDir string) []Candidate {
+	var candidates []Candidate
+	for _, d := range detectors {
+		if d.Match == nil || !d.Match(sourceDir) {
+			continue
+		}
+		candidates = append(candidates, Candidate{Name: d.Name, Priority: d.Priority, Confidence: d.Confidence})
+	}
+	return candidates
+}
+
+func detectorConfidence(d Detector, si *SourceInfo) float64 {
+	if si.Confidence > 0 {
+		return si.Confidence
+	}
+	return d.Confidence
+}
+
+// Confidence levels used by the built-in detectors.
+const (
+	confidenceFramework = 0.9
+	confidenceDocker    = 0.8
+	confidenceLanguage  = 0.6
+	confidenceFallback  = 0.2
+)
+
+// builtinDetectors returns the detectors shipped with flyctl. Frameworks are
+// placed before generic scanners since they might mix languages or have a
+// Dockerfile that doesn't work with Fly.
+func builtinDetectors() []Detector {
+	return []Detector{
+		{Name: "Django", Priority: 220, Confidence: confidenceFramework, Scan: configureDjango,
+			Match: anyCheck(dirContains("requirements.txt", "(?i)Django"), dirContains("Pipfile", "(?i)Django"), dirContains("pyproject.toml", "(?i)Django"))},
+		{Name: "Laravel", Priority: 210, Confidence: confidenceFramework, Scan: configureLaravel, Match: fileExists("artisan")},
+		{Name: "Phoenix", Priority: 200, Confidence: confidenceFramework, Scan: configurePhoenix, Match: dirContains("mix.exs", "phoenix")},
+		{Name: "Rails", Priority: 190, Confidence: confidenceFramework, Scan: configureRails,
+			Match: anyCheck(dirContains("config.ru", "Rails"), dirContains("Gemfile.lock", " rails "))},
+		{Name: "RedwoodJS", Priority: 180, Confidence: confidenceFramework, Scan: configureRedwood, Match: fileExists("redwood.toml")},
+		{Name: "JavaScript framework", Priority: 170, Confidence: confidenceFramework, Scan: configureJsFramework, Match: hasStartScript},
+		{Name: "Dockerfile", Priority: 160, Confidence: confidenceDocker, Scan: configureDockerfile, Match: fileExists("Dockerfile")},
+		{Name: "Bridgetown", Priority: 150, Confidence: confidenceFramework, Scan: configureBridgetown, Match: dirContains("Gemfile", "bridgetown")},
+		{Name: "Lucky", Priority: 140, Confidence: confidenceFramework, Scan: configureLucky, Match: dirContains("shard.yml", "lucky")},
+		{Name: "JVM", Priority: 135, Confidence: confidenceFramework, Scan: configureJvm,
+			Match: anyCheck(dirContains("pom.xml", `io\.quarkus|io\.micronaut|org\.springframework\.boot`), dirContains("build.gradle*", `io\.quarkus|io\.micronaut|org\.springframework\.boot`))},
+		{Name: "Ruby", Priority: 130, Confidence: confidenceLanguage, Scan: configureRuby, Match: fileExists("Gemfile", "config.ru")},
+		{Name: "Go", Priority: 120, Confidence: confidenceLanguage, Scan: configureGo, Match: fileExists("go.mod")},
+		{Name: "Elixir", Priority: 110, Confidence: confidenceLanguage, Scan: configureElixir, Match: fileExists("mix.exs")},
+		{Name: "Flask", Priority: 100, Confidence: confidenceFramework, Scan: configureFlask, Match: dirContains("requirements.txt", "Flask")},
+		{Name: "Python", Priority: 90, Confidence: confidenceLanguage, Scan: configurePython,
+			Match: fileExists("requirements.txt", "environment.yml", "poetry.lock", "Pipfile", "setup.py", "setup.cfg", "pyproject.toml")},
+		{Name: "Deno", Priority: 80, Confidence: confidenceLanguage, Scan: configureDeno, Match: fileExists("deno.json", "deno.jsonc")},
+		{Name: "NuxtJS", Priority: 70, Confidence: confidenceFramework, Scan: configureNuxt, Match: fileExists("nuxt.config.ts")},
+		{Name: "Next.js", Priority: 60, Confidence: confidenceFramework, Scan: configureNextJs,
+			Match: anyCheck(fileExists("next.config.js"), dirContains("package.json", "\"next\""))},
+		{Name: "NodeJS", Priority: 50, Confidence: confidenceLanguage, Scan: configureNode, Match: fileExists("package.json")},
+		{Name: "Static", Priority: 40, Confidence: confidenceFallback, Scan: configureStatic, Match: fileExists("index.html")},
+		{Name: ".NET", Priority: 30, Confidence: confidenceLanguage, Scan: configureDotnet, Match: dirContains("*.csproj", "Microsoft.NET.Sdk.Web")},
+		{Name: "Rust", Priority: 20, Confidence: confidenceLanguage, Scan: configureRust, Match: fileExists("Cargo.toml", "Cargo.lock")},
+	}
+}
+
+func anyCheck(checks ...checkFn) checkFn {
+	return func(dir string) bool {
+		return checksPass(dir, checks...)
+	}
+}
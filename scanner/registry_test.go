@@ -0,0 +1,121 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectorsSortedByPriority(t *testing.T) {
+	detectors := Detectors()
+	require.NotEmpty(t, detectors)
+	for i := 1; i < len(detectors); i++ {
+		assert.GreaterOrEqual(t, detectors[i-1].Priority, detectors[i].Priority)
+	}
+	assert.Equal(t, "Django", detectors[0].Name)
+}
+
+func TestSortDetectorsUsesConfidenceOnTies(t *testing.T) {
+	detectors := []Detector{
+		{Name: "low", Priority: 10, Confidence: 0.1},
+		{Name: "high", Priority: 10, Confidence: 0.9},
+		{Name: "first", Priority: 20, Confidence: 0.1},
+	}
+	sortDetectors(detectors)
+	assert.Equal(t, []string{"first", "high", "low"}, []string{detectors[0].Name, detectors[1].Name, detectors[2].Name})
+}
+
+func TestDetectionExplain(t *testing.T) {
+	var d *Detection
+	assert.Empty(t, d.Explain())
+
+	d = &Detection{Chosen: Candidate{Name: "Vite", Priority: 170, Confidence: 0.9}}
+	assert.Empty(t, d.Explain())
+
+	d.Alternatives = []Candidate{{Name: "Go", Priority: 120, Confidence: 0.6}}
+	assert.Equal(t, "Picked Vite (priority 170, confidence 0.90) over Go (priority 120, confidence 0.60) because it has the highest priority", d.Explain())
+
+	d.Alternatives = []Candidate{{Name: "Other", Priority: 170, Confidence: 0.5}}
+	assert.Contains(t, d.Explain(), "same priority and a higher confidence")
+}
+
+func TestScanReportsAlternatives(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Cargo.toml"), []byte("[package]\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0o644))
+
+	t.Setenv("OPT_OUT_GITHUB_ACTIONS", "1")
+	si, err := Scan(dir, &ScannerConfig{})
+	require.NoError(t, err)
+	require.NotNil(t, si)
+	require.NotNil(t, si.Detection)
+
+	assert.Equal(t, "Static", si.Detection.Chosen.Name)
+	require.Len(t, si.Detection.Alternatives, 1)
+	assert.Equal(t, "Rust", si.Detection.Alternatives[0].Name)
+}
+
+func TestRegister(t *testing.T) {
+	registryMu.Lock()
+	saved := registry
+	registryMu.Unlock()
+	t.Cleanup(func() {
+		registryMu.Lock()
+		registry = saved
+		registryMu.Unlock()
+	})
+
+	Register(Detector{
+		Name:       "Custom",
+		Priority:   1000,
+		Confidence: 1,
+		Match:      fileExists("custom.txt"),
+		Scan: func(sourceDir string, _ *ScannerConfig) (*SourceInfo, error) {
+			if !checksPass(sourceDir, fileExists("custom.txt")) {
+				return nil, nil
+			}
+			return &SourceInfo{Family: "Custom", Port: 9000}, nil
+		},
+	})
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "custom.txt"), []byte("hi"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0o644))
+
+	t.Setenv("OPT_OUT_GITHUB_ACTIONS", "1")
+	si, err := Scan(dir, &ScannerConfig{})
+	require.NoError(t, err)
+	require.NotNil(t, si)
+	assert.Equal(t, "Custom", si.Family)
+	assert.Equal(t, 1.0, si.Detection.Chosen.Confidence)
+	require.Len(t, si.Detection.Alternatives, 1)
+	assert.Equal(t, "Static", si.Detection.Alternatives[0].Name)
+}
+
+func TestEveryBuiltinDetectorHasMatch(t *testing.T) {
+	// detectors without a Match never show up as alternatives
+	for _, d := range builtinDetectors() {
+		assert.NotNil(t, d.Match, d.Name)
+	}
+}
+
+func TestJsFrameworkMatch(t *testing.T) {
+	dir := t.TempDir()
+	assert.False(t, hasStartScript(dir))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"scripts": {"dev": "vite"}}`), 0o644))
+	assert.False(t, hasStartScript(dir), "static sites with only a dev server aren't launched as JavaScript frameworks")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"scripts": {"start": "node server.js"}}`), 0o644))
+	assert.True(t, hasStartScript(dir))
+
+	alts := alternatives(builtinDetectors(), dir)
+	names := make([]string, 0, len(alts))
+	for _, c := range alts {
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{"JavaScript framework", "NodeJS"}, names)
+}
@@ -0,0 +1,190 @@
+package scanner
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pkg/errors"
+)
+
+// projectRulesDir is where a project can keep its own detection rules.
+var projectRulesDir = filepath.Join(".fly", "detectors")
+
+// DetectionRule is a declarative detector loaded from a TOML file. A rule
+// matches when every entry in Match passes, and produces a SourceInfo with
+// the files found in its Templates directory.
+//
+//	name = "Hugo"
+//	priority = 165
+//	confidence = 0.8
+//	port = 1313
+//	templates = "hugo"
+//
+//	[[match]]
+//	file = "hugo.toml"
+//	contains = "baseURL"
+type DetectionRule struct {
+	Name       string            `toml:"name"`
+	Family     string            `toml:"family"`
+	Version    string            `toml:"version"`
+	Priority   int               `toml:"priority"`
+	Confidence float64           `toml:"confidence"`
+	Port       int               `toml:"port"`
+	Templates  string            `toml:"templates"`
+	Env        map[string]string `toml:"env"`
+	Match      []RuleMatch       `toml:"match"`
+
+	// dir is the directory the rule was loaded from. Templates are relative to it.
+	dir string
+}
+
+// RuleMatch is a single condition of a DetectionRule. File is a glob relative
+// to the source directory; when Contains is set, at least one matching file
+// must also contain the regular expression.
+type RuleMatch struct {
+	File     string `toml:"file"`
+	Contains string `toml:"contains"`
+}
+
+func (r *DetectionRule) matches(sourceDir string) bool {
+	if len(r.Match) == 0 {
+		return false
+	}
+	for _, m := range r.Match {
+		if m.Contains == "" {
+			filenames, _ := filepath.Glob(filepath.Join(sourceDir, m.File))
+			if !absFileExists(filenames...) {
+				return false
+			}
+		} else if !dirContains(m.File, m.Contains)(sourceDir) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *DetectionRule) scan(sourceDir string, _ *ScannerConfig) (*SourceInfo, error) {
+	if !r.matches(sourceDir) {
+		return nil, nil
+	}
+
+	family := r.Family
+	if family == "" {
+		family = r.Name
+	}
+
+	si := &SourceInfo{
+		Family:     family,
+		Version:    r.Version,
+		Port:       r.Port,
+		Env:        r.Env,
+		Confidence: r.Confidence,
+	}
+
+	if r.Templates != "" {
+		files, err := ruleTemplates(filepath.Join(r.dir, r.Templates), si)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error rendering templates of detection rule %s", r.Name)
+		}
+		si.Files = files
+	}
+
+	return si, nil
+}
+
+// Detector returns a Detector backed by the rule.
+func (r *DetectionRule) Detector() Detector {
+	return Detector{
+		Name:       r.Name,
+		Priority:   r.Priority,
+		Confidence: r.Confidence,
+		Match:      r.matches,
+		Scan:       r.scan,
+	}
+}
+
+// ruleTemplates renders every file below dir as a text/template with the
+// SourceInfo as data, mirroring the embedded templates directory.
+func ruleTemplates(dir string, si *SourceInfo) (files []SourceFile, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		tmpl, err := template.New(relPath).Parse(string(data))
+		if err != nil {
+			return err
+		}
+		var result strings.Builder
+		if err := tmpl.Execute(&result, si); err != nil {
+			return err
+		}
+
+		files = append(files, SourceFile{Path: relPath, Contents: []byte(result.String())})
+		return nil
+	})
+	return files, err
+}
+
+// LoadDetectionRules reads every *.toml rule in dir. A missing directory
+// yields no rules.
+func LoadDetectionRules(dir string) ([]*DetectionRule, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]*DetectionRule, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		rule := &DetectionRule{}
+		if err := toml.Unmarshal(data, rule); err != nil {
+			return nil, errors.Wrapf(err, "error parsing detection rule %s", path)
+		}
+		if rule.Name == "" {
+			return nil, fmt.Errorf("detection rule %s is missing a name", path)
+		}
+		rule.dir = dir
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func loadDetectionRules(sourceDir string, config *ScannerConfig) ([]Detector, error) {
+	dirs := []string{filepath.Join(sourceDir, projectRulesDir)}
+	if config != nil {
+		dirs = append(dirs, config.RuleDirs...)
+	}
+
+	var detectors []Detector
+	for _, dir := range dirs {
+		rules, err := LoadDetectionRules(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range rules {
+			detectors = append(detectors, rule.Detector())
+		}
+	}
+	return detectors, nil
+}
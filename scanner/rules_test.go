@@ -0,0 +1,67 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectionRules(t *testing.T) {
+	dir := t.TempDir()
+	rulesDir := filepath.Join(dir, ".fly", "detectors")
+	require.NoError(t, os.MkdirAll(filepath.Join(rulesDir, "hugo"), 0o755))
+
+	rule := `
+name = "Hugo"
+priority = 500
+confidence = 0.75
+port = 1313
+templates = "hugo"
+
+[env]
+HUGO_ENV = "production"
+
+[[match]]
+file = "hugo.toml"
+contains = "baseURL"
+`
+	require.NoError(t, os.WriteFile(filepath.Join(rulesDir, "hugo.toml"), []byte(rule), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(rulesDir, "hugo", "Dockerfile"), []byte("EXPOSE {{ .Port }}\n"), 0o644))
+
+	rules, err := LoadDetectionRules(rulesDir)
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.False(t, rules[0].matches(dir))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hugo.toml"), []byte(`baseURL = "https://example.com"`), 0o644))
+	assert.True(t, rules[0].matches(dir))
+
+	t.Setenv("OPT_OUT_GITHUB_ACTIONS", "1")
+	si, err := Scan(dir, &ScannerConfig{})
+	require.NoError(t, err)
+	require.NotNil(t, si)
+	assert.Equal(t, "Hugo", si.Family)
+	assert.Equal(t, 1313, si.Port)
+	assert.Equal(t, "production", si.Env["HUGO_ENV"])
+	assert.Equal(t, 0.75, si.Detection.Chosen.Confidence)
+	require.Len(t, si.Files, 1)
+	assert.Equal(t, "Dockerfile", si.Files[0].Path)
+	assert.Equal(t, "EXPOSE 1313\n", string(si.Files[0].Contents))
+}
+
+func TestLoadDetectionRulesMissingDir(t *testing.T) {
+	rules, err := LoadDetectionRules(filepath.Join(t.TempDir(), "missing"))
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+}
+
+func TestLoadDetectionRulesRequiresName(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.toml"), []byte("priority = 1\n"), 0o644))
+
+	_, err := LoadDetectionRules(dir)
+	assert.Error(t, err)
+}
@@ -89,6 +89,10 @@ type SourceInfo struct {
 	FailureCallback                 func(err error) error
 	Runtime                         plan.RuntimeStruct
 	PostInitCallback                func() error
+	// Confidence optionally overrides the detector's default confidence.
+	Confidence float64
+	// Detection is set by Scan to explain which detector was picked.
+	Detection *Detection
 }
 
 type SourceFile struct {
@@ -105,6 +109,8 @@ type ScannerConfig struct {
 	ExistingPort    int
 	Colorize        *iostreams.ColorScheme
 	SkipHealthcheck bool // Skip healthcheck goroutine (primarily for tests)
+	// RuleDirs are additional directories holding declarative detection rules.
+	RuleDirs []string
 }
 
 type GitHubActionsStruct struct {
@@ -114,40 +120,32 @@ type GitHubActionsStruct struct {
 }
 
 func Scan(sourceDir string, config *ScannerConfig) (*SourceInfo, error) {
-	scanners := []sourceScanner{
-		configureDjango,
-		configureLaravel,
-		configurePhoenix,
-		configureRails,
-		configureRedwood,
-		configureJsFramework,
-		/* frameworks scanners are placed before generic scanners,
-		   since they might mix languages or have a Dockerfile that
-			 doesn't work with Fly */
-		configureDockerfile,
-		configureBridgetown,
-		configureLucky,
-		configureRuby,
-		configureGo,
-		configureElixir,
-		configureFlask,
-		configurePython,
-		configureDeno,
-		configureNuxt,
-		configureNextJs,
-		configureNode,
-		configureStatic,
-		configureDotnet,
-		configureRust,
+	detectors := Detectors()
+
+	rules, err := loadDetectionRules(sourceDir, config)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) > 0 {
+		detectors = append(detectors, rules...)
+		sortDetectors(detectors)
 	}
 
-	for _, scanner := range scanners {
-		si, err := scanner(sourceDir, config)
+	for i, detector := range detectors {
+		si, err := detector.Scan(sourceDir, config)
 		if err != nil {
 			return nil, err
 		}
 		optOutGithubActions := os.Getenv("OPT_OUT_GITHUB_ACTIONS")
 		if si != nil {
+			si.Detection = &Detection{
+				Chosen: Candidate{
+					Name:       detector.Name,
+					Priority:   detector.Priority,
+					Confidence: detectorConfidence(detector, si),
+				},
+				Alternatives: alternatives(detectors[i+1:], sourceDir),
+			}
 			if optOutGithubActions == "" {
 				github_actions(sourceDir, &si.GitHubActions)
 			}
@@ -158,8 +156,6 @@ func Scan(sourceDir string, config *ScannerConfig) (*SourceInfo, error) {
 	return nil, nil
 }
 
-type sourceScanner func(sourceDir string, config *ScannerConfig) (*SourceInfo, error)
-
 // templates recursively returns files from the templates directory within the named directory
 // will panic on errors since these files are embedded and should work
 func templates(name string) (files []SourceFile) {
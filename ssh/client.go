@@ -8,6 +8,7 @@ import (
 	"net"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 type Client struct {
@@ -20,6 +21,8 @@ type Client struct {
 
 	Client *ssh.Client
 	conn   ssh.Conn
+
+	forwardAgent bool
 }
 
 func (c *Client) Close() error {
@@ -126,5 +129,11 @@ func (c *Client) Shell(ctx context.Context, sessIO *SessionIO, cmd string, conta
 
 	defer sess.Close()
 
+	if c.forwardAgent {
+		if err := agent.RequestAgentForwarding(sess); err != nil {
+			return err
+		}
+	}
+
 	return sessIO.attach(ctx, sess, cmd)
 }
@@ -0,0 +1,227 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/superfly/flyctl/terminal"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Forward is a port forward in the style of OpenSSH's -L and -R options.
+// Connections accepted on Listen are relayed to Target. For local forwards
+// Listen is on this machine and Target is dialed from the remote; remote
+// forwards are the other way around.
+type Forward struct {
+	Listen string
+	Target string
+}
+
+func (f Forward) String() string {
+	return f.Listen + " -> " + f.Target
+}
+
+// ParseForward parses a forward spec of the form
+// [bind_address:]port:host:hostport. IPv6 addresses go in brackets. The bind
+// address defaults to localhost when left out.
+func ParseForward(spec string) (Forward, error) {
+	parts, err := splitForwardSpec(spec)
+	if err != nil {
+		return Forward{}, err
+	}
+
+	switch len(parts) {
+	case 3:
+		parts = append([]string{"localhost"}, parts...)
+	case 4:
+	default:
+		return Forward{}, fmt.Errorf("invalid forward %q: expected [bind_address:]port:host:hostport", spec)
+	}
+
+	for _, port := range []string{parts[1], parts[3]} {
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			return Forward{}, fmt.Errorf("invalid forward %q: bad port %q", spec, port)
+		}
+	}
+	if parts[2] == "" {
+		return Forward{}, fmt.Errorf("invalid forward %q: missing host", spec)
+	}
+	if parts[0] == "*" {
+		// like OpenSSH, * and an empty bind address mean all interfaces
+		parts[0] = ""
+	}
+
+	return Forward{
+		Listen: net.JoinHostPort(parts[0], parts[1]),
+		Target: net.JoinHostPort(parts[2], parts[3]),
+	}, nil
+}
+
+// splitForwardSpec splits on colons outside of square brackets.
+func splitForwardSpec(spec string) (parts []string, err error) {
+	var (
+		cur       strings.Builder
+		inBracket bool
+	)
+
+	for _, r := range spec {
+		switch {
+		case r == '[' && !inBracket:
+			inBracket = true
+		case r == ']' && inBracket:
+			inBracket = false
+		case r == ':' && !inBracket:
+			parts = append(parts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if inBracket {
+		return nil, fmt.Errorf("invalid forward %q: unterminated [", spec)
+	}
+
+	return append(parts, cur.String()), nil
+}
+
+// ForwardLocal listens on f.Listen locally and relays each connection to
+// f.Target as seen from the remote, until the context is cancelled. It
+// returns once the listener is bound.
+func (c *Client) ForwardLocal(ctx context.Context, f Forward) error {
+	if err := c.ensureConnected(ctx); err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", f.Listen)
+	if err != nil {
+		return fmt.Errorf("local forward %s: %w", f, err)
+	}
+
+	go c.serveForward(ctx, l, f, func() (net.Conn, error) {
+		return c.Client.Dial("tcp", f.Target)
+	})
+
+	return nil
+}
+
+// ForwardRemote asks the remote to listen on f.Listen and relays each
+// connection to f.Target as seen from this machine, until the context is
+// cancelled. It returns once the remote listener is bound.
+func (c *Client) ForwardRemote(ctx context.Context, f Forward) error {
+	if err := c.ensureConnected(ctx); err != nil {
+		return err
+	}
+
+	l, err := c.Client.Listen("tcp", f.Listen)
+	if err != nil {
+		return fmt.Errorf("remote forward %s: %w", f, err)
+	}
+
+	go c.serveForward(ctx, l, f, func() (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", f.Target)
+	})
+
+	return nil
+}
+
+func (c *Client) serveForward(ctx context.Context, l net.Listener, f Forward, dial func() (net.Conn, error)) {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) && ctx.Err() == nil {
+				terminal.Debugf("forward %s: accept: %v\n", f, err)
+			}
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+
+			target, err := dial()
+			if err != nil {
+				terminal.Debugf("forward %s: %v\n", f, err)
+				return
+			}
+			defer target.Close()
+
+			relay(conn, target)
+		}()
+	}
+}
+
+// relay copies in both directions until either side is done.
+func relay(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	cp := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+
+	go cp(a, b)
+	go cp(b, a)
+	wg.Wait()
+}
+
+// ForwardAgent makes the ssh-agent listening on socket available to
+// sessions started from now on, through SSH_AUTH_SOCK on the remote.
+func (c *Client) ForwardAgent(ctx context.Context, socket string) error {
+	if socket == "" {
+		return errors.New("no ssh-agent to forward; is SSH_AUTH_SOCK set?")
+	}
+
+	if err := c.ensureConnected(ctx); err != nil {
+		return err
+	}
+
+	if err := agent.ForwardToRemote(c.Client, socket); err != nil {
+		return fmt.Errorf("forward ssh-agent: %w", err)
+	}
+
+	c.forwardAgent = true
+	return nil
+}
+
+func (c *Client) ensureConnected(ctx context.Context) error {
+	if c.Client != nil {
+		return nil
+	}
+
+	return c.Connect(ctx)
+}
+
+// Wait blocks until the connection to the remote closes or the context is
+// cancelled, for sessions that only forward ports.
+func (c *Client) Wait(ctx context.Context) error {
+	if err := c.ensureConnected(ctx); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.Client.Wait() }()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-done:
+		return err
+	}
+}
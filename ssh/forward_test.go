@@ -0,0 +1,27 @@
+package ssh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseForward(t *testing.T) {
+	for spec, want := range map[string]Forward{
+		"9229:localhost:9229":         {Listen: "localhost:9229", Target: "localhost:9229"},
+		"0.0.0.0:8080:web:80":         {Listen: "0.0.0.0:8080", Target: "web:80"},
+		"*:8080:web:80":               {Listen: ":8080", Target: "web:80"},
+		"5432:[fdaa::3]:5432":         {Listen: "localhost:5432", Target: "[fdaa::3]:5432"},
+		"[::1]:5432:db.internal:5432": {Listen: "[::1]:5432", Target: "db.internal:5432"},
+	} {
+		got, err := ParseForward(spec)
+		require.NoError(t, err, spec)
+		assert.Equal(t, want, got, spec)
+	}
+
+	for _, bad := range []string{"", "9229", "9229:9229", "x:localhost:9229", "9229::9229", "9229:localhost:70000", "[::1:5432:db:5432"} {
+		_, err := ParseForward(bad)
+		assert.Error(t, err, bad)
+	}
+}
@@ -3,6 +3,7 @@ package ssh
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"sync"
 
@@ -34,6 +35,11 @@ type SessionIO struct {
 
 	AllocPTY bool
 	TermEnv  string
+
+	// Recorder, if set, records the session's output, and its input as well
+	// if RecordInput is set.
+	Recorder    *Recorder
+	RecordInput bool
 }
 
 func getFd(reader io.Reader) (fd int, ok bool) {
@@ -66,6 +72,12 @@ func (s *SessionIO) attach(ctx context.Context, sess *ssh.Session, cmd string) e
 		if err := sess.RequestPty(s.TermEnv, height, width, modes); err != nil {
 			return err
 		}
+
+		if err := s.Recorder.Start(width, height); err != nil {
+			return fmt.Errorf("start recording: %w", err)
+		}
+	} else if err := s.Recorder.Start(DefaultWidth, DefaultHeight); err != nil {
+		return fmt.Errorf("start recording: %w", err)
 	}
 
 	var closeStdin sync.Once
@@ -87,12 +99,21 @@ func (s *SessionIO) attach(ctx context.Context, sess *ssh.Session, cmd string) e
 		return err
 	}
 
+	stdinSrc := s.Stdin
+	if s.Recorder != nil {
+		if stdinSrc != nil && s.RecordInput {
+			stdinSrc = s.Recorder.InputReader(stdinSrc)
+		}
+		stdout = io.TeeReader(stdout, s.Recorder.OutputWriter())
+		stderr = io.TeeReader(stderr, s.Recorder.OutputWriter())
+	}
+
 	go func() {
 		defer closeStdin.Do(func() {
 			stdin.Close()
 		})
-		if s.Stdin != nil {
-			io.Copy(stdin, s.Stdin)
+		if stdinSrc != nil {
+			io.Copy(stdin, stdinSrc)
 		}
 	}()
 	if s.Stdout != nil {
@@ -0,0 +1,257 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// CastHeader is the first line of an asciicast v2 recording. Fly stores who
+// opened the session and where under the "fly" key, which players ignore.
+type CastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	Fly       *CastMetadata     `json:"fly,omitempty"`
+}
+
+// CastMetadata identifies the session a recording was made of.
+type CastMetadata struct {
+	User    string `json:"user,omitempty"`
+	App     string `json:"app,omitempty"`
+	Machine string `json:"machine,omitempty"`
+	Region  string `json:"region,omitempty"`
+}
+
+const (
+	castOutput = "o"
+	castInput  = "i"
+	castResize = "r"
+)
+
+// Recorder writes a terminal session to w in the asciicast v2 format. Its
+// methods are safe for concurrent use and do nothing on a nil Recorder.
+type Recorder struct {
+	header CastHeader
+
+	mu      sync.Mutex
+	w       io.Writer
+	start   time.Time
+	started bool
+	err     error
+}
+
+// NewRecorder returns a recorder that writes header, with the terminal size
+// filled in, once the session starts.
+func NewRecorder(w io.Writer, header CastHeader) *Recorder {
+	header.Version = 2
+	return &Recorder{w: w, header: header}
+}
+
+// Start writes the header. Events are timed relative to when Start is called.
+func (r *Recorder) Start(width, height int) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.started {
+		return r.err
+	}
+
+	r.started = true
+	r.start = time.Now()
+	r.header.Width, r.header.Height = width, height
+	r.header.Timestamp = r.start.Unix()
+
+	r.writeLine(r.header)
+	return r.err
+}
+
+// Resize records a change of the terminal size.
+func (r *Recorder) Resize(width, height int) {
+	r.event(castResize, fmt.Sprintf("%dx%d", width, height))
+}
+
+// Err returns the first error writing the recording.
+func (r *Recorder) Err() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+// OutputWriter returns a writer that records what's written as output.
+func (r *Recorder) OutputWriter() io.Writer {
+	return &castWriter{r: r, code: castOutput}
+}
+
+// InputReader returns a reader that records what's read from src as input.
+func (r *Recorder) InputReader(src io.Reader) io.Reader {
+	return io.TeeReader(src, &castWriter{r: r, code: castInput})
+}
+
+func (r *Recorder) event(code, data string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.started {
+		return
+	}
+
+	elapsed := time.Since(r.start).Seconds()
+	r.writeLine([]any{json.Number(fmt.Sprintf("%.6f", elapsed)), code, data})
+}
+
+func (r *Recorder) writeLine(v any) {
+	if r.err != nil {
+		return
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		r.err = err
+		return
+	}
+
+	_, r.err = r.w.Write(append(b, '\n'))
+}
+
+// castWriter turns writes into events. Multi-byte characters split across
+// writes are held back until complete, since events must be valid UTF-8.
+type castWriter struct {
+	r    *Recorder
+	code string
+	tail []byte
+}
+
+func (w *castWriter) Write(p []byte) (int, error) {
+	buf := append(w.tail, p...)
+
+	n := len(buf)
+	for i := 1; i < utf8.UTFMax && i <= len(buf); i++ {
+		if utf8.RuneStart(buf[len(buf)-i]) {
+			if !utf8.FullRune(buf[len(buf)-i:]) {
+				n = len(buf) - i
+			}
+			break
+		}
+	}
+
+	w.tail = append([]byte(nil), buf[n:]...)
+	if n > 0 {
+		w.r.event(w.code, string(buf[:n]))
+	}
+
+	return len(p), nil
+}
+
+// ReplayOptions control playback of a recording.
+type ReplayOptions struct {
+	// Speed multiplies playback speed, defaulting to 1.
+	Speed float64
+	// IdleLimit caps pauses between events, if set.
+	IdleLimit time.Duration
+}
+
+// ReadCastHeader reads the header of a recording.
+func ReadCastHeader(r *bufio.Reader) (*CastHeader, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return nil, fmt.Errorf("read recording header: %w", err)
+	}
+
+	var header CastHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, fmt.Errorf("read recording header: %w", err)
+	}
+
+	if header.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+
+	return &header, nil
+}
+
+// Replay writes the output events of the recording after its header to w,
+// keeping the recorded timing. It returns when the recording ends or the
+// context is cancelled.
+func Replay(ctx context.Context, w io.Writer, r *bufio.Reader, opts ReplayOptions) error {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+
+	var last float64
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var event []json.RawMessage
+			if err := json.Unmarshal(line, &event); err != nil || len(event) != 3 {
+				return errors.New("malformed recording event")
+			}
+
+			var (
+				at   float64
+				code string
+				data string
+			)
+			if err := errors.Join(
+				json.Unmarshal(event[0], &at),
+				json.Unmarshal(event[1], &code),
+				json.Unmarshal(event[2], &data),
+			); err != nil {
+				return fmt.Errorf("malformed recording event: %w", err)
+			}
+
+			if code != castOutput {
+				continue
+			}
+
+			wait := time.Duration((at - last) / opts.Speed * float64(time.Second))
+			if opts.IdleLimit > 0 && wait > opts.IdleLimit {
+				wait = opts.IdleLimit
+			}
+			last = at
+
+			if wait > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(wait):
+				}
+			}
+
+			if _, err := io.WriteString(w, data); err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	var buf bytes.Buffer
+
+	rec := NewRecorder(&buf, CastHeader{
+		Command: "bash",
+		Fly:     &CastMetadata{User: "jane@example.com", App: "my-app", Machine: "148e"},
+	})
+
+	// nothing is recorded before the session starts
+	io.WriteString(rec.OutputWriter(), "early")
+	require.NoError(t, rec.Start(120, 40))
+
+	out := rec.OutputWriter()
+	io.WriteString(out, "$ ")
+	// a multi-byte character split across writes comes out whole
+	io.WriteString(out, "caf\xc3")
+	io.WriteString(out, "\xa9\r\n")
+
+	in, err := io.ReadAll(rec.InputReader(strings.NewReader("ls\r")))
+	require.NoError(t, err)
+	assert.Equal(t, "ls\r", string(in))
+
+	rec.Resize(100, 30)
+	require.NoError(t, rec.Err())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 6)
+
+	header, err := ReadCastHeader(bufio.NewReader(strings.NewReader(lines[0])))
+	require.NoError(t, err)
+	assert.Equal(t, 120, header.Width)
+	assert.Equal(t, 40, header.Height)
+	assert.Equal(t, "bash", header.Command)
+	assert.Equal(t, "jane@example.com", header.Fly.User)
+	assert.NotZero(t, header.Timestamp)
+
+	var events [][]any
+	for _, line := range lines[1:] {
+		var event []any
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		require.Len(t, event, 3)
+		events = append(events, event[1:])
+	}
+	assert.Equal(t, [][]any{
+		{"o", "$ "},
+		{"o", "caf"},
+		{"o", "é\r\n"},
+		{"i", "ls\r"},
+		{"r", "100x30"},
+	}, events)
+}
+
+func TestReplay(t *testing.T) {
+	recording := `{"version": 2, "width": 80, "height": 24, "timestamp": 1700000000}
+[0.1, "o", "hello "]
+[0.2, "i", "ignored"]
+[0.3, "r", "100x30"]
+[60.0, "o", "world\r\n"]
+`
+	r := bufio.NewReader(strings.NewReader(recording))
+
+	header, err := ReadCastHeader(r)
+	require.NoError(t, err)
+	assert.Equal(t, 80, header.Width)
+
+	var out bytes.Buffer
+	err = Replay(context.Background(), &out, r, ReplayOptions{Speed: 10, IdleLimit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "hello world\r\n", out.String())
+
+	_, err = ReadCastHeader(bufio.NewReader(strings.NewReader(`{"version": 1}`)))
+	assert.Error(t, err)
+}
@@ -26,7 +26,7 @@ func (s *SessionIO) getAndWatchSize(ctx context.Context, sess *ssh.Session) (int
 	}
 
 	go func() {
-		if err := watchWindowSize(ctx, fd, sess); err != nil {
+		if err := watchWindowSize(ctx, fd, sess, s.Recorder); err != nil {
 			terminal.Debugf("Error watching window size: %s\n", err)
 		}
 	}()
@@ -34,7 +34,7 @@ func (s *SessionIO) getAndWatchSize(ctx context.Context, sess *ssh.Session) (int
 	return width, height, nil
 }
 
-func watchWindowSize(ctx context.Context, fd int, sess *ssh.Session) error {
+func watchWindowSize(ctx context.Context, fd int, sess *ssh.Session, rec *Recorder) error {
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, syscall.SIGWINCH)
 
@@ -53,5 +53,6 @@ func watchWindowSize(ctx context.Context, fd int, sess *ssh.Session) error {
 		if err := sess.WindowChange(height, width); err != nil {
 			return err
 		}
+		rec.Resize(width, height)
 	}
 }
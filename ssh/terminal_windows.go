@@ -23,7 +23,7 @@ func (s *SessionIO) getAndWatchSize(ctx context.Context, sess *ssh.Session) (int
 	}
 
 	go func() {
-		if err := watchWindowSize(ctx, fd, sess, width, height); err != nil {
+		if err := watchWindowSize(ctx, fd, sess, s.Recorder, width, height); err != nil {
 			terminal.Debugf("Error watching window size: %s\n", err)
 		}
 	}()
@@ -31,7 +31,7 @@ func (s *SessionIO) getAndWatchSize(ctx context.Context, sess *ssh.Session) (int
 	return width, height, nil
 }
 
-func watchWindowSize(ctx context.Context, fd windows.Handle, sess *ssh.Session, width int, height int) error {
+func watchWindowSize(ctx context.Context, fd windows.Handle, sess *ssh.Session, rec *Recorder, width int, height int) error {
 
 	// NOTE(Ali): Windows doesn't support SIGWINCH. The closest it has is WINDOW_BUFFER_SIZE_EVENT,
 	// which you only seem to be able to receive if *all* of your console input is read with ReadConsoleInput.
@@ -64,6 +64,7 @@ func watchWindowSize(ctx context.Context, fd windows.Handle, sess *ssh.Session,
 		if err := sess.WindowChange(height, width); err != nil {
 			return err
 		}
+		rec.Resize(width, height)
 	}
 
 	return nil
@@ -4,10 +4,16 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/miekg/dns"
 	"golang.zx2c4.com/wireguard/conn"
@@ -24,6 +30,10 @@ type Tunnel struct {
 	State  *WireGuardState
 	Config *Config
 
+	// OnDNSQuery, if set, is called after every query sent to the tunnel's
+	// DNS server by LookupTXT, LookupAAAA and the Resolver.
+	OnDNSQuery func(latency time.Duration, err error)
+
 	wscancel func()
 	resolv   *net.Resolver
 }
@@ -94,22 +104,94 @@ func doConnect(ctx context.Context, state *WireGuardState, wswg bool) (*Tunnel,
 	}
 	wgDev.Up()
 
-	return &Tunnel{
+	t := &Tunnel{
 		dev:    wgDev,
 		tun:    tunDev,
 		net:    gNet,
 		dnsIP:  cfg.DNS,
 		Config: cfg,
 		State:  state,
+	}
 
-		resolv: &net.Resolver{
-			PreferGo: true,
-			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				fmt.Println("resolver.Dial", network, address)
+	t.resolv = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			fmt.Println("resolver.Dial", network, address)
+			return t.dialDNS(ctx, func(ctx context.Context) (net.Conn, error) {
 				return gNet.DialContext(ctx, "tcp", net.JoinHostPort(dnsIP.String(), "53"))
-			},
+			})
 		},
-	}, nil
+	}
+
+	return t, nil
+}
+
+// dialDNS dials the tunnel's DNS server for the Resolver, which opens a
+// connection for every query, so that the query is reported to OnDNSQuery
+// once the Resolver is done with it.
+func (t *Tunnel) dialDNS(ctx context.Context, dial func(context.Context) (net.Conn, error)) (net.Conn, error) {
+	start := time.Now()
+
+	c, err := dial(ctx)
+	if t.OnDNSQuery == nil {
+		return c, err
+	}
+	if err != nil {
+		t.OnDNSQuery(time.Since(start), err)
+		return nil, err
+	}
+
+	return &timedDNSConn{Conn: c, start: start, report: t.OnDNSQuery}, nil
+}
+
+// timedDNSConn reports how long a query took, and its first read or write
+// error, when it's closed.
+type timedDNSConn struct {
+	net.Conn
+	start  time.Time
+	report func(latency time.Duration, err error)
+
+	mu     sync.Mutex
+	err    error
+	closed bool
+}
+
+func (c *timedDNSConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.fail(err)
+	return n, err
+}
+
+func (c *timedDNSConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.fail(err)
+	return n, err
+}
+
+func (c *timedDNSConn) fail(err error) {
+	if err == nil || errors.Is(err, io.EOF) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+func (c *timedDNSConn) Close() error {
+	c.mu.Lock()
+	report := !c.closed
+	c.closed = true
+	err := c.err
+	c.mu.Unlock()
+
+	if report {
+		c.report(time.Since(c.start), err)
+	}
+	return c.Conn.Close()
 }
 
 func (t *Tunnel) Close() error {
@@ -190,7 +272,13 @@ func (t *Tunnel) LookupAAAA(ctx context.Context, name string) ([]net.IP, error)
 	return results, nil
 }
 
-func (t *Tunnel) queryDNS(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+func (t *Tunnel) queryDNS(ctx context.Context, msg *dns.Msg) (r *dns.Msg, err error) {
+	if t.OnDNSQuery != nil {
+		defer func(start time.Time) {
+			t.OnDNSQuery(time.Since(start), err)
+		}(time.Now())
+	}
+
 	client := dns.Client{
 		Net: "tcp",
 		Dialer: &net.Dialer{
@@ -207,6 +295,52 @@ func (t *Tunnel) queryDNS(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
 	conn := &dns.Conn{Conn: c}
 	defer conn.Close()
 
-	r, _, err := client.ExchangeWithConn(msg, conn)
+	r, _, err = client.ExchangeWithConn(msg, conn)
 	return r, err
 }
+
+// TunnelStats are counters reported by the WireGuard device.
+type TunnelStats struct {
+	LastHandshake time.Time
+	RxBytes       uint64
+	TxBytes       uint64
+}
+
+// Stats reads the peer's counters from the WireGuard device.
+func (t *Tunnel) Stats() (TunnelStats, error) {
+	var stats TunnelStats
+
+	if t.dev == nil {
+		return stats, net.ErrClosed
+	}
+
+	ipc, err := t.dev.IpcGet()
+	if err != nil {
+		return stats, err
+	}
+
+	var sec, nsec int64
+	for _, line := range strings.Split(ipc, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "rx_bytes":
+			stats.RxBytes, _ = strconv.ParseUint(value, 10, 64)
+		case "tx_bytes":
+			stats.TxBytes, _ = strconv.ParseUint(value, 10, 64)
+		case "last_handshake_time_sec":
+			sec, _ = strconv.ParseInt(value, 10, 64)
+		case "last_handshake_time_nsec":
+			nsec, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+
+	if sec != 0 || nsec != 0 {
+		stats.LastHandshake = time.Unix(sec, nsec)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,60 @@
+package wg
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialDNSReportsQueries(t *testing.T) {
+	type query struct {
+		latency time.Duration
+		err     error
+	}
+	var queries []query
+
+	tunnel := &Tunnel{OnDNSQuery: func(latency time.Duration, err error) {
+		queries = append(queries, query{latency, err})
+	}}
+
+	client, server := net.Pipe()
+	go func() {
+		b := make([]byte, 4)
+		if _, err := server.Read(b); err == nil {
+			time.Sleep(10 * time.Millisecond)
+			server.Write(b)
+		}
+		server.Close()
+	}()
+
+	c, err := tunnel.dialDNS(context.Background(), func(context.Context) (net.Conn, error) {
+		return client, nil
+	})
+	require.NoError(t, err)
+
+	_, err = c.Write([]byte("ping"))
+	require.NoError(t, err)
+	_, err = c.Read(make([]byte, 4))
+	require.NoError(t, err)
+	assert.Empty(t, queries, "reported once the resolver is done")
+
+	require.NoError(t, c.Close())
+	c.Close()
+	require.Len(t, queries, 1)
+	assert.NoError(t, queries[0].err)
+	assert.GreaterOrEqual(t, queries[0].latency, 10*time.Millisecond)
+
+	// failing to reach the server counts as a failed query
+	dialErr := errors.New("unreachable")
+	_, err = tunnel.dialDNS(context.Background(), func(context.Context) (net.Conn, error) {
+		return nil, dialErr
+	})
+	assert.ErrorIs(t, err, dialErr)
+	require.Len(t, queries, 2)
+	assert.ErrorIs(t, queries[1].err, dialErr)
+}